@@ -0,0 +1,74 @@
+package brotli
+
+import (
+	"errors"
+)
+
+var errUnexpectedEOF = errors.New("brotli: unexpected end of data")
+
+// bitReader reads bits least significant first from an in memory byte slice, which is all
+// Brotli needs.
+type bitReader struct {
+	data []byte
+	pos  uint64 // position in bits
+}
+
+func newBitReader(data []byte) *bitReader {
+	return &bitReader{data: data}
+}
+
+func (br *bitReader) readBits(bits uint32) (uint32, error) {
+	if bits == 0 {
+		return 0, nil
+	}
+	if br.pos+uint64(bits) > uint64(len(br.data))*8 {
+		return 0, errUnexpectedEOF
+	}
+	var v uint32
+	for i := uint32(0); i < bits; {
+		b := br.data[br.pos>>3] >> (br.pos & 7)
+		n := 8 - uint32(br.pos&7)
+		if n > bits-i {
+			n = bits - i
+		}
+		v |= (uint32(b) & (1<<n - 1)) << i
+		i += n
+		br.pos += uint64(n)
+	}
+	return v, nil
+}
+
+func (br *bitReader) readBool() (bool, error) {
+	v, err := br.readBits(1)
+	return v == 1, err
+}
+
+// zeroPadToByte skips to the next byte boundary. The skipped bits must be zero.
+func (br *bitReader) zeroPadToByte() error {
+	if br.pos&7 == 0 {
+		return nil
+	}
+	v, err := br.readBits(uint32(8 - br.pos&7))
+	if err != nil {
+		return err
+	}
+	if v != 0 {
+		return errors.New("brotli: non zero padding bits")
+	}
+	return nil
+}
+
+// readBytes reads n bytes, the reader must be at a byte boundary.
+func (br *bitReader) readBytes(n uint32) ([]byte, error) {
+	start := br.pos >> 3
+	if start+uint64(n) > uint64(len(br.data)) {
+		return nil, errUnexpectedEOF
+	}
+	br.pos += uint64(n) * 8
+	return br.data[start : start+uint64(n)], nil
+}
+
+// remaining returns the number of whole bytes after the current position.
+func (br *bitReader) remaining() int {
+	return len(br.data) - int((br.pos+7)>>3)
+}
@@ -0,0 +1,570 @@
+// Package brotli is a Brotli (RFC 7932) decompressor, used for the Brotli compressed parts of
+// JPEG XL files (JPEG reconstruction data and brob metadata boxes).
+package brotli
+
+import (
+	"errors"
+)
+
+const (
+	numLiteralSymbols     = 256
+	numInsertCopySymbols  = 704
+	numBlockLengthSymbols = 26
+	literalContextBits    = 6
+	distanceContextBits   = 2
+)
+
+var (
+	// ErrTooLarge is returned when the decompressed data would be larger than the size limit.
+	ErrTooLarge = errors.New("brotli: decompressed data too large")
+
+	errInvalidDistance = errors.New("brotli: invalid distance")
+	errInvalidLength   = errors.New("brotli: invalid meta-block length")
+
+	blockLengthBase  = [numBlockLengthSymbols]uint32{1, 5, 9, 13, 17, 25, 33, 41, 49, 65, 81, 97, 113, 145, 177, 209, 241, 305, 369, 497, 753, 1265, 2289, 4337, 8433, 16625}
+	blockLengthExtra = [numBlockLengthSymbols]uint32{2, 2, 2, 2, 3, 3, 3, 3, 4, 4, 4, 4, 5, 5, 5, 5, 6, 6, 7, 8, 9, 10, 11, 12, 13, 24}
+
+	insertLengthBase  = [24]uint32{0, 1, 2, 3, 4, 5, 6, 8, 10, 14, 18, 26, 34, 50, 66, 98, 130, 194, 322, 578, 1090, 2114, 6210, 22594}
+	insertLengthExtra = [24]uint32{0, 0, 0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 7, 8, 9, 10, 12, 14, 24}
+	copyLengthBase    = [24]uint32{2, 3, 4, 5, 6, 7, 8, 9, 10, 12, 14, 18, 22, 30, 38, 54, 70, 102, 134, 198, 326, 582, 1094, 2118}
+	copyLengthExtra   = [24]uint32{0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 7, 8, 9, 10, 24}
+
+	// insert and copy length code ranges for each block of 64 insert and copy symbols
+	insertRangeBase = [11]uint32{0, 0, 0, 0, 8, 8, 0, 16, 8, 16, 16}
+	copyRangeBase   = [11]uint32{0, 8, 0, 8, 0, 8, 16, 0, 16, 8, 16}
+
+	// distance short codes: which of the last distances to use and what to add to it
+	distanceShortCodeIndex = [16]int{0, 1, 2, 3, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 1, 1}
+	distanceShortCodeDelta = [16]int{0, 0, 0, 0, -1, 1, -2, 2, -3, 3, -1, 1, -2, 2, -3, 3}
+)
+
+// blockSwitcher tracks the block type and remaining block length for one of the literal,
+// insert and copy, and distance categories.
+type blockSwitcher struct {
+	numTypes   uint32
+	typeCode   *prefixCode
+	lengthCode *prefixCode
+	current    uint32
+	previous   uint32
+	remaining  uint32
+}
+
+func readBlockSwitcher(br *bitReader) (*blockSwitcher, error) {
+	bs := &blockSwitcher{previous: 1, remaining: 1 << 24}
+	var err error
+	if bs.numTypes, err = readVarLenUint8(br); err != nil {
+		return nil, err
+	}
+	if bs.numTypes < 2 {
+		return bs, nil
+	}
+	if bs.typeCode, err = readPrefixCode(br, bs.numTypes+2); err != nil {
+		return nil, err
+	}
+	if bs.lengthCode, err = readPrefixCode(br, numBlockLengthSymbols); err != nil {
+		return nil, err
+	}
+	if bs.remaining, err = readBlockLength(br, bs.lengthCode); err != nil {
+		return nil, err
+	}
+	return bs, nil
+}
+
+// next returns the block type for the next symbol, switching block if the current one is used up.
+func (bs *blockSwitcher) next(br *bitReader) (uint32, error) {
+	if bs.remaining == 0 {
+		sym, err := bs.typeCode.readSymbol(br)
+		if err != nil {
+			return 0, err
+		}
+		var newType uint32
+		switch sym {
+		case 0:
+			newType = bs.previous
+		case 1:
+			newType = bs.current + 1
+		default:
+			newType = sym - 2
+		}
+		if newType >= bs.numTypes {
+			newType -= bs.numTypes
+		}
+		bs.previous = bs.current
+		bs.current = newType
+		if bs.remaining, err = readBlockLength(br, bs.lengthCode); err != nil {
+			return 0, err
+		}
+	}
+	bs.remaining--
+	return bs.current, nil
+}
+
+func readBlockLength(br *bitReader, code *prefixCode) (uint32, error) {
+	sym, err := code.readSymbol(br)
+	if err != nil {
+		return 0, err
+	}
+	extra, err := br.readBits(blockLengthExtra[sym])
+	if err != nil {
+		return 0, err
+	}
+	return blockLengthBase[sym] + extra, nil
+}
+
+// readVarLenUint8 reads the 1 to 256 values used for the number of block types and trees.
+func readVarLenUint8(br *bitReader) (uint32, error) {
+	more, err := br.readBool()
+	if err != nil || !more {
+		return 1, err
+	}
+	n, err := br.readBits(3)
+	if err != nil {
+		return 0, err
+	}
+	extra, err := br.readBits(n)
+	if err != nil {
+		return 0, err
+	}
+	return 1<<n + 1 + extra, nil
+}
+
+func readContextMap(br *bitReader, size int, numTrees uint32) ([]uint8, error) {
+	contextMap := make([]uint8, size)
+	if numTrees < 2 {
+		return contextMap, nil
+	}
+
+	rleMax := uint32(0)
+	useRLE, err := br.readBool()
+	if err != nil {
+		return nil, err
+	}
+	if useRLE {
+		if rleMax, err = br.readBits(4); err != nil {
+			return nil, err
+		}
+		rleMax++
+	}
+	code, err := readPrefixCode(br, numTrees+rleMax)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < size; {
+		sym, err := code.readSymbol(br)
+		if err != nil {
+			return nil, err
+		}
+		if sym == 0 {
+			contextMap[i] = 0
+			i++
+		} else if sym <= rleMax {
+			extra, err := br.readBits(sym)
+			if err != nil {
+				return nil, err
+			}
+			reps := int(1<<sym + extra)
+			if i+reps > size {
+				return nil, errors.New("brotli: invalid context map")
+			}
+			for ; reps > 0; reps-- {
+				contextMap[i] = 0
+				i++
+			}
+		} else {
+			contextMap[i] = uint8(sym - rleMax)
+			i++
+		}
+	}
+
+	imtf, err := br.readBool()
+	if err != nil {
+		return nil, err
+	}
+	if imtf {
+		inverseMoveToFront(contextMap)
+	}
+	return contextMap, nil
+}
+
+func inverseMoveToFront(values []uint8) {
+	var mtf [256]uint8
+	for i := range mtf {
+		mtf[i] = uint8(i)
+	}
+	for i, index := range values {
+		v := mtf[index]
+		values[i] = v
+		copy(mtf[1:index+1], mtf[:index])
+		mtf[0] = v
+	}
+}
+
+// Decompress decompresses a complete Brotli stream. Streams that decompress to more than maxSize
+// bytes are rejected with ErrTooLarge before the data is decoded, which protects against
+// decompression bombs.
+func Decompress(data []byte, maxSize int) ([]byte, error) {
+	d := &decoder{
+		br:        newBitReader(data),
+		distances: [4]int{4, 11, 15, 16},
+		maxSize:   maxSize,
+	}
+	if err := d.decode(); err != nil {
+		return nil, err
+	}
+	return d.out, nil
+}
+
+type decoder struct {
+	br         *bitReader
+	out        []byte
+	windowSize int
+	distances  [4]int
+	maxSize    int
+}
+
+func (d *decoder) decode() error {
+	if err := d.readWindowSize(); err != nil {
+		return err
+	}
+	for {
+		last, err := d.decodeMetaBlock()
+		if err != nil {
+			return err
+		}
+		if last {
+			break
+		}
+	}
+	if err := d.br.zeroPadToByte(); err != nil {
+		return err
+	}
+	if d.br.remaining() != 0 {
+		return errors.New("brotli: unexpected data after end of stream")
+	}
+	return nil
+}
+
+func (d *decoder) readWindowSize() error {
+	br := d.br
+	wbits := uint32(16)
+	if v, err := br.readBool(); err != nil {
+		return err
+	} else if v {
+		n, err := br.readBits(3)
+		if err != nil {
+			return err
+		}
+		if n != 0 {
+			wbits = 17 + n
+		} else {
+			m, err := br.readBits(3)
+			if err != nil {
+				return err
+			}
+			if m == 1 {
+				return errors.New("brotli: invalid window size")
+			}
+			if m == 0 {
+				wbits = 17
+			} else {
+				wbits = 8 + m
+			}
+		}
+	}
+	d.windowSize = 1 << wbits
+	return nil
+}
+
+// decodeMetaBlock decodes a single meta-block, returning true if it was the last one.
+func (d *decoder) decodeMetaBlock() (bool, error) {
+	br := d.br
+	last, err := br.readBool()
+	if err != nil {
+		return false, err
+	}
+	if last {
+		empty, err := br.readBool()
+		if err != nil || empty {
+			return true, err
+		}
+	}
+
+	nibbles, err := br.readBits(2)
+	if err != nil {
+		return false, err
+	}
+	if nibbles == 3 {
+		// metadata block, which is skipped
+		if reserved, err := br.readBool(); err != nil {
+			return false, err
+		} else if reserved {
+			return false, errors.New("brotli: reserved bit set")
+		}
+		skipBytes, err := br.readBits(2)
+		if err != nil {
+			return false, err
+		}
+		skipLength := uint32(0)
+		if skipBytes > 0 {
+			if skipLength, err = br.readBits(8 * skipBytes); err != nil {
+				return false, err
+			}
+			if skipBytes > 1 && skipLength>>(8*(skipBytes-1)) == 0 {
+				return false, errInvalidLength
+			}
+			skipLength++
+		}
+		if err := br.zeroPadToByte(); err != nil {
+			return false, err
+		}
+		if _, err := br.readBytes(skipLength); err != nil {
+			return false, err
+		}
+		return last, nil
+	}
+
+	nibbles += 4
+	length, err := br.readBits(4 * nibbles)
+	if err != nil {
+		return false, err
+	}
+	if nibbles > 4 && length>>(4*(nibbles-1)) == 0 {
+		return false, errInvalidLength
+	}
+	length++
+	if int(length) > d.maxSize-len(d.out) {
+		return false, ErrTooLarge
+	}
+
+	if !last {
+		uncompressed, err := br.readBool()
+		if err != nil {
+			return false, err
+		}
+		if uncompressed {
+			if err := br.zeroPadToByte(); err != nil {
+				return false, err
+			}
+			b, err := br.readBytes(length)
+			if err != nil {
+				return false, err
+			}
+			d.out = append(d.out, b...)
+			return false, nil
+		}
+	}
+
+	return last, d.decodeCompressed(int(length))
+}
+
+func (d *decoder) decodeCompressed(length int) error {
+	br := d.br
+
+	var switchers [3]*blockSwitcher
+	for i := range switchers {
+		var err error
+		if switchers[i], err = readBlockSwitcher(br); err != nil {
+			return err
+		}
+	}
+	literalSwitch, commandSwitch, distanceSwitch := switchers[0], switchers[1], switchers[2]
+
+	npostfix, err := br.readBits(2)
+	if err != nil {
+		return err
+	}
+	ndirect, err := br.readBits(4)
+	if err != nil {
+		return err
+	}
+	ndirect <<= npostfix
+
+	contextModes := make([]uint8, literalSwitch.numTypes)
+	for i := range contextModes {
+		mode, err := br.readBits(2)
+		if err != nil {
+			return err
+		}
+		contextModes[i] = uint8(mode)
+	}
+
+	numLiteralTrees, err := readVarLenUint8(br)
+	if err != nil {
+		return err
+	}
+	literalContextMap, err := readContextMap(br, int(literalSwitch.numTypes)<<literalContextBits, numLiteralTrees)
+	if err != nil {
+		return err
+	}
+	numDistanceTrees, err := readVarLenUint8(br)
+	if err != nil {
+		return err
+	}
+	distanceContextMap, err := readContextMap(br, int(distanceSwitch.numTypes)<<distanceContextBits, numDistanceTrees)
+	if err != nil {
+		return err
+	}
+
+	literalCodes, err := readPrefixCodes(br, numLiteralTrees, numLiteralSymbols)
+	if err != nil {
+		return err
+	}
+	commandCodes, err := readPrefixCodes(br, commandSwitch.numTypes, numInsertCopySymbols)
+	if err != nil {
+		return err
+	}
+	distanceCodes, err := readPrefixCodes(br, numDistanceTrees, 16+ndirect+48<<npostfix)
+	if err != nil {
+		return err
+	}
+
+	end := len(d.out) + length
+	for len(d.out) < end {
+		commandType, err := commandSwitch.next(br)
+		if err != nil {
+			return err
+		}
+		sym, err := commandCodes[commandType].readSymbol(br)
+		if err != nil {
+			return err
+		}
+		cell := sym >> 6
+		insertCode := insertRangeBase[cell] + (sym>>3)&7
+		copyCode := copyRangeBase[cell] + sym&7
+		insertExtra, err := br.readBits(insertLengthExtra[insertCode])
+		if err != nil {
+			return err
+		}
+		copyExtra, err := br.readBits(copyLengthExtra[copyCode])
+		if err != nil {
+			return err
+		}
+		insertLength := int(insertLengthBase[insertCode] + insertExtra)
+		copyLength := int(copyLengthBase[copyCode] + copyExtra)
+
+		if len(d.out)+insertLength > end {
+			return errInvalidLength
+		}
+		for i := 0; i < insertLength; i++ {
+			literalType, err := literalSwitch.next(br)
+			if err != nil {
+				return err
+			}
+			context := d.literalContext(contextModes[literalType])
+			tree := literalContextMap[literalType<<literalContextBits+context]
+			literal, err := literalCodes[tree].readSymbol(br)
+			if err != nil {
+				return err
+			}
+			d.out = append(d.out, byte(literal))
+		}
+		if len(d.out) == end {
+			break
+		}
+
+		distanceCode := uint32(0)
+		if sym >= 128 {
+			distanceType, err := distanceSwitch.next(br)
+			if err != nil {
+				return err
+			}
+			context := uint32(copyLength - 2)
+			if copyLength > 4 {
+				context = 3
+			}
+			tree := distanceContextMap[distanceType<<distanceContextBits+context]
+			if distanceCode, err = distanceCodes[tree].readSymbol(br); err != nil {
+				return err
+			}
+		}
+		distance, err := d.readDistance(distanceCode, npostfix, ndirect)
+		if err != nil {
+			return err
+		}
+
+		maxDistance := len(d.out)
+		if maxDistance > d.windowSize-16 {
+			maxDistance = d.windowSize - 16
+		}
+		if distance > maxDistance {
+			word, err := dictionaryWord(copyLength, distance-maxDistance-1)
+			if err != nil {
+				return err
+			}
+			if len(d.out)+len(word) > end {
+				return errInvalidLength
+			}
+			d.out = append(d.out, word...)
+			continue
+		}
+
+		if distanceCode != 0 {
+			d.distances[3], d.distances[2], d.distances[1] = d.distances[2], d.distances[1], d.distances[0]
+			d.distances[0] = distance
+		}
+		if len(d.out)+copyLength > end {
+			return errInvalidLength
+		}
+		start := len(d.out) - distance
+		for i := 0; i < copyLength; i++ {
+			d.out = append(d.out, d.out[start+i])
+		}
+	}
+	return nil
+}
+
+func readPrefixCodes(br *bitReader, count uint32, alphabetSize uint32) ([]*prefixCode, error) {
+	codes := make([]*prefixCode, count)
+	for i := range codes {
+		var err error
+		if codes[i], err = readPrefixCode(br, alphabetSize); err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+func (d *decoder) readDistance(code uint32, npostfix uint32, ndirect uint32) (int, error) {
+	if code < 16 {
+		distance := d.distances[distanceShortCodeIndex[code]] + distanceShortCodeDelta[code]
+		if distance <= 0 {
+			return 0, errInvalidDistance
+		}
+		return distance, nil
+	}
+	if code < 16+ndirect {
+		return int(code - 15), nil
+	}
+	code -= ndirect + 16
+	postfixMask := uint32(1)<<npostfix - 1
+	numBits := 1 + code>>(npostfix+1)
+	extra, err := d.br.readBits(numBits)
+	if err != nil {
+		return 0, err
+	}
+	hcode := code >> npostfix
+	lcode := code & postfixMask
+	offset := (2+hcode&1)<<numBits - 4
+	return int((offset+extra)<<npostfix + lcode + ndirect + 1), nil
+}
+
+// literalContext returns the context ID for the next literal (RFC 7932 section 7.1).
+func (d *decoder) literalContext(mode uint8) uint32 {
+	var p1, p2 byte
+	if n := len(d.out); n > 0 {
+		p1 = d.out[n-1]
+		if n > 1 {
+			p2 = d.out[n-2]
+		}
+	}
+	switch mode {
+	case 0:
+		return uint32(p1 & 0x3F)
+	case 1:
+		return uint32(p1 >> 2)
+	case 2:
+		return uint32(contextLUT0[p1] | contextLUT1[p2])
+	default:
+		return uint32(contextLUT2[p1]<<3 | contextLUT2[p2])
+	}
+}
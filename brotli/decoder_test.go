@@ -0,0 +1,109 @@
+package brotli
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const quickBrownFox = "The quick brown fox jumps over the lazy dog. The quick brown fox jumps over the lazy dog again, and then the dog jumps over the fox."
+
+func TestDecompress(t *testing.T) {
+
+	raw := make([]byte, 40)
+	for i := range raw {
+		raw[i] = byte(i*151 + 7)
+	}
+
+	for _, tc := range []struct {
+		name       string
+		compressed string
+		expected   []byte
+	}{
+		{
+			name:       "empty",
+			compressed: "3b",
+			expected:   []byte{},
+		},
+		{
+			name:       "quality 11 text",
+			compressed: "1b8300001ca7c71acb5dbb8aaa2cc9ccd84007473aa0a347451d924a27070eb7df2ef7226991052679cad605d1d094efade289595479e991c1e5a9e30f4e2110a1c6d5091b074e2eaf6dbd02",
+			expected:   []byte(quickBrownFox),
+		},
+		{
+			name:       "quality 0 text",
+			compressed: "8341000080aaaaaaeaff7465b81bd8ed64878b9aa9a88aaa992aabe966cbff5df5efb9b7ba675497b731dc152f161616363656f532a33af8f0e1c7603018c799acaac16030180c0673a8332215a551382cb493ec3dc177bba9e01ee138b1573e198e2fe248a581532aba0a2ef37fb0f42b02bccfa730de84bcc0648bae9263836ce913b8501cdf75",
+			expected:   []byte(quickBrownFox),
+		},
+		{
+			name:       "dictionary word",
+			compressed: "1b0a00f825000298a820",
+			expected:   []byte("information"),
+		},
+		{
+			name:       "uncompressed meta-block",
+			compressed: "8b1380079e35cc63fa9128bf56ed841bb249e0770ea53cd36a01982fc65df48b22b950e77e15ac43da710803",
+			expected:   raw,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			compressed, err := hex.DecodeString(tc.compressed)
+			require.NoError(t, err)
+
+			out, err := Decompress(compressed, len(tc.expected))
+			require.NoError(t, err)
+			assert.Equal(t, len(tc.expected), len(out))
+			assert.Equal(t, string(tc.expected), string(out))
+		})
+	}
+}
+
+func TestDecompressErrors(t *testing.T) {
+
+	valid, err := hex.DecodeString("1b8300001ca7c71acb5dbb8aaa2cc9ccd84007473aa0a347451d924a27070eb7df2ef7226991052679cad605d1d094efade289595479e991c1e5a9e30f4e2110a1c6d5091b074e2eaf6dbd02")
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name string
+		data []byte
+	}{
+		{
+			name: "no data",
+			data: []byte{},
+		},
+		{
+			name: "truncated",
+			data: valid[:len(valid)/2],
+		},
+		{
+			name: "trailing data",
+			data: append(append([]byte{}, valid...), 0),
+		},
+		{
+			name: "invalid window size",
+			data: []byte{0x11},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Decompress(tc.data, 1<<20)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestDecompressLimit(t *testing.T) {
+
+	compressed, err := hex.DecodeString("8341000080aaaaaaeaff7465b81bd8ed64878b9aa9a88aaa992aabe966cbff5df5efb9b7ba675497b731dc152f161616363656f532a33af8f0e1c7603018c799acaac16030180c0673a8332215a551382cb493ec3dc177bba9e01ee138b1573e198e2fe248a581532aba0a2ef37fb0f42b02bccfa730de84bcc0648bae9263836ce913b8501cdf75")
+	require.NoError(t, err)
+
+	_, err = Decompress(compressed, len(quickBrownFox)-1)
+	assert.ErrorIs(t, err, ErrTooLarge)
+
+	// the uncompressed meta-block is checked before it is copied.
+	uncompressed, err := hex.DecodeString("8b1380079e35cc63fa9128bf56ed841bb249e0770ea53cd36a01982fc65df48b22b950e77e15ac43da710803")
+	require.NoError(t, err)
+	_, err = Decompress(uncompressed, 39)
+	assert.ErrorIs(t, err, ErrTooLarge)
+}
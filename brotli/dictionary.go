@@ -0,0 +1,256 @@
+package brotli
+
+import (
+	_ "embed"
+)
+
+const (
+	minDictionaryWordLength = 4
+	maxDictionaryWordLength = 24
+)
+
+const (
+	transformIdentity = iota
+	transformOmitLast1
+	transformOmitLast2
+	transformOmitLast3
+	transformOmitLast4
+	transformOmitLast5
+	transformOmitLast6
+	transformOmitLast7
+	transformOmitLast8
+	transformOmitLast9
+	transformUppercaseFirst
+	transformUppercaseAll
+	transformOmitFirst1
+	transformOmitFirst2
+	transformOmitFirst3
+	transformOmitFirst4
+	transformOmitFirst5
+	transformOmitFirst6
+	transformOmitFirst7
+	transformOmitFirst8
+	transformOmitFirst9
+)
+
+var (
+	// dictionary is the static dictionary from RFC 7932 appendix A.
+	//go:embed dictionary.bin
+	dictionary []byte
+
+	// dictionarySizeBits is NDBITS from RFC 7932 section 8, indexed by word length.
+	dictionarySizeBits = [maxDictionaryWordLength + 1]uint32{
+		0, 0, 0, 0, 10, 10, 11, 11, 10, 10, 10, 10, 10, 9, 9, 8, 7, 7, 8, 7, 7, 6, 6, 5, 5,
+	}
+
+	// dictionaryOffsets is DOFFSET from RFC 7932 section 8, indexed by word length.
+	dictionaryOffsets [maxDictionaryWordLength + 1]uint32
+)
+
+type transform struct {
+	prefix        string
+	transformType int
+	suffix        string
+}
+
+// transforms is the list of word transforms from RFC 7932 appendix B.
+var transforms = []transform{
+	{"", transformIdentity, ""},
+	{"", transformIdentity, " "},
+	{" ", transformIdentity, " "},
+	{"", transformOmitFirst1, ""},
+	{"", transformUppercaseFirst, " "},
+	{"", transformIdentity, " the "},
+	{" ", transformIdentity, ""},
+	{"s ", transformIdentity, " "},
+	{"", transformIdentity, " of "},
+	{"", transformUppercaseFirst, ""},
+	{"", transformIdentity, " and "},
+	{"", transformOmitFirst2, ""},
+	{"", transformOmitLast1, ""},
+	{", ", transformIdentity, " "},
+	{"", transformIdentity, ", "},
+	{" ", transformUppercaseFirst, " "},
+	{"", transformIdentity, " in "},
+	{"", transformIdentity, " to "},
+	{"e ", transformIdentity, " "},
+	{"", transformIdentity, "\""},
+	{"", transformIdentity, "."},
+	{"", transformIdentity, "\">"},
+	{"", transformIdentity, "\n"},
+	{"", transformOmitLast3, ""},
+	{"", transformIdentity, "]"},
+	{"", transformIdentity, " for "},
+	{"", transformOmitFirst3, ""},
+	{"", transformOmitLast2, ""},
+	{"", transformIdentity, " a "},
+	{"", transformIdentity, " that "},
+	{" ", transformUppercaseFirst, ""},
+	{"", transformIdentity, ". "},
+	{".", transformIdentity, ""},
+	{" ", transformIdentity, ", "},
+	{"", transformOmitFirst4, ""},
+	{"", transformIdentity, " with "},
+	{"", transformIdentity, "'"},
+	{"", transformIdentity, " from "},
+	{"", transformIdentity, " by "},
+	{"", transformOmitFirst5, ""},
+	{"", transformOmitFirst6, ""},
+	{" the ", transformIdentity, ""},
+	{"", transformOmitLast4, ""},
+	{"", transformIdentity, ". The "},
+	{"", transformUppercaseAll, ""},
+	{"", transformIdentity, " on "},
+	{"", transformIdentity, " as "},
+	{"", transformIdentity, " is "},
+	{"", transformOmitLast7, ""},
+	{"", transformOmitLast1, "ing "},
+	{"", transformIdentity, "\n\t"},
+	{"", transformIdentity, ":"},
+	{" ", transformIdentity, ". "},
+	{"", transformIdentity, "ed "},
+	{"", transformOmitFirst9, ""},
+	{"", transformOmitFirst7, ""},
+	{"", transformOmitLast6, ""},
+	{"", transformIdentity, "("},
+	{"", transformUppercaseFirst, ", "},
+	{"", transformOmitLast8, ""},
+	{"", transformIdentity, " at "},
+	{"", transformIdentity, "ly "},
+	{" the ", transformIdentity, " of "},
+	{"", transformOmitLast5, ""},
+	{"", transformOmitLast9, ""},
+	{" ", transformUppercaseFirst, ", "},
+	{"", transformUppercaseFirst, "\""},
+	{".", transformIdentity, "("},
+	{"", transformUppercaseAll, " "},
+	{"", transformUppercaseFirst, "\">"},
+	{"", transformIdentity, "=\""},
+	{" ", transformIdentity, "."},
+	{".com/", transformIdentity, ""},
+	{" the ", transformIdentity, " of the "},
+	{"", transformUppercaseFirst, "'"},
+	{"", transformIdentity, ". This "},
+	{"", transformIdentity, ","},
+	{".", transformIdentity, " "},
+	{"", transformUppercaseFirst, "("},
+	{"", transformUppercaseFirst, "."},
+	{"", transformIdentity, " not "},
+	{" ", transformIdentity, "=\""},
+	{"", transformIdentity, "er "},
+	{" ", transformUppercaseAll, " "},
+	{"", transformIdentity, "al "},
+	{" ", transformUppercaseAll, ""},
+	{"", transformIdentity, "='"},
+	{"", transformUppercaseAll, "\""},
+	{"", transformUppercaseFirst, ". "},
+	{" ", transformIdentity, "("},
+	{"", transformIdentity, "ful "},
+	{" ", transformUppercaseFirst, ". "},
+	{"", transformIdentity, "ive "},
+	{"", transformIdentity, "less "},
+	{"", transformUppercaseAll, "'"},
+	{"", transformIdentity, "est "},
+	{" ", transformUppercaseFirst, "."},
+	{"", transformUppercaseAll, "\">"},
+	{" ", transformIdentity, "='"},
+	{"", transformUppercaseFirst, ","},
+	{"", transformIdentity, "ize "},
+	{"", transformUppercaseAll, "."},
+	{"\xc2\xa0", transformIdentity, ""},
+	{" ", transformIdentity, ","},
+	{"", transformUppercaseFirst, "=\""},
+	{"", transformUppercaseAll, "=\""},
+	{"", transformIdentity, "ous "},
+	{"", transformUppercaseAll, ", "},
+	{"", transformUppercaseFirst, "='"},
+	{" ", transformUppercaseFirst, ","},
+	{" ", transformUppercaseAll, "=\""},
+	{" ", transformUppercaseAll, ", "},
+	{"", transformUppercaseAll, ","},
+	{"", transformUppercaseAll, "("},
+	{"", transformUppercaseAll, ". "},
+	{" ", transformUppercaseAll, "."},
+	{"", transformUppercaseAll, "='"},
+	{" ", transformUppercaseAll, ". "},
+	{" ", transformUppercaseFirst, "=\""},
+	{" ", transformUppercaseAll, "='"},
+	{" ", transformUppercaseFirst, "='"},
+}
+
+func init() {
+	for l := minDictionaryWordLength; l < maxDictionaryWordLength; l++ {
+		dictionaryOffsets[l+1] = dictionaryOffsets[l] + uint32(l)<<dictionarySizeBits[l]
+	}
+}
+
+// dictionaryWord returns the static dictionary word for a copy of length wordLength with word ID
+// wordID, with the transform given by the top bits of the word ID applied.
+func dictionaryWord(wordLength int, wordID int) ([]byte, error) {
+	if wordLength < minDictionaryWordLength || wordLength > maxDictionaryWordLength {
+		return nil, errInvalidDistance
+	}
+	bits := dictionarySizeBits[wordLength]
+	index := uint32(wordID) & (1<<bits - 1)
+	transformID := wordID >> bits
+	if transformID >= len(transforms) {
+		return nil, errInvalidDistance
+	}
+	offset := dictionaryOffsets[wordLength] + index*uint32(wordLength)
+	word := dictionary[offset : offset+uint32(wordLength)]
+	return applyTransform(word, transforms[transformID]), nil
+}
+
+func applyTransform(word []byte, t transform) []byte {
+	w := make([]byte, len(word))
+	copy(w, word)
+	switch {
+	case t.transformType >= transformOmitLast1 && t.transformType <= transformOmitLast9:
+		omit := t.transformType - transformOmitLast1 + 1
+		if omit > len(w) {
+			omit = len(w)
+		}
+		w = w[:len(w)-omit]
+	case t.transformType >= transformOmitFirst1 && t.transformType <= transformOmitFirst9:
+		omit := t.transformType - transformOmitFirst1 + 1
+		if omit > len(w) {
+			omit = len(w)
+		}
+		w = w[omit:]
+	case t.transformType == transformUppercaseFirst:
+		toUpperCase(w)
+	case t.transformType == transformUppercaseAll:
+		for i := 0; i < len(w); {
+			i += toUpperCase(w[i:])
+		}
+	}
+
+	out := make([]byte, 0, len(t.prefix)+len(w)+len(t.suffix))
+	out = append(out, t.prefix...)
+	out = append(out, w...)
+	return append(out, t.suffix...)
+}
+
+// toUpperCase upper cases the first character of w using the simplified UTF-8 model from
+// RFC 7932, returning how many bytes the character used.
+func toUpperCase(w []byte) int {
+	if len(w) == 0 {
+		return 1
+	}
+	if w[0] < 0xC0 {
+		if w[0] >= 'a' && w[0] <= 'z' {
+			w[0] ^= 32
+		}
+		return 1
+	}
+	if w[0] < 0xE0 {
+		if len(w) > 1 {
+			w[1] ^= 32
+		}
+		return 2
+	}
+	if len(w) > 2 {
+		w[2] ^= 5
+	}
+	return 3
+}
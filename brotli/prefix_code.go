@@ -0,0 +1,252 @@
+package brotli
+
+import (
+	"errors"
+)
+
+const maxCodeLength = 15
+
+var (
+	// order the code length code lengths are stored in (RFC 7932 section 3.5)
+	codeLengthCodeOrder = [18]int{1, 2, 3, 4, 0, 5, 17, 6, 16, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+	// fixed code used for the code length code lengths, indexed by the next 4 bits
+	codeLengthPrefixLength = [16]uint32{2, 2, 2, 3, 2, 2, 2, 4, 2, 2, 2, 3, 2, 2, 2, 4}
+	codeLengthPrefixValue  = [16]uint8{0, 4, 3, 2, 0, 4, 3, 1, 0, 4, 3, 2, 0, 4, 3, 5}
+
+	errInvalidPrefixCode = errors.New("brotli: invalid prefix code")
+)
+
+// prefixCode is a canonical Huffman code. Symbols are decoded a bit at a time, which is plenty
+// fast enough for the metadata sized streams this is used for.
+type prefixCode struct {
+	count   [maxCodeLength + 1]uint16
+	symbols []uint16
+
+	// codes with a single symbol use no bits at all
+	single    bool
+	singleSym uint16
+}
+
+func newPrefixCodeFromLengths(lengths []uint8) (*prefixCode, error) {
+	pc := &prefixCode{}
+	used := 0
+	for sym, l := range lengths {
+		if l != 0 {
+			pc.count[l]++
+			used++
+			pc.singleSym = uint16(sym)
+		}
+	}
+	if used == 0 {
+		return nil, errInvalidPrefixCode
+	}
+	if used == 1 {
+		pc.single = true
+		return pc, nil
+	}
+
+	var offsets [maxCodeLength + 2]uint16
+	for l := 1; l <= maxCodeLength; l++ {
+		offsets[l+1] = offsets[l] + pc.count[l]
+	}
+	pc.symbols = make([]uint16, used)
+	for sym, l := range lengths {
+		if l != 0 {
+			pc.symbols[offsets[l]] = uint16(sym)
+			offsets[l]++
+		}
+	}
+	return pc, nil
+}
+
+func (pc *prefixCode) readSymbol(br *bitReader) (uint32, error) {
+	if pc.single {
+		return uint32(pc.singleSym), nil
+	}
+	code := 0
+	first := 0
+	index := 0
+	for l := 1; l <= maxCodeLength; l++ {
+		bit, err := br.readBits(1)
+		if err != nil {
+			return 0, err
+		}
+		code |= int(bit)
+		count := int(pc.count[l])
+		if code-count < first {
+			return uint32(pc.symbols[index+code-first]), nil
+		}
+		index += count
+		first += count
+		first <<= 1
+		code <<= 1
+	}
+	return 0, errInvalidPrefixCode
+}
+
+// readPrefixCode reads a simple or complex prefix code (RFC 7932 sections 3.4 and 3.5).
+func readPrefixCode(br *bitReader, alphabetSize uint32) (*prefixCode, error) {
+	hskip, err := br.readBits(2)
+	if err != nil {
+		return nil, err
+	}
+	lengths := make([]uint8, alphabetSize)
+	if hskip == 1 {
+		if err := readSimplePrefixCode(br, lengths); err != nil {
+			return nil, err
+		}
+	} else if err := readComplexPrefixCode(br, hskip, lengths); err != nil {
+		return nil, err
+	}
+	return newPrefixCodeFromLengths(lengths)
+}
+
+func readSimplePrefixCode(br *bitReader, lengths []uint8) error {
+	alphabetBits := uint32(0)
+	for 1<<alphabetBits < len(lengths) {
+		alphabetBits++
+	}
+	nsym, err := br.readBits(2)
+	if err != nil {
+		return err
+	}
+	nsym++
+
+	symbols := make([]uint32, nsym)
+	for i := range symbols {
+		if symbols[i], err = br.readBits(alphabetBits); err != nil {
+			return err
+		}
+		if symbols[i] >= uint32(len(lengths)) {
+			return errInvalidPrefixCode
+		}
+		for j := 0; j < i; j++ {
+			if symbols[j] == symbols[i] {
+				return errInvalidPrefixCode
+			}
+		}
+	}
+
+	// codes are assigned in symbol order within each length, so only the lengths are needed.
+	var codeLengths []uint8
+	switch nsym {
+	case 1:
+		codeLengths = []uint8{1}
+	case 2:
+		codeLengths = []uint8{1, 1}
+	case 3:
+		codeLengths = []uint8{1, 2, 2}
+	case 4:
+		treeSelect, err := br.readBool()
+		if err != nil {
+			return err
+		}
+		if treeSelect {
+			codeLengths = []uint8{1, 2, 3, 3}
+		} else {
+			codeLengths = []uint8{2, 2, 2, 2}
+		}
+	}
+	for i, sym := range symbols {
+		lengths[sym] = codeLengths[i]
+	}
+	return nil
+}
+
+func readComplexPrefixCode(br *bitReader, hskip uint32, lengths []uint8) error {
+	var codeLengthLengths [18]uint8
+	space := 32
+	numCodes := 0
+	for i := int(hskip); i < len(codeLengthCodeOrder); i++ {
+
+		// the fixed code is at most 4 bits, but may be the last thing in the stream
+		// so only consume what it needs.
+		peek := uint32(0)
+		for n := uint32(0); n < 4; n++ {
+			if br.pos+uint64(n) >= uint64(len(br.data))*8 {
+				break
+			}
+			bit := uint32(br.data[(br.pos+uint64(n))>>3]>>((br.pos+uint64(n))&7)) & 1
+			peek |= bit << n
+		}
+		if _, err := br.readBits(codeLengthPrefixLength[peek]); err != nil {
+			return err
+		}
+		l := codeLengthPrefixValue[peek]
+		codeLengthLengths[codeLengthCodeOrder[i]] = l
+		if l != 0 {
+			space -= 32 >> l
+			numCodes++
+			if space <= 0 {
+				break
+			}
+		}
+	}
+	if numCodes != 1 && space != 0 {
+		return errInvalidPrefixCode
+	}
+
+	codeLengthCode, err := newPrefixCodeFromLengths(codeLengthLengths[:])
+	if err != nil {
+		return err
+	}
+
+	symbol := 0
+	prevCodeLen := uint8(8)
+	repeat := uint32(0)
+	repeatCodeLen := uint8(0)
+	space = 1 << 15
+	for symbol < len(lengths) && space > 0 {
+		p, err := codeLengthCode.readSymbol(br)
+		if err != nil {
+			return err
+		}
+		if p < 16 {
+			repeat = 0
+			lengths[symbol] = uint8(p)
+			if p != 0 {
+				prevCodeLen = uint8(p)
+				space -= 1 << 15 >> p
+			}
+			symbol++
+			continue
+		}
+
+		extraBits := uint32(2)
+		newLen := prevCodeLen
+		if p == 17 {
+			extraBits = 3
+			newLen = 0
+		}
+		if repeatCodeLen != newLen {
+			repeat = 0
+			repeatCodeLen = newLen
+		}
+		oldRepeat := repeat
+		if repeat > 0 {
+			repeat -= 2
+			repeat <<= extraBits
+		}
+		extra, err := br.readBits(extraBits)
+		if err != nil {
+			return err
+		}
+		repeat += extra + 3
+		delta := int(repeat - oldRepeat)
+		if symbol+delta > len(lengths) {
+			return errInvalidPrefixCode
+		}
+		for i := 0; i < delta; i++ {
+			lengths[symbol] = repeatCodeLen
+			symbol++
+		}
+		if repeatCodeLen != 0 {
+			space -= delta << (15 - repeatCodeLen)
+		}
+	}
+	if space != 0 {
+		return errInvalidPrefixCode
+	}
+	return nil
+}
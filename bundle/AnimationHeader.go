@@ -1,15 +1,32 @@
 package bundle
 
 import (
-	"errors"
-
 	"github.com/kpfaulkner/jxl-go/jxlio"
 )
 
+// AnimationHeader holds the timing information for animated images. Frame durations
+// are expressed in ticks, where there are TpsNumerator/TpsDenominator ticks per second.
 type AnimationHeader struct {
-	HaveTimeCodes bool
+	TpsNumerator   uint32
+	TpsDenominator uint32
+	NumLoops       uint32
+	HaveTimeCodes  bool
 }
 
 func NewAnimationHeader(reader jxlio.BitReader) (*AnimationHeader, error) {
-	return nil, errors.New("Animation not implemented")
+	ah := &AnimationHeader{}
+	var err error
+	if ah.TpsNumerator, err = reader.ReadU32(100, 0, 1000, 0, 1, 10, 1, 30); err != nil {
+		return nil, err
+	}
+	if ah.TpsDenominator, err = reader.ReadU32(1, 0, 1001, 0, 1, 8, 1, 10); err != nil {
+		return nil, err
+	}
+	if ah.NumLoops, err = reader.ReadU32(0, 0, 0, 3, 0, 16, 0, 32); err != nil {
+		return nil, err
+	}
+	if ah.HaveTimeCodes, err = reader.ReadBool(); err != nil {
+		return nil, err
+	}
+	return ah, nil
 }
@@ -0,0 +1,66 @@
+package bundle
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kpfaulkner/jxl-go/testcommon"
+)
+
+func TestNewAnimationHeader(t *testing.T) {
+
+	for _, tc := range []struct {
+		name           string
+		u32Data        []uint32
+		boolData       []bool
+		expectedResult AnimationHeader
+		expectErr      bool
+	}{
+		{
+			name:      "no data",
+			expectErr: true,
+		},
+		{
+			name:      "missing loop count",
+			u32Data:   []uint32{100, 1},
+			expectErr: true,
+		},
+		{
+			name:      "missing timecodes flag",
+			u32Data:   []uint32{100, 1, 0},
+			expectErr: true,
+		},
+		{
+			name:     "success",
+			u32Data:  []uint32{30000, 1001, 3},
+			boolData: []bool{true},
+			expectedResult: AnimationHeader{
+				TpsNumerator:   30000,
+				TpsDenominator: 1001,
+				NumLoops:       3,
+				HaveTimeCodes:  true,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			bitReader := &testcommon.FakeBitReader{
+				ReadU32Data:  tc.u32Data,
+				ReadBoolData: tc.boolData,
+			}
+			header, err := NewAnimationHeader(bitReader)
+			if err != nil && !tc.expectErr {
+				t.Errorf("got error when none was expected : %v", err)
+			}
+			if err == nil && tc.expectErr {
+				t.Errorf("expected error but got none")
+			}
+			if err != nil && tc.expectErr {
+				return
+			}
+
+			if !reflect.DeepEqual(*header, tc.expectedResult) {
+				t.Errorf("expected AnimationHeader %+v, got %+v", tc.expectedResult, *header)
+			}
+		})
+	}
+}
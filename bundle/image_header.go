@@ -373,6 +373,26 @@ func (h *ImageHeader) GetSize() util.Dimension {
 	return h.Size
 }
 
+// GetPreviewImageHeader returns a copy of the image header with the size set to the
+// preview size. Preview frames are parsed and decoded relative to the preview size rather
+// than the full image size. Returns nil if the image has no preview.
+func (h *ImageHeader) GetPreviewImageHeader() *ImageHeader {
+	if h.PreviewSize == nil {
+		return nil
+	}
+
+	preview := *h
+	preview.Size = *h.PreviewSize
+	if preview.Orientation > 4 {
+		preview.OrientedWidth = preview.Size.Height
+		preview.OrientedHeight = preview.Size.Width
+	} else {
+		preview.OrientedWidth = preview.Size.Width
+		preview.OrientedHeight = preview.Size.Height
+	}
+	return &preview
+}
+
 func (h *ImageHeader) GetColourModel() int32 {
 	return h.ColourEncoding.ColourEncoding
 }
@@ -389,6 +409,22 @@ func (h *ImageHeader) HasAlpha() bool {
 	return len(h.AlphaIndices) > 0
 }
 
+// GetBlackChannelIndex returns the index of the CMYK_BLACK extra channel, or -1 if there isn't one.
+func (h *ImageHeader) GetBlackChannelIndex() int {
+	for i, eci := range h.ExtraChannelInfo {
+		if eci.EcType == CMYK_BLACK {
+			return i
+		}
+	}
+	return -1
+}
+
+// IsCMYK returns true if the image is CMYK. JXL stores CMYK as three colour channels (C, M and Y)
+// along with a CMYK_BLACK extra channel, described by a CMYK ICC profile.
+func (h *ImageHeader) IsCMYK() bool {
+	return h.ColourEncoding != nil && h.ColourEncoding.ColourEncoding != colour.CE_GRAY && h.GetBlackChannelIndex() >= 0
+}
+
 func (h *ImageHeader) GetTotalChannelCount() int {
 	return len(h.ExtraChannelInfo) + h.GetColourChannelCount()
 }
@@ -482,3 +482,111 @@ func TestGetDecodedICC(t *testing.T) {
 		})
 	}
 }
+
+func TestGetPreviewImageHeader(t *testing.T) {
+
+	for _, tc := range []struct {
+		name                   string
+		header                 ImageHeader
+		expectNil              bool
+		expectedOrientedWidth  uint32
+		expectedOrientedHeight uint32
+	}{
+		{
+			name:      "no preview",
+			header:    ImageHeader{Size: util.Dimension{Width: 100, Height: 50}},
+			expectNil: true,
+		},
+		{
+			name: "preview",
+			header: ImageHeader{
+				Size:        util.Dimension{Width: 100, Height: 50},
+				PreviewSize: &util.Dimension{Width: 20, Height: 10},
+				Orientation: 1,
+			},
+			expectedOrientedWidth:  20,
+			expectedOrientedHeight: 10,
+		},
+		{
+			name: "transposed preview",
+			header: ImageHeader{
+				Size:        util.Dimension{Width: 100, Height: 50},
+				PreviewSize: &util.Dimension{Width: 20, Height: 10},
+				Orientation: 6,
+			},
+			expectedOrientedWidth:  10,
+			expectedOrientedHeight: 20,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			preview := tc.header.GetPreviewImageHeader()
+			if tc.expectNil {
+				if preview != nil {
+					t.Errorf("expected nil preview header but got %+v", preview)
+				}
+				return
+			}
+			if preview.Size != *tc.header.PreviewSize {
+				t.Errorf("expected size %+v but got %+v", *tc.header.PreviewSize, preview.Size)
+			}
+			if preview.OrientedWidth != tc.expectedOrientedWidth || preview.OrientedHeight != tc.expectedOrientedHeight {
+				t.Errorf("expected oriented size %dx%d but got %dx%d", tc.expectedOrientedWidth, tc.expectedOrientedHeight, preview.OrientedWidth, preview.OrientedHeight)
+			}
+			if tc.header.Size.Width != 100 {
+				t.Errorf("original header modified")
+			}
+		})
+	}
+}
+
+func TestIsCMYK(t *testing.T) {
+
+	rgb := &colour.ColourEncodingBundle{ColourEncoding: colour.CE_RGB}
+	gray := &colour.ColourEncodingBundle{ColourEncoding: colour.CE_GRAY}
+	for _, tc := range []struct {
+		name          string
+		header        ImageHeader
+		expectedIndex int
+		expectedCMYK  bool
+	}{
+		{
+			name:          "RGB",
+			header:        ImageHeader{ColourEncoding: rgb},
+			expectedIndex: -1,
+		},
+		{
+			name: "RGB with alpha",
+			header: ImageHeader{
+				ColourEncoding:   rgb,
+				ExtraChannelInfo: []ExtraChannelInfo{{EcType: ALPHA}},
+			},
+			expectedIndex: -1,
+		},
+		{
+			name: "CMYK",
+			header: ImageHeader{
+				ColourEncoding:   rgb,
+				ExtraChannelInfo: []ExtraChannelInfo{{EcType: ALPHA}, {EcType: CMYK_BLACK}},
+			},
+			expectedIndex: 1,
+			expectedCMYK:  true,
+		},
+		{
+			name: "gray with black",
+			header: ImageHeader{
+				ColourEncoding:   gray,
+				ExtraChannelInfo: []ExtraChannelInfo{{EcType: CMYK_BLACK}},
+			},
+			expectedIndex: 0,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if idx := tc.header.GetBlackChannelIndex(); idx != tc.expectedIndex {
+				t.Errorf("expected black channel %d but got %d", tc.expectedIndex, idx)
+			}
+			if cmyk := tc.header.IsCMYK(); cmyk != tc.expectedCMYK {
+				t.Errorf("expected IsCMYK %v but got %v", tc.expectedCMYK, cmyk)
+			}
+		})
+	}
+}
@@ -18,7 +18,7 @@ func TestAdaptWhitePoint(t *testing.T) {
 			name:            "no targetWP not currentWP",
 			targetWP:        nil,
 			currentWP:       nil,
-			expectedResults: [][]float32{[]float32{1.065479, 0.033983506, -0.042857543}, []float32{0.04747088, 0.9720429, -0.01575847}, []float32{-0.0062496495, 0.009066325, 0.8170424}},
+			expectedResults: [][]float32{[]float32{1.0478543, 0.022905376, -0.050165102}, []float32{0.02956701, 0.9904795, -0.017062169}, []float32{-0.009241354, 0.015054692, 0.75195026}},
 			expectErr:       false,
 		},
 
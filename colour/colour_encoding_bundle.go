@@ -152,7 +152,7 @@ func getWhitePoint(whitePoint int32) *CIEXY {
 	case WP_DCI:
 		return NewCIEXY(0.314, 0.351)
 	case WP_D50:
-		return NewCIEXY(0.34567, 0.34567)
+		return NewCIEXY(0.34567, 0.35850)
 	}
 	return nil
 }
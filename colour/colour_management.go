@@ -2,6 +2,7 @@ package colour
 
 import (
 	"errors"
+	"math"
 
 	"github.com/kpfaulkner/jxl-go/util"
 )
@@ -59,6 +60,48 @@ func GetConversionMatrix(targetPrim CIEPrimaries, targetWP CIEXY, currentPrim CI
 	return res, nil
 }
 
+// GetLuminanceWeights returns the weights that give luminance (Y) from linear RGB samples
+// in the given primaries and white point.
+func GetLuminanceWeights(primaries *CIEPrimaries, whitePoint *CIEXY) ([]float32, error) {
+	if primaries == nil {
+		return nil, errors.New("no primaries for luminance weights")
+	}
+	toXYZ, err := primariesToXYZ(primaries, whitePoint)
+	if err != nil {
+		return nil, err
+	}
+	return toXYZ[1], nil
+}
+
+// GetHLGSystemGamma returns the system gamma of the HLG OOTF for a display with the given
+// peak luminance in nits, using the extended range formula from BT.2100.
+func GetHLGSystemGamma(peakLuminance float32) float64 {
+	return 1.2 * math.Pow(1.111, math.Log2(float64(peakLuminance)/1000.0))
+}
+
+// HLGOOTF maps scene linear RGB to display linear RGB (relative to the display peak) in place.
+// If inverse is set it maps display linear back to scene linear instead.
+func HLGOOTF(rgb []float32, weights []float32, gamma float64, inverse bool) {
+	var y float64
+	for c := range rgb {
+		y += float64(weights[c] * rgb[c])
+	}
+	if y <= 0 {
+		return
+	}
+
+	// Fd = Ys^(gamma-1) * Es, and for the inverse Ys = Yd^(1/gamma).
+	var scale float64
+	if inverse {
+		scale = math.Pow(y, (1.0-gamma)/gamma)
+	} else {
+		scale = math.Pow(y, gamma-1.0)
+	}
+	for c := range rgb {
+		rgb[c] = float32(float64(rgb[c]) * scale)
+	}
+}
+
 func primariesToXYZ(primaries *CIEPrimaries, wp *CIEXY) ([][]float32, error) {
 	if primaries == nil {
 		return nil, nil
@@ -123,9 +166,10 @@ func GetTransferFunction(transfer int32) (TransferFunction, error) {
 	case TF_BT709:
 		return BT709TransferFunction{}, nil
 	case TF_DCI:
-		return NewGammaTransferFunction(transfer), nil
+		// DCI is a plain 2.6 gamma, stored the same way as the gamma transfer values (1/gamma * 10^7).
+		return NewGammaTransferFunction(3846154), nil
 	case TF_HLG:
-		return nil, errors.New("Not implemented")
+		return HLGTransferFunction{}, nil
 	}
 
 	if transfer < (1 << 24) {
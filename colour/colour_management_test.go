@@ -1,6 +1,7 @@
 package colour
 
 import (
+	"math"
 	"reflect"
 	"testing"
 
@@ -91,3 +92,73 @@ func TestGetConversionMatrix(t *testing.T) {
 		})
 	}
 }
+
+func TestGetLuminanceWeights(t *testing.T) {
+
+	for _, tc := range []struct {
+		name      string
+		primaries *CIEPrimaries
+		expected  []float32
+		expectErr bool
+	}{
+		{name: "sRGB", primaries: CM_PRI_SRGB, expected: []float32{0.2126, 0.7152, 0.0722}},
+		{name: "BT2100", primaries: CM_PRI_BT2100, expected: []float32{0.2627, 0.6780, 0.0593}},
+		{name: "no primaries", primaries: nil, expectErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			weights, err := GetLuminanceWeights(tc.primaries, CM_WP_D65)
+			if tc.expectErr {
+				if err == nil {
+					t.Errorf("expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got error %v", err)
+			}
+			for i := range tc.expected {
+				if math.Abs(float64(weights[i]-tc.expected[i])) > 1e-3 {
+					t.Errorf("expected weights %v got %v", tc.expected, weights)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestGetHLGSystemGamma(t *testing.T) {
+	if res := GetHLGSystemGamma(1000); math.Abs(res-1.2) > 1e-6 {
+		t.Errorf("expected 1.2 for 1000 nits, got %f", res)
+	}
+	if res := GetHLGSystemGamma(2000); math.Abs(res-1.2*1.111) > 1e-6 {
+		t.Errorf("expected %f for 2000 nits, got %f", 1.2*1.111, res)
+	}
+}
+
+func TestHLGOOTF(t *testing.T) {
+	weights := []float32{0.2627, 0.6780, 0.0593}
+
+	// grey just gets the system gamma applied.
+	rgb := []float32{0.5, 0.5, 0.5}
+	HLGOOTF(rgb, weights, 1.2, false)
+	for c := range rgb {
+		if math.Abs(float64(rgb[c])-math.Pow(0.5, 1.2)) > 1e-5 {
+			t.Errorf("expected %f got %v", math.Pow(0.5, 1.2), rgb)
+		}
+	}
+
+	rgb = []float32{0.8, 0.2, 0.05}
+	HLGOOTF(rgb, weights, 1.2, false)
+	HLGOOTF(rgb, weights, 1.2, true)
+	for c, expected := range []float32{0.8, 0.2, 0.05} {
+		if math.Abs(float64(rgb[c]-expected)) > 1e-5 {
+			t.Errorf("round trip expected %v got %v", []float32{0.8, 0.2, 0.05}, rgb)
+		}
+	}
+
+	rgb = []float32{0, 0, 0}
+	HLGOOTF(rgb, weights, 1.2, true)
+	if rgb[0] != 0 || rgb[1] != 0 || rgb[2] != 0 {
+		t.Errorf("expected black to stay black, got %v", rgb)
+	}
+}
@@ -53,7 +53,7 @@ type PQTransferFunction struct {
 
 func (tf PQTransferFunction) ToLinear(input float64) float64 {
 	d := math.Pow(input, 0.012683313515655965121)
-	return math.Pow((d-0.8359375)/(18.8515625+18.6875*d), 6.2725880551301684533)
+	return math.Pow(math.Max(d-0.8359375, 0)/(18.8515625-18.6875*d), 6.2725880551301684533)
 }
 
 func (tf PQTransferFunction) FromLinear(input float64) float64 {
@@ -61,6 +61,31 @@ func (tf PQTransferFunction) FromLinear(input float64) float64 {
 	return math.Pow((0.8359375+18.8515625*d)/(1.0+18.6875*d), 78.84375)
 }
 
+// HLGTransferFunction is the HLG OETF and its inverse from BT.2100. It only maps between
+// the signal and scene linear light, the OOTF is applied separately (see HLGOOTF).
+type HLGTransferFunction struct {
+}
+
+const (
+	hlgA = 0.17883277
+	hlgB = 0.28466892
+	hlgC = 0.55991073
+)
+
+func (tf HLGTransferFunction) ToLinear(input float64) float64 {
+	if input <= 0.5 {
+		return input * input / 3.0
+	}
+	return (math.Exp((input-hlgC)/hlgA) + hlgB) / 12.0
+}
+
+func (tf HLGTransferFunction) FromLinear(input float64) float64 {
+	if input <= 1.0/12.0 {
+		return math.Sqrt(3.0 * math.Max(input, 0))
+	}
+	return hlgA*math.Log(12.0*input-hlgB) + hlgC
+}
+
 type GammaTransferFunction struct {
 	gamma        float64
 	inverseGamma float64
@@ -0,0 +1,70 @@
+package colour
+
+import (
+	"math"
+	"testing"
+)
+
+func TestTransferFunctionRoundTrip(t *testing.T) {
+
+	for _, tc := range []struct {
+		name     string
+		transfer int32
+	}{
+		{name: "linear", transfer: TF_LINEAR},
+		{name: "sRGB", transfer: TF_SRGB},
+		{name: "BT709", transfer: TF_BT709},
+		{name: "PQ", transfer: TF_PQ},
+		{name: "DCI", transfer: TF_DCI},
+		{name: "HLG", transfer: TF_HLG},
+		{name: "gamma 2.2", transfer: 4545455},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tf, err := GetTransferFunction(tc.transfer)
+			if err != nil {
+				t.Fatalf("got error %v", err)
+			}
+			for _, v := range []float64{0, 0.001, 0.01, 0.18, 0.5, 0.75, 1} {
+				res := tf.ToLinear(tf.FromLinear(v))
+				if math.Abs(res-v) > 1e-6 {
+					t.Errorf("round trip of %f gave %f", v, res)
+				}
+			}
+		})
+	}
+}
+
+func TestGetTransferFunctionDCI(t *testing.T) {
+	tf, err := GetTransferFunction(TF_DCI)
+	if err != nil {
+		t.Fatalf("got error %v", err)
+	}
+
+	if res := tf.ToLinear(0.5); math.Abs(res-math.Pow(0.5, 2.6)) > 1e-6 {
+		t.Errorf("expected DCI to be gamma 2.6, got %f for 0.5", res)
+	}
+}
+
+func TestHLGTransferFunction(t *testing.T) {
+	tf, err := GetTransferFunction(TF_HLG)
+	if err != nil {
+		t.Fatalf("got error %v", err)
+	}
+
+	for _, tc := range []struct {
+		signal float64
+		linear float64
+	}{
+		{signal: 0, linear: 0},
+		{signal: 0.5, linear: 1.0 / 12.0},
+		{signal: 0.75, linear: 0.26496256},
+		{signal: 1, linear: 1},
+	} {
+		if res := tf.ToLinear(tc.signal); math.Abs(res-tc.linear) > 1e-5 {
+			t.Errorf("ToLinear(%f) expected %f got %f", tc.signal, tc.linear, res)
+		}
+		if res := tf.FromLinear(tc.linear); math.Abs(res-tc.signal) > 1e-5 {
+			t.Errorf("FromLinear(%f) expected %f got %f", tc.linear, tc.signal, res)
+		}
+	}
+}
@@ -0,0 +1,401 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+
+	"github.com/kpfaulkner/jxl-go/brotli"
+	"github.com/kpfaulkner/jxl-go/jxlio"
+	"github.com/kpfaulkner/jxl-go/options"
+)
+
+// ErrNotContainer is returned by BoxIterator when the file is a bare codestream rather than a JXL container.
+var ErrNotContainer = errors.New("not a JXL container")
+
+// Box is a box of the JXL (ISOBMFF) container.
+type Box struct {
+	// Type is the four character box type, eg "jxlc" or "Exif". For a Brotli compressed (brob) box
+	// this is the type of the box it holds.
+	Type string
+
+	// Offset of the start of the box (its header) from the start of the file.
+	Offset int64
+
+	// Size of the whole box, including the header. 0 for a last box that runs to the end of the file.
+	Size uint64
+
+	// PayloadOffset is the offset from the start of the file of the first byte read from Payload.
+	// For compressed boxes it is the offset of the compressed data.
+	PayloadOffset int64
+
+	// Payload reads the box contents. For jxlp boxes the part index has already been read (into
+	// PartIndex and LastPart) so Payload is just the codestream data. For compressed boxes it reads
+	// the decompressed data. Payload is only valid until the next call to Next.
+	Payload io.Reader
+
+	// Compressed is true if the box was stored Brotli compressed in a brob box.
+	Compressed bool
+
+	// PartIndex and LastPart are the sequence index of a jxlp box and whether it is the last part
+	// of the codestream.
+	PartIndex uint32
+	LastPart  bool
+
+	tag uint64
+}
+
+// payloadSize returns the number of bytes in Payload, or 0 if the box runs to the end of the file.
+func (b *Box) payloadSize() uint64 {
+	if b.Size == 0 {
+		return 0
+	}
+	return b.Size - uint64(b.PayloadOffset-b.Offset)
+}
+
+// BoxIterator iterates over the boxes of a JXL container, checking the signature and ftyp boxes
+// and the ordering of the codestream boxes as it goes.
+type BoxIterator struct {
+	in     io.Reader
+	offset int64
+
+	// payload of the current box, so the unread part can be skipped.
+	payload     *io.LimitedReader
+	payloadSize int64
+
+	// length of the input when it can seek, or -1 if unknown.
+	length int64
+
+	// maxBoxSize is the most that a brob box can decompress to.
+	maxBoxSize int
+
+	boxCount     int
+	toEnd        bool
+	seenJXLC     bool
+	nextJXLPPart uint32
+	seenLastJXLP bool
+}
+
+// NewBoxIterator returns an iterator over the boxes read from in, which must be at the start of
+// the file. If in is also an io.Seeker unread payloads are seeked over rather than read.
+func NewBoxIterator(in io.Reader) *BoxIterator {
+	return NewBoxIteratorWithMaxBoxSize(in, options.DEFAULT_MAX_BOX_SIZE)
+}
+
+// NewBoxIteratorWithMaxBoxSize returns an iterator that rejects Brotli compressed boxes that
+// decompress to more than maxBoxSize bytes.
+func NewBoxIteratorWithMaxBoxSize(in io.Reader, maxBoxSize int) *BoxIterator {
+	return &BoxIterator{in: in, length: -1, maxBoxSize: maxBoxSize}
+}
+
+// Next returns the next box, or io.EOF once all boxes have been read. The first box is always the
+// JXL signature box and the second the ftyp box.
+func (it *BoxIterator) Next() (*Box, error) {
+
+	if it.toEnd {
+		return nil, it.checkEnd()
+	}
+	if err := it.skipPayload(); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 16)
+	_, err := io.ReadFull(it.in, header[:8])
+	if err != nil {
+		if err == io.EOF && it.boxCount > 0 {
+			return nil, it.checkEnd()
+		}
+		if it.boxCount == 0 {
+			return nil, ErrNotContainer
+		}
+		return nil, errors.New("truncated box header")
+	}
+
+	box := &Box{
+		Type:   string(header[4:8]),
+		Offset: it.offset,
+		Size:   uint64(binary.BigEndian.Uint32(header)),
+		tag:    makeTag(header, 4, 4),
+	}
+	headerSize := int64(8)
+	if box.Size == 1 {
+		if _, err = io.ReadFull(it.in, header[8:16]); err != nil {
+			return nil, errors.New("truncated box header")
+		}
+		box.Size = binary.BigEndian.Uint64(header[8:])
+		headerSize = 16
+		if box.Size < 16 {
+			return nil, errors.New("invalid box size")
+		}
+	} else if box.Size != 0 && box.Size < 8 {
+		return nil, errors.New("invalid box size")
+	}
+	if it.boxCount == 0 && (box.tag != JXL || box.Size != 12) {
+		return nil, ErrNotContainer
+	}
+	it.offset += headerSize
+	box.PayloadOffset = it.offset
+
+	if box.Size == 0 {
+		it.toEnd = true
+		it.payload = &io.LimitedReader{R: it.in, N: math.MaxInt64}
+		box.Payload = it.payload
+	} else {
+		payloadSize := box.Size - uint64(headerSize)
+		if payloadSize > 1<<62 {
+			return nil, errors.New("invalid box size")
+		}
+		if it.length < 0 {
+			it.length = it.inputLength()
+		}
+		if it.length >= 0 && uint64(it.offset)+payloadSize > uint64(it.length) {
+			return nil, errors.New("truncated box")
+		}
+		it.payload = &io.LimitedReader{R: it.in, N: int64(payloadSize)}
+		it.payloadSize = int64(payloadSize)
+		box.Payload = it.payload
+	}
+
+	if err = it.checkBox(box); err != nil {
+		return nil, err
+	}
+	it.boxCount++
+	return box, nil
+}
+
+// checkBox validates the box against its position in the file, reads the jxlp part index and
+// unwraps brob boxes.
+func (it *BoxIterator) checkBox(box *Box) error {
+
+	switch it.boxCount {
+	case 0:
+		signature := make([]byte, 4)
+		if _, err := it.readPayload(signature); err != nil || !bytes.Equal(signature, JPEGXL_CONTAINER_HEADER[8:]) {
+			return ErrNotContainer
+		}
+		box.Payload = bytes.NewReader(signature)
+		return nil
+	case 1:
+		if box.tag != FTYP {
+			return errors.New("ftyp box must follow the JXL signature")
+		}
+		if box.Size < 16 || box.Size > 1024 {
+			return errors.New("invalid ftyp box size")
+		}
+		ftyp := make([]byte, box.payloadSize())
+		if _, err := it.readPayload(ftyp); err != nil {
+			return err
+		}
+		if makeTag(ftyp, 0, 4) != JXL_BRAND {
+			return errors.New("ftyp box is not for a JXL file")
+		}
+		box.Payload = bytes.NewReader(ftyp)
+		return nil
+	}
+
+	switch box.tag {
+	case JXL, FTYP:
+		return errors.New("duplicate " + box.Type + " box")
+	case JXLC:
+		if it.seenJXLC || it.nextJXLPPart > 0 {
+			return errors.New("jxlc box can't be combined with other codestream boxes")
+		}
+		it.seenJXLC = true
+	case JXLP:
+		if it.seenJXLC {
+			return errors.New("jxlp box can't be combined with a jxlc box")
+		}
+		if it.seenLastJXLP {
+			return errors.New("jxlp box after the last jxlp box")
+		}
+		index := make([]byte, 4)
+		if _, err := it.readPayload(index); err != nil {
+			return errors.New("jxlp box too small")
+		}
+		box.PayloadOffset += 4
+		part := binary.BigEndian.Uint32(index)
+		box.PartIndex = part & 0x7FFFFFFF
+		box.LastPart = part&0x80000000 != 0
+		if box.PartIndex != it.nextJXLPPart {
+			return errors.New("jxlp boxes out of order")
+		}
+		it.nextJXLPPart++
+		it.seenLastJXLP = box.LastPart
+	case BROB:
+		inner := make([]byte, 4)
+		if _, err := it.readPayload(inner); err != nil {
+			return errors.New("brob box too small")
+		}
+		switch makeTag(inner, 0, 4) {
+		case BROB, JXL, FTYP, JXLL, JXLI, JXLC, JXLP, JBRD:
+			return errors.New("invalid brob box type")
+		}
+		box.Type = string(inner)
+		box.tag = makeTag(inner, 0, 4)
+		box.PayloadOffset += 4
+		box.Payload = &brotliPayload{compressed: it.payload, maxSize: it.maxBoxSize}
+		box.Compressed = true
+	}
+	return nil
+}
+
+// brotliPayload decompresses the payload of a brob box when it is first read, so boxes that
+// aren't wanted are skipped without being decompressed.
+type brotliPayload struct {
+	compressed io.Reader
+	maxSize    int
+	data       *bytes.Reader
+	err        error
+}
+
+func (p *brotliPayload) Read(buffer []byte) (int, error) {
+	if p.data == nil && p.err == nil {
+		compressed, err := io.ReadAll(p.compressed)
+		if err == nil {
+			var data []byte
+			if data, err = brotli.Decompress(compressed, p.maxSize); err == nil {
+				p.data = bytes.NewReader(data)
+			}
+		}
+		p.err = err
+	}
+	if p.err != nil {
+		return 0, p.err
+	}
+	return p.data.Read(buffer)
+}
+
+// readPayload reads into buffer from the payload of the current box.
+func (it *BoxIterator) readPayload(buffer []byte) (int, error) {
+	n, err := io.ReadFull(it.payload, buffer)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// skipPayload moves past whatever is left of the current box payload.
+func (it *BoxIterator) skipPayload() error {
+	if it.payload == nil {
+		return nil
+	}
+	remaining := it.payload.N
+	it.offset += it.payloadSize
+	it.payload = nil
+	if remaining <= 0 {
+		return nil
+	}
+	if s, ok := it.in.(io.Seeker); ok {
+		if _, err := s.Seek(remaining, io.SeekCurrent); err != nil {
+			return err
+		}
+	} else if _, err := io.CopyN(io.Discard, it.in, remaining); err != nil {
+		if err == io.EOF {
+			return errors.New("truncated box")
+		}
+		return err
+	}
+	return nil
+}
+
+// checkEnd is called once all boxes have been read.
+func (it *BoxIterator) checkEnd() error {
+	if it.nextJXLPPart > 0 && !it.seenLastJXLP {
+		return errors.New("missing last jxlp box")
+	}
+	return io.EOF
+}
+
+// inputLength returns the length of the input if it can seek, otherwise -1.
+func (it *BoxIterator) inputLength() int64 {
+	s, ok := it.in.(io.Seeker)
+	if !ok {
+		return -1
+	}
+	pos, err := s.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return -1
+	}
+	end, err := s.Seek(0, io.SeekEnd)
+	if err != nil {
+		return -1
+	}
+	if _, err = s.Seek(pos, io.SeekStart); err != nil {
+		return -1
+	}
+	return end - pos + it.offset
+}
+
+// bitReaderStream reads bytes from a byte aligned BitReader, so the boxes can be read with a
+// BoxIterator and the codestream with a SegmentedReader.
+type bitReaderStream struct {
+	reader jxlio.BitReader
+
+	// length of the stream, or -1 if not known yet.
+	length int64
+}
+
+func newBitReaderStream(reader jxlio.BitReader) *bitReaderStream {
+	return &bitReaderStream{reader: reader, length: -1}
+}
+
+func (s *bitReaderStream) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if r, ok := s.reader.(io.Reader); ok {
+		return r.Read(p)
+	}
+	// ReadBytesToBuffer fails short reads, so only ask for what is left.
+	end, err := s.size()
+	if err != nil {
+		return 0, err
+	}
+	pos, err := s.reader.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	if pos >= end {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > end-pos {
+		p = p[:end-pos]
+	}
+	if err = s.reader.ReadBytesToBuffer(p, uint32(len(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *bitReaderStream) Seek(offset int64, whence int) (int64, error) {
+	if whence == io.SeekEnd {
+		end, err := s.size()
+		if err != nil {
+			return 0, err
+		}
+		return s.reader.Seek(end+offset, io.SeekStart)
+	}
+	return s.reader.Seek(offset, whence)
+}
+
+// size returns the length of the stream.
+func (s *bitReaderStream) size() (int64, error) {
+	if s.length >= 0 {
+		return s.length, nil
+	}
+	pos, err := s.reader.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	end, err := s.reader.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	if _, err = s.reader.Seek(pos, io.SeekStart); err != nil {
+		return 0, err
+	}
+	s.length = end
+	return end, nil
+}
@@ -0,0 +1,423 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/kpfaulkner/jxl-go/jxlio"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// makeJXLPBox returns a jxlp box with the given part index.
+func makeJXLPBox(index uint32, last bool, data []byte) []byte {
+	if last {
+		index |= 0x80000000
+	}
+	return makeBox("jxlp", append(binary.BigEndian.AppendUint32(nil, index), data...))
+}
+
+// readBoxes returns every box from the iterator along with its payload, stopping at the first error.
+func readBoxes(it *BoxIterator) ([]Box, [][]byte, error) {
+	var boxes []Box
+	var payloads [][]byte
+	for {
+		box, err := it.Next()
+		if err != nil {
+			return boxes, payloads, err
+		}
+		payload, err := io.ReadAll(box.Payload)
+		if err != nil {
+			return boxes, payloads, err
+		}
+		box.Payload = nil
+		boxes = append(boxes, *box)
+		payloads = append(payloads, payload)
+	}
+}
+
+func TestBoxIterator(t *testing.T) {
+
+	data, err := os.ReadFile("../testdata/ants.jxl")
+	require.NoError(t, err)
+
+	it := NewBoxIterator(bytes.NewReader(data))
+	var boxes []Box
+	var exif []byte
+	for {
+		box, err := it.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		if box.Type == "jbrd" {
+			// partly read payloads are skipped.
+			_, err = box.Payload.Read(make([]byte, 10))
+			require.NoError(t, err)
+		}
+		if box.Type == "Exif" {
+			exif, err = io.ReadAll(box.Payload)
+			require.NoError(t, err)
+		}
+		box.Payload = nil
+		box.tag = 0
+		boxes = append(boxes, *box)
+	}
+
+	assert.Equal(t, []Box{
+		{Type: "JXL ", Offset: 0, Size: 12, PayloadOffset: 8},
+		{Type: "ftyp", Offset: 12, Size: 20, PayloadOffset: 20},
+		{Type: "jxlp", Offset: 32, Size: 18, PayloadOffset: 44},
+		{Type: "jbrd", Offset: 50, Size: 457, PayloadOffset: 58},
+		{Type: "Exif", Offset: 507, Size: 8252, PayloadOffset: 519, Compressed: true},
+		{Type: "jxlp", Offset: 8759, Size: 2669207, PayloadOffset: 8771, PartIndex: 1, LastPart: true},
+	}, boxes)
+
+	// the Exif box is stored in a brob box, so is decompressed. It starts with the offset of the
+	// TIFF header.
+	require.GreaterOrEqual(t, len(exif), 8)
+	assert.Equal(t, []byte{0, 0, 0, 0, 'I', 'I', '*', 0}, exif[:8])
+}
+
+func TestBoxIteratorBrotli(t *testing.T) {
+
+	// Brotli compressed "information"
+	compressed := []byte{0x1b, 0x0a, 0x00, 0xf8, 0x25, 0x00, 0x02, 0x98, 0xa8, 0x20}
+
+	for _, tc := range []struct {
+		name        string
+		box         []byte
+		maxBoxSize  int
+		expectedErr string
+	}{
+		{
+			name: "compressed box",
+			box:  makeBox("brob", append([]byte("xml "), compressed...)),
+		},
+		{
+			name:        "nested brob box",
+			box:         makeBox("brob", append([]byte("brob"), compressed...)),
+			expectedErr: "invalid brob box type",
+		},
+		{
+			name:        "codestream box",
+			box:         makeBox("brob", append([]byte("jxlp"), compressed...)),
+			expectedErr: "invalid brob box type",
+		},
+		{
+			name:        "no box type",
+			box:         makeBox("brob", []byte("xm")),
+			expectedErr: "brob box too small",
+		},
+		{
+			name:        "decompressed box too large",
+			box:         makeBox("brob", append([]byte("xml "), compressed...)),
+			maxBoxSize:  10,
+			expectedErr: "brotli: decompressed data too large",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data := makeContainer(tc.box, makeBox("jxlc", []byte{0xff, 0x0a}))
+			maxBoxSize := tc.maxBoxSize
+			if maxBoxSize == 0 {
+				maxBoxSize = 1024
+			}
+			for _, in := range []io.Reader{bytes.NewReader(data), struct{ io.Reader }{bytes.NewReader(data)}} {
+				boxes, payloads, err := readBoxes(NewBoxIteratorWithMaxBoxSize(in, maxBoxSize))
+				if tc.expectedErr != "" {
+					assert.EqualError(t, err, tc.expectedErr)
+					continue
+				}
+				assert.Equal(t, io.EOF, err)
+				require.Len(t, boxes, 4)
+				assert.Equal(t, "xml ", boxes[2].Type)
+				assert.True(t, boxes[2].Compressed)
+				assert.Equal(t, []byte("information"), payloads[2])
+				assert.Equal(t, "jxlc", boxes[3].Type)
+				assert.Equal(t, []byte{0xff, 0x0a}, payloads[3])
+			}
+		})
+	}
+}
+
+func TestBoxIteratorSizes(t *testing.T) {
+
+	largeBox := binary.BigEndian.AppendUint32(nil, 1)
+	largeBox = append(largeBox, "Exif"...)
+	largeBox = binary.BigEndian.AppendUint64(largeBox, 16+3)
+	largeBox = append(largeBox, 1, 2, 3)
+	toEndBox := append([]byte{0, 0, 0, 0}, "jxlc\xff\x0a\x01\x02"...)
+
+	data := makeContainer(largeBox, toEndBox)
+
+	for _, tc := range []struct {
+		name string
+		in   io.Reader
+	}{
+		{
+			name: "seekable",
+			in:   bytes.NewReader(data),
+		},
+		{
+			name: "not seekable",
+			in:   struct{ io.Reader }{bytes.NewReader(data)},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			boxes, payloads, err := readBoxes(NewBoxIterator(tc.in))
+			assert.Equal(t, io.EOF, err)
+			require.Len(t, boxes, 4)
+
+			assert.Equal(t, "Exif", boxes[2].Type)
+			assert.Equal(t, int64(32), boxes[2].Offset)
+			assert.Equal(t, uint64(19), boxes[2].Size)
+			assert.Equal(t, int64(48), boxes[2].PayloadOffset)
+			assert.Equal(t, []byte{1, 2, 3}, payloads[2])
+
+			assert.Equal(t, "jxlc", boxes[3].Type)
+			assert.Equal(t, int64(51), boxes[3].Offset)
+			assert.Equal(t, uint64(0), boxes[3].Size)
+			assert.Equal(t, []byte{0xff, 0x0a, 1, 2}, payloads[3])
+		})
+	}
+}
+
+func TestBoxIteratorErrors(t *testing.T) {
+
+	for _, tc := range []struct {
+		name        string
+		data        []byte
+		expectedErr error
+	}{
+		{
+			name:        "bare codestream",
+			data:        []byte{0xff, 0x0a, 0xfa, 0x1f, 0x38, 0x1d, 0x08, 0x10},
+			expectedErr: ErrNotContainer,
+		},
+		{
+			name:        "invalid signature",
+			data:        append(append([]byte{}, JPEGXL_CONTAINER_HEADER[:11]...), 0),
+			expectedErr: ErrNotContainer,
+		},
+		{
+			name: "missing ftyp",
+			data: append(append([]byte{}, JPEGXL_CONTAINER_HEADER[:]...), makeBox("jxlc", []byte{0xff, 0x0a})...),
+		},
+		{
+			name: "wrong brand",
+			data: append(append([]byte{}, JPEGXL_CONTAINER_HEADER[:]...), makeBox("ftyp", []byte("avif\x00\x00\x00\x00avif"))...),
+		},
+		{
+			name: "duplicate ftyp",
+			data: makeContainer(makeBox("ftyp", []byte("jxl \x00\x00\x00\x00jxl "))),
+		},
+		{
+			name: "invalid box size",
+			data: makeContainer([]byte{0, 0, 0, 4, 'E', 'x', 'i', 'f'}),
+		},
+		{
+			name: "invalid 64 bit box size",
+			data: makeContainer([]byte{0, 0, 0, 1, 'E', 'x', 'i', 'f', 0, 0, 0, 0, 0, 0, 0, 8}),
+		},
+		{
+			name: "truncated box header",
+			data: makeContainer([]byte{0, 0, 0, 9, 'E'}),
+		},
+		{
+			name: "truncated box",
+			data: makeContainer(makeBox("Exif", []byte{1, 2, 3})[:10]),
+		},
+		{
+			name: "jxlp out of order",
+			data: makeContainer(makeJXLPBox(1, false, []byte{0xff}), makeJXLPBox(0, true, []byte{0x0a})),
+		},
+		{
+			name: "jxlp after last",
+			data: makeContainer(makeJXLPBox(0, true, []byte{0xff}), makeJXLPBox(1, true, []byte{0x0a})),
+		},
+		{
+			name: "missing last jxlp",
+			data: makeContainer(makeJXLPBox(0, false, []byte{0xff}), makeJXLPBox(1, false, []byte{0x0a})),
+		},
+		{
+			name: "jxlp too small",
+			data: makeContainer(makeBox("jxlp", []byte{0, 0})),
+		},
+		{
+			name: "jxlc and jxlp",
+			data: makeContainer(makeBox("jxlc", []byte{0xff, 0x0a}), makeJXLPBox(0, true, nil)),
+		},
+		{
+			name: "multiple jxlc",
+			data: makeContainer(makeBox("jxlc", []byte{0xff, 0x0a}), makeBox("jxlc", nil)),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, in := range []io.Reader{bytes.NewReader(tc.data), struct{ io.Reader }{bytes.NewReader(tc.data)}} {
+				_, _, err := readBoxes(NewBoxIterator(in))
+				require.Error(t, err)
+				assert.NotEqual(t, io.EOF, err)
+				if tc.expectedErr != nil {
+					assert.Equal(t, tc.expectedErr, err)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeContainerBoxSizes(t *testing.T) {
+
+	codestream, err := os.ReadFile("../testdata/alpha-triangles.jxl")
+	require.NoError(t, err)
+	expected, err := NewJXLDecoder(bytes.NewReader(codestream), nil).Decode()
+	require.NoError(t, err)
+
+	largeBox := binary.BigEndian.AppendUint32(nil, 1)
+	largeBox = append(largeBox, "jxlc"...)
+	largeBox = binary.BigEndian.AppendUint64(largeBox, uint64(16+len(codestream)))
+
+	for _, tc := range []struct {
+		name string
+		box  []byte
+	}{
+		{
+			name: "64 bit size",
+			box:  append(largeBox, codestream...),
+		},
+		{
+			name: "runs to end of file",
+			box:  append(append([]byte{0, 0, 0, 0}, "jxlc"...), codestream...),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data := makeContainer(makeBox("Exif", []byte{0, 0, 0, 0, 'I', 'I', '*', 0}), tc.box)
+			img, err := NewJXLDecoder(bytes.NewReader(data), nil).Decode()
+			require.NoError(t, err)
+			assert.Equal(t, expected.Buffer, img.Buffer)
+		})
+	}
+}
+
+func TestReadLevelBox(t *testing.T) {
+
+	codestream, err := os.ReadFile("../testdata/alpha-triangles.jxl")
+	require.NoError(t, err)
+
+	largeBox := binary.BigEndian.AppendUint32(nil, 1)
+	largeBox = append(largeBox, "jxll"...)
+	largeBox = binary.BigEndian.AppendUint64(largeBox, 16+1)
+
+	for _, tc := range []struct {
+		name          string
+		box           []byte
+		expectedLevel int
+		expectedErr   string
+	}{
+		{
+			name:          "32 bit size",
+			box:           makeBox("jxll", []byte{10}),
+			expectedLevel: 10,
+		},
+		{
+			name:          "64 bit size",
+			box:           append(largeBox, 10),
+			expectedLevel: 10,
+		},
+		{
+			name:        "payload too large",
+			box:         makeBox("jxll", []byte{10, 0}),
+			expectedErr: "jxll box payload must be 1 byte",
+		},
+		{
+			name:        "invalid level",
+			box:         makeBox("jxll", []byte{7}),
+			expectedErr: "invalid level",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data := makeContainer(tc.box, makeBox("jxlc", codestream))
+			br := NewBoxReader(jxlio.NewBitStreamReader(bytes.NewReader(data)))
+			_, err := br.ReadBoxHeader()
+			if tc.expectedErr != "" {
+				assert.EqualError(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedLevel, br.level)
+		})
+	}
+}
+
+// splitCodestream returns a container with the codestream split into jxlp boxes of the given sizes,
+// with the last box taking whatever is left.
+func splitCodestream(codestream []byte, sizes []int) []byte {
+	var boxes [][]byte
+	for i, size := range sizes {
+		size = min(size, len(codestream))
+		boxes = append(boxes, makeJXLPBox(uint32(i), false, codestream[:size]))
+		codestream = codestream[size:]
+	}
+	boxes = append(boxes, makeJXLPBox(uint32(len(sizes)), true, codestream))
+	return makeContainer(boxes...)
+}
+
+func TestDecodeSplitCodestream(t *testing.T) {
+
+	for _, tc := range []struct {
+		name     string
+		filename string
+		sizes    []int
+	}{
+		{
+			name:     "animation split every byte",
+			filename: "../testdata/blendmodes_5.jxl",
+			sizes:    slices.Repeat([]int{1}, 144),
+		},
+		{
+			name:     "empty parts",
+			filename: "../testdata/blendmodes_5.jxl",
+			sizes:    []int{0, 3, 0, 0, 50},
+		},
+		{
+			name:     "split mid frame",
+			filename: "../testdata/lenna.jxl",
+			sizes:    []int{5, 997, 13, 20000, 7},
+		},
+		{
+			name:     "split container",
+			filename: "../testdata/tiny2.jxl",
+			sizes:    []int{3, 20, 50},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := os.ReadFile(tc.filename)
+			require.NoError(t, err)
+			expected, err := NewJXLDecoder(bytes.NewReader(data), nil).DecodeAnimation()
+			require.NoError(t, err)
+
+			// gather up the codestream of files that are already in a container.
+			codestream := data
+			if bytes.HasPrefix(data, JPEGXL_CONTAINER_HEADER[:]) {
+				codestream = nil
+				boxes, payloads, err := readBoxes(NewBoxIterator(bytes.NewReader(data)))
+				require.Equal(t, io.EOF, err)
+				for i, box := range boxes {
+					if box.Type == "jxlp" || box.Type == "jxlc" {
+						codestream = append(codestream, payloads[i]...)
+					}
+				}
+			}
+
+			anim, err := NewJXLDecoder(bytes.NewReader(splitCodestream(codestream, tc.sizes)), nil).DecodeAnimation()
+			require.NoError(t, err)
+			require.Len(t, anim.Frames, len(expected.Frames))
+			for i := range anim.Frames {
+				assert.Equal(t, expected.Frames[i].Buffer, anim.Frames[i].Buffer)
+			}
+		})
+	}
+}
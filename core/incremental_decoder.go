@@ -0,0 +1,219 @@
+package core
+
+import (
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/kpfaulkner/jxl-go/frame"
+	"github.com/kpfaulkner/jxl-go/options"
+)
+
+// EventType is the type of an Event reported by IncrementalDecoder.
+type EventType int
+
+const (
+	// EVENT_BASIC_INFO is reported once the image header (size, bit depth, extra channels etc)
+	// has been read.
+	EVENT_BASIC_INFO EventType = iota
+
+	// EVENT_COLOUR_ENCODING is reported once the colour encoding, including any ICC profile, is
+	// available. This is stored with the image header, so always follows EVENT_BASIC_INFO.
+	EVENT_COLOUR_ENCODING
+
+	// EVENT_FRAME_HEADER is reported once the header of a frame has been read.
+	EVENT_FRAME_HEADER
+
+	// EVENT_LF is reported once the LF (1:8) image of a VarDCT frame has been decoded.
+	EVENT_LF
+
+	// EVENT_PASS is reported once Pass passes of every group of a frame have been decoded.
+	EVENT_PASS
+
+	// EVENT_FRAME is reported once a frame has been decoded and blended onto the canvas.
+	EVENT_FRAME
+)
+
+// Event is reported by IncrementalDecoder as decoding progresses.
+type Event struct {
+	Type EventType
+
+	// Frame is the index of the frame in the codestream (including frames that aren't displayed)
+	// and Header its header, for the frame events.
+	Frame  int
+	Header *frame.FrameHeader
+
+	// Pass is the number of passes that have been decoded, for EVENT_PASS.
+	Pass int
+}
+
+// IncrementalDecoder decodes a JXL image (either a bare codestream or a container) as its data
+// arrives, such as when it is being downloaded. Data is pushed to the decoder as it is received,
+// and the events for whatever could be decoded are returned. At any point Flush renders the image
+// decoded so far, which allows progressive rendering. This is also useful for truncated images.
+//
+// Decoding happens in a separate goroutine, which is left waiting for more data between calls to
+// Push, so Close must be called once all of the data has been pushed.
+type IncrementalDecoder struct {
+	decoder *JXLDecoder
+
+	lock    sync.Mutex
+	cond    *sync.Cond
+	data    []byte
+	closed  bool
+	started bool
+	waiting bool
+	done    bool
+	err     error
+	events  []Event
+
+	// image is the last frame displayed.
+	image *JXLImage
+}
+
+// incrementalReader feeds the pushed data to the decoder, blocking when it needs more.
+type incrementalReader struct {
+	d *IncrementalDecoder
+}
+
+// NewIncrementalDecoder creates a decoder which has data pushed to it, rather than reading from an
+// io.Reader.
+func NewIncrementalDecoder(opts *options.JXLOptions) *IncrementalDecoder {
+	d := &IncrementalDecoder{}
+	d.cond = sync.NewCond(&d.lock)
+	d.decoder = NewJXLDecoder(&incrementalReader{d: d}, opts)
+	d.decoder.decoder.events = d.addEvent
+	return d
+}
+
+// Push adds the next chunk of data, then waits until the decoder needs more data (or has finished)
+// before returning the events for what has been decoded since the last call. Decoding errors are
+// returned once all of the events before the error have been returned. As the decoder reads ahead,
+// the final events may only be returned by Close.
+func (d *IncrementalDecoder) Push(data []byte) ([]Event, error) {
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.closed {
+		return nil, errors.New("push after close")
+	}
+	if !d.started {
+		d.started = true
+		go d.decode()
+	}
+	d.data = append(d.data, data...)
+	d.waiting = false
+	d.cond.Broadcast()
+	return d.wait()
+}
+
+// Close marks the end of the data. If the image is incomplete then the error for the truncated
+// data is returned, and Flush can still be used to get what was decoded.
+func (d *IncrementalDecoder) Close() ([]Event, error) {
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.closed = true
+	if !d.started {
+		return nil, io.ErrUnexpectedEOF
+	}
+	d.waiting = false
+	d.cond.Broadcast()
+	return d.wait()
+}
+
+// Flush returns a best effort rendering of the image decoded so far, similar to libjxl's
+// JxlDecoderFlushImage. For a still image this starts as the upsampled LF image of a VarDCT image,
+// which is replaced by each group as its passes are decoded. Once a frame has been decoded it is
+// the composited frame, in the same way as Decode. Returns an error if nothing has been decoded.
+// Must not be called concurrently with Push or Close.
+func (d *IncrementalDecoder) Flush() (*JXLImage, error) {
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.done && d.err == nil {
+		if d.image == nil {
+			return nil, errNoImageData
+		}
+		return d.image, nil
+	}
+	return d.decoder.decoder.flushImage()
+}
+
+// Done returns true once the whole image has been decoded.
+func (d *IncrementalDecoder) Done() bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.done && d.err == nil
+}
+
+// wait waits (with the lock held) for the decoder to need more data or finish, and returns the
+// events since the last call. waiting is only set by the decoder, and cleared when there is more
+// data (or the data has ended).
+func (d *IncrementalDecoder) wait() ([]Event, error) {
+	for !d.done && !(d.waiting && len(d.data) == 0) {
+		d.cond.Wait()
+	}
+	events := d.events
+	d.events = nil
+	if d.done {
+		return events, d.err
+	}
+	return events, nil
+}
+
+func (d *IncrementalDecoder) decode() {
+
+	err := d.decoder.decoder.decodeFrames(func(img *JXLImage, header *frame.FrameHeader) (bool, error) {
+		d.lock.Lock()
+		defer d.lock.Unlock()
+		d.image = img
+		return true, nil
+	}, false)
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	d.done = true
+	d.err = err
+	d.cond.Broadcast()
+}
+
+func (d *IncrementalDecoder) addEvent(event Event) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.events = append(d.events, event)
+}
+
+func (r *incrementalReader) Read(p []byte) (int, error) {
+
+	d := r.d
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for len(d.data) == 0 {
+		if d.closed {
+			return 0, io.EOF
+		}
+
+		// the groups already queued are decoded before reporting that more data is needed, so
+		// that nothing is being decoded in the background while waiting.
+		if f := d.decoder.decoder.currentFrame; f != nil {
+			d.lock.Unlock()
+			f.WaitIdle()
+			d.lock.Lock()
+			if len(d.data) > 0 || d.closed {
+				continue
+			}
+		}
+		d.waiting = true
+		d.cond.Broadcast()
+		for d.waiting {
+			d.cond.Wait()
+		}
+	}
+	n := copy(p, d.data)
+	d.data = d.data[n:]
+	return n, nil
+}
@@ -0,0 +1,172 @@
+package core
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func pushChunks(t *testing.T, d *IncrementalDecoder, data []byte, chunkSize int) []Event {
+
+	var events []Event
+	for len(data) > 0 {
+		n := min(chunkSize, len(data))
+		e, err := d.Push(data[:n])
+		require.NoError(t, err)
+		events = append(events, e...)
+		data = data[n:]
+	}
+	return events
+}
+
+// groupDiff returns the mean difference between the colour channels of two images over the
+// 256x256 group at x0, y0.
+func groupDiff(a *JXLImage, b *JXLImage, x0 int, y0 int) float64 {
+
+	diff := float64(0)
+	count := 0
+	for c := 0; c < 3; c++ {
+		for y := y0; y < min(y0+256, int(a.Height)); y++ {
+			for x := x0; x < min(x0+256, int(a.Width)); x++ {
+				d := float64(a.Buffer[c].FloatBuffer[y][x] - b.Buffer[c].FloatBuffer[y][x])
+				diff += max(d, -d)
+				count++
+			}
+		}
+	}
+	return diff / float64(count)
+}
+
+func TestIncrementalDecoder(t *testing.T) {
+
+	for _, filename := range []string{
+		"../testdata/tiny2.jxl",
+		"../testdata/lenna.jxl",
+		"../testdata/church.jxl",
+		"../testdata/patches.jxl",
+		"../testdata/blendmodes_5.jxl",
+	} {
+		t.Run(filename, func(t *testing.T) {
+			data, err := os.ReadFile(filename)
+			require.NoError(t, err)
+			expected, err := NewJXLDecoder(bytes.NewReader(data), nil).Decode()
+			require.NoError(t, err)
+
+			d := NewIncrementalDecoder(nil)
+			events := pushChunks(t, d, data, 4096)
+			e, err := d.Close()
+			require.NoError(t, err)
+			events = append(events, e...)
+			assert.True(t, d.Done())
+
+			require.GreaterOrEqual(t, len(events), 4)
+			assert.Equal(t, EVENT_BASIC_INFO, events[0].Type)
+			assert.Equal(t, EVENT_COLOUR_ENCODING, events[1].Type)
+			assert.Equal(t, EVENT_FRAME_HEADER, events[2].Type)
+			assert.Equal(t, EVENT_FRAME, events[len(events)-1].Type)
+
+			img, err := d.Flush()
+			require.NoError(t, err)
+			assert.Equal(t, expected.Buffer, img.Buffer)
+		})
+	}
+}
+
+func TestIncrementalDecoderEvents(t *testing.T) {
+
+	data, err := os.ReadFile("../testdata/lenna.jxl")
+	require.NoError(t, err)
+	d := NewIncrementalDecoder(nil)
+	events := pushChunks(t, d, data, 1000)
+	e, err := d.Close()
+	require.NoError(t, err)
+	events = append(events, e...)
+
+	var types []EventType
+	for _, event := range events {
+		types = append(types, event.Type)
+		if event.Type >= EVENT_FRAME_HEADER {
+			assert.Equal(t, 0, event.Frame)
+			assert.NotNil(t, event.Header)
+		}
+	}
+	assert.Equal(t, []EventType{EVENT_BASIC_INFO, EVENT_COLOUR_ENCODING, EVENT_FRAME_HEADER, EVENT_LF,
+		EVENT_PASS, EVENT_FRAME}, types)
+	assert.Equal(t, 1, events[4].Pass)
+}
+
+func TestIncrementalDecoderTruncated(t *testing.T) {
+
+	data, err := os.ReadFile("../testdata/lenna.jxl")
+	require.NoError(t, err)
+	expected, err := NewJXLDecoder(bytes.NewReader(data), nil).Decode()
+	require.NoError(t, err)
+
+	d := NewIncrementalDecoder(nil)
+	_, err = d.Flush()
+	assert.Error(t, err)
+
+	events := pushChunks(t, d, data[:len(data)/2], 4096)
+	require.NotEmpty(t, events)
+	assert.Equal(t, EVENT_LF, events[len(events)-1].Type)
+
+	// the LF image, with whichever groups have been decoded. Half of the file has the first of
+	// the four groups, which only differs from the full decode by the restoration filters, while
+	// the last group is still the upsampled LF image.
+	img, err := d.Flush()
+	require.NoError(t, err)
+	assert.Equal(t, expected.Width, img.Width)
+	assert.Equal(t, expected.Height, img.Height)
+	assert.Equal(t, len(expected.Buffer), len(img.Buffer))
+	assert.Less(t, groupDiff(expected, img, 0, 0), 0.01)
+	assert.Greater(t, groupDiff(expected, img, 256, 256), 0.02)
+
+	_, err = d.Close()
+	assert.Error(t, err)
+	assert.False(t, d.Done())
+	_, err = d.Push(data[len(data)/2:])
+	assert.Error(t, err)
+
+	// the decoded groups are still there after the error.
+	img, err = d.Flush()
+	require.NoError(t, err)
+	assert.Equal(t, expected.Width, img.Width)
+	assert.Less(t, groupDiff(expected, img, 0, 0), 0.01)
+}
+
+// TestIncrementalDecoderCorruptGroup checks that a pass group that fails to decode fails the
+// frame, rather than the group being left blank.
+func TestIncrementalDecoderCorruptGroup(t *testing.T) {
+
+	data, err := os.ReadFile("../testdata/lenna.jxl")
+	require.NoError(t, err)
+	// corrupts the HF coefficients of the second group.
+	corrupt := append([]byte{}, data...)
+	for i := 20000; i < 20008; i++ {
+		corrupt[i] ^= 0x5a
+	}
+
+	_, err = NewJXLDecoder(bytes.NewReader(corrupt), nil).Decode()
+	assert.Error(t, err)
+
+	d := NewIncrementalDecoder(nil)
+	_, err = d.Push(corrupt)
+	if err == nil {
+		_, err = d.Close()
+	}
+	assert.Error(t, err)
+	assert.False(t, d.Done())
+}
+
+func TestIncrementalDecoderEmpty(t *testing.T) {
+
+	d := NewIncrementalDecoder(nil)
+	_, err := d.Close()
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	_, err = d.Flush()
+	assert.Error(t, err)
+}
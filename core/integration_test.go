@@ -4,11 +4,16 @@ import (
 	"crypto/md5"
 	"encoding/binary"
 	"fmt"
+	"image"
+	"io"
 	"math"
 	"testing"
 
+	"github.com/kpfaulkner/jxl-go/bundle"
+	"github.com/kpfaulkner/jxl-go/frame"
 	"github.com/kpfaulkner/jxl-go/options"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func hashImage(img *JXLImage) string {
@@ -73,10 +78,26 @@ func TestIntegrationDecodes(t *testing.T) {
 			filename:     "../testdata/lenna.jxl",
 			expectedHash: "e2cd199b6f3e838dfe1faee7634ecf01",
 		},
+		{
+			filename:     "../testdata/patches-lossless.jxl",
+			expectedHash: "48485a996e89c5940747a7745573f72e",
+		},
+		{
+			filename:     "../testdata/wb-rainbow.jxl",
+			expectedHash: "087f410920f102f5e4dda6f5fe550879",
+		},
 		{
 			filename:     "../testdata/church.jxl",
 			expectedHash: "51e3fffb70989be99282fefeb289cffe",
 		},
+		{
+			filename:     "../testdata/patches.jxl",
+			expectedHash: "82986c7048cdd94827fd342eeb673b84",
+		},
+		{
+			filename:     "../testdata/bbb.jxl",
+			expectedHash: "279422cb60fc9d2c6f35ec3f3d153a53",
+		},
 	}
 
 	for _, tc := range testCases {
@@ -98,3 +119,156 @@ func TestIntegrationDecodes(t *testing.T) {
 		})
 	}
 }
+
+// TestIntegrationPatches checks patches against an independent encoding of the same image.
+// patches.jxl (lossy VarDCT) and patches-lossless.jxl (modular) are encodings of the same
+// screenshot, both drawing the repeated text with patches, so a patch that is missing,
+// misplaced or wrongly blended in either decode shows up as a large difference between them.
+func TestIntegrationPatches(t *testing.T) {
+
+	decode := func(filename string) image.Image {
+		decoder := NewJXLCodestreamDecoder(GenerateTestBitReader(t, filename), options.NewJXLOptions(nil))
+		jxlImage, err := decoder.decode()
+		require.NoError(t, err)
+		img, err := jxlImage.ToImage()
+		require.NoError(t, err)
+		return img
+	}
+	lossy := decode("../testdata/patches.jxl")
+	lossless := decode("../testdata/patches-lossless.jxl")
+	require.Equal(t, lossless.Bounds(), lossy.Bounds())
+
+	var squaredError [4]float64
+	bounds := lossless.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r1, g1, b1, a1 := lossy.At(x, y).RGBA()
+			r2, g2, b2, a2 := lossless.At(x, y).RGBA()
+			for c, v := range [][2]uint32{{r1, r2}, {g1, g2}, {b1, b2}, {a1, a2}} {
+				d := (float64(v[0]) - float64(v[1])) / 0xffff
+				squaredError[c] += d * d
+			}
+		}
+	}
+	pixels := float64(bounds.Dx() * bounds.Dy())
+	for c := 0; c < 3; c++ {
+		psnr := 10 * math.Log10(pixels/squaredError[c])
+		assert.Greater(t, psnr, 40.0, "channel %d", c)
+	}
+	// alpha is stored losslessly in both.
+	assert.Zero(t, squaredError[3])
+}
+
+// TestIntegrationExtraChannelDimShift decodes upsampling-dimshift.jxl, which is upsampling.jxl
+// with a dim_shift of 1 on its alpha channel and the ec_upsampling of the frame halved to
+// compensate. The frame data is unchanged, so the alpha is stored at the same size, is
+// upsampled by the same factor and decodes to the same image.
+func TestIntegrationExtraChannelDimShift(t *testing.T) {
+
+	decode := func(filename string) *JXLImage {
+		decoder := NewJXLCodestreamDecoder(GenerateTestBitReader(t, filename), options.NewJXLOptions(nil))
+		img, err := decoder.decode()
+		require.NoError(t, err)
+		return img
+	}
+	original := decode("../testdata/upsampling.jxl")
+	shifted := decode("../testdata/upsampling-dimshift.jxl")
+	require.Len(t, shifted.imageHeader.ExtraChannelInfo, 1)
+	assert.Equal(t, int32(1), shifted.imageHeader.ExtraChannelInfo[0].DimShift)
+	assert.Equal(t, original.Width, shifted.Width)
+	assert.Equal(t, original.Height, shifted.Height)
+	assert.Equal(t, hashImage(original), hashImage(shifted))
+}
+
+// TestIntegrationTransformTypes checks the inverse transform of each varblock type against
+// the original pixels. patches.jxl is lossy, so each type is compared to patches-lossless.jxl
+// over the blocks that use it, with a minimum PSNR a couple of dB below the decode of the
+// file. A transposed, flipped or misscaled transform drops the PSNR of its blocks by 8dB or
+// more. patches.jxl doesn't use DCT 8x32/32x8 or the DCTs of 128 and 256 samples, which are
+// only checked against the DCT definition in the frame tests.
+func TestIntegrationTransformTypes(t *testing.T) {
+
+	minPSNR := map[string]float64{
+		"DCT 8x8":   36,
+		"DCT 2x2":   38,
+		"DCT 4x4":   38,
+		"DCT 4x8":   31,
+		"DCT 8x4":   33,
+		"Hornuss":   38,
+		"AFV0":      35,
+		"AFV1":      35,
+		"AFV2":      35,
+		"AFV3":      35,
+		"DCT 16x16": 47,
+		"DCT 8x16":  37,
+		"DCT 16x8":  37,
+		"DCT 32x32": 49,
+		"DCT 16x32": 46,
+		"DCT 32x16": 44,
+		"DCT 64x64": 50,
+		"DCT 32x64": 48,
+		"DCT 64x32": 49,
+	}
+
+	// patches.jxl is a bare codestream with the patch dictionary frame followed by the
+	// VarDCT frame.
+	decoder := NewJXLCodestreamDecoder(GenerateTestBitReader(t, "../testdata/patches.jxl"), options.NewJXLOptions(nil))
+	require.NoError(t, decoder.ReadSignatureAndBoxes())
+	_, err := decoder.bitReader.Seek(decoder.boxHeaders[0].Offset, io.SeekStart)
+	require.NoError(t, err)
+	imageHeader, err := bundle.ParseImageHeader(decoder.bitReader, int32(decoder.level))
+	require.NoError(t, err)
+	var vardctFrame *frame.Frame
+	for vardctFrame == nil {
+		imgFrame := frame.NewFrameWithReader(decoder.bitReader, imageHeader, &decoder.options)
+		header, err := imgFrame.ReadFrameHeader()
+		require.NoError(t, err)
+		require.NoError(t, imgFrame.ReadTOC())
+		if header.Encoding != frame.VARDCT {
+			require.NoError(t, imgFrame.SkipFrameData())
+			continue
+		}
+		require.NoError(t, imgFrame.DecodeFrame(nil, frame.NewLFGlobalWithReader))
+		vardctFrame = imgFrame
+	}
+
+	decoder = NewJXLCodestreamDecoder(GenerateTestBitReader(t, "../testdata/patches.jxl"), options.NewJXLOptions(nil))
+	jxlImage, err := decoder.decode()
+	require.NoError(t, err)
+	lossy, err := jxlImage.ToImage()
+	require.NoError(t, err)
+
+	decoder = NewJXLCodestreamDecoder(GenerateTestBitReader(t, "../testdata/patches-lossless.jxl"), options.NewJXLOptions(nil))
+	jxlImage, err = decoder.decode()
+	require.NoError(t, err)
+	lossless, err := jxlImage.ToImage()
+	require.NoError(t, err)
+	bounds := lossless.Bounds()
+
+	squaredError := map[string]float64{}
+	samples := map[string]int{}
+	for _, block := range vardctFrame.BlockTransforms() {
+		r := image.Rect(int(block.Bounds.Origin.X), int(block.Bounds.Origin.Y),
+			int(block.Bounds.Origin.X)+int(block.Bounds.Size.Width), int(block.Bounds.Origin.Y)+int(block.Bounds.Size.Height)).Intersect(bounds)
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				r1, g1, b1, _ := lossy.At(x, y).RGBA()
+				r2, g2, b2, _ := lossless.At(x, y).RGBA()
+				for _, v := range [][2]uint32{{r1, r2}, {g1, g2}, {b1, b2}} {
+					d := (float64(v[0]) - float64(v[1])) / 0xffff
+					squaredError[block.Name] += d * d
+					samples[block.Name]++
+				}
+			}
+		}
+	}
+
+	for name, minimum := range minPSNR {
+		require.NotZero(t, samples[name], "no %s blocks", name)
+		psnr := 10 * math.Log10(float64(samples[name])/squaredError[name])
+		assert.Greater(t, psnr, minimum, name)
+	}
+	for name := range samples {
+		assert.Contains(t, minPSNR, name)
+	}
+}
@@ -0,0 +1,95 @@
+package core
+
+import (
+	"errors"
+	"io"
+
+	"github.com/kpfaulkner/jxl-go/frame"
+	"github.com/kpfaulkner/jxl-go/jpeg"
+)
+
+var errNoJPEGData = errors.New("image does not contain JPEG reconstruction data")
+
+// reconstructJPEG decodes the coefficients of the (single) VarDCT frame and combines them with
+// the jbrd box to write out the original JPEG.
+func (jxl *JXLCodestreamDecoder) reconstructJPEG(w io.Writer) error {
+
+	jxl.keepJPEGCoefficients = true
+	err := jxl.decodeFrames(func(img *JXLImage, header *frame.FrameHeader) (bool, error) {
+		return false, nil
+	}, false)
+	if err != nil {
+		return err
+	}
+	if err = jxl.readRemainingBoxes(); err != nil {
+		return err
+	}
+	if jxl.jpegData == nil {
+		return errNoJPEGData
+	}
+	jc := jxl.jpegCoefficients
+	if jc == nil {
+		return errors.New("image does not contain JPEG coefficients")
+	}
+
+	jd, err := jpeg.ReadJPEGData(jxl.jpegData)
+	if err != nil {
+		return err
+	}
+	jd.Width = jxl.imageHeader.Size.Width
+	jd.Height = jxl.imageHeader.Size.Height
+
+	// JXL channel for each JPEG component. JXL keeps YCbCr as Cb, Y, Cr.
+	var channels []int
+	switch {
+	case len(jd.Components) == 1:
+		channels = []int{1}
+	case len(jd.Components) == 3 && jc.YCbCr:
+		channels = []int{1, 0, 2}
+	case len(jd.Components) == 3:
+		channels = []int{0, 1, 2}
+	default:
+		return errors.New("only 1 or 3 component JPEGs can be reconstructed")
+	}
+	for i := range jd.Components {
+		comp := &jd.Components[i]
+		c := channels[i]
+		if int(comp.QuantIndex) >= len(jd.Quant) {
+			return errors.New("invalid JPEG quantisation table index")
+		}
+		comp.HSampFactor = jc.HSampFactor[c]
+		comp.VSampFactor = jc.VSampFactor[c]
+		comp.WidthInBlocks = jc.WidthInBlocks[c]
+		comp.HeightInBlocks = jc.HeightInBlocks[c]
+		comp.Coeffs = jc.Coeffs[c]
+		jd.Quant[comp.QuantIndex].Values = jc.QuantTables[c]
+	}
+
+	if jd.HasAppMarker(jpeg.APP_MARKER_ICC) {
+		icc, err := jxl.imageHeader.GetDecodedICC()
+		if err != nil {
+			return err
+		}
+		if err = jd.SetICC(icc); err != nil {
+			return err
+		}
+	}
+	if jd.HasAppMarker(jpeg.APP_MARKER_EXIF) {
+		if jxl.metadata.Exif == nil {
+			return errors.New("missing Exif box for JPEG reconstruction")
+		}
+		if err = jd.SetExif(jxl.metadata.Exif.exif); err != nil {
+			return err
+		}
+	}
+	if jd.HasAppMarker(jpeg.APP_MARKER_XMP) {
+		if jxl.metadata.XMP == nil {
+			return errors.New("missing XMP box for JPEG reconstruction")
+		}
+		if err = jd.SetXMP(jxl.metadata.XMP.Data); err != nil {
+			return err
+		}
+	}
+
+	return jd.Write(w)
+}
@@ -0,0 +1,211 @@
+package core
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// jpegPlanePSNR compares the Y, Cb and Cr planes of a decoded JPEG with the pixels decoded
+// from the JXL, converted back to YCbCr. The chroma of the JPEG is compared at its own
+// resolution, so doesn't include the differences between the chroma upsampling of the two.
+func jpegPlanePSNR(jxlImage *JXLImage, jpg *image.YCbCr) [3]float64 {
+
+	var squaredError [3]float64
+	bounds := jpg.Bounds()
+	// the JXL is oriented, the only orientation used by the test files transposes the JPEG.
+	transposed := int(jxlImage.Buffer[0].Width) != bounds.Dx()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			jx, jy := x, y
+			if transposed {
+				jx, jy = y, x
+			}
+			r := 255 * float64(jxlImage.Buffer[0].FloatBuffer[jy][jx])
+			g := 255 * float64(jxlImage.Buffer[1].FloatBuffer[jy][jx])
+			b := 255 * float64(jxlImage.Buffer[2].FloatBuffer[jy][jx])
+			actual := [3]float64{
+				0.299*r + 0.587*g + 0.114*b,
+				-0.168736*r - 0.331264*g + 0.5*b + 128,
+				0.5*r - 0.418688*g - 0.081312*b + 128,
+			}
+			expected := [3]uint8{jpg.Y[jpg.YOffset(x, y)], jpg.Cb[jpg.COffset(x, y)], jpg.Cr[jpg.COffset(x, y)]}
+			for c := 0; c < 3; c++ {
+				d := min(max(actual[c], 0), 255) - float64(expected[c])
+				squaredError[c] += d * d
+			}
+		}
+	}
+	var psnr [3]float64
+	pixels := float64(bounds.Dx() * bounds.Dy())
+	for c := 0; c < 3; c++ {
+		psnr[c] = 10 * math.Log10(255*255*pixels/squaredError[c])
+	}
+	return psnr
+}
+
+// TestReconstructJPEG checks the reconstructed JPEGs against the pixels decoded from the JXL.
+// JXL decodes the JPEG coefficients with its own quantisation bias rather than the exact
+// JPEG dequantisation, so the planes differ slightly, most for the coarsely quantised cafe.jxl.
+// A wrong quantisation table, component or coefficient order drops the PSNR by 10dB or more.
+// The original JPEGs of these files aren't in testdata, so the md5 of each reconstruction is checked to catch
+// changes in the parts of the output that aren't pixels, such as markers and padding.
+func TestReconstructJPEG(t *testing.T) {
+
+	for _, tc := range []struct {
+		name        string
+		filename    string
+		width       int
+		height      int
+		progressive bool
+		minPSNR     [3]float64
+		md5         string
+	}{
+		{
+			name:     "4:2:0 with ICC profile",
+			filename: "../testdata/cafe.jxl",
+			width:    1280,
+			height:   1600,
+			minPSNR:  [3]float64{45, 36, 36},
+			md5:      "46bc34d959da7a1b381f5034c63613ae",
+		},
+		{
+			name:        "progressive split codestream",
+			filename:    "../testdata/church.jxl",
+			width:       1623,
+			height:      1080,
+			progressive: true,
+			minPSNR:     [3]float64{55, 52, 52},
+			md5:         "82b3b7e30d878d645571a3a06ae6d043",
+		},
+		{
+			name:     "Brotli compressed Exif",
+			filename: "../testdata/ants.jxl",
+			width:    3264,
+			height:   2448,
+			minPSNR:  [3]float64{55, 48, 52},
+			md5:      "1107bd0b2d25ebfdaad0bd93dd9645cb",
+		},
+		{
+			name:     "Exif and chroma from luma",
+			filename: "../testdata/bench.jxl",
+			width:    500,
+			height:   606,
+			minPSNR:  [3]float64{50, 45, 44},
+			md5:      "e89042df720971c4510c1c013f08e1ef",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := os.ReadFile(tc.filename)
+			require.NoError(t, err)
+
+			var buf bytes.Buffer
+			err = NewJXLDecoder(bytes.NewReader(data), nil).ReconstructJPEG(&buf)
+			require.NoError(t, err)
+
+			out := buf.Bytes()
+			assert.Equal(t, []byte{0xFF, 0xD8}, out[:2])
+			assert.Equal(t, []byte{0xFF, 0xD9}, out[len(out)-2:])
+			assert.Equal(t, tc.progressive, bytes.Contains(out, []byte{0xFF, 0xC2}))
+
+			assert.Equal(t, tc.md5, fmt.Sprintf("%x", md5.Sum(out)))
+
+			img, err := jpeg.Decode(bytes.NewReader(out))
+			require.NoError(t, err)
+			assert.Equal(t, tc.width, img.Bounds().Dx())
+			assert.Equal(t, tc.height, img.Bounds().Dy())
+			ycc, ok := img.(*image.YCbCr)
+			require.True(t, ok)
+
+			jxlImage, err := NewJXLDecoder(bytes.NewReader(data), nil).Decode()
+			require.NoError(t, err)
+			psnr := jpegPlanePSNR(jxlImage, ycc)
+			for c, name := range []string{"Y", "Cb", "Cr"} {
+				assert.Greater(t, psnr[c], tc.minPSNR[c], name)
+			}
+		})
+	}
+}
+
+// TestReconstructJPEGOriginal checks reconstruction against the original JPEGs, which are the
+// video-001 test images of the Go image package. Their JXLs were made with a minimal encoder
+// that keeps the coefficients and uses a flat prefix code, as cjxl isn't available here.
+func TestReconstructJPEGOriginal(t *testing.T) {
+
+	for _, tc := range []struct {
+		name     string
+		filename string
+	}{
+		{
+			name:     "4:4:4",
+			filename: "../testdata/video-001-444",
+		},
+		{
+			name:     "4:2:0 with restart markers",
+			filename: "../testdata/video-001-restart",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := os.ReadFile(tc.filename + ".jxl")
+			require.NoError(t, err)
+			original, err := os.ReadFile(tc.filename + ".jpg")
+			require.NoError(t, err)
+
+			var buf bytes.Buffer
+			err = NewJXLDecoder(bytes.NewReader(data), nil).ReconstructJPEG(&buf)
+			require.NoError(t, err)
+			assert.True(t, bytes.Equal(original, buf.Bytes()))
+		})
+	}
+}
+
+func TestReconstructJPEGExif(t *testing.T) {
+
+	data, err := os.ReadFile("../testdata/bench.jxl")
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	err = NewJXLDecoder(bytes.NewReader(data), nil).ReconstructJPEG(&buf)
+	require.NoError(t, err)
+
+	// the 102 byte Exif box follows the ftyp box. Its payload starts with a 4 byte offset that
+	// isn't part of the APP1 marker.
+	boxStart := 32
+	require.Equal(t, []byte("Exif"), data[boxStart+4:boxStart+8])
+	exif := data[boxStart+8+4 : boxStart+102]
+	idx := bytes.Index(buf.Bytes(), []byte("Exif\x00\x00"))
+	require.Greater(t, idx, 0)
+	assert.Equal(t, exif, buf.Bytes()[idx+6:idx+6+len(exif)])
+
+	// with a TIFF header offset the bytes before the TIFF header are still part of the marker,
+	// so the same JPEG is reconstructed.
+	shifted := bytes.Clone(data)
+	binary.BigEndian.PutUint32(shifted[boxStart+8:], 4)
+	metadata, err := NewJXLDecoder(bytes.NewReader(shifted), nil).GetMetadata()
+	require.NoError(t, err)
+	assert.Equal(t, exif[4:], metadata.Exif.Data)
+	var shiftedBuf bytes.Buffer
+	err = NewJXLDecoder(bytes.NewReader(shifted), nil).ReconstructJPEG(&shiftedBuf)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(buf.Bytes(), shiftedBuf.Bytes()))
+}
+
+func TestReconstructJPEGNotJPEG(t *testing.T) {
+
+	data, err := os.ReadFile("../testdata/tiny2.jxl")
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	err = NewJXLDecoder(bytes.NewReader(data), nil).ReconstructJPEG(&buf)
+	assert.ErrorIs(t, err, errNoJPEGData)
+	assert.Zero(t, buf.Len())
+}
@@ -0,0 +1,56 @@
+package core
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// JXLAnimation holds the composited frames of an animated JXL image. This is similar
+// to image/gif.GIF where Frames[i] is displayed for Durations[i] ticks.
+// Still images decode to a single frame with a duration of 0.
+type JXLAnimation struct {
+	Frames []*JXLImage
+
+	// Durations of each frame, in ticks. See TickDuration
+	Durations []uint32
+
+	// Timecodes of each frame (SMPTE format), only populated if the animation header has
+	// timecodes, otherwise nil.
+	Timecodes []uint32
+
+	// ticks per second is TpsNumerator / TpsDenominator
+	TpsNumerator   uint32
+	TpsDenominator uint32
+
+	// LoopCount is the number of times the animation should be played. 0 means loop forever.
+	LoopCount uint32
+}
+
+// TickDuration returns the length of a single tick. Returns 0 for still images.
+func (a *JXLAnimation) TickDuration() time.Duration {
+	if a.TpsNumerator == 0 {
+		return 0
+	}
+	return time.Duration(int64(time.Second) * int64(a.TpsDenominator) / int64(a.TpsNumerator))
+}
+
+// Delay returns how long frame i should be displayed for. Durations too long to be
+// represented by a time.Duration (around 292 years) are clamped to the maximum.
+func (a *JXLAnimation) Delay(i int) time.Duration {
+	if a.TpsNumerator == 0 || i < 0 || i >= len(a.Durations) {
+		return 0
+	}
+
+	// duration * denominator fits in 64 bits, but multiplying by the nanoseconds in a
+	// second can overflow so the full product is kept as 128 bits for the division.
+	hi, lo := bits.Mul64(uint64(a.Durations[i])*uint64(a.TpsDenominator), uint64(time.Second))
+	if hi >= uint64(a.TpsNumerator) {
+		return math.MaxInt64
+	}
+	nanos, _ := bits.Div64(hi, lo, uint64(a.TpsNumerator))
+	if nanos > math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return time.Duration(nanos)
+}
@@ -0,0 +1,101 @@
+package core
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+	"math"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/kpfaulkner/jxl-go/options"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJXLAnimationDelay(t *testing.T) {
+	anim := &JXLAnimation{
+		Durations:      []uint32{0, 1, 30},
+		TpsNumerator:   1000,
+		TpsDenominator: 1,
+	}
+	assert.Equal(t, time.Millisecond, anim.TickDuration())
+	assert.Equal(t, time.Duration(0), anim.Delay(0))
+	assert.Equal(t, time.Millisecond, anim.Delay(1))
+	assert.Equal(t, 30*time.Millisecond, anim.Delay(2))
+	assert.Equal(t, time.Duration(0), anim.Delay(3))
+
+	ntsc := &JXLAnimation{Durations: []uint32{1}, TpsNumerator: 30000, TpsDenominator: 1001}
+	assert.Equal(t, 33366666*time.Nanosecond, ntsc.Delay(0))
+
+	// 2^24 ticks * 1001 * 1e9 overflows int64 if multiplied before dividing.
+	long := &JXLAnimation{Durations: []uint32{1 << 24, math.MaxUint32}, TpsNumerator: 30000, TpsDenominator: 1001}
+	assert.Equal(t, time.Duration(559799773866666), long.Delay(0))
+	assert.Equal(t, time.Duration(143308742076500000), long.Delay(1))
+	slow := &JXLAnimation{Durations: []uint32{math.MaxUint32}, TpsNumerator: 1, TpsDenominator: 1024}
+	assert.Equal(t, time.Duration(math.MaxInt64), slow.Delay(0))
+
+	still := &JXLAnimation{Durations: []uint32{0}}
+	assert.Equal(t, time.Duration(0), still.TickDuration())
+	assert.Equal(t, time.Duration(0), still.Delay(0))
+}
+
+func TestDecodeAnimationStillImage(t *testing.T) {
+	decoder := NewJXLCodestreamDecoder(GenerateTestBitReader(t, "../testdata/tiny2.jxl"), options.NewJXLOptions(nil))
+	anim, err := decoder.decodeAnimation()
+	require.NoError(t, err)
+	require.NotNil(t, anim)
+	require.Len(t, anim.Frames, 1)
+	assert.Equal(t, []uint32{0}, anim.Durations)
+	assert.Equal(t, uint32(0), anim.TpsNumerator)
+	assert.Nil(t, anim.Timecodes)
+	animHash := hashImage(anim.Frames[0])
+
+	decoder = NewJXLCodestreamDecoder(GenerateTestBitReader(t, "../testdata/tiny2.jxl"), options.NewJXLOptions(nil))
+	img, err := decoder.decode()
+	require.NoError(t, err)
+	assert.Equal(t, hashImage(img), animHash)
+	assert.Equal(t, animHash, hashImage(anim.Frames[0]))
+}
+
+// art-animated.jxl is a 256x128 animation of three 128x128 frames, each the libjxl encoded
+// art.jxl frame cropped to a different position: (0,0), (128,0) then (64,0). The first two
+// frames are saved as a reference that the next frame is blended onto, so each frame shows
+// the previous frames outside its crop.
+func TestDecodeAnimation(t *testing.T) {
+	data, err := os.ReadFile("../testdata/art-animated.jxl")
+	require.NoError(t, err)
+	anim, err := NewJXLDecoder(bytes.NewReader(data), nil).DecodeAnimation()
+	require.NoError(t, err)
+	require.Len(t, anim.Frames, 3)
+	assert.Equal(t, []uint32{10, 20, 30}, anim.Durations)
+	assert.Equal(t, uint32(100), anim.TpsNumerator)
+	assert.Equal(t, uint32(1), anim.TpsDenominator)
+	assert.Equal(t, uint32(0), anim.LoopCount)
+	// the animation header has no timecodes.
+	assert.Nil(t, anim.Timecodes)
+	assert.Equal(t, 100*time.Millisecond, anim.Delay(0))
+	assert.Equal(t, 200*time.Millisecond, anim.Delay(1))
+	assert.Equal(t, 300*time.Millisecond, anim.Delay(2))
+
+	data, err = os.ReadFile("../testdata/art.jxl")
+	require.NoError(t, err)
+	art, err := NewJXLDecoder(bytes.NewReader(data), nil).Decode()
+	require.NoError(t, err)
+	artImage, err := art.ToImage()
+	require.NoError(t, err)
+
+	// the canvas outside the first frame is black, and opaque as the image has no alpha.
+	expected := image.NewNRGBA(image.Rect(0, 0, 256, 128))
+	draw.Draw(expected, expected.Bounds(), image.Black, image.Point{}, draw.Src)
+	for i, x := range []int{0, 128, 64} {
+		draw.Draw(expected, image.Rect(x, 0, x+128, 128), artImage, image.Point{}, draw.Src)
+		img, err := anim.Frames[i].ToImage()
+		require.NoError(t, err)
+		require.Equal(t, expected.Bounds(), img.Bounds())
+		actual := image.NewNRGBA(img.Bounds())
+		draw.Draw(actual, actual.Bounds(), img, image.Point{}, draw.Src)
+		assert.True(t, bytes.Equal(expected.Pix, actual.Pix), "frame %d", i)
+	}
+}
@@ -1,7 +1,9 @@
 package core
 
 import (
+	"context"
 	"errors"
+	"image"
 	"io"
 
 	"github.com/kpfaulkner/jxl-go/bundle"
@@ -13,6 +15,12 @@ import (
 	"github.com/kpfaulkner/jxl-go/util"
 )
 
+var (
+	errNoCodestream = errors.New("no codestream box found")
+	errAlreadyRead  = errors.New("non-seekable input can only be decoded once")
+	errNoImageData  = errors.New("no image data has been decoded yet")
+)
+
 // JXLCodestreamDecoder decodes the JXL image
 type JXLCodestreamDecoder struct {
 	// bit reader... the actual thing that will read the bits/U16/U32/U64 etc.
@@ -24,6 +32,42 @@ type JXLCodestreamDecoder struct {
 	bitReader   jxlio.BitReader
 	imageHeader *bundle.ImageHeader
 
+	// codestream reads the codestream, which in a container can be split over multiple jxlp boxes.
+	codestream jxlio.BitReader
+
+	// forwardOnly is set for non-seekable input, in which case the boxes are read along with the
+	// codestream by boxReader and the image can only be decoded once.
+	forwardOnly bool
+	boxReader   *BoxReader
+	consumed    bool
+
+	// jpeg reconstruction data (jbrd box) and the Exif/XMP/JUMBF boxes.
+	jpegData []byte
+	metadata Metadata
+
+	// when set, the JPEG coefficients of decoded frames are kept in jpegCoefficients.
+	keepJPEGCoefficients bool
+	jpegCoefficients     *frame.JPEGCoefficients
+
+	// region limits decoding to a rectangle of the (oriented) image when set.
+	region *image.Rectangle
+
+	// downsampling is the factor that decodeDownscaled scales the image down by, or 0 for full
+	// resolution. The frames and canvas are held at canvasScale, and lfDownsampling is the
+	// downsampling (relative to their own frame) that each of lfBuffer was decoded with.
+	downsampling   uint32
+	canvasScale    uint32
+	lfDownsampling []uint32
+
+	// events is called as decoding progresses when set (see IncrementalDecoder). currentFrame is
+	// the frame being decoded, until it has been blended onto the canvas.
+	events       func(event Event)
+	currentFrame *frame.Frame
+
+	// ctx cancels decoding when set (see decodeContext). It is checked between frames, and passed
+	// on to each frame.
+	ctx context.Context
+
 	options        options.JXLOptions
 	level          int
 	foundSignature bool
@@ -34,6 +78,7 @@ func NewJXLCodestreamDecoder(br jxlio.BitReader, opts *options.JXLOptions) *JXLC
 	jxl.bitReader = br
 	jxl.foundSignature = false
 	jxl.lfBuffer = make([][]image2.ImageBuffer, 5)
+	jxl.lfDownsampling = make([]uint32, 5)
 	if opts != nil {
 		jxl.options = *options.NewJXLOptions(opts)
 	}
@@ -42,8 +87,8 @@ func NewJXLCodestreamDecoder(br jxlio.BitReader, opts *options.JXLOptions) *JXLC
 }
 
 func (jxl *JXLCodestreamDecoder) atEnd() bool {
-	if jxl.bitReader != nil {
-		return jxl.bitReader.AtEnd()
+	if jxl.codestream != nil {
+		return jxl.codestream.AtEnd()
 	}
 	return false
 }
@@ -58,12 +103,11 @@ func (jxl *JXLCodestreamDecoder) GetImageHeader() (*bundle.ImageHeader, error) {
 		return nil, err
 	}
 
-	if len(jxl.boxHeaders) > 1 {
-		return nil, errors.New("multiple boxes found, cannot get image header alone")
+	if len(jxl.boxHeaders) == 0 {
+		return nil, errNoCodestream
 	}
 
-	box := jxl.boxHeaders[0]
-	_, err = jxl.bitReader.Seek(box.Offset, io.SeekStart)
+	_, err = jxl.codestream.Seek(0, io.SeekStart)
 	if err != nil {
 		return nil, err
 	}
@@ -73,7 +117,7 @@ func (jxl *JXLCodestreamDecoder) GetImageHeader() (*bundle.ImageHeader, error) {
 	}
 
 	level := int32(jxl.level)
-	imageHeader, err := bundle.ParseImageHeader(jxl.bitReader, level)
+	imageHeader, err := bundle.ParseImageHeader(jxl.codestream, level)
 	if err != nil {
 		return nil, err
 	}
@@ -81,610 +125,920 @@ func (jxl *JXLCodestreamDecoder) GetImageHeader() (*bundle.ImageHeader, error) {
 	return imageHeader, nil
 }
 
+// decode returns the first displayed frame. For still images this is the fully composited image,
+// for animations it is the first frame of the animation (in the same way that image/gif.Decode does).
 func (jxl *JXLCodestreamDecoder) decode() (*JXLImage, error) {
 
-	// read header to get signature
-	err := jxl.ReadSignatureAndBoxes()
+	var img *JXLImage
+	err := jxl.decodeFrames(func(frameImage *JXLImage, header *frame.FrameHeader) (bool, error) {
+		img = frameImage
+		return false, nil
+	}, false)
 	if err != nil {
 		return nil, err
 	}
+	return img, nil
+}
+
+// decodeRegion returns the region of the first displayed frame. Only the groups of the frame that
+// are needed for the region are decoded.
+func (jxl *JXLCodestreamDecoder) decodeRegion(region image.Rectangle) (*JXLImage, error) {
+
+	jxl.region = &region
+	defer func() { jxl.region = nil }()
+	return jxl.decode()
+}
+
+// decodeContext returns the first displayed frame in the same way as decode, but stops decoding
+// and returns ctx.Err() once ctx is cancelled.
+func (jxl *JXLCodestreamDecoder) decodeContext(ctx context.Context) (*JXLImage, error) {
+
+	jxl.ctx = ctx
+	defer func() { jxl.ctx = nil }()
+	return jxl.decode()
+}
+
+// decodeDownscaled returns the first displayed frame scaled down by factor. VarDCT frames are only
+// decoded as far as the largest LF level that isn't more than factor: their 1:8 LF coefficients,
+// or from 64 the LF of the LF frame where there is one. Factors below 8 need a full decode. The
+// rest of the scaling is done by averaging.
+func (jxl *JXLCodestreamDecoder) decodeDownscaled(factor uint32) (*JXLImage, error) {
+
+	switch {
+	case factor >= 64:
+		jxl.canvasScale = 64
+	case factor >= 8:
+		jxl.canvasScale = 8
+	case factor >= 1:
+		jxl.canvasScale = 0
+	default:
+		return nil, errors.New("downscaling factor must be positive")
+	}
+	jxl.downsampling = factor
+	defer func() {
+		jxl.downsampling = 0
+		jxl.canvasScale = 0
+	}()
+	return jxl.decode()
+}
 
-	box := jxl.boxHeaders[0]
-	_, err = jxl.bitReader.Seek(box.Offset, io.SeekStart)
+// decodePreview decodes only the preview frame. Returns nil if the image doesn't have a preview.
+func (jxl *JXLCodestreamDecoder) decodePreview() (*JXLImage, error) {
+
+	var img *JXLImage
+	err := jxl.decodeFrames(func(frameImage *JXLImage, header *frame.FrameHeader) (bool, error) {
+		img = frameImage
+		return false, nil
+	}, true)
 	if err != nil {
 		return nil, err
 	}
+	return img, nil
+}
 
-	if jxl.atEnd() {
+// decodeAnimation decodes every displayed frame. Frames with a zero duration that
+// are not the last frame are composited into the following displayed frame.
+func (jxl *JXLCodestreamDecoder) decodeAnimation() (*JXLAnimation, error) {
+
+	anim := &JXLAnimation{}
+	err := jxl.decodeFrames(func(frameImage *JXLImage, header *frame.FrameHeader) (bool, error) {
+		anim.Frames = append(anim.Frames, frameImage)
+		anim.Durations = append(anim.Durations, header.Duration)
+		if ah := jxl.imageHeader.AnimationHeader; ah != nil && ah.HaveTimeCodes {
+			anim.Timecodes = append(anim.Timecodes, header.Timecode)
+		}
+		return true, nil
+	}, false)
+	if err != nil {
+		return nil, err
+	}
+	if jxl.imageHeader == nil || len(anim.Frames) == 0 {
 		return nil, nil
 	}
 
-	level := int32(jxl.level)
-	imageHeader, err := bundle.ParseImageHeader(jxl.bitReader, level)
+	if jxl.imageHeader.AnimationHeader != nil {
+		anim.TpsNumerator = jxl.imageHeader.AnimationHeader.TpsNumerator
+		anim.TpsDenominator = jxl.imageHeader.AnimationHeader.TpsDenominator
+		anim.LoopCount = jxl.imageHeader.AnimationHeader.NumLoops
+	}
+	return anim, nil
+}
+
+// decodeFrames decodes the frames of the codestream, calling displayFrame with the composited
+// canvas for each displayed frame. Decoding stops once displayFrame returns false.
+// If preview is true then only the preview frame is decoded (and displayed), otherwise the
+// preview frame is skipped.
+func (jxl *JXLCodestreamDecoder) decodeFrames(displayFrame func(img *JXLImage, header *frame.FrameHeader) (bool, error), preview bool) error {
+
+	// read header to get signature
+	err := jxl.ReadSignatureAndBoxes()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	jxl.imageHeader = imageHeader
-	size := imageHeader.Size
-	jxl.canvas = make([]image2.ImageBuffer, imageHeader.GetColourChannelCount()+len(imageHeader.ExtraChannelInfo))
-	if imageHeader.AnimationHeader != nil {
-		return nil, errors.New("animation not implemented")
+	// for non-seekable input the jbrd box may not have been read yet.
+	if jxl.keepJPEGCoefficients && jxl.jpegData == nil && !jxl.forwardOnly {
+		return errNoJPEGData
+	}
+	if jxl.forwardOnly {
+		if jxl.consumed {
+			return errAlreadyRead
+		}
+		jxl.consumed = true
+	}
+	if len(jxl.boxHeaders) == 0 {
+		return errNoCodestream
+	}
+
+	_, err = jxl.codestream.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	if jxl.atEnd() {
+		return nil
+	}
+
+	level := int32(jxl.level)
+	imageHeader, err := bundle.ParseImageHeader(jxl.codestream, level)
+	if err != nil {
+		return err
 	}
 
 	if imageHeader.PreviewSize != nil {
-		//previewOptions := options.NewJXLOptions(&jxl.options)
-		//previewOptions.ParseOnly = true
-		//frame := frame.NewFrameWithReader(jxl.bitReader, jxl.imageHeader, previewOptions)
-		//frame.ReadFrameHeader()
-		return nil, errors.New("not implemented preview yet")
+		previewHeader := imageHeader.GetPreviewImageHeader()
+		if preview {
+			// the preview frame is decoded as if it was the whole image.
+			imageHeader = previewHeader
+		} else {
+			previewFrame := frame.NewFrameWithReader(jxl.codestream, previewHeader, &jxl.options)
+			if _, err = previewFrame.ReadFrameHeader(); err != nil {
+				return err
+			}
+			if err = previewFrame.ReadTOC(); err != nil {
+				return err
+			}
+			if err = previewFrame.SkipFrameData(); err != nil {
+				return err
+			}
+		}
+	} else if preview {
+		return nil
 	}
 
-	var matrix *colour.OpsinInverseMatrix
-	if imageHeader.XybEncoded {
-		bundle := imageHeader.ColourEncoding
-		matrix, err = imageHeader.OpsinInverseMatrix.GetMatrix(bundle.Prim, bundle.White)
-		if err != nil {
-			return nil, err
+	jxl.imageHeader = imageHeader
+	size := jxl.canvasSize()
+
+	var canvasRegion *util.Rectangle
+	if jxl.region != nil && !preview {
+		if canvasRegion, err = jxl.getCanvasRegion(*jxl.region); err != nil {
+			return err
 		}
 	}
+	jxl.canvas = make([]image2.ImageBuffer, imageHeader.GetColourChannelCount()+len(imageHeader.ExtraChannelInfo))
+
+	matrix, err := jxl.opsinInverseMatrix()
+	if err != nil {
+		return err
+	}
+	// the ICC profile is part of the image header, so is available along with it.
+	jxl.emit(Event{Type: EVENT_BASIC_INFO})
+	jxl.emit(Event{Type: EVENT_COLOUR_ENCODING})
 
 	frameCount := 0
 	invisibleFrames := int64(0)
 	visibleFrames := 0
 	header := frame.FrameHeader{}
 
-	// If we have multiple box headers...  then we want to loop over from the second one.
-	// Need to also seek to that box offset.
-	ii := 0
-	shouldSeekBoxOffset := false
-	if len(jxl.boxHeaders) > 1 {
-		ii = 1
-		shouldSeekBoxOffset = true
+	if jxl.atEnd() {
+		return nil
 	}
 
-	for _, box := range jxl.boxHeaders[ii:] {
-
-		// only seek if we're not dealing with a single box.
-		if shouldSeekBoxOffset {
-			_, err = jxl.bitReader.Seek(box.Offset, io.SeekStart)
-			if err != nil {
-				return nil, err
-			}
+	for {
+		if err = jxl.cancelled(); err != nil {
+			return err
 		}
-
-		if jxl.atEnd() {
-			return nil, nil
+		imgFrame := frame.NewFrameWithReader(jxl.codestream, jxl.imageHeader, &jxl.options)
+		imgFrame.SetContext(jxl.ctx)
+		header, err = imgFrame.ReadFrameHeader()
+		if err != nil {
+			return err
 		}
+		frameCount++
+		frameIndex := frameCount - 1
+		jxl.emit(Event{Type: EVENT_FRAME_HEADER, Frame: frameIndex, Header: imgFrame.Header})
 
-		for {
-			imgFrame := frame.NewFrameWithReader(jxl.bitReader, jxl.imageHeader, &jxl.options)
-			header, err = imgFrame.ReadFrameHeader()
-			if err != nil {
-				return nil, err
-			}
-			frameCount++
+		if jxl.lfBuffer[header.LfLevel] == nil && header.Flags&frame.USE_LF_FRAME != 0 {
+			return errors.New("LF level too large")
+		}
 
-			if jxl.lfBuffer[header.LfLevel] == nil && header.Flags&frame.USE_LF_FRAME != 0 {
-				return nil, errors.New("LF level too large")
-			}
+		err := imgFrame.ReadTOC()
+		if err != nil {
+			return err
+		}
 
-			err := imgFrame.ReadTOC()
-			if err != nil {
-				return nil, err
+		if jxl.options.ParseOnly {
+			if err := imgFrame.SkipFrameData(); err != nil {
+				return err
 			}
+			continue
+		}
 
-			if jxl.options.ParseOnly {
-				if err := imgFrame.SkipFrameData(); err != nil {
-					return nil, err
+		if jxl.keepJPEGCoefficients {
+			imgFrame.KeepJPEGCoefficients()
+		}
+		jxl.currentFrame = imgFrame
+		if jxl.events != nil {
+			imgFrame.SetProgressFunc(func(passes int) {
+				if passes == 0 {
+					jxl.emit(Event{Type: EVENT_LF, Frame: frameIndex, Header: imgFrame.Header})
+				} else {
+					jxl.emit(Event{Type: EVENT_PASS, Frame: frameIndex, Pass: passes, Header: imgFrame.Header})
 				}
-				continue
-			}
-
-			err = imgFrame.DecodeFrame(jxl.lfBuffer[header.LfLevel], frame.NewLFGlobalWithReader)
-			if err != nil {
-				return nil, err
-			}
-
-			if header.LfLevel > 0 {
-				jxl.lfBuffer[header.LfLevel-1] = imgFrame.Buffer
-			}
-			if header.FrameType == frame.LF_FRAME {
-				imgFrame.Release()
-				continue
-			}
-			save := (header.SaveAsReference != 0 || header.Duration == 0) && !header.IsLast && header.FrameType != frame.LF_FRAME
-			if imgFrame.IsVisible() {
-				visibleFrames++
-				invisibleFrames = 0
-			} else {
-				invisibleFrames++
-			}
+			})
+		}
+		save := (header.SaveAsReference != 0 || header.Duration == 0) && !header.IsLast && header.FrameType != frame.LF_FRAME
 
-			err = imgFrame.Upsample()
-			if err != nil {
-				return nil, err
+		// frames that later frames refer to need to be decoded in full.
+		if canvasRegion != nil && !save && header.FrameType != frame.LF_FRAME {
+			imgFrame.SetRegion(*canvasRegion)
+		}
+		// when downscaling, LF frames are only needed in full unless their own LF is small enough.
+		frameDownsampling := uint32(1)
+		if jxl.canvasScale != 0 && imgFrame.CanDecodeLF() &&
+			(header.FrameType != frame.LF_FRAME || header.LfLevel == 1 && jxl.canvasScale == 64) {
+			frameDownsampling, err = imgFrame.DecodeLF(jxl.lfBuffer[header.LfLevel], jxl.lfDownsampling[header.LfLevel], frame.NewLFGlobalWithReader)
+		} else {
+			if header.Encoding == frame.VARDCT && header.Flags&frame.USE_LF_FRAME != 0 && jxl.lfDownsampling[header.LfLevel] != 1 {
+				return errors.New("LF frame was not decoded at full resolution")
 			}
+			err = imgFrame.DecodeFrame(jxl.lfBuffer[header.LfLevel], frame.NewLFGlobalWithReader)
+		}
+		if err != nil {
+			return err
+		}
+		if imgFrame.JPEGCoefficients != nil {
+			jxl.jpegCoefficients = imgFrame.JPEGCoefficients
+		}
 
+		if header.LfLevel > 0 {
+			jxl.lfBuffer[header.LfLevel-1] = imgFrame.Buffer
+			jxl.lfDownsampling[header.LfLevel-1] = frameDownsampling
+		}
+		if header.FrameType == frame.LF_FRAME {
+			invisibleFrames++
+			imgFrame.Release()
+			jxl.currentFrame = nil
+			jxl.emit(Event{Type: EVENT_FRAME, Frame: frameIndex, Header: imgFrame.Header})
+			continue
+		}
+		err = imgFrame.Upsample()
+		if err != nil {
+			return err
+		}
+		// when downscaling, frames are scaled down to the canvas after the colour transforms, as
+		// they aren't linear.
+		downsampling := uint32(1)
+		if jxl.canvasScale != 0 {
+			downsampling = jxl.canvasScale / frameDownsampling
+		} else {
+			// noise is seeded with the number of frames decoded before this one.
 			err = imgFrame.InitializeNoise(int64(visibleFrames<<32) | invisibleFrames)
 			if err != nil {
-				return nil, err
+				return err
 			}
+		}
+		if imgFrame.IsVisible() {
+			visibleFrames++
+			invisibleFrames = 0
+		} else {
+			invisibleFrames++
+		}
 
-			if save && header.SaveBeforeCT {
-				jxl.reference[header.SaveAsReference] = imgFrame.Buffer
+		if save && header.SaveBeforeCT {
+			// the frame buffer is colour transformed in place below, so the reference needs its
+			// own copy of the pre colour transform samples.
+			ref := make([]image2.ImageBuffer, 0, len(imgFrame.Buffer))
+			for _, ib := range imgFrame.Buffer {
+				if downsampling > 1 {
+					buf, err := ib.Downsample(int32(downsampling))
+					if err != nil {
+						return err
+					}
+					ref = append(ref, *buf)
+					continue
+				}
+				ref = append(ref, *image2.NewImageBufferFromImageBuffer(&ib, true))
 			}
+			jxl.reference[header.SaveAsReference] = ref
+		}
 
+		// patches, splines and noise are full resolution detail, so are left out when downscaling.
+		if jxl.canvasScale == 0 {
 			err = jxl.computePatches(imgFrame)
 			if err != nil {
-				return nil, err
+				return err
 			}
 
 			err = imgFrame.RenderSplines()
 			if err != nil {
-				return nil, err
+				return err
 			}
 
 			err = imgFrame.SynthesizeNoise()
 			if err != nil {
-				return nil, err
+				return err
 			}
+		}
 
-			err = jxl.performColourTransforms(matrix, imgFrame)
-			if err != nil {
-				return nil, err
-			}
+		if err = jxl.cancelled(); err != nil {
+			return err
+		}
+		err = jxl.performColourTransforms(matrix, imgFrame)
+		if err != nil {
+			return err
+		}
+		if err = imgFrame.Downsample(downsampling); err != nil {
+			return err
+		}
 
-			if header.Encoding == frame.VARDCT && jxl.options.RenderVarblocks {
-				panic("VARDCT not implemented yet")
-			}
+		if header.Encoding == frame.VARDCT && jxl.options.RenderVarblocks {
+			panic("VARDCT not implemented yet")
+		}
 
-			if jxl.canvas[0].Height == 0 && jxl.canvas[0].Width == 0 {
-				for c := 0; c < len(jxl.canvas); c++ {
-					canvas, err := image2.NewImageBuffer(imgFrame.Buffer[0].BufferType, int32(size.Height), int32(size.Width))
-					if err != nil {
-						return nil, err
-					}
-					jxl.canvas[c] = *canvas
+		if jxl.canvas[0].Height == 0 && jxl.canvas[0].Width == 0 {
+			for c := 0; c < len(jxl.canvas); c++ {
+				canvas, err := image2.NewImageBuffer(imgFrame.Buffer[0].BufferType, int32(size.Height), int32(size.Width))
+				if err != nil {
+					return err
 				}
+				jxl.canvas[c] = *canvas
 			}
-			if header.FrameType == frame.REGULAR_FRAME || header.FrameType == frame.SKIP_PROGRESSIVE {
-				found := false
-				for i := uint32(0); i < 4; i++ {
-					if image2.ImageBufferSliceEquals(jxl.reference[i], jxl.canvas) && i != header.SaveAsReference {
-						found = true
-						break
-					}
+		}
+		if header.FrameType == frame.REGULAR_FRAME || header.FrameType == frame.SKIP_PROGRESSIVE {
+			if err = jxl.prepareCanvas(imgFrame); err != nil {
+				return err
+			}
+			found := false
+			for i := uint32(0); i < 4; i++ {
+				if image2.ImageBufferSliceEquals(jxl.reference[i], jxl.canvas) && i != header.SaveAsReference {
+					found = true
+					break
 				}
+			}
 
-				if found {
-					canvas2 := make([]image2.ImageBuffer, 0, len(jxl.canvas))
-					for _, ib := range jxl.canvas {
-						ib2 := image2.NewImageBufferFromImageBuffer(&ib, true)
-						canvas2 = append(canvas2, *ib2)
-					}
-					jxl.canvas = canvas2
-				}
-				err = jxl.blendFrame(jxl.canvas, imgFrame)
-				if err != nil {
-					return nil, err
+			if found {
+				canvas2 := make([]image2.ImageBuffer, 0, len(jxl.canvas))
+				for _, ib := range jxl.canvas {
+					ib2 := image2.NewImageBufferFromImageBuffer(&ib, true)
+					canvas2 = append(canvas2, *ib2)
 				}
+				jxl.canvas = canvas2
 			}
-
-			if save && !header.SaveBeforeCT {
-				jxl.reference[header.SaveAsReference] = jxl.canvas
+			err = jxl.blendFrame(jxl.canvas, imgFrame)
+			if err != nil {
+				return err
 			}
+		}
 
-			if header.IsLast && header.Duration == 0 {
-				break
+		if save && !header.SaveBeforeCT {
+			jxl.reference[header.SaveAsReference] = jxl.canvas
+		}
+		jxl.currentFrame = nil
+
+		// there is only a single preview frame, so it is always displayed.
+		if preview || imgFrame.IsVisible() {
+			// the canvas is blended onto by later frames so needs to be copied if more frames follow.
+			img, err := jxl.canvasToImage(jxl.canvas, !header.IsLast && !preview, canvasRegion)
+			if err != nil {
+				return err
+			}
+			cont, err := displayFrame(img, &header)
+			if err != nil {
+				return err
+			}
+			if !cont || preview {
+				return nil
 			}
 		}
+		jxl.emit(Event{Type: EVENT_FRAME, Frame: frameIndex, Header: imgFrame.Header})
 
-		err = jxl.bitReader.ZeroPadToByte()
+		if header.IsLast {
+			break
+		}
+	}
+
+	err = jxl.codestream.ZeroPadToByte()
+	if err != nil {
+		return err
+	}
+
+	// TOOD(kpfaulkner) unsure if need to perform similar drain cache functionality here. Don't think we do.
+	return nil
+}
+
+// canvasToImage generates a JXLImage from the canvas, applying the image orientation.
+// If region is set then the image is cropped to it.
+func (jxl *JXLCodestreamDecoder) canvasToImage(canvasBuffers []image2.ImageBuffer, copyCanvas bool, region *util.Rectangle) (*JXLImage, error) {
+
+	var err error
+	orientation := jxl.imageHeader.Orientation
+	orientedCanvas := make([]image2.ImageBuffer, len(canvasBuffers))
+	for i := 0; i < len(orientedCanvas); i++ {
+		canvas := canvasBuffers[i]
+		if region != nil {
+			canvas = cropBuffer(canvas, *region)
+		}
+		if jxl.rescalesCanvas() {
+			if canvas, err = jxl.rescaleCanvas(canvas); err != nil {
+				return nil, err
+			}
+		}
+		orientedCanvas[i], err = jxl.transposeBuffer(canvas, orientation)
 		if err != nil {
 			return nil, err
 		}
-
-		// TOOD(kpfaulkner) unsure if need to perform similar drain cache functionality here. Don't think we do.
-		if jxl.options.ParseOnly {
-			return nil, nil
+		// transposeBuffer reuses the buffer when there is no orientation change.
+		if copyCanvas && orientation == 1 && region == nil && !jxl.rescalesCanvas() {
+			orientedCanvas[i] = *image2.NewImageBufferFromImageBuffer(&orientedCanvas[i], true)
 		}
+	}
+
+	img, err := NewJXLImageWithBuffer(orientedCanvas, *jxl.imageHeader)
+	if err != nil {
+		return nil, err
+	}
+	if region != nil || jxl.downsampling != 0 {
+		img.Width = uint32(orientedCanvas[0].Width)
+		img.Height = uint32(orientedCanvas[0].Height)
+	}
+	return img, nil
+}
 
-		orientation := imageHeader.Orientation
-		orientedCanvas := make([]image2.ImageBuffer, len(jxl.canvas))
-		for i := 0; i < len(orientedCanvas); i++ {
-			orientedCanvas[i], err = jxl.transposeBuffer(jxl.canvas[i], orientation)
+// rescalesCanvas reports whether the canvas is held at a different scale to the one that
+// decodeDownscaled was asked for.
+func (jxl *JXLCodestreamDecoder) rescalesCanvas() bool {
+	return jxl.downsampling != 0 && jxl.downsampling != max(jxl.canvasScale, 1)
+}
+
+// rescaleCanvas scales a canvas buffer down from canvasScale to downsampling. When downsampling
+// is a multiple of canvasScale this averages whole blocks, otherwise the blocks are fractional.
+func (jxl *JXLCodestreamDecoder) rescaleCanvas(canvas image2.ImageBuffer) (image2.ImageBuffer, error) {
+
+	scale := max(jxl.canvasScale, 1)
+	var buf *image2.ImageBuffer
+	var err error
+	if jxl.downsampling%scale == 0 {
+		buf, err = canvas.Downsample(int32(jxl.downsampling / scale))
+	} else {
+		size := jxl.imageHeader.Size
+		buf, err = canvas.DownsampleTo(int32(util.CeilDiv(size.Height, jxl.downsampling)),
+			int32(util.CeilDiv(size.Width, jxl.downsampling)), float64(jxl.downsampling)/float64(scale))
+	}
+	if err != nil {
+		return image2.ImageBuffer{}, err
+	}
+	return *buf, nil
+}
+
+// flushImage renders the image decoded so far. This is the canvas, with the frame being decoded
+// (if it is displayed) drawn on top from what has been decoded of it. Must only be called while
+// decoding is blocked waiting for input, or has finished.
+func (jxl *JXLCodestreamDecoder) flushImage() (*JXLImage, error) {
+
+	if jxl.imageHeader == nil {
+		return nil, errNoImageData
+	}
+	canvas := make([]image2.ImageBuffer, len(jxl.canvas))
+	for c := 0; c < len(canvas); c++ {
+		canvas[c] = *image2.NewImageBufferFromImageBuffer(&jxl.canvas[c], true)
+	}
+
+	imgFrame := jxl.currentFrame
+	if imgFrame != nil && (imgFrame.Header.FrameType == frame.REGULAR_FRAME || imgFrame.Header.FrameType == frame.SKIP_PROGRESSIVE) {
+		progressive, err := imgFrame.ProgressiveFrame()
+		if err != nil {
+			return nil, err
+		}
+		if progressive != nil {
+			if err = progressive.Upsample(); err != nil {
+				return nil, err
+			}
+			matrix, err := jxl.opsinInverseMatrix()
 			if err != nil {
 				return nil, err
 			}
+			if err = jxl.performColourTransforms(matrix, progressive); err != nil {
+				return nil, err
+			}
+			if len(canvas) > 0 && canvas[0].Height == 0 && canvas[0].Width == 0 {
+				size := jxl.canvasSize()
+				for c := 0; c < len(canvas); c++ {
+					buf, err := image2.NewImageBuffer(progressive.Buffer[0].BufferType, int32(size.Height), int32(size.Width))
+					if err != nil {
+						return nil, err
+					}
+					canvas[c] = *buf
+				}
+			}
+			if err = jxl.blendFrame(canvas, progressive); err != nil {
+				return nil, err
+			}
 		}
+	}
 
-		// generate image and return.
-		img, err := NewJXLImageWithBuffer(orientedCanvas, *imageHeader)
-		if err != nil {
-			return nil, err
+	if len(canvas) == 0 || canvas[0].Height == 0 && canvas[0].Width == 0 {
+		return nil, errNoImageData
+	}
+	return jxl.canvasToImage(canvas, false, nil)
+}
+
+// opsinInverseMatrix returns the matrix for converting XYB to RGB, or nil if the image isn't
+// XYB encoded.
+func (jxl *JXLCodestreamDecoder) opsinInverseMatrix() (*colour.OpsinInverseMatrix, error) {
+	if !jxl.imageHeader.XybEncoded {
+		return nil, nil
+	}
+	bundle := jxl.imageHeader.ColourEncoding
+	return jxl.imageHeader.OpsinInverseMatrix.GetMatrix(bundle.Prim, bundle.White)
+}
+
+// cancelled returns ctx.Err(), or nil if decoding isn't cancellable.
+func (jxl *JXLCodestreamDecoder) cancelled() error {
+	if jxl.ctx == nil {
+		return nil
+	}
+	return jxl.ctx.Err()
+}
+
+func (jxl *JXLCodestreamDecoder) emit(event Event) {
+	if jxl.events != nil {
+		jxl.events(event)
+	}
+}
+
+// getCanvasRegion converts a region of the oriented image to the region of the canvas (before
+// orientation is applied) that it comes from. The region is clipped to the image.
+func (jxl *JXLCodestreamDecoder) getCanvasRegion(region image.Rectangle) (*util.Rectangle, error) {
+
+	header := jxl.imageHeader
+	region = region.Intersect(image.Rect(0, 0, int(header.OrientedWidth), int(header.OrientedHeight)))
+	if region.Empty() {
+		return nil, errors.New("region is outside of the image")
+	}
+
+	width := int32(header.Size.Width)
+	height := int32(header.Size.Height)
+	x0, y0 := int32(region.Min.X), int32(region.Min.Y)
+	x1, y1 := int32(region.Max.X), int32(region.Max.Y)
+	switch header.Orientation {
+	case 1:
+	case 2:
+		x0, x1 = width-x1, width-x0
+	case 3:
+		x0, x1 = width-x1, width-x0
+		y0, y1 = height-y1, height-y0
+	case 4:
+		y0, y1 = height-y1, height-y0
+	case 5:
+		x0, y0, x1, y1 = y0, x0, y1, x1
+	case 6:
+		x0, y0, x1, y1 = y0, height-x1, y1, height-x0
+	case 7:
+		x0, y0, x1, y1 = width-y1, height-x1, width-y0, height-x0
+	case 8:
+		x0, y0, x1, y1 = width-y1, x0, width-y0, x1
+	default:
+		return nil, errors.New("Invalid orientation")
+	}
+
+	return &util.Rectangle{
+		Origin: util.Point{X: x0, Y: y0},
+		Size:   util.Dimension{Width: uint32(x1 - x0), Height: uint32(y1 - y0)},
+	}, nil
+}
+
+// cropBuffer copies the region of the buffer, so the rest of the buffer can be released.
+func cropBuffer(src image2.ImageBuffer, region util.Rectangle) image2.ImageBuffer {
+
+	lowerCorner := region.ComputeLowerCorner()
+	if src.IsInt() {
+		ints := util.MakeMatrix2D[int32](int(region.Size.Height), int(region.Size.Width))
+		for y := range ints {
+			copy(ints[y], src.IntBuffer[region.Origin.Y+int32(y)][region.Origin.X:lowerCorner.X])
 		}
+		return *image2.NewImageBufferFromInts(ints)
+	}
+	floats := util.MakeMatrix2D[float32](int(region.Size.Height), int(region.Size.Width))
+	for y := range floats {
+		copy(floats[y], src.FloatBuffer[region.Origin.Y+int32(y)][region.Origin.X:lowerCorner.X])
+	}
+	return *image2.NewImageBufferFromFloats(floats)
+}
 
-		return img, nil
+// canvasSize is the size of the canvas, which is scaled down by canvasScale when downscaling.
+func (jxl *JXLCodestreamDecoder) canvasSize() util.Dimension {
+	size := jxl.imageHeader.Size
+	if jxl.canvasScale != 0 {
+		size.Height = util.CeilDiv(size.Height, jxl.canvasScale)
+		size.Width = util.CeilDiv(size.Width, jxl.canvasScale)
 	}
+	return size
+}
 
-	return nil, nil
+// prepareCanvas initialises the canvas from the blending source reference frame when the frame
+// doesn't cover the whole image. Samples outside of the frame come from the reference frame (or are 0
+// if there is no reference frame) rather than whatever the previous frame left on the canvas.
+func (jxl *JXLCodestreamDecoder) prepareCanvas(imgFrame *frame.Frame) error {
+
+	header := imgFrame.Header
+	size := jxl.canvasSize()
+	lowerCorner := header.Bounds.ComputeLowerCorner()
+	if header.Bounds.Origin.X <= 0 && header.Bounds.Origin.Y <= 0 &&
+		lowerCorner.X >= int32(size.Width) && lowerCorner.Y >= int32(size.Height) {
+		return nil
+	}
+
+	imageColours := jxl.imageHeader.GetColourChannelCount()
+	canvas := make([]image2.ImageBuffer, len(jxl.canvas))
+	for c := 0; c < len(canvas); c++ {
+		source := header.BlendingInfo.Source
+		if c >= imageColours {
+			source = header.EcBlendingInfo[c-imageColours].Source
+		}
+		ref := jxl.reference[source]
+		if c < len(ref) && ref[c].Height == jxl.canvas[c].Height && ref[c].Width == jxl.canvas[c].Width {
+			canvas[c] = *image2.NewImageBufferFromImageBuffer(&ref[c], true)
+			continue
+		}
+		buf, err := image2.NewImageBuffer(jxl.canvas[c].BufferType, jxl.canvas[c].Height, jxl.canvas[c].Width)
+		if err != nil {
+			return err
+		}
+		canvas[c] = *buf
+	}
+	jxl.canvas = canvas
+	return nil
 }
 
-// Read signature
+// Read signature. The boxes are only read once, so later calls (such as decoding after reading
+// the metadata) reuse them.
 func (jxl *JXLCodestreamDecoder) ReadSignatureAndBoxes() error {
 
+	if jxl.boxHeaders != nil {
+		return nil
+	}
 	br := NewBoxReader(jxl.bitReader)
+	if jxl.options.MaxBoxSize > 0 {
+		br.maxBoxSize = jxl.options.MaxBoxSize
+	}
+	br.forwardOnly = jxl.forwardOnly
 	boxHeaders, err := br.ReadBoxHeader()
 	if err != nil {
 		return err
 	}
 
+	jxl.codestream = jxl.bitReader
+	if br.container && jxl.forwardOnly {
+		jxl.codestream = jxlio.NewBitStreamReader(jxlio.NewForwardReader(br.codestreamReader()))
+	} else if br.container && len(boxHeaders) > 0 {
+		segments := make([]jxlio.Segment, len(boxHeaders))
+		for i, box := range boxHeaders {
+			segments[i] = jxlio.Segment{Offset: box.Offset, Length: int64(box.BoxSize)}
+		}
+		jxl.codestream = jxlio.NewBitStreamReader(jxlio.NewSegmentedReader(newBitReaderStream(jxl.bitReader), segments))
+	}
+
 	jxl.boxHeaders = boxHeaders
 	jxl.level = br.level
+	jxl.jpegData = br.jpegData
+	jxl.metadata = br.metadata
+	jxl.boxReader = br
+	return nil
+}
+
+// readRemainingBoxes reads the boxes of non-seekable input that follow the part of the codestream
+// that has been decoded, so the metadata and jbrd boxes after the codestream are found.
+func (jxl *JXLCodestreamDecoder) readRemainingBoxes() error {
+	if !jxl.forwardOnly || jxl.boxReader == nil {
+		return nil
+	}
+	jxl.consumed = true
+	if err := jxl.boxReader.readRemainingBoxes(); err != nil {
+		return err
+	}
+	jxl.jpegData = jxl.boxReader.jpegData
+	jxl.metadata = jxl.boxReader.metadata
 	return nil
 }
 
-func (jxl *JXLCodestreamDecoder) computePatches(_ *frame.Frame) error {
+// computePatches blends every patch from the frames patch dictionary onto the frame buffer.
+// Patches are copied out of one of the saved reference frames and blended using the per patch
+// (and per channel) BlendingInfo. This happens after upsampling but before splines/noise and colour transforms.
+func (jxl *JXLCodestreamDecoder) computePatches(imgFrame *frame.Frame) error {
+
+	if imgFrame.LfGlobal == nil || len(imgFrame.LfGlobal.Patches) == 0 {
+		return nil
+	}
+
+	header := imgFrame.Header
+	frameBuffer := imgFrame.Buffer
+	colourChannels := imgFrame.GetColourChannelCount()
+	extraChannels := len(jxl.imageHeader.ExtraChannelInfo)
+	patches := imgFrame.LfGlobal.Patches
+
+	for i := 0; i < len(patches); i++ {
+		patch := patches[i]
+		if patch.Ref < 0 || int(patch.Ref) >= len(jxl.reference) {
+			return errors.New("patch out of range")
+		}
+		refBuffer := jxl.reference[patch.Ref]
+		if len(refBuffer) == 0 {
+			return errors.New("patch references a frame that has not been saved")
+		}
+		refColourChannels := len(refBuffer) - extraChannels
+		if refColourChannels < 1 {
+			return errors.New("patch reference frame has too few channels")
+		}
+		lowerCorner := patch.Bounds.ComputeLowerCorner()
+		if patch.Bounds.Origin.X < 0 || patch.Bounds.Origin.Y < 0 ||
+			lowerCorner.Y > refBuffer[0].Height || lowerCorner.X > refBuffer[0].Width {
+			return errors.New("patch too large")
+		}
 
-	// do not support patches yet.
+		for j := 0; j < len(patch.Positions); j++ {
+			pos := patch.Positions[j]
+			if pos.X < 0 || pos.Y < 0 ||
+				uint32(pos.Y)+patch.Bounds.Size.Height > header.Bounds.Size.Height ||
+				uint32(pos.X)+patch.Bounds.Size.Width > header.Bounds.Size.Width {
+				return errors.New("patch size out of bounds")
+			}
+
+			for d := 0; d < util.Min(colourChannels+extraChannels, len(frameBuffer)); d++ {
+				// blending info 0 is for all colour channels, 1+ is per extra channel.
+				var c int
+				var refC int
+				if d < colourChannels {
+					c = 0
+					refC = util.Min(d, refColourChannels-1)
+				} else {
+					c = d - colourChannels + 1
+					refC = refColourChannels + c - 1
+				}
+				if c >= len(patch.BlendingInfos[j]) {
+					return errors.New("missing patch blending info")
+				}
+				info := patch.BlendingInfos[j][c]
+				if info.Mode == frame.PATCH_BLEND_NONE {
+					continue
+				}
+				if c > 0 && header.Upsampling > 1 &&
+					header.EcUpsampling[c-1] != header.Upsampling {
+					return errors.New("extra channel upsampling mismatch during patches")
+				}
+
+				var alphaOld, alphaNew *image2.ImageBuffer
+				if info.Mode > frame.PATCH_BLEND_MULT && extraChannels > 0 {
+					alphaOld = &frameBuffer[colourChannels+int(info.AlphaChannel)]
+					alphaNew = &refBuffer[refColourChannels+int(info.AlphaChannel)]
+				}
+				err := jxl.blendPatch(patch, pos, info, c, &frameBuffer[d], &refBuffer[refC], alphaOld, alphaNew)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
 	return nil
+}
+
+// blendPatch blends a single channel of a patch (read from ref at patch.Bounds) onto the
+// frame buffer at pos. c is the blending info index (0 for colour, 1+ for extra channels).
+// alphaOld/alphaNew are nil when the image has no alpha channel to blend with.
+func (jxl *JXLCodestreamDecoder) blendPatch(patch frame.Patch, pos util.Point, info frame.BlendingInfo, c int,
+	frameBuffer *image2.ImageBuffer, ref *image2.ImageBuffer, alphaOld *image2.ImageBuffer, alphaNew *image2.ImageBuffer) error {
+
+	height := int32(patch.Bounds.Size.Height)
+	width := int32(patch.Bounds.Size.Width)
+	refY0 := patch.Bounds.Origin.Y
+	refX0 := patch.Bounds.Origin.X
+
+	// replace and add can stay in the integer domain if both buffers are int.
+	if frameBuffer.IsInt() && ref.IsInt() {
+		switch info.Mode {
+		case frame.PATCH_BLEND_REPLACE:
+			for y := int32(0); y < height; y++ {
+				copy(frameBuffer.IntBuffer[pos.Y+y][pos.X:pos.X+width], ref.IntBuffer[refY0+y][refX0:refX0+width])
+			}
+			return nil
+		case frame.PATCH_BLEND_ADD:
+			for y := int32(0); y < height; y++ {
+				frameRow := frameBuffer.IntBuffer[pos.Y+y]
+				refRow := ref.IntBuffer[refY0+y]
+				for x := int32(0); x < width; x++ {
+					frameRow[pos.X+x] += refRow[refX0+x]
+				}
+			}
+			return nil
+		}
+	}
+
+	var depth uint32
+	if c == 0 {
+		depth = jxl.imageHeader.BitDepth.BitsPerSample
+	} else {
+		depth = jxl.imageHeader.ExtraChannelInfo[c-1].BitDepth.BitsPerSample
+	}
+	if err := frameBuffer.CastToFloatIfMax(^(^0 << depth)); err != nil {
+		return err
+	}
+	if err := ref.CastToFloatIfMax(^(^0 << depth)); err != nil {
+		return err
+	}
+
+	premult := true
+	isAlpha := false
+	var oaf, naf [][]float32
+	if alphaOld != nil && alphaNew != nil {
+		alphaInfo := jxl.imageHeader.ExtraChannelInfo[info.AlphaChannel]
+		alphaDepth := alphaInfo.BitDepth.BitsPerSample
+		if err := alphaOld.CastToFloatIfMax(^(^0 << alphaDepth)); err != nil {
+			return err
+		}
+		if err := alphaNew.CastToFloatIfMax(^(^0 << alphaDepth)); err != nil {
+			return err
+		}
+		oaf = alphaOld.FloatBuffer
+		naf = alphaNew.FloatBuffer
+		premult = alphaInfo.AlphaAssociated
+		isAlpha = c > 0 && uint32(c-1) == info.AlphaChannel
+	}
 
-	//header := frame.Header
-	//frameBuffer := frame.Buffer
-	//colourChannels := jxl.imageHeader.GetColourChannelCount()
-	//extraChannels := len(jxl.imageHeader.ExtraChannelInfo)
-	//patches := frame.LfGlobal.Patches
-	//hasAlpha := jxl.imageHeader.HasAlpha()
-	//for i := 0; i < len(patches); i++ {
-	//	patch := patches[i]
-	//	if patch.Ref > 3 {
-	//		return errors.New("patch out of range")
-	//	}
-	//	refBuffer := jxl.reference[patch.Ref]
-	//	if refBuffer == nil || len(refBuffer) == 0 {
-	//		continue
-	//	}
-	//	lowerCorner := patch.Bounds.ComputeLowerCorner()
-	//	if lowerCorner.Y > refBuffer[0].Height || lowerCorner.X > refBuffer[0].Width {
-	//		return errors.New("patch too large")
-	//	}
-	//	for j := 0; i < len(patch.Positions); j++ {
-	//		x0 := patch.Positions[j].X
-	//		y0 := patch.Positions[j].Y
-	//		if x0 < 0 || y0 < 0 {
-	//			return errors.New("patch size out of bounds")
-	//		}
-	//
-	//		if patch.Bounds.Size.Height+uint32(y0) > header.Bounds.Size.Height ||
-	//			patch.Bounds.Size.Width+uint32(x0) > header.Bounds.Size.Width {
-	//			return errors.New("patch size out of bounds")
-	//		}
-	//
-	//		for d := int32(0); d < int32(colourChannels)+int32(extraChannels); d++ {
-	//			var c int32
-	//			if d < int32(colourChannels) {
-	//				c = 0
-	//			} else {
-	//				c = d - int32(colourChannels) + 1
-	//			}
-	//			info := patch.BlendingInfos[j][c]
-	//			if info.Mode == 0 {
-	//				continue
-	//			}
-	//			var premult bool
-	//			if jxl.imageHeader.HasAlpha() {
-	//				premult = jxl.imageHeader.ExtraChannelInfo[info.AlphaChannel].AlphaAssociated
-	//			} else {
-	//				premult = true
-	//			}
-	//			isAlpha := c > 0 && jxl.imageHeader.ExtraChannelInfo[c-1].EcType == bundle.ALPHA
-	//			if info.Mode > 0 && header.Upsampling > 1 && c > 0 && header.EcUpsampling[c-1]<<jxl.imageHeader.ExtraChannelInfo[c-1].DimShift != header.Upsampling {
-	//				return errors.New("Alpha channel upsampling mismatch during patches")
-	//			}
-	//
-	//			toFloat := true
-	//			switch info.Mode {
-	//			case 1:
-	//				if refBuffer[0].IsInt() && frameBuffer[d].IsInt() {
-	//					refBufferI := refBuffer[d].IntBuffer
-	//					frameBufferI := frameBuffer[d].IntBuffer
-	//					for y := uint32(0); y < patch.Bounds.Size.Height; y++ {
-	//						copy(frameBufferI[y+uint32(patch.Bounds.Origin.Y)][patch.Bounds.Origin.X:], refBufferI[y0+int32(y)][x0:])
-	//					}
-	//					toFloat = false
-	//				}
-	//				break
-	//			case 2:
-	//				if refBuffer[0].IsInt() && frameBuffer[d].IsInt() {
-	//					refBufferI := refBuffer[d].IntBuffer
-	//					frameBufferI := frameBuffer[d].IntBuffer
-	//					for y := int32(0); y < int32(patch.Bounds.Size.Height); y++ {
-	//						for x := int32(0); x < int32(patch.Bounds.Size.Width); x++ {
-	//							frameBufferI[int32(y0)+y][int32(x0)+x] += refBufferI[patch.Bounds.Origin.Y+y][patch.Bounds.Origin.X+x]
-	//						}
-	//					}
-	//					toFloat = false
-	//				}
-	//				break
-	//			}
-	//
-	//			if toFloat {
-	//				var depth uint32
-	//				if c == 0 {
-	//					depth = jxl.imageHeader.BitDepth.BitsPerSample
-	//				} else {
-	//					depth = jxl.imageHeader.ExtraChannelInfo[c-1].BitDepth.BitsPerSample
-	//				}
-	//				max := ^(^int32(0) << depth)
-	//				refBuffer[d].CastToFloatIfMax(max)
-	//				frameBuffer[d].CastToFloatIfMax(max)
-	//			}
-	//			var refBufferF [][]float32
-	//			var frameBufferF [][]float32
-	//			if toFloat {
-	//				refBufferF = refBuffer[d].FloatBuffer
-	//				frameBufferF = frameBuffer[d].FloatBuffer
-	//			} else {
-	//				refBufferF = nil
-	//				frameBufferF = nil
-	//			}
-	//			var alphaBufferOld [][]float32
-	//			var alphaBufferNew [][]float32
-	//			if info.Mode > 3 && hasAlpha {
-	//				depth := jxl.imageHeader.ExtraChannelInfo[info.AlphaChannel].BitDepth.BitsPerSample
-	//				if err := frameBuffer[colourChannels+int(info.AlphaChannel)].CastToFloatIfMax(^(^0 << depth)); err != nil {
-	//					return err
-	//				}
-	//				if err := refBuffer[colourChannels+int(info.AlphaChannel)].CastToFloatIfMax(^(^0 << depth)); err != nil {
-	//					return err
-	//				}
-	//				alphaBufferOld = frameBuffer[colourChannels+int(info.AlphaChannel)].FloatBuffer
-	//				alphaBufferNew = refBuffer[colourChannels+int(info.AlphaChannel)].FloatBuffer
-	//			} else {
-	//				alphaBufferOld = nil
-	//				alphaBufferNew = nil
-	//			}
-	//
-	//			switch info.Mode {
-	//			case 1:
-	//				if !toFloat {
-	//					break
-	//				}
-	//				for y := 0; y < int(patch.Bounds.Size.Height); y++ {
-	//					copy(frameBufferF[y+int(patch.Bounds.Origin.Y)][int(patch.Bounds.Origin.X):], refBufferF[int(y0)+y][x0:])
-	//				}
-	//				break
-	//			case 2:
-	//				if !toFloat {
-	//					break
-	//				}
-	//				for y := int32(0); y < int32(patch.Bounds.Size.Height); y++ {
-	//					for x := int32(0); x < int32(patch.Bounds.Size.Width); x++ {
-	//						frameBufferF[y0+y][x0+x] += refBufferF[patch.Bounds.Origin.Y+y][patch.Bounds.Origin.X+x]
-	//					}
-	//				}
-	//				break
-	//			case 3:
-	//				for y := uint32(0); y < patch.Bounds.Size.Height; y++ {
-	//					for x := uint32(0); x < patch.Bounds.Size.Width; x++ {
-	//						frameBufferF[uint32(y0)+y][uint32(x0)+x] *= refBufferF[uint32(patch.Bounds.Origin.Y)+y][uint32(patch.Bounds.Origin.X)+x]
-	//					}
-	//				}
-	//				break
-	//			case 4:
-	//				if isAlpha {
-	//					for y := int32(0); y < int32(patch.Bounds.Size.Height); y++ {
-	//						newY := y + patch.Bounds.Origin.Y
-	//						oldY := y + int32(y0)
-	//						for x := int32(0); x < int32(patch.Bounds.Size.Width); x++ {
-	//							oldX := x + int32(x0)
-	//							newX := x + patch.Bounds.Origin.X
-	//							newAlpha := alphaBufferNew[newY][newX]
-	//							if info.Clamp {
-	//								if newAlpha < 0 {
-	//									newAlpha = 0
-	//								} else if newAlpha > 1 {
-	//									newAlpha = 1
-	//								}
-	//							}
-	//							frameBufferF[oldY][oldX] = alphaBufferOld[oldY][oldY] +
-	//								newAlpha*(1-alphaBufferOld[oldY][oldX])
-	//						}
-	//					}
-	//				} else if premult {
-	//					for y := int32(0); y < int32(patch.Bounds.Size.Height); y++ {
-	//						newY := y + patch.Bounds.Origin.Y
-	//						oldY := y + int32(y0)
-	//						for x := int32(0); x < int32(patch.Bounds.Size.Width); x++ {
-	//							newX := x + patch.Bounds.Origin.X
-	//							oldX := x + int32(x0)
-	//							newAlpha := alphaBufferNew[newY][newX]
-	//							if info.Clamp {
-	//								if newAlpha < 0 {
-	//									newAlpha = 0
-	//								} else if newAlpha > 1 {
-	//									newAlpha = 1
-	//								}
-	//							}
-	//							frameBufferF[oldY][oldX] = refBufferF[newY][newX] + frameBufferF[oldY][oldX]*(1-newAlpha)
-	//						}
-	//					}
-	//				} else {
-	//					for y := int32(0); y < int32(patch.Bounds.Size.Height); y++ {
-	//						newY := y + patch.Bounds.Origin.Y
-	//						oldY := y + int32(y0)
-	//						for x := int32(0); x < int32(patch.Bounds.Size.Width); x++ {
-	//							newX := x + patch.Bounds.Origin.X
-	//							oldX := x + int32(x0)
-	//							var oldAlpha float32
-	//							var newAlpha float32
-	//							if hasAlpha {
-	//								oldAlpha = alphaBufferOld[oldY][oldX]
-	//								newAlpha = alphaBufferNew[newY][newX]
-	//							} else {
-	//								oldAlpha = 1
-	//								newAlpha = 1
-	//							}
-	//							if info.Clamp {
-	//								if newAlpha < 0 {
-	//									newAlpha = 0
-	//								} else {
-	//									if newAlpha > 1 {
-	//										newAlpha = 1
-	//									}
-	//								}
-	//							}
-	//							alpha := oldAlpha + newAlpha*(1-oldAlpha)
-	//							frameBufferF[oldY][oldX] = (refBufferF[newY][newX]*newAlpha + frameBufferF[oldY][oldX]*oldAlpha*(1-newAlpha)) / alpha
-	//						}
-	//					}
-	//				}
-	//				break
-	//			case 5:
-	//				if isAlpha {
-	//					for y := int32(0); y < int32(patch.Bounds.Size.Height); y++ {
-	//						newY := y + patch.Bounds.Origin.Y
-	//						oldY := y + int32(y0)
-	//						for x := int32(0); x < int32(patch.Bounds.Size.Width); x++ {
-	//							oldX := x + int32(x0)
-	//							newX := x + patch.Bounds.Origin.X
-	//							frameBufferF[oldY][oldX] = alphaBufferOld[oldY][oldY] +
-	//								alphaBufferNew[newY][newX]*(1-alphaBufferOld[oldY][oldX])
-	//						}
-	//					}
-	//				} else if premult {
-	//					for y := int32(0); y < int32(patch.Bounds.Size.Height); y++ {
-	//						newY := y + patch.Bounds.Origin.Y
-	//						oldY := y + int32(y0)
-	//						for x := int32(0); x < int32(patch.Bounds.Size.Width); x++ {
-	//							newX := x + patch.Bounds.Origin.X
-	//							oldX := x + int32(x0)
-	//							newAlpha := alphaBufferNew[newY][newX]
-	//							if info.Clamp {
-	//								if newAlpha < 0 {
-	//									newAlpha = 0
-	//								} else if newAlpha > 1 {
-	//									newAlpha = 1
-	//								}
-	//							}
-	//							frameBufferF[oldY][oldX] = frameBufferF[oldY][oldX] + refBufferF[newY][newX]*(1-newAlpha)
-	//						}
-	//					}
-	//				} else {
-	//					for y := int32(0); y < int32(patch.Bounds.Size.Height); y++ {
-	//						newY := y + patch.Bounds.Origin.Y
-	//						oldY := y + int32(y0)
-	//						for x := int32(0); x < int32(patch.Bounds.Size.Width); x++ {
-	//							newX := x + patch.Bounds.Origin.X
-	//							oldX := x + int32(x0)
-	//							var oldAlpha float32
-	//							var newAlpha float32
-	//							if hasAlpha {
-	//								oldAlpha = alphaBufferOld[oldY][oldX]
-	//								newAlpha = alphaBufferNew[newY][newX]
-	//							} else {
-	//								oldAlpha = 1
-	//								newAlpha = 1
-	//							}
-	//							alpha := oldAlpha + newAlpha*(1-oldAlpha)
-	//							frameBufferF[oldY][oldX] = (frameBufferF[oldY][oldX]*newAlpha + refBufferF[newY][newX]*oldAlpha*(1-newAlpha)) / alpha
-	//						}
-	//					}
-	//				}
-	//				break
-	//			case 6:
-	//				if isAlpha {
-	//					for y := int32(0); y < int32(patch.Bounds.Size.Height); y++ {
-	//						newY := y + patch.Bounds.Origin.Y
-	//						oldY := y + int32(y0)
-	//						for x := int32(0); x < int32(patch.Bounds.Size.Width); x++ {
-	//							oldX := x + int32(x0)
-	//							newX := x + patch.Bounds.Origin.X
-	//							newAlpha := alphaBufferNew[newY][newX]
-	//							if info.Clamp {
-	//								if newAlpha < 0 {
-	//									newAlpha = 0
-	//								} else if newAlpha > 1 {
-	//									newAlpha = 1
-	//								}
-	//							}
-	//							v := float32(1.0)
-	//							if !hasAlpha {
-	//								v = newAlpha
-	//							}
-	//							frameBufferF[oldY][oldX] = v
-	//						}
-	//					}
-	//				} else {
-	//					for y := int32(0); y < int32(patch.Bounds.Size.Height); y++ {
-	//						newY := y + patch.Bounds.Origin.Y
-	//						oldY := y + int32(y0)
-	//						for x := int32(0); x < int32(patch.Bounds.Size.Width); x++ {
-	//							newX := x + patch.Bounds.Origin.X
-	//							oldX := x + int32(x0)
-	//							newAlpha := alphaBufferNew[newY][newX]
-	//							if info.Clamp {
-	//								if newAlpha < 0 {
-	//									newAlpha = 0
-	//								} else if newAlpha > 1 {
-	//									newAlpha = 1
-	//								}
-	//							}
-	//							frameBufferF[oldY][oldX] += refBufferF[newY][newX]
-	//						}
-	//					}
-	//				}
-	//				break
-	//			case 7:
-	//				if isAlpha {
-	//					for y := int32(0); y < int32(patch.Bounds.Size.Height); y++ {
-	//						oldY := y + int32(y0)
-	//						for x := int32(0); x < int32(patch.Bounds.Size.Width); x++ {
-	//							oldX := x + int32(x0)
-	//
-	//							v := float32(1.0)
-	//							if !hasAlpha {
-	//								v = alphaBufferOld[oldY][oldX]
-	//							}
-	//							frameBufferF[oldY][oldX] = v
-	//						}
-	//					}
-	//				} else {
-	//					for y := int32(0); y < int32(patch.Bounds.Size.Height); y++ {
-	//						newY := y + patch.Bounds.Origin.Y
-	//						oldY := y + int32(y0)
-	//						for x := int32(0); x < int32(patch.Bounds.Size.Width); x++ {
-	//							newX := x + patch.Bounds.Origin.X
-	//							oldX := x + int32(x0)
-	//							var oldAlpha float32
-	//							var newAlpha float32
-	//							if hasAlpha {
-	//								oldAlpha = alphaBufferOld[oldY][oldX]
-	//								newAlpha = alphaBufferNew[newY][newX]
-	//							} else {
-	//								oldAlpha = 1
-	//								newAlpha = 1
-	//							}
-	//							if info.Clamp {
-	//								if newAlpha < 0 {
-	//									newAlpha = 0
-	//								} else if newAlpha > 1 {
-	//									newAlpha = 1
-	//								}
-	//							}
-	//							alpha := oldAlpha + newAlpha*(1-oldAlpha)
-	//							frameBufferF[oldY][oldX] = refBufferF[newY][newX] + alpha*frameBufferF[oldY][oldX]
-	//						}
-	//					}
-	//				}
-	//				break
-	//			default:
-	//				return errors.New("unknown blending mode")
-	//			}
-	//		}
-	//	}
-	//}
-	//return nil
+	clamp := func(v float32) float32 {
+		if !info.Clamp {
+			return v
+		}
+		return util.Clamp3Float32(v, 0, 1)
+	}
+
+	ff := frameBuffer.FloatBuffer
+	rf := ref.FloatBuffer
+	for y := int32(0); y < height; y++ {
+		oldY := pos.Y + y
+		newY := refY0 + y
+		for x := int32(0); x < width; x++ {
+			oldX := pos.X + x
+			newX := refX0 + x
+			bg := ff[oldY][oldX]
+			fg := rf[newY][newX]
+			bgAlpha := float32(1)
+			fgAlpha := float32(1)
+			if oaf != nil {
+				bgAlpha = oaf[oldY][oldX]
+				fgAlpha = naf[newY][newX]
+			}
+
+			switch info.Mode {
+			case frame.PATCH_BLEND_REPLACE:
+				ff[oldY][oldX] = fg
+			case frame.PATCH_BLEND_ADD:
+				ff[oldY][oldX] = bg + fg
+			case frame.PATCH_BLEND_MULT:
+				ff[oldY][oldX] = bg * clamp(fg)
+			case frame.PATCH_BLEND_BLEND_ABOVE:
+				ff[oldY][oldX] = blendPatchSample(bg, bgAlpha, fg, clamp(fgAlpha), isAlpha, premult)
+			case frame.PATCH_BLEND_BLEND_BELOW:
+				ff[oldY][oldX] = blendPatchSample(fg, fgAlpha, bg, clamp(bgAlpha), isAlpha, premult)
+			case frame.PATCH_BLEND_ALPHA_WEIGHTED_ABOVE:
+				if !isAlpha {
+					ff[oldY][oldX] = bg + fg*clamp(fgAlpha)
+				}
+			case frame.PATCH_BLEND_ALPHA_WEIGHTED_BELOW:
+				if isAlpha {
+					ff[oldY][oldX] = fg
+				} else {
+					ff[oldY][oldX] = fg + bg*clamp(bgAlpha)
+				}
+			default:
+				return errors.New("unknown patch blending mode")
+			}
+		}
+	}
+	return nil
+}
+
+// blendPatchSample performs "over" alpha compositing of a foreground sample onto a background sample.
+func blendPatchSample(bg float32, bgAlpha float32, fg float32, fgAlpha float32, isAlpha bool, premult bool) float32 {
+	if isAlpha {
+		return 1 - (1-fgAlpha)*(1-bgAlpha)
+	}
+	if premult {
+		return fg + bg*(1-fgAlpha)
+	}
+	newAlpha := 1 - (1-fgAlpha)*(1-bgAlpha)
+	if newAlpha <= 0 {
+		return 0
+	}
+	return (fg*fgAlpha + bg*bgAlpha*(1-fgAlpha)) / newAlpha
 }
 
 func (jxl *JXLCodestreamDecoder) performColourTransforms(matrix *colour.OpsinInverseMatrix, frame *frame.Frame) error {
@@ -713,12 +1067,8 @@ func (jxl *JXLCodestreamDecoder) performColourTransforms(matrix *colour.OpsinInv
 	}
 
 	if frame.Header.DoYCbCr {
-		size, err := frame.GetPaddedFrameSize()
-		if err != nil {
-			return err
-		}
-		for y := uint32(0); y < size.Height; y++ {
-			for x := uint32(0); x < size.Width; x++ {
+		for y := 0; y < len(buffers[1]); y++ {
+			for x := 0; x < len(buffers[1][y]); x++ {
 				cb := buffers[0][y][x]
 				yh := buffers[1][y][x] + 0.50196078431372549019
 				cr := buffers[2][y][x]
@@ -733,7 +1083,7 @@ func (jxl *JXLCodestreamDecoder) performColourTransforms(matrix *colour.OpsinInv
 
 func (jxl *JXLCodestreamDecoder) blendFrame(canvas []image2.ImageBuffer, imgFrame *frame.Frame) error {
 
-	imageSize := jxl.imageHeader.GetSize()
+	imageSize := jxl.canvasSize()
 	header := imgFrame.Header
 	frameStartY := int32(0)
 	if header.Bounds.Origin.X >= 0 {
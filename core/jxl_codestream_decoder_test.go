@@ -1092,6 +1092,8 @@ func TestComputePatches(t *testing.T) {
 		frame       *frame2.Frame
 		imageHeader *bundle.ImageHeader
 		expectErr   bool
+		// expected value of frame buffer 0 after patches. nil means dont check.
+		expectedBuffer [][]int32
 	}{
 		{
 			name: "success",
@@ -1202,6 +1204,42 @@ func TestComputePatches(t *testing.T) {
 			},
 			expectErr: false,
 		},
+		{
+			name:        "replace",
+			imageHeader: patchTestImageHeader(),
+			frame: patchTestFrame(frame2.Patch{
+				Bounds:        util.Rectangle{Origin: util.Point{X: 1, Y: 1}, Size: util.Dimension{Width: 2, Height: 2}},
+				Positions:     []util.Point{{X: 0, Y: 0}, {X: 7, Y: 8}},
+				BlendingInfos: [][]frame2.BlendingInfo{{{Mode: frame2.PATCH_BLEND_REPLACE}}, {{Mode: frame2.PATCH_BLEND_ADD}}},
+			}),
+			expectedBuffer: func() [][]int32 {
+				buf := makeFullMatrix[int32](10, 10, 1)
+				buf[0][0], buf[0][1], buf[1][0], buf[1][1] = 3, 3, 3, 3
+				buf[8][7], buf[8][8], buf[9][7], buf[9][8] = 4, 4, 4, 4
+				return buf
+			}(),
+			expectErr: false,
+		},
+		{
+			name:        "position out of bounds",
+			imageHeader: patchTestImageHeader(),
+			frame: patchTestFrame(frame2.Patch{
+				Bounds:        util.Rectangle{Size: util.Dimension{Width: 2, Height: 2}},
+				Positions:     []util.Point{{X: 9, Y: 9}},
+				BlendingInfos: [][]frame2.BlendingInfo{{{Mode: frame2.PATCH_BLEND_REPLACE}}},
+			}),
+			expectErr: true,
+		},
+		{
+			name:        "reference out of bounds",
+			imageHeader: patchTestImageHeader(),
+			frame: patchTestFrame(frame2.Patch{
+				Bounds:        util.Rectangle{Origin: util.Point{X: 9, Y: 9}, Size: util.Dimension{Width: 2, Height: 2}},
+				Positions:     []util.Point{{X: 0, Y: 0}},
+				BlendingInfos: [][]frame2.BlendingInfo{{{Mode: frame2.PATCH_BLEND_REPLACE}}},
+			}),
+			expectErr: true,
+		},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
 
@@ -1224,11 +1262,41 @@ func TestComputePatches(t *testing.T) {
 			if !tc.expectErr && err != nil {
 				t.Errorf("expected no error but got %v", err)
 			}
+			if tc.expectedBuffer != nil {
+				assert.Equal(t, tc.expectedBuffer, tc.frame.Buffer[0].IntBuffer)
+			}
 
 		})
 	}
 }
 
+func patchTestImageHeader() *bundle.ImageHeader {
+	return &bundle.ImageHeader{
+		Size:     util.Dimension{Width: 10, Height: 10},
+		BitDepth: &bundle.BitDepthHeader{BitsPerSample: 8},
+		ColourEncoding: &colour.ColourEncodingBundle{
+			ColourEncoding: colour.CE_GRAY,
+		},
+	}
+}
+
+// patchTestFrame generates a single channel 10x10 int frame (all 1's) with the supplied patch.
+func patchTestFrame(patch frame2.Patch) *frame2.Frame {
+	return &frame2.Frame{
+		Buffer: []image.ImageBuffer{*image.NewImageBufferFromInts(makeFullMatrix[int32](10, 10, 1))},
+		LfGlobal: &frame2.LFGlobal{
+			Patches: []frame2.Patch{patch},
+		},
+		Header: &frame2.FrameHeader{
+			Upsampling: 1,
+			Bounds: &util.Rectangle{
+				Size: util.Dimension{Width: 10, Height: 10},
+			},
+		},
+		GlobalMetadata: patchTestImageHeader(),
+	}
+}
+
 func TestTransposeBufferInt(t *testing.T) {
 
 	for _, tc := range []struct {
@@ -1400,3 +1468,14 @@ func TestTransposeBufferFloat(t *testing.T) {
 		})
 	}
 }
+
+func TestDecodePreviewWithoutPreview(t *testing.T) {
+	decoder := NewJXLCodestreamDecoder(GenerateTestBitReader(t, "../testdata/tiny2.jxl"), options.NewJXLOptions(nil))
+	img, err := decoder.decodePreview()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if img != nil {
+		t.Errorf("expected nil preview for image without preview")
+	}
+}
@@ -1,6 +1,9 @@
 package core
 
 import (
+	"context"
+	"errors"
+	"image"
 	"io"
 
 	"github.com/kpfaulkner/jxl-go/bundle"
@@ -13,18 +16,25 @@ import (
 type JXLDecoder struct {
 
 	// input Stream
-	in io.ReadSeeker
+	in io.Reader
 
 	// decoder
 	decoder *JXLCodestreamDecoder
 }
 
-func NewJXLDecoder(in io.ReadSeeker, opts *options.JXLOptions) *JXLDecoder {
+// NewJXLDecoder creates a decoder reading from in. If in isn't an io.ReadSeeker (eg a HTTP body)
+// it is read forwards only, in which case only one of the decode functions can be called (followed
+// by GetMetadata if needed).
+func NewJXLDecoder(in io.Reader, opts *options.JXLOptions) *JXLDecoder {
 	jxl := &JXLDecoder{
 		in: in,
 	}
 
-	realBR := jxlio.NewBitStreamReader(in)
+	rs, seekable := in.(io.ReadSeeker)
+	if !seekable {
+		rs = jxlio.NewForwardReader(in)
+	}
+	realBR := jxlio.NewBitStreamReader(rs)
 	br := testcommon.NewBitReaderRecorder(realBR)
 
 	// if nil options, then create one
@@ -32,6 +42,7 @@ func NewJXLDecoder(in io.ReadSeeker, opts *options.JXLOptions) *JXLDecoder {
 		opts = options.NewJXLOptions(nil)
 	}
 	jxl.decoder = NewJXLCodestreamDecoder(br, opts)
+	jxl.decoder.forwardOnly = !seekable
 	return jxl
 }
 
@@ -45,6 +56,94 @@ func (jxl *JXLDecoder) Decode() (*JXLImage, error) {
 	return jxlImage, nil
 }
 
+// DecodeContext decodes the image in the same way as Decode, but stops once ctx is cancelled
+// (eg when the request the image is being decoded for has gone away). Cancellation is checked
+// between frames, LF groups, pass groups and the restoration filters, and ctx.Err() is returned.
+func (jxl *JXLDecoder) DecodeContext(ctx context.Context) (*JXLImage, error) {
+
+	jxlImage, err := jxl.decoder.decodeContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return jxlImage, nil
+}
+
+// DecodeRegion decodes the region of the image given by rect, in the same way as Decode. rect is in
+// the coordinates of the oriented image and is clipped to the image, and the returned image is the
+// size of the clipped rect. Only the LF groups and groups of the frame needed for the region are
+// decoded, which makes this much cheaper than Decode for small regions of large images. Frames
+// that later frames refer to, and modular images using the squeeze or palette transforms, are
+// still decoded in full.
+func (jxl *JXLDecoder) DecodeRegion(rect image.Rectangle) (*JXLImage, error) {
+
+	jxlImage, err := jxl.decoder.decodeRegion(rect)
+	if err != nil {
+		return nil, err
+	}
+
+	return jxlImage, nil
+}
+
+// DecodeDownscaled decodes the image scaled down by factor, in the same way as Decode. VarDCT frames
+// are only decoded as far as their 1:8 LF image, so this skips the HF coefficients and IDCT
+// entirely and is much cheaper than Decode, which makes it useful for thumbnails. From 1:64 the LF
+// of the image's LF frame is used where it has one. Other factors are averaged down from the
+// largest of these that isn't more than factor, so 1:12 is resampled from the 1:8 image, and
+// factors below 8 need a full decode. Modular frames, and frames of images with extra channels,
+// are decoded in full and then scaled down (see frame.Frame.CanDecodeLF). Patches, splines and
+// noise are not rendered.
+func (jxl *JXLDecoder) DecodeDownscaled(factor int) (*JXLImage, error) {
+
+	if factor <= 0 {
+		return nil, errors.New("downscaling factor must be positive")
+	}
+	jxlImage, err := jxl.decoder.decodeDownscaled(uint32(factor))
+	if err != nil {
+		return nil, err
+	}
+
+	return jxlImage, nil
+}
+
+// DecodeAnimation decodes all displayed frames of the image along with their durations.
+// Still images are returned as a single frame animation.
+func (jxl *JXLDecoder) DecodeAnimation() (*JXLAnimation, error) {
+
+	anim, err := jxl.decoder.decodeAnimation()
+	if err != nil {
+		return nil, err
+	}
+
+	return anim, nil
+}
+
+// DecodePreview decodes only the preview frame of the image (if the image has one). This is
+// much cheaper than decoding the full image, so is useful for generating thumbnails.
+// Returns nil if the image doesn't contain a preview.
+func (jxl *JXLDecoder) DecodePreview() (*JXLImage, error) {
+
+	jxlImage, err := jxl.decoder.decodePreview()
+	if err != nil {
+		return nil, err
+	}
+
+	return jxlImage, nil
+}
+
+// GetMetadata returns the Exif, XMP and JUMBF boxes of the image. This only reads the container
+// boxes, not the image, and can be called before or after decoding (only after for non-seekable
+// input, as the metadata can follow the codestream).
+func (jxl *JXLDecoder) GetMetadata() (*Metadata, error) {
+
+	metadata, err := jxl.decoder.getMetadata()
+	if err != nil {
+		return nil, err
+	}
+
+	return metadata, nil
+}
+
 func (jxl *JXLDecoder) GetImageHeader() (*bundle.ImageHeader, error) {
 
 	header, err := jxl.decoder.GetImageHeader()
@@ -55,3 +154,10 @@ func (jxl *JXLDecoder) GetImageHeader() (*bundle.ImageHeader, error) {
 	return header, nil
 
 }
+
+// ReconstructJPEG writes out the original JPEG of an image that was losslessly recompressed
+// from a JPEG, using the JPEG reconstruction (jbrd) box and the coefficients of the image.
+// Returns an error if the image wasn't created from a JPEG.
+func (jxl *JXLDecoder) ReconstructJPEG(w io.Writer) error {
+	return jxl.decoder.reconstructJPEG(w)
+}
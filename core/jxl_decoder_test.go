@@ -0,0 +1,350 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"testing"
+	"testing/iotest"
+
+	"github.com/kpfaulkner/jxl-go/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeNonSeekable(t *testing.T) {
+
+	for _, filename := range []string{
+		"../testdata/tiny2.jxl",
+		"../testdata/grayscale.jxl",
+		"../testdata/lenna.jxl",
+		"../testdata/patches.jxl",
+		"../testdata/church.jxl",
+		"../testdata/blendmodes_5.jxl",
+	} {
+		t.Run(filename, func(t *testing.T) {
+			data, err := os.ReadFile(filename)
+			require.NoError(t, err)
+			expected, err := NewJXLDecoder(bytes.NewReader(data), nil).DecodeAnimation()
+			require.NoError(t, err)
+
+			// OneByteReader hides Seek, and only returns a byte per Read.
+			decoder := NewJXLDecoder(iotest.OneByteReader(bytes.NewReader(data)), nil)
+			anim, err := decoder.DecodeAnimation()
+			require.NoError(t, err)
+			require.Len(t, anim.Frames, len(expected.Frames))
+			for i := range anim.Frames {
+				assert.Equal(t, expected.Frames[i].Buffer, anim.Frames[i].Buffer)
+			}
+
+			_, err = decoder.Decode()
+			assert.Equal(t, errAlreadyRead, err)
+		})
+	}
+}
+
+func TestDecodeNonSeekableHeader(t *testing.T) {
+
+	data, err := os.ReadFile("../testdata/church.jxl")
+	require.NoError(t, err)
+	decoder := NewJXLDecoder(iotest.OneByteReader(bytes.NewReader(data)), nil)
+
+	// the image can still be decoded after reading the header.
+	header, err := decoder.GetImageHeader()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(1623), header.Size.Width)
+	img, err := decoder.Decode()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(1623), img.Width)
+}
+
+func TestDecodeNonSeekableMetadata(t *testing.T) {
+
+	data, err := os.ReadFile("../testdata/ants.jxl")
+	require.NoError(t, err)
+	expected, err := NewJXLDecoder(bytes.NewReader(data), nil).GetMetadata()
+	require.NoError(t, err)
+
+	// the Exif box comes between the jxlp boxes.
+	decoder := NewJXLDecoder(iotest.OneByteReader(bytes.NewReader(data)), nil)
+	_, err = decoder.DecodePreview()
+	require.NoError(t, err)
+	metadata, err := decoder.GetMetadata()
+	require.NoError(t, err)
+	assert.Equal(t, expected, metadata)
+
+	// reading the metadata first skips over the codestream.
+	decoder = NewJXLDecoder(iotest.OneByteReader(bytes.NewReader(data)), nil)
+	metadata, err = decoder.GetMetadata()
+	require.NoError(t, err)
+	assert.Equal(t, expected, metadata)
+	_, err = decoder.Decode()
+	assert.Equal(t, errAlreadyRead, err)
+}
+
+func TestReconstructJPEGNonSeekable(t *testing.T) {
+
+	// the jbrd box comes after the first jxlp box.
+	data, err := os.ReadFile("../testdata/church.jxl")
+	require.NoError(t, err)
+	var expected bytes.Buffer
+	require.NoError(t, NewJXLDecoder(bytes.NewReader(data), nil).ReconstructJPEG(&expected))
+
+	var out bytes.Buffer
+	require.NoError(t, NewJXLDecoder(iotest.OneByteReader(bytes.NewReader(data)), nil).ReconstructJPEG(&out))
+	assert.Equal(t, expected.Bytes(), out.Bytes())
+}
+
+func TestDecodeRegion(t *testing.T) {
+
+	for _, tc := range []struct {
+		filename string
+		region   image.Rectangle
+	}{
+		{
+			// VarDCT, with the region crossing group boundaries.
+			filename: "../testdata/lenna.jxl",
+			region:   image.Rect(200, 250, 300, 270),
+		},
+		{
+			// orientation 5 (transposed).
+			filename: "../testdata/bench.jxl",
+			region:   image.Rect(500, 10, 606, 90),
+		},
+		{
+			// orientation 7.
+			filename: "../testdata/sunset_logo.jxl",
+			region:   image.Rect(700, 0, 800, 1386),
+		},
+		{
+			// 4x upsampling.
+			filename: "../testdata/upsampling.jxl",
+			region:   image.Rect(301, 203, 405, 297),
+		},
+		{
+			// modular with a palette, so every group is decoded.
+			filename: "../testdata/delta_palette.jxl",
+			region:   image.Rect(462, 600, 555, 751),
+		},
+	} {
+		t.Run(tc.filename, func(t *testing.T) {
+			data, err := os.ReadFile(tc.filename)
+			require.NoError(t, err)
+			full, err := NewJXLDecoder(bytes.NewReader(data), nil).Decode()
+			require.NoError(t, err)
+
+			img, err := NewJXLDecoder(bytes.NewReader(data), nil).DecodeRegion(tc.region)
+			require.NoError(t, err)
+			assert.Equal(t, uint32(tc.region.Dx()), img.Width)
+			assert.Equal(t, uint32(tc.region.Dy()), img.Height)
+			require.Len(t, img.Buffer, len(full.Buffer))
+			for c := range img.Buffer {
+				expected := cropBuffer(full.Buffer[c], util.Rectangle{
+					Origin: util.Point{X: int32(tc.region.Min.X), Y: int32(tc.region.Min.Y)},
+					Size:   util.Dimension{Width: uint32(tc.region.Dx()), Height: uint32(tc.region.Dy())},
+				})
+				assert.Equal(t, expected, img.Buffer[c])
+			}
+		})
+	}
+}
+
+// countingReader counts the bytes read from a seekable input.
+type countingReader struct {
+	io.ReadSeeker
+	read int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadSeeker.Read(p)
+	r.read += n
+	return n, err
+}
+
+func TestDecodeRegionSkipsSections(t *testing.T) {
+
+	data, err := os.ReadFile("../testdata/lenna.jxl")
+	require.NoError(t, err)
+
+	// lenna.jxl is 512x512 with 256x256 groups, so the region only needs one of the four
+	// groups. The sections of the other groups are seeked over rather than read.
+	in := &countingReader{ReadSeeker: bytes.NewReader(data)}
+	_, err = NewJXLDecoder(in, nil).DecodeRegion(image.Rect(10, 10, 50, 50))
+	require.NoError(t, err)
+	assert.Less(t, in.read, len(data)/2, "read %d of %d bytes", in.read, len(data))
+}
+
+func TestDecodeRegionClipped(t *testing.T) {
+
+	data, err := os.ReadFile("../testdata/lenna.jxl")
+	require.NoError(t, err)
+
+	// the region is clipped to the image.
+	img, err := NewJXLDecoder(bytes.NewReader(data), nil).DecodeRegion(image.Rect(-10, 500, 20, 600))
+	require.NoError(t, err)
+	assert.Equal(t, uint32(20), img.Width)
+	assert.Equal(t, uint32(12), img.Height)
+
+	_, err = NewJXLDecoder(bytes.NewReader(data), nil).DecodeRegion(image.Rect(600, 0, 700, 100))
+	assert.Error(t, err)
+}
+
+func TestDecodeDownscaled(t *testing.T) {
+
+	for _, tc := range []struct {
+		filename string
+		factor   int
+		// maxDiff is the largest mean difference from the average of the full image. Fractional
+		// factors are resampled from LF samples without the detail within them, so differ more.
+		maxDiff float32
+	}{
+		{
+			filename: "../testdata/lenna.jxl",
+			factor:   8,
+		},
+		{
+			// YCbCr (recompressed JPEG).
+			filename: "../testdata/church.jxl",
+			factor:   16,
+		},
+		{
+			// orientation 5 (transposed).
+			filename: "../testdata/bench.jxl",
+			factor:   32,
+		},
+		{
+			filename: "../testdata/grayscale.jxl",
+			factor:   64,
+		},
+		{
+			// modular, so decoded in full and averaged.
+			filename: "../testdata/art.jxl",
+			factor:   16,
+		},
+		{
+			// resampled from the 1:8 image.
+			filename: "../testdata/lenna.jxl",
+			factor:   12,
+			maxDiff:  0.015,
+		},
+		{
+			// below the 1:8 image, so decoded in full.
+			filename: "../testdata/church.jxl",
+			factor:   3,
+		},
+		{
+			// resampled from the 1:64 image.
+			filename: "../testdata/grayscale.jxl",
+			factor:   100,
+			maxDiff:  0.025,
+		},
+		{
+			// VarDCT with an alpha channel, which has no LF image, so decoded in full.
+			filename: "../testdata/upsampling.jxl",
+			factor:   16,
+		},
+	} {
+		t.Run(fmt.Sprintf("%s/%d", tc.filename, tc.factor), func(t *testing.T) {
+			data, err := os.ReadFile(tc.filename)
+			require.NoError(t, err)
+			full, err := NewJXLDecoder(bytes.NewReader(data), nil).Decode()
+			require.NoError(t, err)
+
+			img, err := NewJXLDecoder(bytes.NewReader(data), nil).DecodeDownscaled(tc.factor)
+			require.NoError(t, err)
+			assert.Equal(t, (full.Width+uint32(tc.factor)-1)/uint32(tc.factor), img.Width)
+			assert.Equal(t, (full.Height+uint32(tc.factor)-1)/uint32(tc.factor), img.Height)
+			require.Len(t, img.Buffer, len(full.Buffer))
+
+			// the LF image is close to, but not the same as, the average of the full image.
+			for c := range img.Buffer {
+				expected, err := full.Buffer[c].DownsampleTo(int32(img.Height), int32(img.Width), float64(tc.factor))
+				require.NoError(t, err)
+				require.NoError(t, expected.CastToFloatIfMax(255))
+				actual := img.Buffer[c]
+				require.NoError(t, actual.CastToFloatIfMax(255))
+				diff := float32(0)
+				for y := range expected.FloatBuffer {
+					for x := range expected.FloatBuffer[y] {
+						d := expected.FloatBuffer[y][x] - actual.FloatBuffer[y][x]
+						diff += max(d, -d)
+					}
+				}
+				assert.Less(t, diff/float32(expected.Width*expected.Height), max(tc.maxDiff, 0.01))
+			}
+		})
+	}
+}
+
+func TestDecodeDownscaledInvalidFactor(t *testing.T) {
+
+	data, err := os.ReadFile("../testdata/lenna.jxl")
+	require.NoError(t, err)
+	for _, factor := range []int{0, -1, -8} {
+		_, err = NewJXLDecoder(bytes.NewReader(data), nil).DecodeDownscaled(factor)
+		assert.Error(t, err, "factor %d", factor)
+	}
+}
+
+// cancelReader cancels its context once limit bytes have been read.
+type cancelReader struct {
+	io.ReadSeeker
+	limit  int
+	cancel context.CancelFunc
+}
+
+func (r *cancelReader) Read(p []byte) (int, error) {
+	n, err := r.ReadSeeker.Read(p)
+	r.limit -= n
+	if r.limit <= 0 {
+		r.cancel()
+	}
+	return n, err
+}
+
+func TestDecodeContext(t *testing.T) {
+
+	for _, filename := range []string{
+		"../testdata/lenna.jxl",
+		"../testdata/church.jxl",
+		"../testdata/bench.jxl",
+		"../testdata/blendmodes_5.jxl",
+	} {
+		t.Run(filename, func(t *testing.T) {
+			data, err := os.ReadFile(filename)
+			require.NoError(t, err)
+			expected, err := NewJXLDecoder(bytes.NewReader(data), nil).Decode()
+			require.NoError(t, err)
+
+			img, err := NewJXLDecoder(bytes.NewReader(data), nil).DecodeContext(context.Background())
+			require.NoError(t, err)
+			assert.Equal(t, expected.Buffer, img.Buffer)
+
+			// cancelled part way through the frame data.
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			r := &cancelReader{ReadSeeker: bytes.NewReader(data), limit: len(data) / 2, cancel: cancel}
+			img, err = NewJXLDecoder(r, nil).DecodeContext(ctx)
+			assert.Equal(t, context.Canceled, err)
+			assert.Nil(t, img)
+		})
+	}
+}
+
+func TestDecodeContextCancelled(t *testing.T) {
+
+	data, err := os.ReadFile("../testdata/lenna.jxl")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = NewJXLDecoder(bytes.NewReader(data), nil).DecodeContext(ctx)
+	assert.Equal(t, context.Canceled, err)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	_, err = NewJXLDecoder(bytes.NewReader(data), nil).DecodeContext(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
@@ -1,12 +1,14 @@
 package core
 
 import (
+	"errors"
 	"fmt"
 	"image"
 
 	"github.com/kpfaulkner/jxl-go/bundle"
 	"github.com/kpfaulkner/jxl-go/colour"
 	image2 "github.com/kpfaulkner/jxl-go/image"
+	"github.com/kpfaulkner/jxl-go/options"
 	"github.com/kpfaulkner/jxl-go/util"
 )
 
@@ -37,6 +39,44 @@ type JXLImage struct {
 	alphaIsPremultiplied bool
 }
 
+// ExtraChannel describes an extra channel (alpha, depth, thermal, selection mask etc).
+// Use ExtraChannelData or ExtraChannelToImage to get the samples.
+type ExtraChannel struct {
+
+	// Index of the extra channel
+	Index int
+	Name  string
+
+	// EcType is one of the bundle extra channel types, e.g. bundle.DEPTH or bundle.THERMAL
+	EcType int32
+
+	// CfaIndex is the colour filter array channel, only meaningful for bundle.COLOR_FILTER_ARRAY channels
+	CfaIndex int32
+
+	// DimShift is how much the channel was downsampled by when encoded (1 << DimShift).
+	// The channel data is returned upsampled to the full image size.
+	DimShift      int32
+	BitsPerSample uint32
+	ExpBits       uint32
+}
+
+// IsFloat returns true if the channel holds floating point samples.
+func (ec *ExtraChannel) IsFloat() bool {
+	return ec.ExpBits != 0
+}
+
+// SpotColour is a spot colour extra channel along with the colour it represents.
+type SpotColour struct {
+
+	// Index of the extra channel
+	Index                      int
+	Name                       string
+	Red, Green, Blue, Solidity float32
+
+	// Data is the amount of spot colour for each pixel, from 0 to 1.
+	Data [][]float32
+}
+
 // NewJXLImageWithBuffer creates a new JXLImage with the given buffer and header.
 func NewJXLImageWithBuffer(buffer []image2.ImageBuffer, header bundle.ImageHeader) (*JXLImage, error) {
 	jxl := &JXLImage{}
@@ -101,7 +141,7 @@ func NewJXLImageFromJXLImage(img *JXLImage, copyBuffer bool) (*JXLImage, error)
 	jxl.bitDepths = make([]uint32, len(img.bitDepths))
 	copy(jxl.bitDepths, img.bitDepths)
 
-	for _, ib := range jxl.Buffer {
+	for _, ib := range img.Buffer {
 		buf := image2.NewImageBufferFromImageBuffer(&ib, copyBuffer)
 		jxl.Buffer = append(jxl.Buffer, *buf)
 	}
@@ -167,17 +207,205 @@ func (jxl *JXLImage) IsFloatBased() bool {
 func (jxl *JXLImage) HasAlpha() bool {
 	return jxl.imageHeader.HasAlpha()
 }
+
+// IsCMYK returns true if the image is CMYK, in which case ToImage returns an image.CMYK.
+func (jxl *JXLImage) IsCMYK() bool {
+	return jxl.imageHeader.IsCMYK()
+}
+
 func (jxl *JXLImage) HasICCProfile() bool {
 	return len(jxl.iccProfile) > 0
 }
 
+// GetICCProfile returns the ICC profile describing the image colour, nil if it doesn't have one.
+// For CMYK images this is the CMYK profile needed to convert the image.CMYK from ToImage to RGB.
+func (jxl *JXLImage) GetICCProfile() []byte {
+	return jxl.iccProfile
+}
+
 func (jxl *JXLImage) NumExtraChannels() int {
 	return len(jxl.imageHeader.ExtraChannelInfo)
 }
 
+// GetSpotColours returns the spot colour extra channels of the image, in channel order.
+// The channel data is copied so can be modified freely.
+func (jxl *JXLImage) GetSpotColours() ([]SpotColour, error) {
+	colours := jxl.imageHeader.GetColourChannelCount()
+	var spots []SpotColour
+	for i, eci := range jxl.imageHeader.ExtraChannelInfo {
+		if eci.EcType != bundle.SPOT_COLOR {
+			continue
+		}
+		c := colours + i
+		if c >= len(jxl.Buffer) {
+			return nil, fmt.Errorf("missing buffer for extra channel %d", i)
+		}
+		buf := image2.NewImageBufferFromImageBuffer(&jxl.Buffer[c], true)
+		if err := buf.CastToFloatIfMax(^(^0 << jxl.bitDepths[c])); err != nil {
+			return nil, err
+		}
+		spots = append(spots, SpotColour{
+			Index:    i,
+			Name:     eci.Name,
+			Red:      eci.Red,
+			Green:    eci.Green,
+			Blue:     eci.Blue,
+			Solidity: eci.Solidity,
+			Data:     buf.FloatBuffer,
+		})
+	}
+	return spots, nil
+}
+
+// GetExtraChannels returns the description of each extra channel, in channel order.
+func (jxl *JXLImage) GetExtraChannels() []ExtraChannel {
+	channels := make([]ExtraChannel, len(jxl.imageHeader.ExtraChannelInfo))
+	for i, eci := range jxl.imageHeader.ExtraChannelInfo {
+		channels[i] = ExtraChannel{
+			Index:         i,
+			Name:          eci.Name,
+			EcType:        eci.EcType,
+			CfaIndex:      eci.CfaIndex,
+			DimShift:      eci.DimShift,
+			BitsPerSample: eci.BitDepth.BitsPerSample,
+			ExpBits:       eci.BitDepth.ExpBits,
+		}
+	}
+	return channels
+}
+
+// ExtraChannelData returns a copy of extra channel index at its native precision. Floating point
+// channels are returned as decoded and integer channels as their integer sample values,
+// e.g. 0 to 4095 for a 12 bit channel. Channels with a DimShift are upsampled to the image size.
+func (jxl *JXLImage) ExtraChannelData(index int) ([][]float32, error) {
+	buf, eci, err := jxl.extraChannelBuffer(index)
+	if err != nil {
+		return nil, err
+	}
+
+	data := util.MakeMatrix2D[float32](buf.Height, buf.Width)
+	scale := float32(1.0)
+	if buf.IsFloat() && !eci.IsFloat() {
+		// integer channel that has been normalised to 0..1 while decoding
+		scale = float32(int32(^(^0 << eci.BitsPerSample)))
+	}
+	for y := 0; y < int(buf.Height); y++ {
+		for x := 0; x < int(buf.Width); x++ {
+			if buf.IsFloat() {
+				data[y][x] = buf.FloatBuffer[y][x] * scale
+			} else {
+				data[y][x] = float32(buf.IntBuffer[y][x])
+			}
+		}
+	}
+	return data, nil
+}
+
+// ExtraChannelToImage returns extra channel index as an image.Gray16 holding the channel's integer
+// sample values, unscaled. So a 12 bit depth channel has values from 0 to 4095. Channels with a
+// DimShift are upsampled to the image size. Floating point channels, or channels over 16 bits,
+// need ExtraChannelData instead.
+func (jxl *JXLImage) ExtraChannelToImage(index int) (*image.Gray16, error) {
+	buf, eci, err := jxl.extraChannelBuffer(index)
+	if err != nil {
+		return nil, err
+	}
+	if eci.IsFloat() || eci.BitsPerSample > 16 {
+		return nil, fmt.Errorf("extra channel %d has %d bit samples (%d exponent bits), use ExtraChannelData", index, eci.BitsPerSample, eci.ExpBits)
+	}
+
+	maxValue := int32(^(^0 << eci.BitsPerSample))
+	img := image.NewGray16(image.Rect(0, 0, int(buf.Width), int(buf.Height)))
+	pos := 0
+	for y := 0; y < int(buf.Height); y++ {
+		for x := 0; x < int(buf.Width); x++ {
+			var v int32
+			if buf.IsFloat() {
+				v = int32(buf.FloatBuffer[y][x]*float32(maxValue) + 0.5)
+			} else {
+				v = buf.IntBuffer[y][x]
+			}
+			if v < 0 {
+				v = 0
+			} else if v > maxValue {
+				v = maxValue
+			}
+			img.Pix[pos] = uint8(v >> 8)
+			img.Pix[pos+1] = uint8(v)
+			pos += 2
+		}
+	}
+	return img, nil
+}
+
+func (jxl *JXLImage) extraChannelBuffer(index int) (*image2.ImageBuffer, *ExtraChannel, error) {
+	channels := jxl.GetExtraChannels()
+	if index < 0 || index >= len(channels) {
+		return nil, nil, fmt.Errorf("invalid extra channel index %d", index)
+	}
+	c := jxl.imageHeader.GetColourChannelCount() + index
+	if c >= len(jxl.Buffer) {
+		return nil, nil, fmt.Errorf("missing buffer for extra channel %d", index)
+	}
+	return &jxl.Buffer[c], &channels[index], nil
+}
+
+// renderSpotColours returns a copy of the image with the spot colour channels blended onto the
+// colour channels. Each spot colour is mixed in by its amount multiplied by the solidity, working
+// on the samples as decoded (before any colour transform). Grayscale uses the spot colour luminance.
+func (jxl *JXLImage) renderSpotColours() (*JXLImage, error) {
+	spots, err := jxl.GetSpotColours()
+	if err != nil {
+		return nil, err
+	}
+	if len(spots) == 0 {
+		return jxl, nil
+	}
+
+	img, err := NewJXLImageFromJXLImage(jxl, true)
+	if err != nil {
+		return nil, err
+	}
+
+	colours := img.imageHeader.GetColourChannelCount()
+	for c := 0; c < colours; c++ {
+		if err := img.Buffer[c].CastToFloatIfMax(^(^0 << img.bitDepths[c])); err != nil {
+			return nil, err
+		}
+	}
+
+	var weights []float32
+	if colours == 1 {
+		if weights, err = colour.GetLuminanceWeights(img.primariesXY, img.whiteXY); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, spot := range spots {
+		spotColour := []float32{spot.Red, spot.Green, spot.Blue}
+		if colours == 1 {
+			spotColour = []float32{weights[0]*spot.Red + weights[1]*spot.Green + weights[2]*spot.Blue}
+		}
+		for c := 0; c < colours; c++ {
+			b := img.Buffer[c].FloatBuffer
+			if len(b) != len(spot.Data) || len(b[0]) != len(spot.Data[0]) {
+				return nil, fmt.Errorf("spot colour channel %d size does not match image", spot.Index)
+			}
+			for y := 0; y < len(b); y++ {
+				for x := 0; x < len(b[y]); x++ {
+					mix := spot.Solidity * spot.Data[y][x]
+					b[y][x] = mix*spotColour[c] + (1-mix)*b[y][x]
+				}
+			}
+		}
+	}
+	return img, nil
+}
+
 // ChannelToImage converts a single channel to grayscale Go image.Image interface.
 // Can be used for any channel (R,G,B, alpha, depth..... etc) but is really expected to be
-// used for NON "regular" channels (ie depth etc)
+// used for NON "regular" channels (ie depth etc). The result is always 8 bit, use
+// ExtraChannelToImage or ExtraChannelData to get extra channels at full precision.
 func (jxl *JXLImage) ChannelToImage(channelNo int) (image.Image, error) {
 	buffer, err := jxl.getBuffer(true)
 	if err != nil {
@@ -212,28 +440,66 @@ func (jxl *JXLImage) ChannelToImage(channelNo int) (image.Image, error) {
 	return img, nil
 }
 
-// ToImage converts to standard Go image.Image NRGBA format for the R,G,B and alpha channels
+// ToImage converts to standard Go image.Image NRGBA format for the R,G,B and alpha channels.
+// Images over 8 bits per sample are returned as NRGBA64 (or Gray16 for grayscale).
+// Grayscale images with alpha are returned as NRGBA/NRGBA64 with the gray replicated.
+// CMYK images are returned as (8 bit) image.CMYK, with the colour left as described by the
+// image's CMYK ICC profile.
 func (jxl *JXLImage) ToImage() (image.Image, error) {
+	return jxl.ToImageWithOptions(nil)
+}
+
+// ToImageWithOptions converts to standard Go image.Image, with opts controlling the format of
+// the returned image. nil opts gives the same result as ToImage.
+func (jxl *JXLImage) ToImageWithOptions(opts *options.ImageOptions) (image.Image, error) {
+
+	opts = options.NewImageOptions(opts)
 
 	var bitDepth int32
-	if jxl.imageHeader.BitDepth.BitsPerSample > 8 {
-		bitDepth = 16
-	} else {
+	switch opts.BitDepth {
+	case options.BIT_DEPTH_AUTO:
+		if jxl.imageHeader.BitDepth.BitsPerSample > 8 {
+			bitDepth = 16
+		} else {
+			bitDepth = 8
+		}
+	case options.BIT_DEPTH_8, options.BIT_DEPTH_16:
+		bitDepth = int32(opts.BitDepth)
+	default:
+		return nil, fmt.Errorf("unsupported output bit depth %d", opts.BitDepth)
+	}
+
+	// Go only has an 8 bit CMYK image.
+	cmyk := jxl.IsCMYK()
+	if cmyk {
 		bitDepth = 8
 	}
 
 	//gray := jxlImage.ColourEncoding == color.CE_GRAY
 	primaries := colour.CM_PRI_SRGB
 	tf := colour.TF_SRGB
-	if jxl.isHDR() {
+	if jxl.isHDR() && !opts.SDR {
 		primaries = colour.CM_PRI_BT2100
 		tf = colour.TF_PQ
 	}
 	whitePoint := colour.CM_WP_D65
 	iccProfile := jxl.iccProfile
+	src := jxl
 	var err error
-	if iccProfile == nil {
-		// transforms in place
+	if jxl.alphaIsPremultiplied {
+		// premultiplication applies to the samples as encoded, so needs undoing before
+		// any colour transform. Re-associated below if requested.
+		if jxl, err = jxl.unpremultiplyAlpha(); err != nil {
+			return nil, err
+		}
+	}
+	if opts.RenderSpotColours {
+		if jxl, err = jxl.renderSpotColours(); err != nil {
+			return nil, err
+		}
+	}
+	// CMYK can only be described by an ICC profile, so leave the samples as they are.
+	if iccProfile == nil && !cmyk {
 		img, err := jxl.transform(primaries, whitePoint, tf, PEAK_DETECT_AUTO)
 		if err != nil {
 			return nil, err
@@ -241,8 +507,11 @@ func (jxl *JXLImage) ToImage() (image.Image, error) {
 		jxl = img
 	}
 	maxValue := int32(^(^0 << bitDepth))
-	coerce := jxl.alphaIsPremultiplied
-	buffer, err := jxl.getBuffer(false)
+	associated := opts.AssociatedAlpha && jxl.HasAlpha()
+	coerce := associated
+
+	// the buffers get cast and clamped below, so copy them if nothing above already has.
+	buffer, err := jxl.getBuffer(jxl == src)
 	if err != nil {
 		return nil, err
 	}
@@ -261,8 +530,10 @@ func (jxl *JXLImage) ToImage() (image.Image, error) {
 			}
 		}
 	}
-	if jxl.alphaIsPremultiplied {
-		panic("not implemented")
+	if associated {
+		if err := jxl.multiplyAlpha(buffer, false); err != nil {
+			return nil, err
+		}
 	}
 	for c := 0; c < len(buffer); c++ {
 		if buffer[c].IsInt() && jxl.bitDepths[c] == uint32(bitDepth) {
@@ -278,93 +549,223 @@ func (jxl *JXLImage) ToImage() (image.Image, error) {
 
 	var img image.Image
 	colourCount := jxl.imageHeader.GetColourChannelCount()
-	if colourCount == 1 {
-		img = jxl.createGrayScaleImage(buffer)
+	if cmyk {
+		img = jxl.createCMYKImage(buffer)
+	} else if associated {
+		img = jxl.createAssociatedAlphaImage(buffer, bitDepth)
+	} else if colourCount == 1 && !jxl.HasAlpha() {
+		img = jxl.createGrayScaleImage(buffer, bitDepth)
 	} else {
-		img = jxl.create24BitImage(buffer)
+		img = jxl.createRGBImage(buffer, bitDepth)
 	}
 	return img, nil
 }
 
-func (jxl *JXLImage) createGrayScaleImage(buffer []image2.ImageBuffer) image.Image {
-	img := image.NewGray(image.Rect(0, 0, int(buffer[0].Width), int(buffer[0].Height)))
-	pix := img.Pix
-	dx := img.Bounds().Dx()
-	dy := img.Bounds().Dy()
+// createGrayScaleImage creates an image.Gray (or image.Gray16 for 16 bit) from the first channel.
+func (jxl *JXLImage) createGrayScaleImage(buffer []image2.ImageBuffer, bitDepth int32) image.Image {
+	rect := image.Rect(0, 0, int(buffer[0].Width), int(buffer[0].Height))
+	maxValue := int32(^(^0 << bitDepth))
+
+	var img image.Image
+	var pix []uint8
+	if bitDepth > 8 {
+		gray := image.NewGray16(rect)
+		img, pix = gray, gray.Pix
+	} else {
+		gray := image.NewGray(rect)
+		img, pix = gray, gray.Pix
+	}
+
 	pos := 0
+	for y := 0; y < rect.Dy(); y++ {
+		for x := 0; x < rect.Dx(); x++ {
+			pos = putSample(pix, pos, getSample(&buffer[0], x, y, maxValue), bitDepth)
+		}
+	}
+	return img
+}
 
-	if buffer[0].IsFloat() {
-		for y := 0; y < dy; y++ {
-			for x := 0; x < dx; x++ {
+// createCMYKImage creates an image.CMYK from the 8 bit C, M and Y colour channels and the CMYK_BLACK
+// extra channel. JXL stores these with 0 as full ink, image.CMYK is the other way around.
+func (jxl *JXLImage) createCMYKImage(buffer []image2.ImageBuffer) image.Image {
+	rect := image.Rect(0, 0, int(buffer[0].Width), int(buffer[0].Height))
+	img := image.NewCMYK(rect)
+	black := &buffer[jxl.imageHeader.GetColourChannelCount()+jxl.imageHeader.GetBlackChannelIndex()]
+	channels := []*image2.ImageBuffer{&buffer[0], &buffer[1], &buffer[2], black}
 
-				// assumption of 8 bits per channel but only 1 channel (grayscale)
-				pix[pos] = uint8(buffer[0].FloatBuffer[y][x] * 255)
+	pos := 0
+	for y := 0; y < rect.Dy(); y++ {
+		for x := 0; x < rect.Dx(); x++ {
+			for _, c := range channels {
+				img.Pix[pos] = uint8(255 - getSample(c, x, y, 255))
 				pos++
-
 			}
 		}
+	}
+	return img
+}
+
+// createRGBImage creates an image.NRGBA (or image.NRGBA64 for 16 bit) from the colour channels
+// and alpha, if the image has it. Grayscale is replicated to R,G and B, which is how gray with
+// alpha is returned as Go has no gray+alpha image type.
+func (jxl *JXLImage) createRGBImage(buffer []image2.ImageBuffer, bitDepth int32) image.Image {
+	colours := jxl.imageHeader.GetColourChannelCount()
+	rect := image.Rect(0, 0, int(buffer[0].Width), int(buffer[0].Height))
+	maxValue := int32(^(^0 << bitDepth))
+
+	var img image.Image
+	var pix []uint8
+	if bitDepth > 8 {
+		rgba := image.NewNRGBA64(rect)
+		img, pix = rgba, rgba.Pix
 	} else {
+		rgba := image.NewNRGBA(rect)
+		img, pix = rgba, rgba.Pix
+	}
 
-		for y := 0; y < dy; y++ {
-			for x := 0; x < dx; x++ {
-				pix[pos] = uint8(buffer[0].IntBuffer[y][x])
-				pos++
+	var alpha *image2.ImageBuffer
+	if jxl.HasAlpha() {
+		alpha = &buffer[colours+int(jxl.alphaIndex)]
+	}
+
+	pos := 0
+	for y := 0; y < rect.Dy(); y++ {
+		for x := 0; x < rect.Dx(); x++ {
+			for c := 0; c < 3; c++ {
+				pos = putSample(pix, pos, getSample(&buffer[min(c, colours-1)], x, y, maxValue), bitDepth)
 			}
+			a := maxValue
+			if alpha != nil {
+				a = getSample(alpha, x, y, maxValue)
+			}
+			pos = putSample(pix, pos, a, bitDepth)
 		}
 	}
 	return img
 }
 
-func (jxl *JXLImage) create24BitImage(buffer []image2.ImageBuffer) image.Image {
-	img := image.NewNRGBA(image.Rect(0, 0, int(buffer[0].Width), int(buffer[0].Height)))
-	pix := img.Pix
-	dx := img.Bounds().Dx()
-	dy := img.Bounds().Dy()
-	pos := 0
+// getSample returns the sample at x,y as an int between 0 and maxValue, for both int and float buffers.
+func getSample(buffer *image2.ImageBuffer, x int, y int, maxValue int32) int32 {
+	if buffer.IsFloat() {
+		v := buffer.FloatBuffer[y][x]*float32(maxValue) + 0.5
+		if v <= 0 {
+			return 0
+		}
+		return min(int32(v), maxValue)
+	}
+	return buffer.IntBuffer[y][x]
+}
 
-	if buffer[0].IsFloat() {
-		for y := 0; y < dy; y++ {
-			for x := 0; x < dx; x++ {
+// putSample writes v into pix at pos, as either 8 bit or 16 bit big endian (the layout Go images use).
+// Returns the position of the next sample.
+func putSample(pix []uint8, pos int, v int32, bitDepth int32) int {
+	if bitDepth > 8 {
+		pix[pos] = uint8(v >> 8)
+		pos++
+	}
+	pix[pos] = uint8(v)
+	return pos + 1
+}
 
-				// assumption of 8 bits per channel.
-				pix[pos] = uint8(buffer[0].FloatBuffer[y][x] * 255)
-				pos++
-				pix[pos] = uint8(buffer[1].FloatBuffer[y][x] * 255)
-				pos++
-				pix[pos] = uint8(buffer[2].FloatBuffer[y][x] * 255)
-				pos++
+// createAssociatedAlphaImage creates a premultiplied image.RGBA (or image.RGBA64 for 16 bit) from
+// buffers that already have alpha multiplied in. Grayscale is replicated to R,G and B.
+func (jxl *JXLImage) createAssociatedAlphaImage(buffer []image2.ImageBuffer, bitDepth int32) image.Image {
+	colours := jxl.imageHeader.GetColourChannelCount()
+	alpha := &buffer[colours+int(jxl.alphaIndex)]
+	rect := image.Rect(0, 0, int(buffer[0].Width), int(buffer[0].Height))
+	maxValue := int32(^(^0 << bitDepth))
+	dx := rect.Dx()
+	dy := rect.Dy()
 
-				if jxl.imageHeader.HasAlpha() {
-					// FIXME(kpfaulkner) deal with alpha channels properly
-					pix[pos] = 255 // uint8(buffer[3].FloatBuffer[y][x] * 255)
-					pos++
-				} else {
-					pos++
+	var img image.Image
+	var pix []uint8
+	if bitDepth > 8 {
+		rgba := image.NewRGBA64(rect)
+		img, pix = rgba, rgba.Pix
+	} else {
+		rgba := image.NewRGBA(rect)
+		img, pix = rgba, rgba.Pix
+	}
+
+	pos := 0
+	for y := 0; y < dy; y++ {
+		for x := 0; x < dx; x++ {
+			a := getSample(alpha, x, y, maxValue)
+			for c := 0; c < 4; c++ {
+				v := a
+				if c < 3 {
+					// out of gamut samples can end up above alpha, which isn't valid premultiplied colour.
+					v = min(getSample(&buffer[min(c, colours-1)], x, y, maxValue), a)
 				}
+				pos = putSample(pix, pos, v, bitDepth)
 			}
 		}
-	} else {
+	}
+	return img
+}
 
-		for y := 0; y < dy; y++ {
-			for x := 0; x < dx; x++ {
-				pix[pos] = uint8(buffer[0].IntBuffer[y][x])
-				pos++
-				pix[pos] = uint8(buffer[1].IntBuffer[y][x])
-				pos++
-				pix[pos] = uint8(buffer[2].IntBuffer[y][x])
-				pos++
+// multiplyAlpha multiplies the colour channels in buffer by alpha, or divides them by alpha if
+// inverse is set. The colour and alpha buffers need to be float.
+func (jxl *JXLImage) multiplyAlpha(buffer []image2.ImageBuffer, inverse bool) error {
+	colours := jxl.imageHeader.GetColourChannelCount()
+	alphaChannel := colours + int(jxl.alphaIndex)
+	if jxl.alphaIndex < 0 || alphaChannel >= len(buffer) {
+		return errors.New("image has no alpha channel")
+	}
+	alpha := buffer[alphaChannel]
+	if !alpha.IsFloat() {
+		return errors.New("alpha channel is not float")
+	}
 
-				if jxl.imageHeader.HasAlpha() {
-					pix[pos] = uint8(buffer[3].IntBuffer[y][x])
-					pos++
+	for c := 0; c < colours; c++ {
+		if !buffer[c].IsFloat() {
+			return fmt.Errorf("channel %d is not float", c)
+		}
+		if buffer[c].Width != alpha.Width || buffer[c].Height != alpha.Height {
+			return fmt.Errorf("channel %d size does not match alpha", c)
+		}
+		b := buffer[c].FloatBuffer
+		for y := 0; y < int(alpha.Height); y++ {
+			for x := 0; x < int(alpha.Width); x++ {
+				a := alpha.FloatBuffer[y][x]
+				if !inverse {
+					b[y][x] *= a
+				} else if a > 0 {
+					b[y][x] /= a
 				} else {
-					pix[pos] = 255
-					pos++
+					// colour is undefined when fully transparent.
+					b[y][x] = 0
 				}
 			}
 		}
 	}
-	return img
+	return nil
+}
+
+// unpremultiplyAlpha returns a copy of the image with the colour channels divided by alpha.
+func (jxl *JXLImage) unpremultiplyAlpha() (*JXLImage, error) {
+	img, err := NewJXLImageFromJXLImage(jxl, true)
+	if err != nil {
+		return nil, err
+	}
+
+	colours := img.imageHeader.GetColourChannelCount()
+	alphaChannel := colours + int(img.alphaIndex)
+	if img.alphaIndex < 0 || alphaChannel >= len(img.Buffer) {
+		return nil, errors.New("image has no alpha channel")
+	}
+	for c := 0; c < len(img.Buffer); c++ {
+		if c < colours || c == alphaChannel {
+			if err := img.Buffer[c].CastToFloatIfMax(^(^0 << img.bitDepths[c])); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := img.multiplyAlpha(img.Buffer, true); err != nil {
+		return nil, err
+	}
+	img.alphaIsPremultiplied = false
+	return img, nil
 }
 
 func (jxl *JXLImage) isHDR() bool {
@@ -377,19 +778,23 @@ func (jxl *JXLImage) isHDR() bool {
 		!col.Prim.Matches(colour.CM_PRI_P3)
 }
 
+// transform converts the image to the given primaries, white point and transfer function. The
+// image itself is left untouched, a converted copy is returned unless no conversion is needed.
 func (jxl *JXLImage) transform(primaries *colour.CIEPrimaries, whitePoint *colour.CIEXY, transfer int32, peakDetect int32) (*JXLImage, error) {
 
-	if primaries.Matches(jxl.primariesXY) && whitePoint.Matches(jxl.whiteXY) {
+	// grayscale has no primaries, and adapting the white point leaves achromatic samples unchanged.
+	if jxl.ColorEncoding == colour.CE_GRAY ||
+		(primaries.Matches(jxl.primariesXY) && whitePoint.Matches(jxl.whiteXY)) {
 		return jxl.transferImage(transfer, peakDetect)
 	}
 
 	var img *JXLImage
 	var err error
-	if img, err = jxl.linearize(); err != nil {
+	if img, err = jxl.linearizedCopy(); err != nil {
 		return nil, err
 	}
 
-	if err := img.toneMapLinear(); err != nil {
+	if err := img.toneMapLinear(primaries, whitePoint); err != nil {
 		return nil, err
 	}
 
@@ -457,32 +862,18 @@ func (jxl *JXLImage) determinePeak() (float32, error) {
 	}
 }
 
+// transferWithOp returns a copy of the image with f applied to every colour sample.
 func (jxl *JXLImage) transferWithOp(f func(v float32) float32) (*JXLImage, error) {
 
-	colours := util.IfThenElse(jxl.ColorEncoding == colour.CE_GRAY, 1, 3)
-	buffers := util.MakeMatrix3D[float32](colours, 0, 0)
-	for c := 0; c < colours; c++ {
-		if err := jxl.Buffer[c].CastToFloatIfMax(^(^0 << jxl.bitDepths[c])); err != nil {
-			return nil, err
-		}
-		buffers[c] = jxl.Buffer[c].FloatBuffer
-	}
-
-	img, err := NewJXLImageFromJXLImage(jxl, false)
+	img, err := NewJXLImageFromJXLImage(jxl, true)
 	if err != nil {
 		return nil, err
 	}
 
-	for c := 0; c < colours; c++ {
-		b := img.Buffer[c].FloatBuffer
-		for y := 0; y < int(jxl.Height); y++ {
-			for x := 0; x < int(jxl.Width); x++ {
-				b[y][x] = f(buffers[c][y][x])
-			}
-		}
+	if err := img.transferInPlace(func(v float64) float64 { return float64(f(float32(v))) }); err != nil {
+		return nil, err
 	}
 	return img, nil
-
 }
 
 func (jxl *JXLImage) transferImage(transfer int32, peakDetect int32) (*JXLImage, error) {
@@ -492,13 +883,20 @@ func (jxl *JXLImage) transferImage(transfer int32, peakDetect int32) (*JXLImage,
 
 	var err error
 	var img *JXLImage
-	if img, err = jxl.linearize(); err != nil {
+	if img, err = jxl.linearizedCopy(); err != nil {
 		return nil, err
 	}
 
-	if img.taggedTransfer == colour.TF_PQ &&
+	// PQ and HLG linearise to absolute light, where 1.0 is 10000 nits.
+	toPQ := transfer == colour.TF_PQ || transfer == colour.TF_LINEAR || transfer == colour.TF_HLG
+	if img.taggedTransfer == colour.TF_HLG && !toPQ {
+		// HLG is relative to the display peak, so that becomes 1.0 again for SDR output.
+		scale := 10000.0 / float64(img.intensityTarget())
+		if err := img.transferInPlace(func(v float64) float64 { return v * scale }); err != nil {
+			return nil, err
+		}
+	} else if img.taggedTransfer == colour.TF_PQ &&
 		(peakDetect == PEAK_DETECT_AUTO || peakDetect == PEAK_DETECT_ON) {
-		toPQ := transfer == colour.TF_PQ || transfer == colour.TF_LINEAR
 		fromPQ := img.transfer == colour.TF_PQ || img.transfer == colour.TF_LINEAR
 		if fromPQ && !toPQ {
 			peak, err := img.determinePeak()
@@ -515,6 +913,12 @@ func (jxl *JXLImage) transferImage(transfer int32, peakDetect int32) (*JXLImage,
 		}
 	}
 
+	if transfer == colour.TF_HLG {
+		if err := img.hlgOOTF(true); err != nil {
+			return nil, err
+		}
+	}
+
 	transferFunction, err := colour.GetTransferFunction(transfer)
 	if err != nil {
 		return nil, err
@@ -522,20 +926,152 @@ func (jxl *JXLImage) transferImage(transfer int32, peakDetect int32) (*JXLImage,
 	if err := img.transferInPlace(transferFunction.FromLinear); err != nil {
 		return nil, err
 	}
+	img.transfer = transfer
 
 	return img, nil
 }
 
+// linearize returns the image with a linear transfer function. If the image is
+// already linear it is returned as is, otherwise a linearised copy is returned.
 func (jxl *JXLImage) linearize() (*JXLImage, error) {
 
 	if jxl.transfer == colour.TF_LINEAR {
 		return jxl, nil
 	}
 
-	panic("not implemented")
+	transferFunction, err := colour.GetTransferFunction(jxl.transfer)
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := NewJXLImageFromJXLImage(jxl, true)
+	if err != nil {
+		return nil, err
+	}
+	if err := img.transferInPlace(transferFunction.ToLinear); err != nil {
+		return nil, err
+	}
+	if jxl.transfer == colour.TF_HLG {
+		// the HLG OETF gives scene light, the OOTF takes that to display light.
+		if err := img.hlgOOTF(false); err != nil {
+			return nil, err
+		}
+	}
+	img.transfer = colour.TF_LINEAR
+	return img, nil
+}
+
+// intensityTarget returns the peak luminance of the image in nits.
+func (jxl *JXLImage) intensityTarget() float32 {
+	if jxl.imageHeader.ToneMapping == nil || jxl.imageHeader.ToneMapping.IntensityTarget <= 0 {
+		return colour.NewToneMapping().IntensityTarget
+	}
+	return jxl.imageHeader.ToneMapping.IntensityTarget
+}
+
+// hlgOOTF applies the HLG OOTF in place to scene linear colour channels, giving display light on
+// the same absolute scale as linear PQ. If inverse is set it goes from display light back to scene light.
+// The system gamma comes from the image intensity target, and luminance from the image primaries.
+func (jxl *JXLImage) hlgOOTF(inverse bool) error {
+	peak := jxl.intensityTarget()
+	gamma := colour.GetHLGSystemGamma(peak)
+
+	colours := 1
+	weights := []float32{1}
+	if jxl.ColorEncoding != colour.CE_GRAY {
+		colours = 3
+		var err error
+		if weights, err = colour.GetLuminanceWeights(jxl.primariesXY, jxl.whiteXY); err != nil {
+			return err
+		}
+	}
+
+	buffers := make([][][]float32, colours)
+	for c := 0; c < colours; c++ {
+		if err := jxl.Buffer[c].CastToFloatIfMax(^(^0 << jxl.bitDepths[c])); err != nil {
+			return err
+		}
+		buffers[c] = jxl.Buffer[c].FloatBuffer
+	}
+
+	// display light is relative to the display peak, rescale it to the 10000 nit PQ range.
+	scale := peak / 10000.0
+	rgb := make([]float32, colours)
+	for y := 0; y < len(buffers[0]); y++ {
+		for x := 0; x < len(buffers[0][y]); x++ {
+			for c := 0; c < colours; c++ {
+				rgb[c] = buffers[c][y][x]
+				if inverse {
+					rgb[c] /= scale
+				}
+			}
+			colour.HLGOOTF(rgb, weights, gamma, inverse)
+			for c := 0; c < colours; c++ {
+				if !inverse {
+					rgb[c] *= scale
+				}
+				buffers[c][y][x] = rgb[c]
+			}
+		}
+	}
+	return nil
 }
 
-func (jxl *JXLImage) toneMapLinear() error {
+// linearizedCopy is the same as linearize but always returns a copy, so the result
+// can be modified in place without touching the original image.
+func (jxl *JXLImage) linearizedCopy() (*JXLImage, error) {
+	img, err := jxl.linearize()
+	if err != nil {
+		return nil, err
+	}
+	if img == jxl {
+		return NewJXLImageFromJXLImage(jxl, true)
+	}
+	return img, nil
+}
+
+// toneMapLinear converts the (linear) colour channels in place from the image primaries and
+// white point to the requested ones. Samples that fall outside the target gamut are clipped at 0,
+// anything over 1 is left for the caller to clamp after the transfer function.
+func (jxl *JXLImage) toneMapLinear(primaries *colour.CIEPrimaries, whitePoint *colour.CIEXY) error {
+
+	if jxl.transfer != colour.TF_LINEAR {
+		return errors.New("image must be linear to convert primaries")
+	}
+	if primaries.Matches(jxl.primariesXY) && whitePoint.Matches(jxl.whiteXY) {
+		return nil
+	}
+	if jxl.primariesXY == nil || jxl.whiteXY == nil {
+		return errors.New("image has no primaries or white point to convert from")
+	}
+
+	conversionMatrix, err := colour.GetConversionMatrix(*primaries, *whitePoint, *jxl.primariesXY, *jxl.whiteXY)
+	if err != nil {
+		return err
+	}
+
+	for c := 0; c < 3; c++ {
+		if err := jxl.Buffer[c].CastToFloatIfMax(^(^0 << jxl.bitDepths[c])); err != nil {
+			return err
+		}
+	}
+
+	r := jxl.Buffer[0].FloatBuffer
+	g := jxl.Buffer[1].FloatBuffer
+	b := jxl.Buffer[2].FloatBuffer
+	for y := 0; y < len(r); y++ {
+		for x := 0; x < len(r[y]); x++ {
+			rgb := [3]float32{r[y][x], g[y][x], b[y][x]}
+			r[y][x] = max(0, conversionMatrix[0][0]*rgb[0]+conversionMatrix[0][1]*rgb[1]+conversionMatrix[0][2]*rgb[2])
+			g[y][x] = max(0, conversionMatrix[1][0]*rgb[0]+conversionMatrix[1][1]*rgb[1]+conversionMatrix[1][2]*rgb[2])
+			b[y][x] = max(0, conversionMatrix[2][0]*rgb[0]+conversionMatrix[2][1]*rgb[1]+conversionMatrix[2][2]*rgb[2])
+		}
+	}
+
+	jxl.primariesXY = primaries
+	jxl.whiteXY = whitePoint
+	jxl.primaries = colour.PRI_CUSTOM
+	jxl.whitePoint = colour.WP_CUSTOM
 	return nil
 }
 
@@ -547,15 +1083,15 @@ func (jxl *JXLImage) transferInPlace(transferFunction func(float64) float64) err
 
 	buffers := util.MakeMatrix3D[float32](colours, 0, 0)
 	for c := 0; c < colours; c++ {
-		if err := jxl.Buffer[c].CastToFloatIfMax(int32(jxl.bitDepths[c])); err != nil {
+		if err := jxl.Buffer[c].CastToFloatIfMax(^(^0 << jxl.bitDepths[c])); err != nil {
 			return err
 		}
 		buffers[c] = jxl.Buffer[c].FloatBuffer
 	}
 
 	for c := 0; c < colours; c++ {
-		for y := 0; y < int(jxl.Height); y++ {
-			for x := 0; x < int(jxl.Width); x++ {
+		for y := 0; y < len(buffers[c]); y++ {
+			for x := 0; x < len(buffers[c][y]); x++ {
 				buffers[c][y][x] = float32(transferFunction(float64(buffers[c][y][x])))
 			}
 		}
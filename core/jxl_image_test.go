@@ -0,0 +1,768 @@
+package core
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"math"
+	"testing"
+
+	"github.com/kpfaulkner/jxl-go/bundle"
+	"github.com/kpfaulkner/jxl-go/colour"
+	image2 "github.com/kpfaulkner/jxl-go/image"
+	"github.com/kpfaulkner/jxl-go/options"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// makeTestImage creates a single row image with the given colour encoding and extra channels.
+// pixels holds the colour samples, followed by a sample for each extra channel, for each pixel.
+func makeTestImage(t *testing.T, ceb *colour.ColourEncodingBundle, bitDepth uint32, extraChannels []bundle.ExtraChannelInfo, pixels [][]int32) *JXLImage {
+	t.Helper()
+
+	header := bundle.ImageHeader{
+		BitDepth:         &bundle.BitDepthHeader{BitsPerSample: bitDepth},
+		ColourEncoding:   ceb,
+		ExtraChannelInfo: extraChannels,
+		OrientedWidth:    uint32(len(pixels)),
+		OrientedHeight:   1,
+	}
+	for i, eci := range extraChannels {
+		if eci.EcType == bundle.ALPHA {
+			header.AlphaIndices = append(header.AlphaIndices, int32(i))
+		}
+	}
+
+	buffer := make([]image2.ImageBuffer, len(pixels[0]))
+	for c := range buffer {
+		row := make([]int32, len(pixels))
+		for x := range pixels {
+			row[x] = pixels[x][c]
+		}
+		buffer[c] = *image2.NewImageBufferFromInts([][]int32{row})
+	}
+
+	img, err := NewJXLImageWithBuffer(buffer, header)
+	require.NoError(t, err)
+	return img
+}
+
+// alphaChannel is the extra channel info of an alpha channel, for makeTestImage.
+func alphaChannel(bitDepth uint32, premultiplied bool) []bundle.ExtraChannelInfo {
+	return []bundle.ExtraChannelInfo{{
+		EcType:          bundle.ALPHA,
+		BitDepth:        bundle.BitDepthHeader{BitsPerSample: bitDepth},
+		AlphaAssociated: premultiplied,
+	}}
+}
+
+// makeAlphaImage creates a sRGB image with an alpha channel. pixels holds R,G,B,A per pixel.
+func makeAlphaImage(t *testing.T, bitDepth uint32, premultiplied bool, pixels [][4]int32) *JXLImage {
+	t.Helper()
+
+	ceb, err := colour.NewColourEncodingBundle()
+	require.NoError(t, err)
+	p := make([][]int32, len(pixels))
+	for i := range pixels {
+		p[i] = pixels[i][:]
+	}
+	return makeTestImage(t, ceb, bitDepth, alphaChannel(bitDepth, premultiplied), p)
+}
+
+func TestToImagePremultipliedAlpha(t *testing.T) {
+
+	for _, tc := range []struct {
+		name          string
+		bitDepth      uint32
+		premultiplied bool
+		associated    bool
+		pixels        [][4]int32
+		expected      image.Image
+	}{
+		{
+			name:          "premultiplied to NRGBA",
+			bitDepth:      8,
+			premultiplied: true,
+			pixels:        [][4]int32{{64, 32, 0, 128}, {10, 20, 30, 0}},
+			expected: &image.NRGBA{
+				Pix:    []uint8{128, 64, 0, 128, 0, 0, 0, 0},
+				Stride: 8,
+				Rect:   image.Rect(0, 0, 2, 1),
+			},
+		},
+		{
+			name:          "premultiplied kept as RGBA",
+			bitDepth:      8,
+			premultiplied: true,
+			associated:    true,
+			pixels:        [][4]int32{{64, 32, 0, 128}, {0, 0, 0, 0}},
+			expected: &image.RGBA{
+				Pix:    []uint8{64, 32, 0, 128, 0, 0, 0, 0},
+				Stride: 8,
+				Rect:   image.Rect(0, 0, 2, 1),
+			},
+		},
+		{
+			name:       "straight alpha to RGBA",
+			bitDepth:   8,
+			associated: true,
+			pixels:     [][4]int32{{255, 128, 0, 51}, {255, 255, 255, 255}},
+			expected: &image.RGBA{
+				Pix:    []uint8{51, 26, 0, 51, 255, 255, 255, 255},
+				Stride: 8,
+				Rect:   image.Rect(0, 0, 2, 1),
+			},
+		},
+		{
+			name:          "premultiplied kept as RGBA64",
+			bitDepth:      16,
+			premultiplied: true,
+			associated:    true,
+			pixels:        [][4]int32{{0x1234, 0x0100, 0, 0x8000}, {0xffff, 0xffff, 0xffff, 0xffff}},
+			expected: &image.RGBA64{
+				Pix:    []uint8{0x12, 0x34, 0x01, 0x00, 0, 0, 0x80, 0x00, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+				Stride: 16,
+				Rect:   image.Rect(0, 0, 2, 1),
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			jxlImage := makeAlphaImage(t, tc.bitDepth, tc.premultiplied, tc.pixels)
+			img, err := jxlImage.ToImageWithOptions(&options.ImageOptions{AssociatedAlpha: tc.associated})
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, img)
+		})
+	}
+}
+
+func TestToImagePremultipliedAlphaLeavesImageUnchanged(t *testing.T) {
+	jxlImage := makeAlphaImage(t, 8, true, [][4]int32{{64, 32, 0, 128}})
+
+	first, err := jxlImage.ToImage()
+	require.NoError(t, err)
+	second, err := jxlImage.ToImage()
+	require.NoError(t, err)
+
+	assert.Equal(t, first, second)
+	assert.Equal(t, int32(64), jxlImage.Buffer[0].IntBuffer[0][0])
+}
+
+func TestWritePNGPremultipliedAlpha(t *testing.T) {
+	jxlImage := makeAlphaImage(t, 8, true, [][4]int32{{64, 32, 0, 128}, {10, 20, 30, 0}})
+
+	var buf bytes.Buffer
+	writer := &PNGWriter{}
+	require.NoError(t, writer.WritePNG(jxlImage, &buf))
+
+	img, err := png.Decode(&buf)
+	require.NoError(t, err)
+	nrgba, ok := img.(*image.NRGBA)
+	require.True(t, ok)
+	assert.Equal(t, []uint8{128, 64, 0, 128, 0, 0, 0, 0}, nrgba.Pix)
+}
+
+func TestToImageColourConversion(t *testing.T) {
+
+	for _, tc := range []struct {
+		name      string
+		primaries int32
+		tf        int32
+		pixels    [][]int32
+		expected  []uint8
+	}{
+		{
+			name:      "P3 with sRGB transfer",
+			primaries: colour.PRI_P3,
+			tf:        colour.TF_SRGB,
+			pixels:    [][]int32{{255, 255, 255}, {128, 128, 128}, {255, 0, 0}, {0, 0, 0}},
+			// P3 red is outside sRGB so gets clipped.
+			expected: []uint8{255, 255, 255, 255, 128, 128, 128, 255, 255, 0, 0, 255, 0, 0, 0, 255},
+		},
+		{
+			name:      "P3 with gamma 2.2",
+			primaries: colour.PRI_P3,
+			tf:        4545455,
+			pixels:    [][]int32{{255, 255, 255}, {128, 128, 128}, {0, 0, 0}},
+			expected:  []uint8{255, 255, 255, 255, 129, 129, 129, 255, 0, 0, 0, 255},
+		},
+		{
+			name:      "sRGB with BT709 transfer",
+			primaries: colour.PRI_SRGB,
+			tf:        colour.TF_BT709,
+			pixels:    [][]int32{{255, 255, 255}, {128, 64, 0}},
+			expected:  []uint8{255, 255, 255, 255, 140, 79, 0, 255},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ceb, err := colour.NewColourEncodingBundle()
+			require.NoError(t, err)
+			ceb.Primaries = tc.primaries
+			ceb.Prim = colour.GetPrimaries(tc.primaries)
+			ceb.Tf = tc.tf
+
+			jxlImage := makeTestImage(t, ceb, 8, nil, tc.pixels)
+			img, err := jxlImage.ToImage()
+			require.NoError(t, err)
+			nrgba, ok := img.(*image.NRGBA)
+			require.True(t, ok)
+			assert.Equal(t, tc.expected, nrgba.Pix)
+		})
+	}
+}
+
+func TestToImageRec2020ToPQ(t *testing.T) {
+	ceb, err := colour.NewColourEncodingBundle()
+	require.NoError(t, err)
+	ceb.Primaries = colour.PRI_BT2100
+	ceb.Prim = colour.GetPrimaries(colour.PRI_BT2100)
+
+	jxlImage := makeTestImage(t, ceb, 8, nil, [][]int32{{255, 255, 255}, {0, 0, 0}})
+	img, err := jxlImage.ToImage()
+	require.NoError(t, err)
+
+	// the sRGB curve is swapped for PQ, with peak detection scaling white up to the PQ maximum.
+	assert.Equal(t, []uint8{255, 255, 255, 255, 0, 0, 0, 255}, img.(*image.NRGBA).Pix)
+}
+
+func TestToImageLeavesFloatImageUnchanged(t *testing.T) {
+	ceb, err := colour.NewColourEncodingBundle()
+	require.NoError(t, err)
+	ceb.Primaries = colour.PRI_P3
+	ceb.Prim = colour.GetPrimaries(colour.PRI_P3)
+
+	jxlImage := makeTestImage(t, ceb, 8, nil, [][]int32{{200, 100, 50}})
+	jxlImage.transfer = colour.TF_LINEAR
+	for c := range jxlImage.Buffer {
+		require.NoError(t, jxlImage.Buffer[c].CastToFloatIfMax(255))
+	}
+
+	first, err := jxlImage.ToImage()
+	require.NoError(t, err)
+	second, err := jxlImage.ToImage()
+	require.NoError(t, err)
+
+	assert.Equal(t, first, second)
+	assert.True(t, jxlImage.Buffer[0].IsFloat())
+	assert.Equal(t, colour.TF_LINEAR, jxlImage.transfer)
+}
+
+func TestLinearize(t *testing.T) {
+	ceb, err := colour.NewColourEncodingBundle()
+	require.NoError(t, err)
+	jxlImage := makeTestImage(t, ceb, 8, nil, [][]int32{{255, 128, 0}})
+
+	lin, err := jxlImage.linearize()
+	require.NoError(t, err)
+
+	assert.Equal(t, colour.TF_LINEAR, lin.transfer)
+	assert.Equal(t, colour.TF_SRGB, jxlImage.transfer)
+	assert.True(t, jxlImage.Buffer[0].IsInt())
+	assert.InDelta(t, 1.0, lin.Buffer[0].FloatBuffer[0][0], 1e-5)
+	assert.InDelta(t, 0.2158605, lin.Buffer[1].FloatBuffer[0][0], 1e-5)
+	assert.InDelta(t, 0.0, lin.Buffer[2].FloatBuffer[0][0], 1e-5)
+
+	same, err := lin.linearize()
+	require.NoError(t, err)
+	assert.Same(t, lin, same)
+}
+
+func TestToneMapLinear(t *testing.T) {
+	ceb, err := colour.NewColourEncodingBundle()
+	require.NoError(t, err)
+	jxlImage := makeTestImage(t, ceb, 8, nil, [][]int32{{255, 255, 255}, {255, 0, 0}})
+
+	// needs to be linear first
+	assert.Error(t, jxlImage.toneMapLinear(colour.CM_PRI_P3, colour.CM_WP_D65))
+
+	lin, err := jxlImage.linearize()
+	require.NoError(t, err)
+	require.NoError(t, lin.toneMapLinear(colour.CM_PRI_P3, colour.CM_WP_D65))
+	assert.Same(t, colour.CM_PRI_P3, lin.primariesXY)
+
+	// white is unchanged, sRGB red sits inside P3.
+	expected := [][]float32{{1, 0.8224621}, {1, 0.0331941}, {1, 0.0170827}}
+	for c := 0; c < 3; c++ {
+		for x := 0; x < 2; x++ {
+			assert.InDelta(t, expected[c][x], lin.Buffer[c].FloatBuffer[0][x], 1e-4)
+		}
+	}
+}
+
+func TestTransformHLG(t *testing.T) {
+	ceb, err := colour.NewColourEncodingBundle()
+	require.NoError(t, err)
+	ceb.Primaries = colour.PRI_BT2100
+	ceb.Prim = colour.GetPrimaries(colour.PRI_BT2100)
+	ceb.Tf = colour.TF_HLG
+
+	makeHLGImage := func() *JXLImage {
+		jxlImage := makeTestImage(t, ceb, 8, nil, [][]int32{{255, 255, 255}, {191, 191, 191}, {0, 0, 0}})
+		jxlImage.imageHeader.ToneMapping = &colour.ToneMapping{IntensityTarget: 1000}
+		return jxlImage
+	}
+
+	t.Run("to PQ", func(t *testing.T) {
+		img, err := makeHLGImage().ToImage()
+		require.NoError(t, err)
+
+		// HLG peak at 1000 nits is 0.75 in PQ, reference white (75% HLG) is ~203 nits.
+		assert.Equal(t, []uint8{192, 192, 192, 255, 148, 148, 148, 255, 0, 0, 0, 255}, img.(*image.NRGBA).Pix)
+	})
+
+	t.Run("to sRGB", func(t *testing.T) {
+		// the HLG peak is the display peak, and 75% HLG is reference white at ~0.49 in sRGB.
+		expected := []uint8{255, 255, 255, 255, 124, 124, 124, 255, 0, 0, 0, 255}
+		img, err := makeHLGImage().ToImageWithOptions(&options.ImageOptions{SDR: true})
+		require.NoError(t, err)
+		assert.Equal(t, expected, img.(*image.NRGBA).Pix)
+
+		var buf bytes.Buffer
+		writer := &PNGWriter{SDR: true}
+		require.NoError(t, writer.WritePNG(makeHLGImage(), &buf))
+		// written with a sRGB chunk rather than a PQ ICC profile.
+		assert.True(t, bytes.Contains(buf.Bytes(), []byte("sRGB")))
+		assert.False(t, bytes.Contains(buf.Bytes(), []byte("iCCP")))
+		decoded, err := png.Decode(&buf)
+		require.NoError(t, err)
+		// opaque PNGs decode as image.RGBA.
+		assert.Equal(t, expected, decoded.(*image.RGBA).Pix)
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		jxlImage := makeHLGImage()
+		lin, err := jxlImage.linearize()
+		require.NoError(t, err)
+		img, err := lin.transferImage(colour.TF_HLG, PEAK_DETECT_AUTO)
+		require.NoError(t, err)
+
+		for x, expected := range []float32{1, 191.0 / 255.0, 0} {
+			assert.InDelta(t, expected, img.Buffer[1].FloatBuffer[0][x], 1e-4)
+		}
+	})
+}
+
+func TestToImageBitDepth(t *testing.T) {
+	ceb, err := colour.NewColourEncodingBundle()
+	require.NoError(t, err)
+	grayCeb, err := colour.NewColourEncodingBundle()
+	require.NoError(t, err)
+	grayCeb.ColourEncoding = colour.CE_GRAY
+
+	for _, tc := range []struct {
+		name        string
+		ceb         *colour.ColourEncodingBundle
+		bitDepth    uint32
+		alpha       bool
+		outBitDepth int
+		pixels      [][]int32
+		expected    image.Image
+		expectErr   bool
+	}{
+		{
+			name:     "16 bit with alpha",
+			ceb:      ceb,
+			bitDepth: 16,
+			alpha:    true,
+			pixels:   [][]int32{{0x1234, 0xabcd, 0, 0x8001}},
+			expected: &image.NRGBA64{
+				Pix:    []uint8{0x12, 0x34, 0xab, 0xcd, 0, 0, 0x80, 0x01},
+				Stride: 8,
+				Rect:   image.Rect(0, 0, 1, 1),
+			},
+		},
+		{
+			name:     "12 bit scaled to 16",
+			ceb:      ceb,
+			bitDepth: 12,
+			alpha:    true,
+			pixels:   [][]int32{{4095, 2048, 0, 1}},
+			expected: &image.NRGBA64{
+				Pix:    []uint8{0xff, 0xff, 0x80, 0x08, 0, 0, 0x00, 0x10},
+				Stride: 8,
+				Rect:   image.Rect(0, 0, 1, 1),
+			},
+		},
+		{
+			name:        "16 bit to 8 bit",
+			ceb:         ceb,
+			bitDepth:    16,
+			alpha:       true,
+			outBitDepth: options.BIT_DEPTH_8,
+			pixels:      [][]int32{{0xffff, 0x8080, 0, 0x4040}},
+			expected: &image.NRGBA{
+				Pix:    []uint8{0xff, 0x80, 0, 0x40},
+				Stride: 4,
+				Rect:   image.Rect(0, 0, 1, 1),
+			},
+		},
+		{
+			name:        "8 bit to 16 bit",
+			ceb:         ceb,
+			bitDepth:    8,
+			outBitDepth: options.BIT_DEPTH_16,
+			pixels:      [][]int32{{0xff, 0x12, 0}},
+			expected: &image.NRGBA64{
+				Pix:    []uint8{0xff, 0xff, 0x12, 0x12, 0, 0, 0xff, 0xff},
+				Stride: 8,
+				Rect:   image.Rect(0, 0, 1, 1),
+			},
+		},
+		{
+			name:     "16 bit gray",
+			ceb:      grayCeb,
+			bitDepth: 16,
+			pixels:   [][]int32{{0x1234}, {0xfedc}},
+			expected: &image.Gray16{
+				Pix:    []uint8{0x12, 0x34, 0xfe, 0xdc},
+				Stride: 4,
+				Rect:   image.Rect(0, 0, 2, 1),
+			},
+		},
+		{
+			name:        "unsupported bit depth",
+			ceb:         ceb,
+			bitDepth:    8,
+			outBitDepth: 12,
+			pixels:      [][]int32{{0, 0, 0}},
+			expectErr:   true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var extraChannels []bundle.ExtraChannelInfo
+			if tc.alpha {
+				extraChannels = alphaChannel(tc.bitDepth, false)
+			}
+			jxlImage := makeTestImage(t, tc.ceb, tc.bitDepth, extraChannels, tc.pixels)
+			img, err := jxlImage.ToImageWithOptions(&options.ImageOptions{BitDepth: tc.outBitDepth})
+			if tc.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, img)
+		})
+	}
+}
+
+func TestToImageFloatAlpha(t *testing.T) {
+	jxlImage := makeAlphaImage(t, 8, false, [][4]int32{{255, 0, 0, 0}, {0, 255, 0, 128}})
+
+	// decoded lossy images hold float samples, alpha has to come through those as well.
+	jxlImage.transfer = colour.TF_LINEAR
+	for c := range jxlImage.Buffer {
+		require.NoError(t, jxlImage.Buffer[c].CastToFloatIfMax(255))
+	}
+
+	img, err := jxlImage.ToImage()
+	require.NoError(t, err)
+	assert.Equal(t, []uint8{255, 0, 0, 0, 0, 255, 0, 128}, img.(*image.NRGBA).Pix)
+}
+
+func TestGrayWithAlpha(t *testing.T) {
+	ceb, err := colour.NewColourEncodingBundle()
+	require.NoError(t, err)
+	ceb.ColourEncoding = colour.CE_GRAY
+
+	t.Run("8 bit", func(t *testing.T) {
+		jxlImage := makeTestImage(t, ceb, 8, alphaChannel(8, false), [][]int32{{200, 100}, {10, 255}})
+		img, err := jxlImage.ToImage()
+		require.NoError(t, err)
+		assert.Equal(t, &image.NRGBA{
+			Pix:    []uint8{200, 200, 200, 100, 10, 10, 10, 255},
+			Stride: 8,
+			Rect:   image.Rect(0, 0, 2, 1),
+		}, img)
+	})
+
+	t.Run("16 bit", func(t *testing.T) {
+		jxlImage := makeTestImage(t, ceb, 16, alphaChannel(16, false), [][]int32{{0x1234, 0x8000}})
+		img, err := jxlImage.ToImage()
+		require.NoError(t, err)
+		assert.Equal(t, &image.NRGBA64{
+			Pix:    []uint8{0x12, 0x34, 0x12, 0x34, 0x12, 0x34, 0x80, 0x00},
+			Stride: 8,
+			Rect:   image.Rect(0, 0, 1, 1),
+		}, img)
+	})
+
+	t.Run("associated", func(t *testing.T) {
+		jxlImage := makeTestImage(t, ceb, 8, alphaChannel(8, false), [][]int32{{200, 51}})
+		img, err := jxlImage.ToImageWithOptions(&options.ImageOptions{AssociatedAlpha: true})
+		require.NoError(t, err)
+		assert.Equal(t, []uint8{40, 40, 40, 51}, img.(*image.RGBA).Pix)
+	})
+
+	t.Run("PNG", func(t *testing.T) {
+		jxlImage := makeTestImage(t, ceb, 8, alphaChannel(8, false), [][]int32{{200, 100}, {10, 255}})
+
+		var buf bytes.Buffer
+		writer := &PNGWriter{}
+		require.NoError(t, writer.WritePNG(jxlImage, &buf))
+
+		// colour type 4 is decoded by image/png as NRGBA.
+		img, err := png.Decode(&buf)
+		require.NoError(t, err)
+		assert.Equal(t, []uint8{200, 200, 200, 100, 10, 10, 10, 255}, img.(*image.NRGBA).Pix)
+	})
+
+	t.Run("PNG 12 bit", func(t *testing.T) {
+		jxlImage := makeTestImage(t, ceb, 12, alphaChannel(12, false), [][]int32{{4095, 0}})
+
+		var buf bytes.Buffer
+		writer := &PNGWriter{}
+		require.NoError(t, writer.WritePNG(jxlImage, &buf))
+
+		img, err := png.Decode(&buf)
+		require.NoError(t, err)
+		assert.Equal(t, []uint8{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0, 0}, img.(*image.NRGBA64).Pix)
+	})
+}
+
+// varnish is a red spot colour channel with half solidity.
+var varnish = bundle.ExtraChannelInfo{
+	EcType:   bundle.SPOT_COLOR,
+	Name:     "varnish",
+	BitDepth: bundle.BitDepthHeader{BitsPerSample: 8},
+	Red:      1,
+	Solidity: 0.5,
+}
+
+func TestGetSpotColours(t *testing.T) {
+	ceb, err := colour.NewColourEncodingBundle()
+	require.NoError(t, err)
+	jxlImage := makeTestImage(t, ceb, 8, []bundle.ExtraChannelInfo{varnish}, [][]int32{{0, 0, 0, 0}, {255, 255, 255, 255}})
+
+	spots, err := jxlImage.GetSpotColours()
+	require.NoError(t, err)
+	assert.Equal(t, []SpotColour{{
+		Index:    0,
+		Name:     "varnish",
+		Red:      1,
+		Solidity: 0.5,
+		Data:     [][]float32{{0, 1}},
+	}}, spots)
+
+	// the data is a copy
+	spots[0].Data[0][0] = 1
+	assert.Equal(t, int32(0), jxlImage.Buffer[3].IntBuffer[0][0])
+
+	noSpots := makeAlphaImage(t, 8, false, [][4]int32{{0, 0, 0, 0}})
+	spots, err = noSpots.GetSpotColours()
+	require.NoError(t, err)
+	assert.Empty(t, spots)
+}
+
+func TestRenderSpotColours(t *testing.T) {
+	ceb, err := colour.NewColourEncodingBundle()
+	require.NoError(t, err)
+	grayCeb, err := colour.NewColourEncodingBundle()
+	require.NoError(t, err)
+	grayCeb.ColourEncoding = colour.CE_GRAY
+
+	t.Run("RGB", func(t *testing.T) {
+		jxlImage := makeTestImage(t, ceb, 8, []bundle.ExtraChannelInfo{varnish}, [][]int32{{0, 255, 0, 0}, {0, 255, 0, 255}})
+
+		img, err := jxlImage.ToImageWithOptions(&options.ImageOptions{RenderSpotColours: true})
+		require.NoError(t, err)
+		// full spot coverage at half solidity mixes the red half way in.
+		assert.Equal(t, []uint8{0, 255, 0, 255, 128, 128, 0, 255}, img.(*image.NRGBA).Pix)
+
+		img, err = jxlImage.ToImage()
+		require.NoError(t, err)
+		assert.Equal(t, []uint8{0, 255, 0, 255, 0, 255, 0, 255}, img.(*image.NRGBA).Pix)
+	})
+
+	t.Run("gray", func(t *testing.T) {
+		jxlImage := makeTestImage(t, grayCeb, 8, []bundle.ExtraChannelInfo{varnish}, [][]int32{{255, 0}, {255, 255}})
+
+		img, err := jxlImage.ToImageWithOptions(&options.ImageOptions{RenderSpotColours: true})
+		require.NoError(t, err)
+		// red has luminance of ~0.2126
+		assert.Equal(t, []uint8{255, 155}, img.(*image.Gray).Pix)
+	})
+
+	t.Run("PNG", func(t *testing.T) {
+		jxlImage := makeTestImage(t, ceb, 8, []bundle.ExtraChannelInfo{varnish}, [][]int32{{0, 255, 0, 0}, {0, 255, 0, 255}})
+
+		var buf bytes.Buffer
+		writer := &PNGWriter{RenderSpotColours: true}
+		require.NoError(t, writer.WritePNG(jxlImage, &buf))
+
+		img, err := png.Decode(&buf)
+		require.NoError(t, err)
+		assert.Equal(t, []uint8{0, 255, 0, 255, 128, 128, 0, 255}, img.(*image.RGBA).Pix)
+	})
+}
+
+// TestRenderSpotColoursFile renders the two spot colour channels of spot.jxl, checking each
+// pixel is the colour image with each spot colour mixed in by its coverage in turn.
+func TestRenderSpotColoursFile(t *testing.T) {
+	decoder := NewJXLCodestreamDecoder(GenerateTestBitReader(t, "../testdata/spot.jxl"), options.NewJXLOptions(nil))
+	jxlImage, err := decoder.decode()
+	require.NoError(t, err)
+
+	spots, err := jxlImage.GetSpotColours()
+	require.NoError(t, err)
+	require.Len(t, spots, 2)
+	for i, expected := range [][3]float32{{0.196, 0.392, 0.588}, {0.902, 0, 0.941}} {
+		assert.Equal(t, i+1, spots[i].Index)
+		assert.InDeltaSlice(t, expected[:], []float32{spots[i].Red, spots[i].Green, spots[i].Blue}, 0.001)
+		assert.Equal(t, float32(1), spots[i].Solidity)
+	}
+
+	plain, err := jxlImage.ToImage()
+	require.NoError(t, err)
+	rendered, err := jxlImage.ToImageWithOptions(&options.ImageOptions{RenderSpotColours: true})
+	require.NoError(t, err)
+	require.Equal(t, plain.Bounds(), rendered.Bounds())
+
+	maxError := 0.0
+	changed := 0
+	bounds := plain.Bounds()
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			r, g, b, a := plain.At(x, y).RGBA()
+			expected := []float64{float64(r) / 0xffff, float64(g) / 0xffff, float64(b) / 0xffff}
+			for _, spot := range spots {
+				mix := float64(spot.Solidity * spot.Data[y][x])
+				for c, v := range []float32{spot.Red, spot.Green, spot.Blue} {
+					expected[c] = mix*float64(v) + (1-mix)*expected[c]
+				}
+			}
+			r2, g2, b2, a2 := rendered.At(x, y).RGBA()
+			assert.Equal(t, a, a2)
+			for c, v := range []uint32{r2, g2, b2} {
+				maxError = max(maxError, math.Abs(float64(v)/0xffff-expected[c]))
+			}
+			if r != r2 || g != g2 || b != b2 {
+				changed++
+			}
+		}
+	}
+	assert.Less(t, maxError, 1.0/0xffff)
+	// most of the image is covered by at least one of the spot colours.
+	assert.Greater(t, changed, bounds.Dx()*bounds.Dy()/2)
+}
+
+func TestToImageCMYK(t *testing.T) {
+	ceb, err := colour.NewColourEncodingBundle()
+	require.NoError(t, err)
+
+	header := bundle.ImageHeader{
+		BitDepth:       &bundle.BitDepthHeader{BitsPerSample: 16},
+		ColourEncoding: ceb,
+		AlphaIndices:   []int32{0},
+		ExtraChannelInfo: []bundle.ExtraChannelInfo{
+			{EcType: bundle.ALPHA, BitDepth: bundle.BitDepthHeader{BitsPerSample: 16}},
+			{EcType: bundle.CMYK_BLACK, BitDepth: bundle.BitDepthHeader{BitsPerSample: 16}},
+		},
+		OrientedWidth:  2,
+		OrientedHeight: 1,
+		DecodedICC:     []byte("cmyk profile"),
+	}
+	var buffer []image2.ImageBuffer
+	for _, row := range [][]int32{{0xffff, 0}, {0x8080, 0}, {0, 0xffff}, {0xffff, 0xffff}, {0xffff, 0x4040}} {
+		buffer = append(buffer, *image2.NewImageBufferFromInts([][]int32{row}))
+	}
+	jxlImage, err := NewJXLImageWithBuffer(buffer, header)
+	require.NoError(t, err)
+
+	assert.True(t, jxlImage.IsCMYK())
+	assert.Equal(t, []byte("cmyk profile"), jxlImage.GetICCProfile())
+
+	img, err := jxlImage.ToImage()
+	require.NoError(t, err)
+
+	// 0 is full ink in JXL but no ink in image.CMYK.
+	assert.Equal(t, &image.CMYK{
+		Pix:    []uint8{0, 127, 255, 0, 255, 255, 0, 191},
+		Stride: 8,
+		Rect:   image.Rect(0, 0, 2, 1),
+	}, img)
+
+	var buf bytes.Buffer
+	writer := &PNGWriter{}
+	assert.Error(t, writer.WritePNG(jxlImage, &buf))
+}
+
+func TestGetExtraChannels(t *testing.T) {
+	ceb, err := colour.NewColourEncodingBundle()
+	require.NoError(t, err)
+	ceb.ColourEncoding = colour.CE_GRAY
+	eci := bundle.ExtraChannelInfo{
+		EcType:   bundle.COLOR_FILTER_ARRAY,
+		Name:     "green2",
+		CfaIndex: 3,
+		DimShift: 1,
+		BitDepth: bundle.BitDepthHeader{BitsPerSample: 12},
+	}
+	jxlImage := makeTestImage(t, ceb, 8, []bundle.ExtraChannelInfo{eci}, [][]int32{{0, 0}, {0, 4095}})
+
+	assert.Equal(t, []ExtraChannel{{
+		Index:         0,
+		Name:          "green2",
+		EcType:        bundle.COLOR_FILTER_ARRAY,
+		CfaIndex:      3,
+		DimShift:      1,
+		BitsPerSample: 12,
+	}}, jxlImage.GetExtraChannels())
+}
+
+func TestExtraChannelToImage(t *testing.T) {
+	ceb, err := colour.NewColourEncodingBundle()
+	require.NoError(t, err)
+	ceb.ColourEncoding = colour.CE_GRAY
+	eci := bundle.ExtraChannelInfo{EcType: bundle.DEPTH, BitDepth: bundle.BitDepthHeader{BitsPerSample: 12}}
+
+	for _, tc := range []struct {
+		name   string
+		floats []float32
+	}{
+		{name: "int"},
+		{name: "normalised float", floats: []float32{0, 1000.0 / 4095, 1}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			jxlImage := makeTestImage(t, ceb, 8, []bundle.ExtraChannelInfo{eci}, [][]int32{{0, 0}, {0, 1000}, {0, 4095}})
+			if tc.floats != nil {
+				jxlImage.Buffer[1] = *image2.NewImageBufferFromFloats([][]float32{tc.floats})
+			}
+
+			img, err := jxlImage.ExtraChannelToImage(0)
+			require.NoError(t, err)
+			assert.Equal(t, []uint16{0, 1000, 4095}, []uint16{img.Gray16At(0, 0).Y, img.Gray16At(1, 0).Y, img.Gray16At(2, 0).Y})
+
+			data, err := jxlImage.ExtraChannelData(0)
+			require.NoError(t, err)
+			assert.InDeltaSlice(t, []float32{0, 1000, 4095}, data[0], 0.01)
+		})
+	}
+
+	jxlImage := makeTestImage(t, ceb, 8, []bundle.ExtraChannelInfo{eci}, [][]int32{{0, 0}})
+	_, err = jxlImage.ExtraChannelToImage(1)
+	assert.Error(t, err)
+}
+
+func TestExtraChannelDataFloat(t *testing.T) {
+	ceb, err := colour.NewColourEncodingBundle()
+	require.NoError(t, err)
+	ceb.ColourEncoding = colour.CE_GRAY
+	eci := bundle.ExtraChannelInfo{
+		EcType:   bundle.THERMAL,
+		BitDepth: bundle.BitDepthHeader{BitsPerSample: 32, ExpBits: 8, UsesFloatSamples: true},
+	}
+	jxlImage := makeTestImage(t, ceb, 8, []bundle.ExtraChannelInfo{eci}, [][]int32{{0, 0}, {0, 0}, {0, 0}})
+	jxlImage.Buffer[1] = *image2.NewImageBufferFromFloats([][]float32{{-12.5, 0, 312.25}})
+
+	data, err := jxlImage.ExtraChannelData(0)
+	require.NoError(t, err)
+	assert.Equal(t, [][]float32{{-12.5, 0, 312.25}}, data)
+
+	// data is a copy
+	data[0][0] = 1
+	data, err = jxlImage.ExtraChannelData(0)
+	require.NoError(t, err)
+	assert.Equal(t, float32(-12.5), data[0][0])
+
+	_, err = jxlImage.ExtraChannelToImage(0)
+	assert.Error(t, err)
+}
@@ -0,0 +1,60 @@
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// MetadataBox is a metadata box from the JXL container.
+type MetadataBox struct {
+	// Offset of the start of the box (its header) from the start of the file.
+	Offset int64
+
+	// Compressed is true if the box was stored Brotli compressed in a brob box, in which case
+	// Offset is that of the brob box.
+	Compressed bool
+
+	// Data is the box payload. For Exif this is the TIFF data, starting at the TIFF header
+	// (the offset to the TIFF header that starts the Exif box has already been applied).
+	Data []byte
+
+	// exif is the Exif box payload after the TIFF header offset, including anything before
+	// the TIFF header, as it is stored in the APP1 marker of a reconstructed JPEG.
+	exif []byte
+}
+
+// Metadata holds the Exif, XMP and JUMBF boxes of a JXL file. Boxes that aren't present are nil.
+type Metadata struct {
+	Exif *MetadataBox
+	XMP  *MetadataBox
+
+	// JUMBF are the contents of each jumb superbox (a description box followed by the content
+	// boxes), as used by C2PA.
+	JUMBF []MetadataBox
+}
+
+// newExifBox creates the MetadataBox for an Exif box, whose payload starts with the offset from
+// the end of the offset itself to the TIFF header.
+func newExifBox(offset int64, payload []byte) (*MetadataBox, error) {
+	if len(payload) < 4 {
+		return nil, errors.New("Exif box too small")
+	}
+	tiffOffset := uint64(binary.BigEndian.Uint32(payload))
+	if tiffOffset > uint64(len(payload)-4) {
+		return nil, errors.New("invalid Exif TIFF header offset")
+	}
+	return &MetadataBox{Offset: offset, Data: payload[4+tiffOffset:], exif: payload[4:]}, nil
+}
+
+// getMetadata reads the container boxes (if not already read) and returns the metadata boxes.
+// For non-seekable input this reads the rest of the input, so should be called after decoding.
+func (jxl *JXLCodestreamDecoder) getMetadata() (*Metadata, error) {
+	if err := jxl.ReadSignatureAndBoxes(); err != nil {
+		return nil, err
+	}
+	if err := jxl.readRemainingBoxes(); err != nil {
+		return nil, err
+	}
+	metadata := jxl.metadata
+	return &metadata, nil
+}
@@ -0,0 +1,227 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"github.com/kpfaulkner/jxl-go/options"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// makeBox returns an ISOBMFF box of the given type and payload.
+func makeBox(boxType string, payload []byte) []byte {
+	box := binary.BigEndian.AppendUint32(nil, uint32(8+len(payload)))
+	box = append(box, boxType...)
+	return append(box, payload...)
+}
+
+// makeContainer returns a JXL container with the signature and ftyp boxes followed by boxes.
+func makeContainer(boxes ...[]byte) []byte {
+	data := append([]byte{}, JPEGXL_CONTAINER_HEADER[:]...)
+	data = append(data, makeBox("ftyp", []byte("jxl \x00\x00\x00\x00jxl "))...)
+	for _, box := range boxes {
+		data = append(data, box...)
+	}
+	return data
+}
+
+func TestGetMetadata(t *testing.T) {
+
+	data, err := os.ReadFile("../testdata/patches.jxl")
+	require.NoError(t, err)
+
+	decoder := NewJXLDecoder(bytes.NewReader(data), nil)
+	metadata, err := decoder.GetMetadata()
+	require.NoError(t, err)
+
+	require.NotNil(t, metadata.Exif)
+	assert.Equal(t, int64(32), metadata.Exif.Offset)
+	assert.Len(t, metadata.Exif.Data, 150-8-4)
+	assert.Equal(t, []byte("MM\x00\x2a"), metadata.Exif.Data[:4])
+
+	require.NotNil(t, metadata.XMP)
+	assert.Equal(t, int64(182), metadata.XMP.Offset)
+	assert.Len(t, metadata.XMP.Data, 458-8)
+	assert.True(t, bytes.HasPrefix(metadata.XMP.Data, []byte("<x:xmpmeta")))
+	assert.Empty(t, metadata.JUMBF)
+
+	// the image can still be decoded after reading the metadata, and the metadata read again.
+	img, err := decoder.Decode()
+	require.NoError(t, err)
+	assert.NotNil(t, img)
+	again, err := decoder.GetMetadata()
+	require.NoError(t, err)
+	assert.Equal(t, metadata, again)
+}
+
+func TestGetMetadataContainer(t *testing.T) {
+
+	exif := append([]byte{0, 0, 0, 2, 0xAA, 0xBB}, "II*\x00"...)
+	jumbf := makeBox("jumd", []byte("c2pa"))
+
+	data := append([]byte{}, JPEGXL_CONTAINER_HEADER[:]...)
+	data = append(data, makeBox("ftyp", []byte("jxl \x00\x00\x00\x00jxl "))...)
+	jumbOffset := len(data)
+	data = append(data, makeBox("jumb", jumbf)...)
+	exifOffset := len(data)
+	data = append(data, makeBox("Exif", exif)...)
+	data = append(data, makeBox("jumb", []byte{1, 2, 3})...)
+	data = append(data, makeBox("jxlc", []byte{0xFF, 0x0A})...)
+
+	metadata, err := NewJXLDecoder(bytes.NewReader(data), nil).GetMetadata()
+	require.NoError(t, err)
+
+	require.NotNil(t, metadata.Exif)
+	assert.Equal(t, int64(exifOffset), metadata.Exif.Offset)
+	assert.Equal(t, []byte("II*\x00"), metadata.Exif.Data)
+	assert.Nil(t, metadata.XMP)
+	require.Len(t, metadata.JUMBF, 2)
+	assert.Equal(t, int64(jumbOffset), metadata.JUMBF[0].Offset)
+	assert.Equal(t, jumbf, metadata.JUMBF[0].Data)
+	assert.Equal(t, []byte{1, 2, 3}, metadata.JUMBF[1].Data)
+}
+
+func TestGetMetadataErrors(t *testing.T) {
+
+	for _, tc := range []struct {
+		name string
+		exif []byte
+	}{
+		{
+			name: "Exif too small",
+			exif: []byte{0, 0},
+		},
+		{
+			name: "TIFF offset past end of box",
+			exif: []byte{0, 0, 0, 5, 1, 2, 3, 4},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data := makeContainer(makeBox("Exif", tc.exif))
+			_, err := NewJXLDecoder(bytes.NewReader(data), nil).GetMetadata()
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestGetMetadataCodestream(t *testing.T) {
+
+	// a bare codestream has no container, so no metadata.
+	data, err := os.ReadFile("../testdata/alpha-triangles.jxl")
+	require.NoError(t, err)
+	metadata, err := NewJXLDecoder(bytes.NewReader(data), nil).GetMetadata()
+	require.NoError(t, err)
+	assert.Equal(t, &Metadata{}, metadata)
+}
+
+func TestGetMetadataBrotli(t *testing.T) {
+
+	for _, tc := range []struct {
+		name     string
+		filename string
+		exif     bool
+		offset   int64
+		prefix   string
+	}{
+		{
+			name:     "Exif",
+			filename: "../testdata/ants.jxl",
+			exif:     true,
+			offset:   507,
+			prefix:   "II*\x00",
+		},
+		{
+			name:     "XMP",
+			filename: "../testdata/Test-benchmark.jxl",
+			offset:   49,
+			prefix:   "<?xpacket",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := os.ReadFile(tc.filename)
+			require.NoError(t, err)
+			metadata, err := NewJXLDecoder(bytes.NewReader(data), nil).GetMetadata()
+			require.NoError(t, err)
+
+			box := metadata.XMP
+			if tc.exif {
+				box = metadata.Exif
+			}
+			require.NotNil(t, box)
+			assert.True(t, box.Compressed)
+			assert.Equal(t, tc.offset, box.Offset)
+			assert.Equal(t, tc.prefix, string(box.Data[:len(tc.prefix)]))
+		})
+	}
+}
+
+func TestGetMetadataBrotliContainer(t *testing.T) {
+
+	// Brotli compressed "information"
+	compressed := []byte{0x1b, 0x0a, 0x00, 0xf8, 0x25, 0x00, 0x02, 0x98, 0xa8, 0x20}
+
+	for _, tc := range []struct {
+		name       string
+		box        []byte
+		maxBoxSize int
+		expectErr  bool
+		expected   *Metadata
+	}{
+		{
+			name:     "XMP",
+			box:      makeBox("brob", append([]byte("xml "), compressed...)),
+			expected: &Metadata{XMP: &MetadataBox{Offset: 32, Data: []byte("information"), Compressed: true}},
+		},
+		{
+			name:     "unknown box type isn't decompressed",
+			box:      makeBox("brob", append([]byte("abcd"), 0xFF)),
+			expected: &Metadata{},
+		},
+		{
+			name:      "codestream box",
+			box:       makeBox("brob", append([]byte("jxlc"), compressed...)),
+			expectErr: true,
+		},
+		{
+			name:      "nested brob box",
+			box:       makeBox("brob", append([]byte("brob"), compressed...)),
+			expectErr: true,
+		},
+		{
+			name:      "no box type",
+			box:       makeBox("brob", []byte("xm")),
+			expectErr: true,
+		},
+		{
+			name:      "invalid Brotli stream",
+			box:       makeBox("brob", append([]byte("xml "), compressed[:5]...)),
+			expectErr: true,
+		},
+		{
+			name:       "decompressed box too large",
+			box:        makeBox("brob", append([]byte("xml "), compressed...)),
+			maxBoxSize: 10,
+			expectErr:  true,
+		},
+		{
+			name:       "box too large",
+			box:        makeBox("xml ", make([]byte, 11)),
+			maxBoxSize: 10,
+			expectErr:  true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data := makeContainer(tc.box)
+			metadata, err := NewJXLDecoder(bytes.NewReader(data), &options.JXLOptions{MaxBoxSize: tc.maxBoxSize}).GetMetadata()
+			if tc.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, metadata)
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"compress/zlib"
 	"encoding/binary"
+	"errors"
 	"hash/crc32"
 	"io"
 
@@ -11,6 +12,13 @@ import (
 )
 
 type PNGWriter struct {
+
+	// RenderSpotColours blends any spot colour extra channels onto the colour channels.
+	RenderSpotColours bool
+
+	// SDR writes HDR images as sRGB rather than as PQ with BT.2100 primaries.
+	SDR bool
+
 	bitDepth     int32
 	colourMode   byte
 	width        uint32
@@ -24,7 +32,11 @@ type PNGWriter struct {
 // to write out ICC Profile which doesn't seem to be supported by the standard package.
 func (w *PNGWriter) WritePNG(jxlImage *JXLImage, output io.Writer) error {
 
-	w.hdr = jxlImage.isHDR()
+	if jxlImage.IsCMYK() {
+		return errors.New("CMYK images cannot be written as PNG")
+	}
+
+	w.hdr = jxlImage.isHDR() && !w.SDR
 	var bitDepth int32
 	if w.hdr {
 		bitDepth = 16
@@ -41,12 +53,27 @@ func (w *PNGWriter) WritePNG(jxlImage *JXLImage, output io.Writer) error {
 
 	primaries := colour.CM_PRI_SRGB
 	tf := colour.TF_SRGB
-	if jxlImage.isHDR() {
+	if w.hdr {
 		primaries = colour.CM_PRI_BT2100
 		tf = colour.TF_PQ
 	}
 	whitePoint := colour.CM_WP_D65
 
+	if jxlImage.alphaIsPremultiplied {
+		// PNG only has unassociated alpha.
+		img, err := jxlImage.unpremultiplyAlpha()
+		if err != nil {
+			return err
+		}
+		jxlImage = img
+	}
+	if w.RenderSpotColours {
+		img, err := jxlImage.renderSpotColours()
+		if err != nil {
+			return err
+		}
+		jxlImage = img
+	}
 	if jxlImage.iccProfile == nil {
 		// transforms in place
 		img, err := jxlImage.transform(primaries, whitePoint, tf, PEAK_DETECT_AUTO)
@@ -76,7 +103,7 @@ func (w *PNGWriter) WritePNG(jxlImage *JXLImage, output io.Writer) error {
 	}
 	w.colourMode = colourMode
 
-	coerce := jxlImage.alphaIsPremultiplied
+	coerce := false
 	buffer, err := jxlImage.getBuffer(false)
 	if err != nil {
 		return err
@@ -96,10 +123,6 @@ func (w *PNGWriter) WritePNG(jxlImage *JXLImage, output io.Writer) error {
 			}
 		}
 	}
-
-	if jxlImage.alphaIsPremultiplied {
-		panic("not implemented")
-	}
 	for c := 0; c < len(buffer); c++ {
 		if buffer[c].IsInt() && jxlImage.bitDepths[c] == uint32(bitDepth) {
 			if err := buffer[c].Clamp(maxValue); err != nil {
@@ -293,35 +316,18 @@ func (w *PNGWriter) writeIDAT(jxlImage *JXLImage, output io.Writer) error {
 		return err
 	}
 
-	var bitDepth int32
-	if jxlImage.imageHeader.BitDepth.BitsPerSample > 8 {
-		bitDepth = 16
-	} else {
-		bitDepth = 8
-	}
-
+	// needs to match what writeIHDR declared.
+	bitDepth := w.bitDepth
 	maxValue := int32(^(^0 << bitDepth))
 
-	if err = jxlImage.Buffer[0].CastToIntIfMax(maxValue); err != nil {
-		return err
-	}
-
-	if len(jxlImage.Buffer) > 1 {
-		if err = jxlImage.Buffer[1].CastToIntIfMax(maxValue); err != nil {
-			return err
-		}
-	}
-
-	if len(jxlImage.Buffer) > 2 {
-		if err = jxlImage.Buffer[2].CastToIntIfMax(maxValue); err != nil {
-			return err
-		}
+	// colour channels followed by alpha, which for grayscale is straight after the gray channel.
+	colours := jxlImage.imageHeader.GetColourChannelCount()
+	channels := make([]int, 0, 4)
+	for c := 0; c < colours; c++ {
+		channels = append(channels, c)
 	}
-
 	if jxlImage.HasAlpha() {
-		if err = jxlImage.Buffer[3].CastToIntIfMax(maxValue); err != nil {
-			return err
-		}
+		channels = append(channels, colours+int(jxlImage.alphaIndex))
 	}
 
 	for c := 0; c < len(jxlImage.Buffer); c++ {
@@ -335,46 +341,23 @@ func (w *PNGWriter) writeIDAT(jxlImage *JXLImage, output io.Writer) error {
 			}
 		}
 	}
+
+	row := make([]byte, 0, 1+int(jxlImage.Width)*len(channels)*int(bitDepth/8))
 	for y := uint32(0); y < jxlImage.Height; y++ {
-		if _, err := wr.Write([]byte{0}); err != nil {
-			return err
-		}
+		row = append(row[:0], 0)
 		for x := uint32(0); x < jxlImage.Width; x++ {
-
-			// FIXME(kpfaulkner) remove 3 assumption
-			for c := 0; c < jxlImage.imageHeader.GetColourChannelCount(); c++ {
+			for _, c := range channels {
 				dat := jxlImage.Buffer[c].IntBuffer[y][x]
-				if jxlImage.bitDepths[c] == 8 {
-					if _, err := wr.Write([]byte{byte(dat)}); err != nil {
-						return err
-					}
-				} else {
-					byte1 := dat & 0xFF
-					byte2 := dat & 0xFF00
-					byte2 >>= 8
-					if _, err := wr.Write([]byte{byte(byte2), byte(byte1)}); err != nil {
-						return err
-					}
-				}
-
-			}
-			if jxlImage.HasAlpha() {
-				dat := jxlImage.Buffer[3].IntBuffer[y][x]
-				if jxlImage.bitDepths[3] == 8 {
-					if _, err := wr.Write([]byte{byte(dat)}); err != nil {
-						return err
-					}
+				if bitDepth == 8 {
+					row = append(row, byte(dat))
 				} else {
-					byte1 := dat & 0xFF
-					byte2 := dat & 0xFF00
-					byte2 >>= 8
-
-					if _, err := wr.Write([]byte{byte(byte2), byte(byte1)}); err != nil {
-						return err
-					}
+					row = append(row, byte(dat>>8), byte(dat))
 				}
 			}
 		}
+		if _, err := wr.Write(row); err != nil {
+			return err
+		}
 	}
 	wr.Close()
 
@@ -6,6 +6,7 @@ import (
 	"io"
 
 	"github.com/kpfaulkner/jxl-go/jxlio"
+	"github.com/kpfaulkner/jxl-go/options"
 )
 
 var (
@@ -14,6 +15,17 @@ var (
 	JXLL = makeTag([]byte{'j', 'x', 'l', 'l'}, 0, 4)
 	JXLP = makeTag([]byte{'j', 'x', 'l', 'p'}, 0, 4)
 	JXLC = makeTag([]byte{'j', 'x', 'l', 'c'}, 0, 4)
+	JBRD = makeTag([]byte{'j', 'b', 'r', 'd'}, 0, 4)
+	EXIF = makeTag([]byte{'E', 'x', 'i', 'f'}, 0, 4)
+	XML  = makeTag([]byte{'x', 'm', 'l', ' '}, 0, 4)
+	JUMB = makeTag([]byte{'j', 'u', 'm', 'b'}, 0, 4)
+	BROB = makeTag([]byte{'b', 'r', 'o', 'b'}, 0, 4)
+	FTYP = makeTag([]byte{'f', 't', 'y', 'p'}, 0, 4)
+	JXLI = makeTag([]byte{'j', 'x', 'l', 'i'}, 0, 4)
+	JXL  = makeTag([]byte{'J', 'X', 'L', ' '}, 0, 4)
+
+	// JXL_BRAND is the ftyp major brand of JXL files.
+	JXL_BRAND = makeTag([]byte{'j', 'x', 'l', ' '}, 0, 4)
 )
 
 type ContainerBoxHeader struct {
@@ -25,14 +37,31 @@ type ContainerBoxHeader struct {
 }
 
 type BoxReader struct {
-	reader jxlio.BitReader
-	level  int
+	reader     jxlio.BitReader
+	level      int
+	maxBoxSize int
+
+	// container is false for a bare codestream.
+	container bool
+
+	// boxes iterates over the container boxes. For non-seekable (forwardOnly) input the boxes
+	// are read as the codestream is, with codestream being the payload of the current jxlc or
+	// jxlp box.
+	boxes       *BoxIterator
+	forwardOnly bool
+	codestream  io.Reader
+
+	// jpeg reconstruction data (jbrd box) and the metadata boxes. Only the first jbrd, Exif
+	// and xml box is kept.
+	jpegData []byte
+	metadata Metadata
 }
 
 func NewBoxReader(reader jxlio.BitReader) *BoxReader {
 	return &BoxReader{
-		reader: reader,
-		level:  5,
+		reader:     reader,
+		level:      5,
+		maxBoxSize: options.DEFAULT_MAX_BOX_SIZE,
 	}
 }
 
@@ -62,128 +91,205 @@ func (br *BoxReader) ReadBoxHeader() ([]ContainerBoxHeader, error) {
 		return containerBoxHeaders, nil
 	}
 
-	if containerBoxHeaders, err = br.readAllBoxes(); err != nil {
+	br.container = true
+
+	// read the boxes from the start again, so the signature box is checked as well.
+	if _, err = br.reader.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	stream := newBitReaderStream(br.reader)
+	br.boxes = NewBoxIteratorWithMaxBoxSize(stream, br.maxBoxSize)
+
+	if br.forwardOnly {
+		// only read up to the start of the codestream, the rest are read as the codestream is.
+		// The codestream then starts at offset 0 of the reader returned by codestreamReader.
+		if err = br.nextCodestreamBox(); err != nil {
+			if err == io.EOF {
+				return nil, errNoCodestream
+			}
+			return nil, err
+		}
+		return []ContainerBoxHeader{{BoxType: JXLC, BoxSize: 0, IsLast: true, Offset: 0}}, nil
+	}
+
+	if containerBoxHeaders, err = br.readAllBoxes(stream); err != nil {
 		return nil, err
 	}
 
 	return containerBoxHeaders, nil
 }
 
-func (br *BoxReader) readAllBoxes() ([]ContainerBoxHeader, error) {
+func (br *BoxReader) readAllBoxes(stream *bitReaderStream) ([]ContainerBoxHeader, error) {
 
 	var boxHeaders []ContainerBoxHeader
-	//boxSizeArray := make([]byte, 4)
-	boxSizeArray := make([]byte, 8)
-	boxTag := make([]byte, 4)
 	for {
-		err := br.reader.ReadBytesToBuffer(boxSizeArray, 4)
+		box, err := br.boxes.Next()
 		if err != nil {
 			if err == io.EOF {
-				// simple end of file... return with boxHeaders
 				return boxHeaders, nil
 			}
 			return nil, err
 		}
 
-		boxSize := makeTag(boxSizeArray, 0, 4)
-		if boxSize == 1 {
-			err = br.reader.ReadBytesToBuffer(boxSizeArray, 8)
-			if err != nil {
+		if box.tag != JXLP && box.tag != JXLC {
+			if err = br.processBox(box); err != nil {
 				return nil, err
 			}
-			boxSize = makeTag(boxSizeArray, 0, 8)
-			if boxSize > 0 {
-				boxSize -= 8
+			continue
+		}
+
+		size := box.payloadSize()
+		if box.Size == 0 {
+			// runs to the end of the file.
+			end, err := stream.size()
+			if err != nil {
+				return nil, err
 			}
+			size = uint64(end - box.PayloadOffset)
+		}
+		boxHeaders = append(boxHeaders, ContainerBoxHeader{
+			BoxType:   box.tag,
+			BoxSize:   size,
+			IsLast:    box.LastPart,
+			Offset:    box.PayloadOffset,
+			Processed: false,
+		})
+	}
+}
+
+// processBox handles the boxes other than the codestream boxes. Unknown box types are skipped by
+// the iterator, which has already unwrapped any Brotli compressed boxes.
+func (br *BoxReader) processBox(box *Box) error {
+
+	switch box.tag {
+	case JXLL:
+		if box.payloadSize() != 1 {
+			return errors.New("jxll box payload must be 1 byte")
+		}
+		l := make([]byte, 1)
+		if _, err := io.ReadFull(box.Payload, l); err != nil {
+			return err
 		}
-		if boxSize > 0 {
-			boxSize -= 8
+		if l[0] != 5 && l[0] != 10 {
+			return errors.New("invalid level")
 		}
+		br.level = int(l[0])
 
-		err = br.reader.ReadBytesToBuffer(boxTag, 4)
+	case JBRD, EXIF, XML, JUMB:
+		payload, err := br.readPayload(box)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		tag := makeTag(boxTag, 0, 4)
+		return br.addBox(box.tag, box.Offset, payload, box.Compressed)
+	}
+	return nil
+}
 
-		// check boxType...  if we dont know the box type, just skip over the bytes and keep reading.
-		switch tag {
-		case JXLP:
-			// reads next 4 bytes as additional tag?
-			err = br.reader.ReadBytesToBuffer(boxTag, 4)
-			if err != nil {
-				return nil, err
-			}
-			boxSize -= 4
+// nextCodestreamBox reads the boxes up to and including the next jxlc or jxlp box. Returns io.EOF
+// if there aren't any more.
+func (br *BoxReader) nextCodestreamBox() error {
+	for {
+		box, err := br.boxes.Next()
+		if err != nil {
+			return err
+		}
+		if box.tag == JXLC || box.tag == JXLP {
+			br.codestream = box.Payload
+			return nil
+		}
+		if err = br.processBox(box); err != nil {
+			return err
+		}
+	}
+}
 
-			// fileoffset...  directly from ReadSeeker?
-			pos, err := br.reader.Seek(0, io.SeekCurrent)
-			if err != nil {
-				return nil, err
-			}
-			bh := ContainerBoxHeader{
-				BoxType:   tag,
-				BoxSize:   boxSize,
-				IsLast:    false,
-				Offset:    pos,
-				Processed: false,
-			}
-			boxHeaders = append(boxHeaders, bh)
-			// skip past this box.
-			_, err = br.SkipFully(int64(boxSize))
-			if err != nil {
-				return nil, err
+// readRemainingBoxes reads whatever boxes of non-seekable input haven't been read yet, skipping
+// any codestream left.
+func (br *BoxReader) readRemainingBoxes() error {
+	if !br.forwardOnly || br.boxes == nil {
+		return nil
+	}
+	for {
+		br.codestream = nil
+		if err := br.nextCodestreamBox(); err != nil {
+			if err == io.EOF {
+				return nil
 			}
+			return err
+		}
+	}
+}
 
-		case JXLL:
-			if boxSize != 1 {
-				return nil, errors.New("JXLL box size should be 1")
-			}
-			l, err := br.reader.ReadByte()
-			if err != nil {
-				return nil, err
-			}
-			if l != 5 && l != 10 {
-				return nil, errors.New("invalid level")
-			}
-			br.level = int(l)
+// codestreamReader returns a reader of the codestream of non-seekable input, which reads the
+// payloads of the jxlc/jxlp boxes one after the other.
+func (br *BoxReader) codestreamReader() io.Reader {
+	return boxCodestream{br}
+}
 
-		case JXLC:
-			// fileoffset...  directly from ReadSeeker?
-			pos, err := br.reader.Seek(0, io.SeekCurrent)
-			if err != nil {
-				return nil, err
+type boxCodestream struct {
+	br *BoxReader
+}
+
+func (bc boxCodestream) Read(p []byte) (int, error) {
+	for {
+		if bc.br.codestream == nil {
+			if err := bc.br.nextCodestreamBox(); err != nil {
+				return 0, err
 			}
-			bh := ContainerBoxHeader{
-				BoxType:   tag,
-				BoxSize:   boxSize,
-				IsLast:    false,
-				Offset:    pos,
-				Processed: false,
+		}
+		n, err := bc.br.codestream.Read(p)
+		if err == io.EOF {
+			bc.br.codestream = nil
+			if n == 0 {
+				continue
 			}
-			boxHeaders = append(boxHeaders, bh)
+			err = nil
+		}
+		return n, err
+	}
+}
 
-			// skip past this box.
-			_, err = br.SkipFully(int64(boxSize))
-			if err != nil {
-				return nil, err
-			}
+// readPayload reads the payload of a box that is kept in memory.
+func (br *BoxReader) readPayload(box *Box) ([]byte, error) {
+	if box.Size == 0 || box.Compressed {
+		// runs to the end of the file, or is only limited by the decompressed size.
+		payload, err := io.ReadAll(io.LimitReader(box.Payload, int64(br.maxBoxSize)+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(payload) > br.maxBoxSize {
+			return nil, errors.New("box larger than maximum box size")
+		}
+		return payload, nil
+	}
+	if box.payloadSize() > uint64(br.maxBoxSize) {
+		return nil, errors.New("box larger than maximum box size")
+	}
+	payload := make([]byte, box.payloadSize())
+	if _, err := io.ReadFull(box.Payload, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
 
-		default:
-			// skip over the bytes
-			if boxSize > 0 {
-				s, err := br.SkipFully(int64(boxSize))
-				if err != nil {
-					return nil, err
-				}
-				if s != 0 {
-					return nil, errors.New("truncated extra box")
-				}
-			} else {
-				panic("java read supplyExceptionally... unsure why?")
-			}
+// addBox keeps the payload of a jbrd or metadata box.
+func (br *BoxReader) addBox(tag uint64, offset int64, payload []byte, compressed bool) error {
+	switch {
+	case tag == JBRD && br.jpegData == nil:
+		br.jpegData = payload
+	case tag == EXIF && br.metadata.Exif == nil:
+		exif, err := newExifBox(offset, payload)
+		if err != nil {
+			return err
 		}
+		exif.Compressed = compressed
+		br.metadata.Exif = exif
+	case tag == XML && br.metadata.XMP == nil:
+		br.metadata.XMP = &MetadataBox{Offset: offset, Data: payload, Compressed: compressed}
+	case tag == JUMB:
+		br.metadata.JUMBF = append(br.metadata.JUMBF, MetadataBox{Offset: offset, Data: payload, Compressed: compressed})
 	}
+	return nil
 }
 
 // returns number of bytes that were NOT skipped.
@@ -2,8 +2,10 @@ package frame
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"math"
 	"sync"
 
@@ -33,6 +35,10 @@ var (
 		{X: 0, Y: -2}, {X: 0, Y: 2}, {X: 2, Y: 0}, {X: -2, Y: 0}}
 )
 
+// regionBorder is how many samples past a region (set by SetRegion) need decoding. This covers
+// the reach of the chroma upsampling, gaborish, the 3 EPF iterations and upsampling.
+const regionBorder = 16
+
 type NewLFGlobalWithReaderFunc func(reader jxlio.BitReader, parent Framer, hfBlockContextFunc NewHFBlockContextFunc) (*LFGlobal, error)
 
 type ReadPermutationFunc func(reader jxlio.BitReader, stream entropy.EntropyStreamer, size uint32, skip uint32) ([]uint32, error)
@@ -71,6 +77,7 @@ type Frame struct {
 	globalTree     *MATreeNode
 	hfGlobal       *HFGlobal
 	LfGlobal       *LFGlobal
+	noiseBuffer    [][][]float32
 
 	groupRowStride   uint32
 	lfGroupRowStride uint32
@@ -79,6 +86,34 @@ type Frame struct {
 	permutatedTOC    bool
 
 	decoded bool
+
+	// JPEGCoefficients are only populated when KeepJPEGCoefficients has been called.
+	JPEGCoefficients     *JPEGCoefficients
+	keepJPEGCoefficients bool
+
+	// region is the part of the frame (in frame samples, rounded out to whole groups) that is
+	// decoded when SetRegion has been called. decodeAllGroups is set when the groups can't be
+	// decoded independently, in which case region only limits the restoration filters.
+	region          *util.Rectangle
+	decodeAllGroups bool
+
+	// sectionsRead is the number of TOC sections read into bitreaders so far. Sections are read as
+	// they are needed, so that decoding keeps up with data that arrives incrementally.
+	sectionsRead int
+
+	// progress is called as the frame is decoded (see SetProgressFunc). pending counts the pass
+	// groups queued for the workers, and lfDecoded, groupPasses and passGroups are the decoding
+	// state used by ProgressiveFrame, which keeps the upsampled LF image in lfPreview.
+	progress    func(passes int)
+	pending     *sync.WaitGroup
+	lfDecoded   bool
+	groupPasses []int
+	passGroups  [][]PassGroup
+	lfPreview   [][][]float32
+
+	// ctx is checked between the LF groups, pass groups and filter stages when set (see
+	// SetContext), and decoding stops with ctx.Err() once it is cancelled.
+	ctx context.Context
 }
 
 func (f *Frame) getGlobalTree() *MATreeNode {
@@ -291,8 +326,9 @@ func NewFrameWithReader(reader jxlio.BitReader, imageHeader *bundle.ImageHeader,
 	return frame
 }
 
+// SkipFrameData skips the sections of the frame that haven't been read.
 func (f *Frame) SkipFrameData() error {
-	for i := 0; i < len(f.tocLengths); i++ {
+	for i := f.sectionsRead; i < len(f.tocLengths); i++ {
 		_, err := f.reader.Skip(f.tocLengths[i])
 		if err != nil {
 			return err
@@ -328,7 +364,7 @@ func (f *Frame) DecodeFrame(lfBuffer []image.ImageBuffer, newLFGlobalWithReader
 	}
 	f.decoded = true
 
-	err := f.setupBitReaders()
+	err := f.readSections(1)
 	if err != nil {
 		return err
 	}
@@ -341,6 +377,10 @@ func (f *Frame) DecodeFrame(lfBuffer []image.ImageBuffer, newLFGlobalWithReader
 	if err != nil {
 		return err
 	}
+	// with a single TOC entry the sections are read one after another, so none can be skipped.
+	if f.region != nil && (len(f.tocLengths) == 1 || f.LfGlobal.globalModular.hasNonLocalTransforms()) {
+		f.decodeAllGroups = true
+	}
 
 	paddedSize, err := f.GetPaddedFrameSize()
 	if err != nil {
@@ -377,12 +417,22 @@ func (f *Frame) DecodeFrame(lfBuffer []image.ImageBuffer, newLFGlobalWithReader
 		f.Buffer[c] = *buf
 	}
 
+	if err = f.readSections(1 + int(f.numLFGroups)); err != nil {
+		return err
+	}
 	err = f.decodeLFGroups(lfBuffer)
 	if err != nil {
 		log.Errorf("Error decoding LFGroups %v", err)
 		return err
 	}
+	if f.Header.Encoding == VARDCT {
+		f.lfDecoded = true
+		f.reportProgress(0)
+	}
 
+	if err = f.readSections(2 + int(f.numLFGroups)); err != nil {
+		return err
+	}
 	hfGlobalReader, err := f.getBitreader(1 + int(f.numLFGroups))
 	if err != nil {
 		return err
@@ -464,6 +514,9 @@ func (f *Frame) DecodeFrame(lfBuffer []image.ImageBuffer, newLFGlobalWithReader
 	}
 
 	if f.Header.restorationFilter.gab {
+		if err := f.cancelled(); err != nil {
+			return err
+		}
 		if err := f.performGabConvolution(); err != nil {
 			return err
 		}
@@ -477,24 +530,331 @@ func (f *Frame) DecodeFrame(lfBuffer []image.ImageBuffer, newLFGlobalWithReader
 	return nil
 }
 
-func (f *Frame) setupBitReaders() error {
-	f.bitreaders = make([]jxlio.BitReader, len(f.tocLengths))
-	if len(f.tocLengths) != 1 {
-		for i := 0; i < len(f.tocLengths); i++ {
-			buffer, err := f.readBuffer(i)
-			if err != nil {
+// readSections reads the TOC sections before section n into bitreaders, if they haven't already
+// been read. Sections of LF groups and groups outside the region are seeked over instead, leaving
+// their bitreaders nil. With a single TOC entry everything is read directly from the frame reader.
+func (f *Frame) readSections(n int) error {
+	if f.bitreaders == nil {
+		f.bitreaders = make([]jxlio.BitReader, len(f.tocLengths))
+	}
+	if len(f.tocLengths) == 1 {
+		if f.bitreaders[0] == nil {
+			f.bitreaders[0] = f.reader
+		}
+		return nil
+	}
+	for ; f.sectionsRead < min(n, len(f.tocLengths)); f.sectionsRead++ {
+		if f.bitreaders[f.sectionsRead] != nil {
+			continue
+		}
+		if !f.sectionInRegion(f.sectionsRead) {
+			if _, err := f.reader.Seek(int64(f.tocLengths[f.sectionsRead]), io.SeekCurrent); err != nil {
 				return err
 			}
-			f.bitreaders[i] = jxlio.NewBitStreamReader(bytes.NewReader(buffer))
+			continue
 		}
-	} else {
-		f.bitreaders[0] = f.reader
+		buffer, err := f.readBuffer(f.sectionsRead)
+		if err != nil {
+			return err
+		}
+		f.bitreaders[f.sectionsRead] = jxlio.NewBitStreamReader(bytes.NewReader(buffer))
 	}
 	return nil
 }
 
+// SetProgressFunc sets a function that is called as the frame is decoded, with passes 0 once the
+// LF image of a VarDCT frame is decoded and then n once n passes of every group are decoded. It
+// may be called from the worker goroutines, but never concurrently.
+func (f *Frame) SetProgressFunc(progress func(passes int)) {
+	f.progress = progress
+}
+
+func (f *Frame) reportProgress(passes int) {
+	if f.progress != nil {
+		f.progress(passes)
+	}
+}
+
+// SetContext sets a context that cancels decoding of the frame. The workers stop taking on new
+// groups once it is cancelled, and the decode functions return ctx.Err().
+func (f *Frame) SetContext(ctx context.Context) {
+	f.ctx = ctx
+}
+
+// cancelled returns ctx.Err(), or nil if there is no context.
+func (f *Frame) cancelled() error {
+	if f.ctx == nil {
+		return nil
+	}
+	return f.ctx.Err()
+}
+
+// WaitIdle waits for the pass groups queued for the workers to be decoded. With a single TOC entry
+// the workers read directly from the frame reader, so there is nothing to wait for.
+func (f *Frame) WaitIdle() {
+	if len(f.tocLengths) > 1 && f.pending != nil {
+		f.pending.Wait()
+	}
+}
+
+// CanDecodeLF reports whether DecodeLF can be used for the frame. Only VarDCT frames have LF
+// coefficients, and extra channels are modular so have no LF image. Frames that can't be decoded
+// from their LF are decoded in full, and are then scaled down to match by the caller.
+func (f *Frame) CanDecodeLF() bool {
+	return f.Header.Encoding == VARDCT && len(f.GlobalMetadata.ExtraChannelInfo) == 0
+}
+
+// DecodeLF decodes only the LF coefficients of a VarDCT frame, skipping the HF coefficients, IDCT
+// and restoration filters. Buffer is left holding the 1:8 LF image (or the LF frame, if the frame
+// uses one) with the bounds scaled to match, and the downsampling relative to the frame is
+// returned. lfDownsampling is the downsampling that lfBuffer was decoded with.
+func (f *Frame) DecodeLF(lfBuffer []image.ImageBuffer, lfDownsampling uint32, newLFGlobalWithReader NewLFGlobalWithReaderFunc) (uint32, error) {
+
+	if f.decoded {
+		return 0, errors.New("frame already decoded")
+	}
+	f.decoded = true
+
+	f.Buffer = make([]image.ImageBuffer, 3)
+	downsampling := uint32(8)
+	if (f.Header.Flags & USE_LF_FRAME) != 0 {
+		// the LF frame already is the LF image, so none of this frame is needed.
+		if err := f.SkipFrameData(); err != nil {
+			return 0, err
+		}
+		for c := 0; c < 3; c++ {
+			if err := lfBuffer[c].CastToFloatIfMax(^(^0 << f.GlobalMetadata.BitDepth.BitsPerSample)); err != nil {
+				return 0, err
+			}
+			f.Buffer[c] = *image.NewImageBufferFromImageBuffer(&lfBuffer[c], true)
+		}
+		downsampling *= lfDownsampling
+		f.scaleBounds(downsampling)
+		return downsampling, nil
+	}
+
+	if len(f.tocLengths) == 1 {
+		// the section is only partly read, so it is buffered to leave the reader at the next frame.
+		buffer, err := f.readBuffer(0)
+		if err != nil {
+			return 0, err
+		}
+		f.bitreaders = []jxlio.BitReader{jxlio.NewBitStreamReader(bytes.NewReader(buffer))}
+		f.sectionsRead = 1
+	} else if err := f.readSections(1 + int(f.numLFGroups)); err != nil {
+		return 0, err
+	}
+
+	lfGlobalBitReader, err := f.getBitreader(0)
+	if err != nil {
+		return 0, err
+	}
+	f.LfGlobal, err = newLFGlobalWithReader(lfGlobalBitReader, f, NewHFBlockContextWithReader)
+	if err != nil {
+		return 0, err
+	}
+	if err = f.decodeLFGroups(lfBuffer); err != nil {
+		return 0, err
+	}
+	// the HF sections aren't needed.
+	if err = f.SkipFrameData(); err != nil {
+		return 0, err
+	}
+
+	if f.Buffer, err = f.lfImage(); err != nil {
+		return 0, err
+	}
+	if err := f.invertSubsampling(); err != nil {
+		return 0, err
+	}
+
+	f.scaleBounds(downsampling)
+	return downsampling, nil
+}
+
+// ProgressiveFrame returns a copy of the frame rendered from what has been decoded so far, for
+// showing images that are still arriving or were truncated. The LF image is upsampled, and the
+// groups decoded so far are drawn over it with the passes decoded so far. The restoration filters
+// are not applied. Returns nil if there is nothing to show yet, which is always the case for
+// modular frames. Must not be called while groups are being decoded (see WaitIdle).
+func (f *Frame) ProgressiveFrame() (*Frame, error) {
+
+	if f.Header.Encoding != VARDCT || !f.lfDecoded {
+		return nil, nil
+	}
+
+	header := *f.Header
+	bounds := *f.Header.Bounds
+	header.Bounds = &bounds
+	pf := &Frame{
+		GlobalMetadata:   f.GlobalMetadata,
+		options:          f.options,
+		Header:           &header,
+		LfGlobal:         f.LfGlobal,
+		groupRowStride:   f.groupRowStride,
+		lfGroupRowStride: f.lfGroupRowStride,
+		numGroups:        f.numGroups,
+		numLFGroups:      f.numLFGroups,
+	}
+
+	if f.lfPreview == nil {
+		lf, err := f.lfImage()
+		if err != nil {
+			return nil, err
+		}
+		f.lfPreview = make([][][]float32, 3)
+		for c := 0; c < 3; c++ {
+			if f.lfPreview[c], err = f.upsampleBuffer(lf[c].FloatBuffer, 8); err != nil {
+				return nil, err
+			}
+		}
+	}
+	pf.Buffer = make([]image.ImageBuffer, 3)
+	buffers := make([][][]float32, 3)
+	for c := 0; c < 3; c++ {
+		buf := image.NewImageBufferFromFloats(f.lfPreview[c])
+		pf.Buffer[c] = *image.NewImageBufferFromImageBuffer(buf, true)
+		buffers[c] = pf.Buffer[c].FloatBuffer
+	}
+	// extra channels are modular, so are only there once the frame is decoded.
+	for c := 3; c < len(f.Buffer); c++ {
+		pf.Buffer = append(pf.Buffer, *image.NewImageBufferFromImageBuffer(&f.Buffer[c], true))
+	}
+
+	numPasses := len(f.passes)
+	for group := 0; group < len(f.groupPasses); group++ {
+		passes := f.groupPasses[group]
+		if passes == 0 {
+			continue
+		}
+		if passes < numPasses {
+			// the coefficients accumulated so far are inverted again, which doesn't change them.
+			passGroup := &f.passGroups[passes-1][group]
+			if passGroup.hfCoefficients == nil {
+				continue
+			}
+			if err := passGroup.invertVarDCT(buffers, nil); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		pos := f.getGroupLocation(int32(group))
+		for c := 0; c < 3; c++ {
+			y0 := (pos.Y * int32(f.Header.groupDim)) >> f.Header.jpegUpsamplingY[c]
+			x0 := (pos.X * int32(f.Header.groupDim)) >> f.Header.jpegUpsamplingX[c]
+			y1 := min(y0+int32(f.Header.groupDim>>f.Header.jpegUpsamplingY[c]), f.Buffer[c].Height)
+			x1 := min(x0+int32(f.Header.groupDim>>f.Header.jpegUpsamplingX[c]), f.Buffer[c].Width)
+			for y := y0; y < y1; y++ {
+				copy(buffers[c][y][x0:x1], f.Buffer[c].FloatBuffer[y][x0:x1])
+			}
+		}
+	}
+
+	if err := pf.invertSubsampling(); err != nil {
+		return nil, err
+	}
+	return pf, nil
+}
+
+// lfImage assembles the (still subsampled) 1:8 LF image from the LF coefficients of the LF groups.
+func (f *Frame) lfImage() ([]image.ImageBuffer, error) {
+
+	paddedSize, err := f.GetPaddedFrameSize()
+	if err != nil {
+		return nil, err
+	}
+	lf := make([]image.ImageBuffer, 3)
+	for c := 0; c < 3; c++ {
+		buf, err := image.NewImageBuffer(image.TYPE_FLOAT, int32(paddedSize.Height>>3)>>f.Header.jpegUpsamplingY[c],
+			int32(paddedSize.Width>>3)>>f.Header.jpegUpsamplingX[c])
+		if err != nil {
+			return nil, err
+		}
+		lf[c] = *buf
+	}
+	for lfGroupID, lfg := range f.lfGroups {
+		if lfg == nil {
+			continue
+		}
+		pos := f.getLFGroupLocation(int32(lfGroupID))
+		for c := 0; c < 3; c++ {
+			y0 := (pos.Y * int32(f.Header.groupDim)) >> f.Header.jpegUpsamplingY[c]
+			x0 := (pos.X * int32(f.Header.groupDim)) >> f.Header.jpegUpsamplingX[c]
+			for y, row := range lfg.lfCoeff.dequantLFCoeff[c] {
+				copy(lf[c].FloatBuffer[y0+int32(y)][x0:], row)
+			}
+		}
+	}
+	return lf, nil
+}
+
+// Downsample scales a decoded frame down by factor, averaging each factor x factor block of
+// samples. Must be called after Upsample.
+func (f *Frame) Downsample(factor uint32) error {
+	if factor <= 1 {
+		return nil
+	}
+	for c := 0; c < len(f.Buffer); c++ {
+		buf, err := f.Buffer[c].Downsample(int32(factor))
+		if err != nil {
+			return err
+		}
+		f.Buffer[c] = *buf
+	}
+	f.scaleBounds(factor)
+	return nil
+}
+
+// scaleBounds scales the frame bounds down by factor. The origin is rounded down, including for
+// frames that start before the image.
+func (f *Frame) scaleBounds(factor uint32) {
+	bounds := f.Header.Bounds
+	bounds.Size.Height = util.CeilDiv(bounds.Size.Height, factor)
+	bounds.Size.Width = util.CeilDiv(bounds.Size.Width, factor)
+	for _, origin := range []*int32{&bounds.Origin.Y, &bounds.Origin.X} {
+		if *origin < 0 {
+			*origin = -((-*origin + int32(factor) - 1) / int32(factor))
+		} else {
+			*origin /= int32(factor)
+		}
+	}
+}
+
 func (f *Frame) IsVisible() bool {
-	return f.Header.FrameType == REGULAR_FRAME || f.Header.FrameType == SKIP_PROGRESSIVE && (f.Header.Duration != 0 || f.Header.IsLast)
+	return (f.Header.FrameType == REGULAR_FRAME || f.Header.FrameType == SKIP_PROGRESSIVE) && (f.Header.Duration != 0 || f.Header.IsLast)
+}
+
+// BlockTransform is a varblock of a decoded VarDCT frame, along with the name of its
+// transform type.
+type BlockTransform struct {
+	Name   string
+	Bounds util.Rectangle
+}
+
+// BlockTransforms returns the varblocks of a decoded VarDCT frame in frame pixel
+// coordinates. Returns nil for modular frames.
+func (f *Frame) BlockTransforms() []BlockTransform {
+	var blocks []BlockTransform
+	for id, lfg := range f.lfGroups {
+		if lfg == nil || lfg.hfMetadata == nil {
+			continue
+		}
+		lfgPos := f.getLFGroupLocation(int32(id))
+		for _, pos := range lfg.hfMetadata.blockList {
+			tt := lfg.hfMetadata.dctSelect[pos.Y][pos.X]
+			blocks = append(blocks, BlockTransform{
+				Name: tt.name,
+				Bounds: util.Rectangle{
+					Origin: util.Point{
+						X: lfgPos.X*int32(f.Header.lfGroupDim) + pos.X<<3,
+						Y: lfgPos.Y*int32(f.Header.lfGroupDim) + pos.Y<<3,
+					},
+					Size: util.Dimension{Width: uint32(tt.pixelWidth), Height: uint32(tt.pixelHeight)},
+				},
+			})
+		}
+	}
+	return blocks
 }
 
 func (f *Frame) GetColourChannelCount() int {
@@ -588,8 +948,15 @@ func (f *Frame) decodeLFGroups(lfBuffer []image.ImageBuffer) error {
 	sem := make(chan struct{}, maxGoroutines)
 
 	for lfGroupID := uint32(0); lfGroupID < f.numLFGroups; lfGroupID++ {
-		wg.Add(1)
+		if !f.lfGroupInRegion(int32(lfGroupID)) {
+			continue
+		}
 		sem <- struct{}{}
+		if err = f.cancelled(); err != nil {
+			<-sem
+			break
+		}
+		wg.Add(1)
 
 		go func(id uint32) {
 			defer wg.Done()
@@ -633,6 +1000,9 @@ func (f *Frame) decodeLFGroups(lfBuffer []image.ImageBuffer) error {
 	}
 
 	wg.Wait()
+	if err != nil {
+		return err
+	}
 	close(errChan)
 	if len(errChan) > 0 {
 		return <-errChan
@@ -647,6 +1017,9 @@ func (f *Frame) decodeLFGroups(lfBuffer []image.ImageBuffer) error {
 
 	// Copy data from each LF group into the pre-allocated channels
 	for lfGroupID := uint32(0); lfGroupID < f.numLFGroups; lfGroupID++ {
+		if f.lfGroups[lfGroupID] == nil {
+			continue
+		}
 		for j := 0; j < len(lfReplacementChannelIndicies); j++ {
 			index := lfReplacementChannelIndicies[j]
 			channel := channels[index]
@@ -678,12 +1051,18 @@ func (f *Frame) decodePasses(reader jxlio.BitReader) error {
 	return nil
 }
 
-func (f *Frame) startWorker(inputChan chan Inp, passGroups [][]PassGroup) {
+func (f *Frame) startWorker(inputChan chan Inp, passGroups [][]PassGroup, finishGroup func(iPass int, iGroup int, decodeErr error) error, errChan chan error) {
 	for inp := range inputChan {
-		if err := f.doProcessing(inp.iPass, inp.iGroup, passGroups); err != nil {
-			log.Errorf("Error processing %v %v %v", inp.iPass, inp.iGroup, err)
+		// once cancelled the queued groups are drained without being decoded, and finishGroup
+		// reports the cancellation.
+		var decodeErr error
+		if f.cancelled() == nil {
+			decodeErr = f.doProcessing(inp.iPass, inp.iGroup, passGroups)
 		}
-
+		if err := finishGroup(inp.iPass, inp.iGroup, decodeErr); err != nil {
+			errChan <- err
+		}
+		f.pending.Done()
 	}
 }
 
@@ -729,37 +1108,154 @@ func (f *Frame) decodePassGroupsConcurrent() error {
 	numGroups := int(f.numGroups)
 	passGroups := util.MakeMatrix2D[PassGroup](numPasses, numGroups)
 
-	inputChan := make(chan Inp, numPasses*numGroups)
+	maxGoroutines := 1
+	if f.options != nil {
+		maxGoroutines = f.options.MaxGoroutines
+	}
+	if maxGoroutines < 1 {
+		maxGoroutines = 1
+	}
 
-	for pass0 := 0; pass0 < numPasses; pass0++ {
-		pass := pass0
+	var buffers [][][]float32
+	if f.Header.Encoding == VARDCT {
 
-		for group0 := 0; group0 < numGroups; group0++ {
-			inputChan <- Inp{
-				iPass:  pass,
-				iGroup: group0,
+		// get floating point version of frame buffer. These are the frame buffers themselves
+		// so must not be returned to the pool.
+		buffers = make([][][]float32, 3)
+		for c := 0; c < 3; c++ {
+			if err := f.Buffer[c].CastToFloatIfMax(^(^0 << f.GlobalMetadata.BitDepth.BitsPerSample)); err != nil {
+				return err
+			}
+			buffers[c] = f.Buffer[c].FloatBuffer
+		}
+
+		if f.keepJPEGCoefficients {
+			var err error
+			if f.JPEGCoefficients, err = f.newJPEGCoefficients(); err != nil {
+				return err
 			}
 		}
+		f.passGroups = passGroups
 	}
-	close(inputChan)
 
-	wg := sync.WaitGroup{}
-	maxGoroutines := 1
-	if f.options != nil {
-		maxGoroutines = f.options.MaxGoroutines
+	// groups are decoded as their sections are read, with each pass of a group waiting for the
+	// previous pass of the group to finish. remaining counts the groups left to finish each pass.
+	done := make([][]chan struct{}, numPasses)
+	remaining := make([]int, numPasses)
+	for pass := 0; pass < numPasses; pass++ {
+		done[pass] = make([]chan struct{}, numGroups)
+		for group := 0; group < numGroups; group++ {
+			if f.groupInRegion(int32(group)) {
+				done[pass][group] = make(chan struct{})
+				remaining[pass]++
+			}
+		}
 	}
-	if maxGoroutines < 1 {
-		maxGoroutines = 1
+	f.groupPasses = make([]int, numGroups)
+
+	var progressLock sync.Mutex
+	reported := 0
+	reportPasses := func() {
+		for reported < numPasses && remaining[reported] == 0 {
+			reported++
+			f.reportProgress(reported)
+		}
+	}
+
+	// failed marks the groups that failed to decode. The later passes of a failed group are
+	// skipped, but still release the passes waiting on them. Each pass of a group is only
+	// finished after the previous one, so needs no lock.
+	failed := make([]bool, numGroups)
+	finishGroup := func(pass int, group int, decodeErr error) error {
+		defer close(done[pass][group])
+		if pass > 0 {
+			<-done[pass-1][group]
+		}
+		if decodeErr != nil {
+			failed[group] = true
+			return decodeErr
+		}
+		if failed[group] {
+			// the error has already been reported by the failed pass.
+			return nil
+		}
+		if err := f.cancelled(); err != nil {
+			return err
+		}
+		if f.Header.Encoding == VARDCT {
+			passGroup := &passGroups[pass][group]
+			var prev *PassGroup
+			if pass > 0 {
+				prev = &passGroups[pass-1][group]
+			}
+
+			// earlier passes only refine the HF coefficients, so the coefficients are
+			// accumulated pass by pass and only inverted once the last pass is reached.
+			if pass < numPasses-1 {
+				if err := passGroup.accumulateHFCoefficients(prev); err != nil {
+					return err
+				}
+			} else {
+				if err := passGroup.invertVarDCT(buffers, prev); err != nil {
+					return err
+				}
+				if f.JPEGCoefficients != nil {
+					if err := passGroup.copyJPEGCoefficients(f.JPEGCoefficients); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		progressLock.Lock()
+		defer progressLock.Unlock()
+		f.groupPasses[group] = pass + 1
+		remaining[pass]--
+		reportPasses()
+		return nil
 	}
+
+	inputChan := make(chan Inp, numPasses*numGroups)
+	errChan := make(chan error, numPasses*numGroups)
+	f.pending = &sync.WaitGroup{}
+	wg := sync.WaitGroup{}
 	for i := 0; i < maxGoroutines; i++ {
 		wg.Add(1)
 		go func() {
-			f.startWorker(inputChan, passGroups)
+			f.startWorker(inputChan, passGroups, finishGroup, errChan)
 			wg.Done()
 		}()
 	}
 
+	var readErr error
+	for pass := 0; pass < numPasses && readErr == nil; pass++ {
+		for group := 0; group < numGroups; group++ {
+			if readErr = f.cancelled(); readErr != nil {
+				break
+			}
+			if readErr = f.readSections(2 + int(f.numLFGroups) + pass*numGroups + group + 1); readErr != nil {
+				break
+			}
+			if !f.groupInRegion(int32(group)) {
+				continue
+			}
+			f.pending.Add(1)
+			inputChan <- Inp{
+				iPass:  pass,
+				iGroup: group,
+			}
+		}
+	}
+	close(inputChan)
 	wg.Wait()
+	if readErr != nil {
+		return readErr
+	}
+	close(errChan)
+	if len(errChan) > 0 {
+		return <-errChan
+	}
+	// passes without any groups in the region.
+	reportPasses()
 
 	for pass := 0; pass < numPasses; pass++ {
 		j := 0
@@ -772,6 +1268,9 @@ func (f *Frame) decodePassGroupsConcurrent() error {
 			channel := f.LfGlobal.globalModular.getChannels()[ii]
 			channel.allocate()
 			for group := 0; group < int(f.numGroups); group++ {
+				if passGroups[pass][group].modularStream == nil {
+					continue
+				}
 				newChannelInfo := passGroups[pass][group].modularStream.getChannels()[jj]
 				buff := newChannelInfo.buffer
 				for y := 0; y < len(buff); y++ {
@@ -784,49 +1283,8 @@ func (f *Frame) decodePassGroupsConcurrent() error {
 	}
 
 	if f.Header.Encoding == VARDCT {
-
-		// get floating point version of frame buffer
-		buffers := util.MakeMatrix3DPooled[float32](3, 0, 0)
-		defer util.ReturnMatrix3DToPool(buffers)
-		for c := 0; c < 3; c++ {
-			if err := f.Buffer[c].CastToFloatIfMax(^(^0 << f.GlobalMetadata.BitDepth.BitsPerSample)); err != nil {
-				return err
-			}
-			buffers[c] = f.Buffer[c].FloatBuffer
-		}
-
-		for pass := 0; pass < numPasses; pass++ {
-			var wg sync.WaitGroup
-			errChan := make(chan error, numGroups)
-			sem := make(chan struct{}, maxGoroutines)
-
-			for group := 0; group < numGroups; group++ {
-				wg.Add(1)
-				go func(p, g int) {
-					defer wg.Done()
-					sem <- struct{}{}
-					defer func() { <-sem }()
-
-					passGroup := &passGroups[p][g]
-					var prev *PassGroup
-					if p > 0 {
-						prev = &passGroups[p-1][g]
-					} else {
-						prev = nil
-					}
-					if err := passGroup.invertVarDCT(buffers, prev); err != nil {
-						errChan <- err
-					}
-				}(pass, group)
-			}
-			wg.Wait()
-			close(errChan)
-			if len(errChan) > 0 {
-				return <-errChan
-			}
-		}
-
 		// Release HFCoefficients buffers back to pool
+		f.passGroups = nil
 		for pass := 0; pass < numPasses; pass++ {
 			for group := 0; group < numGroups; group++ {
 				passGroups[pass][group].Release()
@@ -1003,6 +1461,8 @@ func (f *Frame) performGabConvolution() error {
 		gabAdj := normGabAdj[c]
 		gabDiag := normGabDiag[c]
 
+		minY, maxY, minX, maxX := f.filterBounds(height, width)
+
 		// Worker function to process a range of rows
 		processRows := func(startY, endY int32) {
 			for y := startY; y < endY; y++ {
@@ -1024,7 +1484,7 @@ func (f *Frame) performGabConvolution() error {
 				buffS := buffC[south]
 				newBuffR := newBufferF[y]
 
-				for x := int32(0); x < width; x++ {
+				for x := minX; x < maxX; x++ {
 					var west int32
 					if x == 0 {
 						west = 0
@@ -1049,16 +1509,16 @@ func (f *Frame) performGabConvolution() error {
 		if numWorkers < 1 {
 			numWorkers = 1
 		}
-		rowsPerWorker := (height + int32(numWorkers) - 1) / int32(numWorkers)
+		rowsPerWorker := (maxY - minY + int32(numWorkers) - 1) / int32(numWorkers)
 
 		var wg sync.WaitGroup
 		for w := 0; w < numWorkers; w++ {
-			startY := int32(w) * rowsPerWorker
+			startY := minY + int32(w)*rowsPerWorker
 			endY := startY + rowsPerWorker
-			if endY > height {
-				endY = height
+			if endY > maxY {
+				endY = maxY
 			}
-			if startY >= height {
+			if startY >= maxY {
 				break
 			}
 			wg.Add(1)
@@ -1103,6 +1563,10 @@ func (f *Frame) performEdgePreservingFilter() error {
 				lfX := x >> 8
 				bX := x - (lfX << 8)
 				lfg := f.lfGroups[lfR+lfX]
+				if lfg == nil {
+					// outside of the decoded region.
+					continue
+				}
 				hf := lfg.hfMetadata.hfMultiplier[bY][bX]
 				sharpness := lfg.hfMetadata.hfStreamBuffer[3][bY][bX]
 				if sharpness < 0 || sharpness > 7 {
@@ -1135,6 +1599,7 @@ func (f *Frame) performEdgePreservingFilter() error {
 
 	height := int32(paddedSize.Height)
 	width := int32(paddedSize.Width)
+	minY, maxY, minX, maxX := f.filterBounds(height, width)
 
 	for i := 0; i < 3; i++ {
 		if i == 0 && f.Header.restorationFilter.epfIterations < 3 {
@@ -1143,6 +1608,9 @@ func (f *Frame) performEdgePreservingFilter() error {
 		if i == 2 && f.Header.restorationFilter.epfIterations < 2 {
 			break
 		}
+		if err := f.cancelled(); err != nil {
+			return err
+		}
 
 		// copy first 3 (well number of colours we have) buffers
 		inputBuffers := copyFloatBuffers(f.Buffer, colours)
@@ -1181,7 +1649,7 @@ func (f *Frame) performEdgePreservingFilter() error {
 					outRows[c] = outputBuffers[c][y]
 				}
 
-				for x := int32(0); x < width; x++ {
+				for x := minX; x < maxX; x++ {
 					s := invSigmaRow[x>>3]
 					if s > skipThreshold {
 						for c := int32(0); c < colours; c++ {
@@ -1366,16 +1834,16 @@ func (f *Frame) performEdgePreservingFilter() error {
 		if numWorkers < 1 {
 			numWorkers = 1
 		}
-		rowsPerWorker := (height + int32(numWorkers) - 1) / int32(numWorkers)
+		rowsPerWorker := (maxY - minY + int32(numWorkers) - 1) / int32(numWorkers)
 
 		var wg sync.WaitGroup
 		for w := 0; w < numWorkers; w++ {
-			startY := int32(w) * rowsPerWorker
+			startY := minY + int32(w)*rowsPerWorker
 			endY := startY + rowsPerWorker
-			if endY > height {
-				endY = height
+			if endY > maxY {
+				endY = maxY
 			}
-			if startY >= height {
+			if startY >= maxY {
 				break
 			}
 			wg.Add(1)
@@ -1418,26 +1886,64 @@ func (f *Frame) getNumLFGroups() uint32 {
 	return f.numLFGroups
 }
 
+// InitializeNoise generates the random noise for each group then applies the
+// (4 * (1 - box)) convolution to it. Noise groups are in the upsampled frame, so each
+// group generates upsampling * upsampling noise groups.
 func (f *Frame) InitializeNoise(seed0 int64) error {
-	if len(f.LfGlobal.noiseParameters) == 0 {
+	if f.LfGlobal.noiseParameters == nil {
 		return nil
 	}
 
-	return errors.New("noise not implemented")
+	width := f.Header.Bounds.Size.Width
+	height := f.Header.Bounds.Size.Height
+	upsampledGroupDim := f.Header.groupDim * f.Header.Upsampling
+	rowStride := util.CeilDiv(width, upsampledGroupDim)
+	numGroups := rowStride * util.CeilDiv(height, upsampledGroupDim)
+	localNoiseBuffer := util.MakeMatrix3D[float32](3, int(height), int(width))
+	for group := uint32(0); group < numGroups; group++ {
+		groupX := (group % rowStride) * f.Header.Upsampling
+		groupY := (group / rowStride) * f.Header.Upsampling
+		for iy := uint32(0); iy < f.Header.Upsampling; iy++ {
+			for ix := uint32(0); ix < f.Header.Upsampling; ix++ {
+				x0 := (groupX + ix) * f.Header.groupDim
+				y0 := (groupY + iy) * f.Header.groupDim
+				NewNoiseGroupWithHeader(f.Header, seed0, localNoiseBuffer, int32(x0), int32(y0))
+			}
+		}
+	}
+
+	// mirrored column indices so the convolution doesn't need to bounds check.
+	xIndex := make([]int32, width+4)
+	for x := range xIndex {
+		xIndex[x] = util.MirrorCoordinate(int32(x)-2, int32(width))
+	}
 
-	//rowStride := util.CeilDiv(f.Header.Width, f.Header.groupDim)
-	//localNoiseBuffer := util.MakeMatrix3D[float32](3, int(f.Header.Height), int(f.Header.Width))
-	//numGroups := rowStride * util.CeilDiv(f.Header.Height, f.Header.groupDim)
-	//for group := uint32(0); group < numGroups; group++ {
-	//	groupXYUp := util.Coordinates(group, rowStride).Times(f.Header.Upsampling)
-	//	for iy := uint32(0); iy < f.Header.Upsampling; iy++ {
-	//		for ix := uint32(0); ix < f.Header.Upsampling; ix++ {
-	//			x0 := (groupXYUp.X + ix) * f.Header.groupDim
-	//			y0 := (groupXYUp.Y + iy) * f.Header.groupDim
-	//
-	//		}
-	//	}
-	//}
+	f.noiseBuffer = util.MakeMatrix3D[float32](3, int(height), int(width))
+	var rows [5][]float32
+	for c := 0; c < 3; c++ {
+		for y := int32(0); y < int32(height); y++ {
+			for k := int32(0); k < 5; k++ {
+				rows[k] = localNoiseBuffer[c][util.MirrorCoordinate(y+k-2, int32(height))]
+			}
+			outRow := f.noiseBuffer[c][y]
+			for x := 0; x < int(width); x++ {
+				others := float32(0)
+				for i := 0; i < 5; i++ {
+					cx := xIndex[x+i]
+					others += rows[0][cx]
+					others += rows[1][cx]
+					others += rows[3][cx]
+					others += rows[4][cx]
+				}
+				others += rows[2][xIndex[x]]
+				others += rows[2][xIndex[x+1]]
+				others += rows[2][xIndex[x+3]]
+				others += rows[2][xIndex[x+4]]
+				outRow[x] = others*0.16 - rows[2][xIndex[x+2]]*3.84
+			}
+		}
+	}
+	return nil
 }
 
 func (f *Frame) Upsample() error {
@@ -1463,12 +1969,30 @@ func (f *Frame) Upsample() error {
 func (f *Frame) performUpsampling(ib image.ImageBuffer, c int) (*image.ImageBuffer, error) {
 
 	colour := f.GetColourChannelCount()
-	var k uint32
-	if c < colour {
-		k = f.Header.Upsampling
-	} else {
+	k := f.Header.Upsampling
+	if c >= colour {
 		k = f.Header.EcUpsampling[c-colour]
 	}
+
+	// Extra channels upsampled by more than the colour channels are decoded at a fraction of
+	// the frame size, into the top left of the buffer.
+	if shift := util.CeilLog2(k) - util.CeilLog2(f.Header.Upsampling); shift > 0 {
+		height := util.CeilDiv(uint32(ib.Height), 1<<shift)
+		width := util.CeilDiv(uint32(ib.Width), 1<<shift)
+		if ib.IsFloat() {
+			rows := make([][]float32, height)
+			for y := range rows {
+				rows[y] = ib.FloatBuffer[y][:width]
+			}
+			ib = *image.NewImageBufferFromFloats(rows)
+		} else {
+			rows := make([][]int32, height)
+			for y := range rows {
+				rows[y] = ib.IntBuffer[y][:width]
+			}
+			ib = *image.NewImageBufferFromInts(rows)
+		}
+	}
 	if k == 1 {
 		return &ib, nil
 	}
@@ -1484,7 +2008,15 @@ func (f *Frame) performUpsampling(ib image.ImageBuffer, c int) (*image.ImageBuff
 		return nil, err
 	}
 
-	buffer := ib.FloatBuffer
+	buffer, err := f.upsampleBuffer(ib.FloatBuffer, k)
+	if err != nil {
+		return nil, err
+	}
+	return image.NewImageBufferFromFloats(buffer), nil
+}
+
+func (f *Frame) upsampleBuffer(buffer [][]float32, k uint32) ([][]float32, error) {
+
 	l := util.CeilLog1p(k-1) - 1
 	up, err := f.GlobalMetadata.GetUpWeights()
 	if err != nil {
@@ -1557,23 +2089,94 @@ func (f *Frame) performUpsampling(ib image.ImageBuffer, c int) (*image.ImageBuff
 	}
 	wg.Wait()
 
-	return image.NewImageBufferFromFloats(newBuffer), nil
-
+	return newBuffer, nil
 }
 
 func (f *Frame) RenderSplines() error {
 	if f.LfGlobal.splines == nil {
 		return nil
 	}
-	return errors.New("RenderSplines not implemented")
+
+	if f.GetColourChannelCount() < 3 {
+		return errors.New("splines require 3 colour channels")
+	}
+
+	baseCorrelationX := float32(0.0)
+	baseCorrelationB := float32(1.0)
+	if f.LfGlobal.lfChanCorr != nil {
+		baseCorrelationX = f.LfGlobal.lfChanCorr.baseCorrelationX
+		baseCorrelationB = f.LfGlobal.lfChanCorr.baseCorrelationB
+	}
+
+	buffers := make([][][]float32, 3)
+	for c := 0; c < 3; c++ {
+		if err := f.Buffer[c].CastToFloatIfMax(^(^0 << f.GlobalMetadata.BitDepth.BitsPerSample)); err != nil {
+			return err
+		}
+		buffers[c] = f.Buffer[c].FloatBuffer
+	}
+
+	width := util.Min(f.Buffer[0].Width, f.Buffer[1].Width, f.Buffer[2].Width)
+	height := util.Min(f.Buffer[0].Height, f.Buffer[1].Height, f.Buffer[2].Height)
+	for _, spline := range f.LfGlobal.splines.Dequantize(baseCorrelationX, baseCorrelationB) {
+		spline.render(buffers, width, height)
+	}
+	return nil
 }
 
+// SynthesizeNoise adds the noise generated by InitializeNoise to the XYB buffers,
+// scaled by the noise strength for the intensity of each pixel.
 func (f *Frame) SynthesizeNoise() error {
 	if f.LfGlobal.noiseParameters == nil {
 		return nil
 	}
+	if f.noiseBuffer == nil {
+		return errors.New("noise has not been initialized")
+	}
+	if f.GetColourChannelCount() < 3 {
+		return errors.New("noise requires 3 colour channels")
+	}
 
-	return errors.New("SynthesizeNoise not implemented")
+	baseCorrelationX := float32(0.0)
+	baseCorrelationB := float32(1.0)
+	if f.LfGlobal.lfChanCorr != nil {
+		baseCorrelationX = f.LfGlobal.lfChanCorr.baseCorrelationX
+		baseCorrelationB = f.LfGlobal.lfChanCorr.baseCorrelationB
+	}
+
+	buffers := make([][][]float32, 3)
+	for c := 0; c < 3; c++ {
+		if err := f.Buffer[c].CastToFloatIfMax(^(^0 << f.GlobalMetadata.BitDepth.BitsPerSample)); err != nil {
+			return err
+		}
+		buffers[c] = f.Buffer[c].FloatBuffer
+	}
+
+	params := f.LfGlobal.noiseParameters
+	height := util.Min(len(f.noiseBuffer[0]), len(buffers[0]), len(buffers[1]), len(buffers[2]))
+	for y := 0; y < height; y++ {
+		rowX := buffers[0][y]
+		rowY := buffers[1][y]
+		rowB := buffers[2][y]
+		noiseR := f.noiseBuffer[0][y]
+		noiseG := f.noiseBuffer[1][y]
+		noiseC := f.noiseBuffer[2][y]
+		width := util.Min(len(noiseR), len(rowX), len(rowY), len(rowB))
+		for x := 0; x < width; x++ {
+			strengthG := params.strength((rowY[x] - rowX[x]) * 0.5)
+			strengthR := params.strength((rowY[x] + rowX[x]) * 0.5)
+			randomR := noiseR[x] * 0.22
+			randomG := noiseG[x] * 0.22
+			randomC := noiseC[x] * 0.22
+			redNoise := strengthR * (0.0078125*randomR + 0.9921875*randomC)
+			greenNoise := strengthG * (0.0078125*randomG + 0.9921875*randomC)
+			rgNoise := redNoise + greenNoise
+			rowX[x] += baseCorrelationX*rgNoise + redNoise - greenNoise
+			rowY[x] += rgNoise
+			rowB[x] += baseCorrelationB * rgNoise
+		}
+	}
+	return nil
 }
 
 func (f *Frame) getLFGroupSize(lfGroupID int32) (util.Dimension, error) {
@@ -1599,6 +2202,91 @@ func (f *Frame) getGroupLocation(groupID int32) *util.Point {
 	return util.NewPoint(groupID/int32(f.groupRowStride), groupID%int32(f.groupRowStride))
 }
 
+// SetRegion limits decoding to the LF groups and groups needed for region (in image coordinates)
+// of the frame. Samples are decoded regionBorder samples past the region so the restoration
+// filters and upsampling of the region are the same as when decoding the whole frame, but
+// anything further out is left unset. So this must not be used for frames that are saved as a
+// reference for later frames. Must be called after ReadFrameHeader.
+func (f *Frame) SetRegion(region util.Rectangle) {
+
+	upsampling := int32(f.Header.Upsampling)
+	lowerCorner := region.ComputeLowerCorner()
+	frameX := f.Header.Bounds.Origin.X
+	frameY := f.Header.Bounds.Origin.Y
+	x0 := max(region.Origin.X/upsampling-frameX-regionBorder, 0)
+	y0 := max(region.Origin.Y/upsampling-frameY-regionBorder, 0)
+	x1 := min((lowerCorner.X+upsampling-1)/upsampling-frameX+regionBorder, int32(f.Header.Bounds.Size.Width))
+	y1 := min((lowerCorner.Y+upsampling-1)/upsampling-frameY+regionBorder, int32(f.Header.Bounds.Size.Height))
+
+	// the region doesn't overlap the frame, so nothing needs decoding.
+	if x1 <= x0 || y1 <= y0 {
+		f.region = &util.Rectangle{}
+		return
+	}
+
+	groupDim := int32(f.Header.groupDim)
+	x0 = x0 / groupDim * groupDim
+	y0 = y0 / groupDim * groupDim
+	f.region = &util.Rectangle{
+		Origin: util.Point{X: x0, Y: y0},
+		Size: util.Dimension{
+			Width:  uint32((x1+groupDim-1)/groupDim*groupDim - x0),
+			Height: uint32((y1+groupDim-1)/groupDim*groupDim - y0),
+		},
+	}
+}
+
+// groupInRegion returns true if the group needs to be decoded.
+func (f *Frame) groupInRegion(groupID int32) bool {
+	if f.region == nil || f.decodeAllGroups {
+		return true
+	}
+	pos := f.getGroupLocation(groupID)
+	groupDim := int32(f.Header.groupDim)
+	return f.overlapsRegion(pos.X*groupDim, pos.Y*groupDim, groupDim)
+}
+
+// lfGroupInRegion returns true if the LF group needs to be decoded, which is when any of its
+// groups do.
+func (f *Frame) lfGroupInRegion(lfGroupID int32) bool {
+	if f.region == nil || f.decodeAllGroups {
+		return true
+	}
+	pos := f.getLFGroupLocation(lfGroupID)
+	lfGroupDim := int32(f.Header.lfGroupDim)
+	return f.overlapsRegion(pos.X*lfGroupDim, pos.Y*lfGroupDim, lfGroupDim)
+}
+
+// sectionInRegion returns true if the TOC section is needed to decode the region. Only the
+// sections of LF groups and groups can be left out.
+func (f *Frame) sectionInRegion(index int) bool {
+	numLFGroups := int(f.numLFGroups)
+	switch {
+	case index == 0 || index == 1+numLFGroups:
+		return true
+	case index <= numLFGroups:
+		return f.lfGroupInRegion(int32(index - 1))
+	}
+	return f.groupInRegion(int32((index - 2 - numLFGroups) % int(f.numGroups)))
+}
+
+func (f *Frame) overlapsRegion(x int32, y int32, size int32) bool {
+	lowerCorner := f.region.ComputeLowerCorner()
+	return x < lowerCorner.X && x+size > f.region.Origin.X &&
+		y < lowerCorner.Y && y+size > f.region.Origin.Y
+}
+
+// filterBounds returns the rows and columns of a buffer that the restoration filters need to
+// process.
+func (f *Frame) filterBounds(height int32, width int32) (int32, int32, int32, int32) {
+	if f.region == nil {
+		return 0, height, 0, width
+	}
+	lowerCorner := f.region.ComputeLowerCorner()
+	return min(f.region.Origin.Y, height), min(lowerCorner.Y, height),
+		min(f.region.Origin.X, width), min(lowerCorner.X, width)
+}
+
 func (f *Frame) getLFGroupForGroup(groupID int32) *LFGroup {
 	pos := f.getGroupLocation(groupID)
 	idx := (pos.Y>>3)*int32(f.lfGroupRowStride) + (pos.X >> 3)
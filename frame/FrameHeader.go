@@ -59,7 +59,7 @@ type FrameHeader struct {
 	xqmScale          uint32
 	bqmScale          uint32
 	Duration          uint32
-	timecode          uint32
+	Timecode          uint32
 	SaveAsReference   uint32
 	SaveBeforeCT      bool
 	DoYCbCr           bool
@@ -150,6 +150,16 @@ func NewFrameHeaderWithReader(reader jxlio.BitReader, parent *bundle.ImageHeader
 			fh.EcUpsampling[i] = 1
 		}
 	}
+	// the extra channel upsampling is relative to the DimShift of the channel, so includes it.
+	for i := 0; i < len(fh.EcUpsampling); i++ {
+		fh.EcUpsampling[i] <<= parent.ExtraChannelInfo[i].DimShift
+		if fh.EcUpsampling[i] < fh.Upsampling {
+			return nil, fmt.Errorf("extra channel %d upsampling %d is less than the frame upsampling %d", i, fh.EcUpsampling[i], fh.Upsampling)
+		}
+		if fh.EcUpsampling[i] > 8 {
+			return nil, fmt.Errorf("extra channel %d upsampling %d is too large", i, fh.EcUpsampling[i])
+		}
+	}
 
 	if fh.Encoding == MODULAR {
 		if groupSizeShift, err := reader.ReadBits(2); err != nil {
@@ -299,29 +309,23 @@ func NewFrameHeaderWithReader(reader jxlio.BitReader, parent *bundle.ImageHeader
 		}
 	}
 
-	// nolint
 	if normalFrame && parent.AnimationHeader != nil {
-		// dont care about animation
-		panic("animation")
-		/*
-			dur, err := reader.ReadU32(0, 0, 1, 0, 0, 8, 0, 32)
-			if err != nil {
-				return nil, err
-			}
-			fh.Duration = dur
-		*/
+		dur, err := reader.ReadU32(0, 0, 1, 0, 0, 8, 0, 32)
+		if err != nil {
+			return nil, err
+		}
+		fh.Duration = dur
 	} else {
 		fh.Duration = 0
 	}
 	if normalFrame && parent.AnimationHeader != nil && parent.AnimationHeader.HaveTimeCodes {
-		// dont care about animation
 		tc, err := reader.ReadBits(32)
 		if err != nil {
 			return nil, err
 		}
-		fh.timecode = uint32(tc)
+		fh.Timecode = uint32(tc)
 	} else {
-		fh.timecode = 0
+		fh.Timecode = 0
 	}
 
 	if normalFrame {
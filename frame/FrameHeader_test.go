@@ -438,6 +438,55 @@ func TestNewFrameHeaderWithReader_ExtraChannels(t *testing.T) {
 	assert.Equal(t, uint32(BLEND_REPLACE), fh.EcBlendingInfo[1].Mode)
 }
 
+func TestNewFrameHeaderWithReader_ExtraChannelUpsampling(t *testing.T) {
+
+	for _, tc := range []struct {
+		name         string
+		upsampling   uint64
+		ecUpsampling uint64
+		dimShift     int32
+		expected     uint32
+		expectErr    bool
+	}{
+		{name: "no dim shift", upsampling: 1, ecUpsampling: 2, expected: 4},
+		{name: "dim shift", upsampling: 0, ecUpsampling: 0, dimShift: 1, expected: 2},
+		{name: "dim shift and upsampling", upsampling: 1, ecUpsampling: 1, dimShift: 1, expected: 4},
+		{name: "dim shift brings up to upsampling", upsampling: 1, ecUpsampling: 0, dimShift: 1, expected: 2},
+		{name: "less than upsampling", upsampling: 1, ecUpsampling: 0, expectErr: true},
+		{name: "over 8", upsampling: 0, ecUpsampling: 3, dimShift: 1, expectErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			parent := makeParent(100, 200, true, 1)
+			parent.ExtraChannelInfo[0].DimShift = tc.dimShift
+			reader := &testcommon.FakeBitReader{
+				ReadBoolData: []bool{false, false, true, true},
+				ReadBitsData: []uint64{0, 0, tc.upsampling, tc.ecUpsampling, 3, 2},
+				ReadU32Data:  []uint32{1, 0, 0, 0},
+				ReadU64Data:  []uint64{0, 0},
+			}
+
+			fh, err := NewFrameHeaderWithReader(reader, parent)
+			if tc.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, []uint32{tc.expected}, fh.EcUpsampling)
+		})
+	}
+
+	// frames without upsampling fields still upsample by the DimShift.
+	parent := makeParent(100, 200, true, 1)
+	parent.ExtraChannelInfo[0].DimShift = 2
+	fh, err := NewFrameHeaderWithReader(&testcommon.FakeBitReader{ReadBoolData: []bool{true}}, parent)
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{4}, fh.EcUpsampling)
+
+	parent.ExtraChannelInfo[0].DimShift = 4
+	_, err = NewFrameHeaderWithReader(&testcommon.FakeBitReader{ReadBoolData: []bool{true}}, parent)
+	assert.Error(t, err)
+}
+
 func TestNewFrameHeaderWithReader_AllDefault_ExtraChannels(t *testing.T) {
 	parent := makeParent(100, 200, true, 3)
 
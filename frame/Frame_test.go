@@ -100,9 +100,14 @@ func TestGetColourChannelCountAndIsVisible(t *testing.T) {
 	f.Header.FrameType = REGULAR_FRAME
 	f.Header.Duration = 0
 	f.Header.IsLast = false
+	if f.IsVisible() {
+		t.Errorf("IsVisible expected false for REGULAR_FRAME without duration that isn't the last frame")
+	}
+	f.Header.IsLast = true
 	if !f.IsVisible() {
-		t.Errorf("IsVisible expected true for REGULAR_FRAME")
+		t.Errorf("IsVisible expected true for last REGULAR_FRAME")
 	}
+	f.Header.IsLast = false
 	f.Header.FrameType = SKIP_PROGRESSIVE
 	f.Header.Duration = 1
 	if !f.IsVisible() {
@@ -195,6 +200,88 @@ func TestGetGroupAndLFGroupSize(t *testing.T) {
 	}
 }
 
+func TestSetRegion(t *testing.T) {
+	f := &Frame{}
+	f.Header = &FrameHeader{groupDim: 128, lfGroupDim: 1024, Upsampling: 2}
+	f.Header.Bounds = &util.Rectangle{Origin: util.Point{}, Size: util.Dimension{Width: 1200, Height: 300}}
+	f.groupRowStride = util.CeilDiv(f.Header.Bounds.Size.Width, f.Header.groupDim)
+	f.lfGroupRowStride = util.CeilDiv(f.Header.Bounds.Size.Width, f.Header.groupDim<<3)
+
+	// x 2200-2300 in the image is 1100-1150 in the frame, which is expanded by the border into
+	// groups 8 and 9. y 10-20 is 5-10, which is only in the first row of groups.
+	f.SetRegion(util.Rectangle{Origin: util.Point{X: 2200, Y: 10}, Size: util.Dimension{Width: 100, Height: 10}})
+	expected := util.Rectangle{Origin: util.Point{X: 1024, Y: 0}, Size: util.Dimension{Width: 256, Height: 128}}
+	if *f.region != expected {
+		t.Errorf("region = %+v; want %+v", *f.region, expected)
+	}
+	for group := int32(0); group < 20; group++ {
+		want := group == 8 || group == 9
+		if got := f.groupInRegion(group); got != want {
+			t.Errorf("groupInRegion(%d) = %v; want %v", group, got, want)
+		}
+	}
+	if f.lfGroupInRegion(0) || !f.lfGroupInRegion(1) {
+		t.Errorf("expected only LF group 1 in region")
+	}
+
+	// the border reaches into the group to the left.
+	f.SetRegion(util.Rectangle{Origin: util.Point{X: 260, Y: 10}, Size: util.Dimension{Width: 10, Height: 10}})
+	if !f.groupInRegion(0) || !f.groupInRegion(1) || f.groupInRegion(2) {
+		t.Errorf("expected groups 0 and 1 in region, got %+v", *f.region)
+	}
+
+	// regions outside of the frame don't need any groups.
+	f.SetRegion(util.Rectangle{Origin: util.Point{X: 10, Y: 1000}, Size: util.Dimension{Width: 10, Height: 10}})
+	if f.groupInRegion(0) || f.lfGroupInRegion(0) {
+		t.Errorf("expected no groups in region, got %+v", *f.region)
+	}
+}
+
+func TestDownsampleFrame(t *testing.T) {
+	f := &Frame{}
+	f.Header = &FrameHeader{}
+	f.Header.Bounds = &util.Rectangle{Origin: util.Point{X: -12, Y: 16}, Size: util.Dimension{Width: 20, Height: 9}}
+	buf, err := image.NewImageBuffer(image.TYPE_FLOAT, 9, 20)
+	if err != nil {
+		t.Fatalf("NewImageBuffer: %v", err)
+	}
+	f.Buffer = []image.ImageBuffer{*buf}
+
+	if err := f.Downsample(8); err != nil {
+		t.Fatalf("Downsample: %v", err)
+	}
+	// sizes round up and origins round down, including before the image.
+	expected := util.Rectangle{Origin: util.Point{X: -2, Y: 2}, Size: util.Dimension{Width: 3, Height: 2}}
+	if *f.Header.Bounds != expected {
+		t.Errorf("bounds = %+v; want %+v", *f.Header.Bounds, expected)
+	}
+	if f.Buffer[0].Width != 3 || f.Buffer[0].Height != 2 {
+		t.Errorf("buffer size = %dx%d; want 3x2", f.Buffer[0].Width, f.Buffer[0].Height)
+	}
+}
+
+func TestCanDecodeLF(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		encoding      uint32
+		extraChannels int
+		expected      bool
+	}{
+		{name: "VarDCT", encoding: VARDCT, expected: true},
+		// extra channels have no LF image, so the frame is decoded in full.
+		{name: "VarDCT with alpha", encoding: VARDCT, extraChannels: 1, expected: false},
+		{name: "modular", encoding: MODULAR, expected: false},
+	} {
+		f := &Frame{
+			Header:         &FrameHeader{Encoding: tc.encoding},
+			GlobalMetadata: &bundle.ImageHeader{ExtraChannelInfo: make([]bundle.ExtraChannelInfo, tc.extraChannels)},
+		}
+		if got := f.CanDecodeLF(); got != tc.expected {
+			t.Errorf("%s: CanDecodeLF() = %v; want %v", tc.name, got, tc.expected)
+		}
+	}
+}
+
 func TestGetNumLFGroups(t *testing.T) {
 	f := &Frame{numLFGroups: 7}
 	if got := f.getNumLFGroups(); got != 7 {
@@ -408,8 +495,8 @@ func TestGetBitreader(t *testing.T) {
 	}
 }
 
-// TestNotImplementedFunctions tests functions that return "not implemented" errors
-func TestNotImplementedFunctions(t *testing.T) {
+// TestOptionalFeaturesDisabled tests the noise and spline functions when the frame doesn't use them
+func TestOptionalFeaturesDisabled(t *testing.T) {
 	f := &Frame{
 		LfGlobal: &LFGlobal{},
 	}
@@ -420,13 +507,6 @@ func TestNotImplementedFunctions(t *testing.T) {
 		t.Errorf("InitializeNoise with empty params should return nil, got: %v", err)
 	}
 
-	// Test InitializeNoise with noise parameters (should return error)
-	f.LfGlobal.noiseParameters = []NoiseParameters{{}}
-	err = f.InitializeNoise(0)
-	if err == nil {
-		t.Error("InitializeNoise with noise params should return error")
-	}
-
 	// Test RenderSplines without splines (should return nil)
 	f.LfGlobal.splines = nil
 	err = f.RenderSplines()
@@ -434,13 +514,6 @@ func TestNotImplementedFunctions(t *testing.T) {
 		t.Errorf("RenderSplines with nil splines should return nil, got: %v", err)
 	}
 
-	// Test RenderSplines with splines (should return error)
-	f.LfGlobal.splines = []SplinesBundle{{}}
-	err = f.RenderSplines()
-	if err == nil {
-		t.Error("RenderSplines with splines should return error")
-	}
-
 	// Test SynthesizeNoise without noise params (should return nil)
 	f.LfGlobal.noiseParameters = nil
 	err = f.SynthesizeNoise()
@@ -448,12 +521,53 @@ func TestNotImplementedFunctions(t *testing.T) {
 		t.Errorf("SynthesizeNoise with nil params should return nil, got: %v", err)
 	}
 
-	// Test SynthesizeNoise with noise params (should return error)
-	f.LfGlobal.noiseParameters = []NoiseParameters{{}}
+	// Test SynthesizeNoise with noise params but without InitializeNoise (should return error)
+	f.LfGlobal.noiseParameters = &NoiseParameters{}
 	err = f.SynthesizeNoise()
 	if err == nil {
-		t.Error("SynthesizeNoise with params should return error")
+		t.Error("SynthesizeNoise without initialized noise should return error")
+	}
+}
+
+func TestRenderSplines(t *testing.T) {
+	f := &Frame{
+		GlobalMetadata: &bundle.ImageHeader{
+			XybEncoded: true,
+			BitDepth:   &bundle.BitDepthHeader{BitsPerSample: 8},
+		},
+		Header:   &FrameHeader{Encoding: MODULAR},
+		LfGlobal: &LFGlobal{lfChanCorr: &LFChannelCorrelation{baseCorrelationX: 0, baseCorrelationB: 1}},
+	}
+	f.Buffer = make([]image.ImageBuffer, 3)
+	for c := 0; c < 3; c++ {
+		ib, err := image.NewImageBuffer(image.TYPE_FLOAT, 16, 16)
+		require.NoError(t, err)
+		f.Buffer[c] = *ib
+	}
+
+	// horizontal line across the middle of the frame with constant colour and width.
+	sb := &SplinesBundle{
+		NumSplines:    1,
+		StartPoints:   []util.Point{{X: 2, Y: 8}},
+		ControlCounts: []int32{1},
+		ControlPoints: [][]util.Point{{{X: 10, Y: 0}}},
+		CoeffX:        [][32]int32{{}},
+		CoeffY:        [][32]int32{{100}},
+		CoeffB:        [][32]int32{{}},
+		CoeffSigma:    [][32]int32{{3}},
 	}
+	f.LfGlobal.splines = sb
+
+	err := f.RenderSplines()
+	require.NoError(t, err)
+
+	y := f.Buffer[1].FloatBuffer
+	assert.Greater(t, y[8][6], float32(0))
+	assert.Greater(t, y[8][6], y[4][6])
+	assert.Equal(t, float32(0), y[0][15])
+	// B is correlated with Y
+	assert.InDelta(t, y[8][6], f.Buffer[2].FloatBuffer[8][6], 1e-6)
+	assert.Equal(t, float32(0), f.Buffer[0].FloatBuffer[8][6])
 }
 
 // TestGetColourChannelCountInt32 tests the int32 version of getColourChannelCount
@@ -1334,7 +1448,7 @@ func TestUpsampleWithExtraChannels(t *testing.T) {
 		},
 		Header: &FrameHeader{
 			Upsampling:   2,
-			EcUpsampling: []uint32{1}, // Extra channel has no upsampling
+			EcUpsampling: []uint32{4}, // Extra channel is stored at half the size of the colour channel
 			Bounds:       &util.Rectangle{Origin: util.Point{X: 0, Y: 0}, Size: util.Dimension{Width: 4, Height: 4}},
 			groupDim:     8,
 			Encoding:     MODULAR, // Not VARDCT, not XYB
@@ -1362,15 +1476,77 @@ func TestUpsampleWithExtraChannels(t *testing.T) {
 		t.Fatalf("Upsample returned error: %v", err)
 	}
 
-	// Grey color channel should be 8x8, extra channel should remain 4x4
+	// Both channels should be 8x8, with the extra channel upsampled from its top left 2x2
 	if f.Buffer[0].Width != 8 || f.Buffer[0].Height != 8 {
 		t.Errorf("Grey color channel after Upsample = %dx%d; want 8x8",
 			f.Buffer[0].Width, f.Buffer[0].Height)
 	}
+	if f.Buffer[1].Width != 8 || f.Buffer[1].Height != 8 {
+		t.Fatalf("Extra channel after Upsample = %dx%d; want 8x8",
+			f.Buffer[1].Width, f.Buffer[1].Height)
+	}
+	// the top left 2x2 increases to the right and down, which the upsampled channel keeps.
+	if f.Buffer[1].FloatBuffer[0][0] >= f.Buffer[1].FloatBuffer[0][7] ||
+		f.Buffer[1].FloatBuffer[0][0] >= f.Buffer[1].FloatBuffer[7][0] {
+		t.Errorf("Extra channel not upsampled from its top left 2x2: %v", f.Buffer[1].FloatBuffer)
+	}
+}
+
+// TestUpsampleWithDimShift tests extra channels decoded at reduced size are upsampled to the frame size
+func TestUpsampleWithDimShift(t *testing.T) {
+	f := &Frame{
+		GlobalMetadata: &bundle.ImageHeader{
+			BitDepth: &bundle.BitDepthHeader{BitsPerSample: 8},
+			ExtraChannelInfo: []bundle.ExtraChannelInfo{
+				{BitDepth: bundle.BitDepthHeader{BitsPerSample: 8}, DimShift: 1},
+			},
+			ColourEncoding: &colour.ColourEncodingBundle{ColourEncoding: colour.CE_GRAY},
+			Up2Weights:     bundle.DEFAULT_UP2,
+			Up4Weights:     bundle.DEFAULT_UP4,
+			Up8Weights:     bundle.DEFAULT_UP8,
+		},
+		Header: &FrameHeader{
+			Upsampling:   1,
+			EcUpsampling: []uint32{2}, // includes the DimShift
+			Bounds:       &util.Rectangle{Origin: util.Point{X: 0, Y: 0}, Size: util.Dimension{Width: 4, Height: 4}},
+			groupDim:     8,
+			Encoding:     MODULAR,
+		},
+	}
+
+	// extra channel holds 2x2 decoded samples in the top left of a 4x4 buffer
+	f.Buffer = make([]image.ImageBuffer, 2)
+	for c := 0; c < 2; c++ {
+		ib, err := image.NewImageBuffer(image.TYPE_INT, 4, 4)
+		if err != nil {
+			t.Fatalf("NewImageBuffer failed: %v", err)
+		}
+		f.Buffer[c] = *ib
+	}
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 2; x++ {
+			f.Buffer[1].IntBuffer[y][x] = 255
+		}
+	}
+
+	if err := f.Upsample(); err != nil {
+		t.Fatalf("Upsample returned error: %v", err)
+	}
+
+	if f.Buffer[0].Width != 4 || f.Buffer[0].Height != 4 || !f.Buffer[0].IsInt() {
+		t.Errorf("Grey color channel should be untouched")
+	}
 	if f.Buffer[1].Width != 4 || f.Buffer[1].Height != 4 {
-		t.Errorf("Extra channel after Upsample = %dx%d; want 4x4",
+		t.Fatalf("Extra channel after Upsample = %dx%d; want 4x4",
 			f.Buffer[1].Width, f.Buffer[1].Height)
 	}
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			if v := f.Buffer[1].FloatBuffer[y][x]; math.Abs(float64(v)-1) > 1e-5 {
+				t.Errorf("Extra channel (%d,%d) = %f; want 1", x, y, v)
+			}
+		}
+	}
 }
 
 func TestDecodeFrame(t *testing.T) {
@@ -1428,7 +1604,7 @@ func TestDecodeFrame(t *testing.T) {
 			xqmScale:        0,
 			bqmScale:        0,
 			Duration:        0,
-			timecode:        0,
+			Timecode:        0,
 			SaveAsReference: 0,
 			SaveBeforeCT:    false,
 			DoYCbCr:         false,
@@ -1640,14 +1816,21 @@ func TestReadBuffer_Error_Merged(t *testing.T) {
 	assert.Error(t, err)
 }
 
-func TestSetupBitReaders_Multiple_Merged(t *testing.T) {
+func TestReadSections_Multiple_Merged(t *testing.T) {
 	f := &Frame{
 		tocLengths: []uint32{10, 20},
 		reader:     &testcommon.FakeBitReader{},
 	}
-	err := f.setupBitReaders()
+	err := f.readSections(1)
 	require.NoError(t, err)
 	assert.Len(t, f.bitreaders, 2)
+	assert.NotNil(t, f.bitreaders[0])
+	assert.Nil(t, f.bitreaders[1])
+
+	err = f.readSections(2)
+	require.NoError(t, err)
+	assert.NotNil(t, f.bitreaders[1])
+	assert.Equal(t, 2, f.sectionsRead)
 }
 
 func TestDecodeFrame_AlreadyDecoded_Merged(t *testing.T) {
@@ -1723,6 +1906,9 @@ func TestDecodeFrame_VARDCT_Merged(t *testing.T) {
 
 func TestIsVisible_AllCases_Merged(t *testing.T) {
 	f := &Frame{Header: &FrameHeader{FrameType: REGULAR_FRAME}}
+	assert.False(t, f.IsVisible())
+
+	f.Header.Duration = 1
 	assert.True(t, f.IsVisible())
 
 	f.Header.FrameType = SKIP_PROGRESSIVE
@@ -1737,6 +1923,7 @@ func TestIsVisible_AllCases_Merged(t *testing.T) {
 	assert.False(t, f.IsVisible())
 
 	f.Header.FrameType = LF_FRAME
+	f.Header.IsLast = true
 	assert.False(t, f.IsVisible())
 }
 
@@ -1916,16 +2103,74 @@ func TestFrame_GetLFGroupForGroup_Merged(t *testing.T) {
 func TestInitializeNoise_WithParams_Merged(t *testing.T) {
 	f := &Frame{
 		LfGlobal: &LFGlobal{
-			noiseParameters: []NoiseParameters{{}},
+			noiseParameters: &NoiseParameters{},
 		},
 		options: &options.JXLOptions{MaxGoroutines: 1},
 		Header: &FrameHeader{
+			Upsampling: 1,
+			groupDim:   16,
 			Bounds: &util.Rectangle{
-				Size: util.Dimension{Width: 16, Height: 16},
+				Size: util.Dimension{Width: 20, Height: 18},
 			},
 		},
 	}
 	err := f.InitializeNoise(1234)
-	assert.Error(t, err)
-	assert.Equal(t, "noise not implemented", err.Error())
+	require.NoError(t, err)
+	require.Len(t, f.noiseBuffer, 3)
+	assert.Len(t, f.noiseBuffer[0], 18)
+	assert.Len(t, f.noiseBuffer[0][0], 20)
+
+	// the convolution kernel sums to 0 and the random samples are in [1,2)
+	// so the noise has to be within +/- 3.84
+	hasNonZero := false
+	for c := 0; c < 3; c++ {
+		for y := 0; y < 18; y++ {
+			for x := 0; x < 20; x++ {
+				v := f.noiseBuffer[c][y][x]
+				assert.True(t, v > -3.84 && v < 3.84)
+				hasNonZero = hasNonZero || v != 0
+			}
+		}
+	}
+	assert.True(t, hasNonZero)
+
+	// same seed, same noise.
+	first := f.noiseBuffer
+	require.NoError(t, f.InitializeNoise(1234))
+	assert.Equal(t, first, f.noiseBuffer)
+}
+
+func TestSynthesizeNoise(t *testing.T) {
+	f := &Frame{
+		GlobalMetadata: &bundle.ImageHeader{
+			XybEncoded: true,
+			BitDepth:   &bundle.BitDepthHeader{BitsPerSample: 8},
+		},
+		LfGlobal: &LFGlobal{
+			noiseParameters: &NoiseParameters{Lut: [8]float32{0.5, 0.5, 0.5, 0.5, 0.5, 0.5, 0.5, 0.5}},
+			lfChanCorr:      &LFChannelCorrelation{baseCorrelationX: 0, baseCorrelationB: 1},
+		},
+		Header: &FrameHeader{Encoding: MODULAR},
+	}
+	f.Buffer = make([]image.ImageBuffer, 3)
+	for c := 0; c < 3; c++ {
+		ib, err := image.NewImageBuffer(image.TYPE_FLOAT, 1, 2)
+		require.NoError(t, err)
+		f.Buffer[c] = *ib
+	}
+	f.noiseBuffer = [][][]float32{{{1, 0}}, {{0, 0}}, {{0, 1}}}
+
+	require.NoError(t, f.SynthesizeNoise())
+
+	// pixel 0 has only red noise : 0.5 * 0.0078125 * 0.22
+	red := float32(0.5 * 0.0078125 * 0.22)
+	assert.InDelta(t, red, f.Buffer[0].FloatBuffer[0][0], 1e-7)
+	assert.InDelta(t, red, f.Buffer[1].FloatBuffer[0][0], 1e-7)
+	assert.InDelta(t, red, f.Buffer[2].FloatBuffer[0][0], 1e-7)
+
+	// pixel 1 has correlated noise only, which is equal for red and green so cancels out in X.
+	correlated := float32(0.5 * 0.9921875 * 0.22)
+	assert.InDelta(t, 0, f.Buffer[0].FloatBuffer[0][1], 1e-7)
+	assert.InDelta(t, 2*correlated, f.Buffer[1].FloatBuffer[0][1], 1e-7)
+	assert.InDelta(t, 2*correlated, f.Buffer[2].FloatBuffer[0][1], 1e-7)
 }
@@ -245,6 +245,34 @@ func (hf *HFCoefficients) getCoefficientContext(k int32, nonZeros int32, numBloc
 	return (coeffNumNonzeroCtx[nonZeros]+coeffFreqCtx[k])*2 + prev
 }
 
+// accumulate adds the quantized coefficients of a previous pass of the same group to this pass.
+// Each pass of a progressive frame refines the coefficients decoded by the passes before it
+// (already scaled by the pass shift), so the final coefficients are the sum over all passes.
+func (hf *HFCoefficients) accumulate(prev *HFCoefficients) error {
+	if prev == nil {
+		return nil
+	}
+	if prev.groupID != hf.groupID || len(prev.quantizedCoeffs) != len(hf.quantizedCoeffs) {
+		return errors.New("mismatched HF coefficients between passes")
+	}
+	for c := 0; c < len(hf.quantizedCoeffs); c++ {
+		if len(prev.quantizedCoeffs[c]) != len(hf.quantizedCoeffs[c]) {
+			return errors.New("mismatched HF coefficients between passes")
+		}
+		for y := 0; y < len(hf.quantizedCoeffs[c]); y++ {
+			row := hf.quantizedCoeffs[c][y]
+			prevRow := prev.quantizedCoeffs[c][y]
+			if len(prevRow) != len(row) {
+				return errors.New("mismatched HF coefficients between passes")
+			}
+			for x := 0; x < len(row); x++ {
+				row[x] += prevRow[x]
+			}
+		}
+	}
+	return nil
+}
+
 func (hf *HFCoefficients) bakeDequantizedCoeffs() error {
 
 	if err := hf.dequantizeHFCoefficients(); err != nil {
@@ -2248,3 +2248,33 @@ func TestNewHFCoefficientsWithReader_OddUcNegativeCoeff(t *testing.T) {
 
 	assert.Equal(t, int32(-2), hf.quantizedCoeffs[1][1][0])
 }
+
+func TestHFCoefficientsAccumulate(t *testing.T) {
+	hf := &HFCoefficients{quantizedCoeffs: util.MakeMatrix3D[int32](3, 2, 2)}
+	prev := &HFCoefficients{quantizedCoeffs: util.MakeMatrix3D[int32](3, 2, 2)}
+	hf.quantizedCoeffs[0][0][1] = 1
+	hf.quantizedCoeffs[2][1][1] = -3
+	prev.quantizedCoeffs[0][0][1] = 4
+	prev.quantizedCoeffs[1][1][0] = 8
+
+	require.NoError(t, hf.accumulate(prev))
+	assert.Equal(t, int32(5), hf.quantizedCoeffs[0][0][1])
+	assert.Equal(t, int32(8), hf.quantizedCoeffs[1][1][0])
+	assert.Equal(t, int32(-3), hf.quantizedCoeffs[2][1][1])
+
+	// previous pass is unchanged
+	assert.Equal(t, int32(4), prev.quantizedCoeffs[0][0][1])
+
+	require.NoError(t, hf.accumulate(nil))
+	assert.Equal(t, int32(5), hf.quantizedCoeffs[0][0][1])
+}
+
+func TestHFCoefficientsAccumulate_Mismatch(t *testing.T) {
+	hf := &HFCoefficients{quantizedCoeffs: util.MakeMatrix3D[int32](3, 2, 2)}
+
+	otherGroup := &HFCoefficients{groupID: 1, quantizedCoeffs: util.MakeMatrix3D[int32](3, 2, 2)}
+	assert.Error(t, hf.accumulate(otherGroup))
+
+	otherSize := &HFCoefficients{quantizedCoeffs: util.MakeMatrix3D[int32](3, 2, 3)}
+	assert.Error(t, hf.accumulate(otherSize))
+}
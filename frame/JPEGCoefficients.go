@@ -0,0 +1,182 @@
+package frame
+
+import (
+	"errors"
+
+	"github.com/kpfaulkner/jxl-go/util"
+)
+
+const (
+	// fixed point precision and colour factor libjxl uses when applying chroma from luma to
+	// JPEG coefficients.
+	cflFixedPointPrecision = 11
+	jpegColourFactor       = 84
+)
+
+// JPEGCoefficients are the quantised DCT coefficients and quantisation tables of a VarDCT frame
+// that was losslessly recompressed from a JPEG. Channels are in JXL order (X/Cb, Y, B/Cr).
+type JPEGCoefficients struct {
+
+	// Coeffs holds 64 coefficients for each 8x8 block of a channel, with blocks in raster order
+	// and the coefficients of each block in JPEG (row major) order. DC is included.
+	Coeffs [3][]int16
+
+	WidthInBlocks  [3]int32
+	HeightInBlocks [3]int32
+
+	// HSampFactor and VSampFactor are the JPEG sampling factors of each channel.
+	HSampFactor [3]int32
+	VSampFactor [3]int32
+
+	// YCbCr is true when the JPEG was YCbCr, rather than RGB or grayscale.
+	YCbCr bool
+
+	// QuantTables are the quantisation tables of each channel in JPEG (row major) order.
+	QuantTables [3][64]int32
+
+	// ratio of the Y quantisation table to each channels table, in cflFixedPointPrecision fixed
+	// point. Chroma from luma works on quantised coefficients so needs this to rescale Y.
+	scaledQuant [3][64]int32
+}
+
+// KeepJPEGCoefficients makes DecodeFrame retain the JPEGCoefficients of the frame, which is
+// needed for JPEG reconstruction. Must be called before DecodeFrame.
+func (f *Frame) KeepJPEGCoefficients() {
+	f.keepJPEGCoefficients = true
+}
+
+// newJPEGCoefficients validates the frame can be turned back into a JPEG and sets up the
+// coefficient buffers. Called once HFGlobal has been read.
+func (f *Frame) newJPEGCoefficients() (*JPEGCoefficients, error) {
+
+	if f.Header.Encoding != VARDCT || f.hfGlobal == nil {
+		return nil, errors.New("frame is not VarDCT so has no JPEG coefficients")
+	}
+	if f.Header.passes.numPasses != 1 {
+		return nil, errors.New("progressive frames are not JPEG compatible")
+	}
+	if f.hfGlobal.params[0].mode != MODE_RAW {
+		return nil, errors.New("DCT8 quantisation table is not JPEG compatible")
+	}
+
+	lfc := f.LfGlobal.lfChanCorr
+	if !f.isSubsampled() && (lfc.baseCorrelationX != 0 || lfc.baseCorrelationB != 0 ||
+		lfc.xFactorLF != 128 || lfc.bFactorLF != 128) {
+		return nil, errors.New("chroma from luma is not JPEG compatible")
+	}
+
+	paddedSize, err := f.GetPaddedFrameSize()
+	if err != nil {
+		return nil, err
+	}
+
+	jc := &JPEGCoefficients{YCbCr: f.Header.DoYCbCr}
+	raw := f.hfGlobal.params[0].param
+	maxShiftX := max(f.Header.jpegUpsamplingX[0], f.Header.jpegUpsamplingX[1], f.Header.jpegUpsamplingX[2])
+	maxShiftY := max(f.Header.jpegUpsamplingY[0], f.Header.jpegUpsamplingY[1], f.Header.jpegUpsamplingY[2])
+	for c := 0; c < 3; c++ {
+		jc.HSampFactor[c] = 1 << (maxShiftX - f.Header.jpegUpsamplingX[c])
+		jc.VSampFactor[c] = 1 << (maxShiftY - f.Header.jpegUpsamplingY[c])
+		jc.HeightInBlocks[c] = int32(paddedSize.Height>>3) >> f.Header.jpegUpsamplingY[c]
+		jc.WidthInBlocks[c] = int32(paddedSize.Width>>3) >> f.Header.jpegUpsamplingX[c]
+		jc.Coeffs[c] = make([]int16, int(jc.WidthInBlocks[c]*jc.HeightInBlocks[c])<<6)
+		// the raw tables are stored transposed compared to the coefficients.
+		for y := 0; y < 8; y++ {
+			for x := 0; x < 8; x++ {
+				jc.QuantTables[c][x*8+y] = int32(raw[c][y*8+x])
+			}
+		}
+	}
+	for c := 0; c < 3; c++ {
+		for i := 0; i < 64; i++ {
+			if jc.QuantTables[c][i] <= 0 {
+				return nil, errors.New("invalid JPEG quantisation table")
+			}
+			jc.scaledQuant[c][i] = (1 << cflFixedPointPrecision) * jc.QuantTables[1][i] / jc.QuantTables[c][i]
+		}
+	}
+	return jc, nil
+}
+
+func (f *Frame) isSubsampled() bool {
+	for c := 0; c < 3; c++ {
+		if f.Header.jpegUpsamplingX[c] != 0 || f.Header.jpegUpsamplingY[c] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// copyJPEGCoefficients copies the quantised coefficients of this (final pass) group into jc.
+// JXL stores JPEG coefficients with chroma from luma applied and the DC in the LF coefficients,
+// so this undoes both.
+func (g *PassGroup) copyJPEGCoefficients(jc *JPEGCoefficients) error {
+
+	header := g.frame.Header
+	hf := g.hfCoefficients
+	subsampled := g.frame.isSubsampled()
+	groupLocation := g.frame.getGroupLocation(int32(g.groupID))
+	lfQuant := g.lfg.lfCoeff.lfQuant
+
+	// DC is offset when the JPEG isn't YCbCr, as JXL doesn't centre RGB samples around 0.
+	var dcOffset [3]int32
+	if !header.DoYCbCr {
+		for c := 0; c < 3; c++ {
+			dcOffset[c] = 1024 / jc.QuantTables[c][0]
+		}
+	}
+
+	for i := 0; i < len(hf.blocks); i++ {
+		posInLfg := hf.blocks[i]
+		if posInLfg == nil {
+			continue
+		}
+		tt := g.lfg.hfMetadata.dctSelect[posInLfg.Y][posInLfg.X]
+		if tt.ttType != DCT8.ttType {
+			return errors.New("only DCT8 blocks are JPEG compatible")
+		}
+		groupY := posInLfg.Y - hf.groupPos.Y
+		groupX := posInLfg.X - hf.groupPos.X
+
+		var cflScale [3]int32
+		if !subsampled {
+			cfl := g.lfg.hfMetadata.hfStreamBuffer
+			cflScale[0] = cfl[0][posInLfg.Y>>3][posInLfg.X>>3] * (1 << cflFixedPointPrecision) / jpegColourFactor
+			cflScale[2] = cfl[1][posInLfg.Y>>3][posInLfg.X>>3] * (1 << cflFixedPointPrecision) / jpegColourFactor
+		}
+
+		for _, c := range cMap {
+			sGroupY := groupY >> header.jpegUpsamplingY[c]
+			sGroupX := groupX >> header.jpegUpsamplingX[c]
+			if sGroupY<<header.jpegUpsamplingY[c] != groupY ||
+				sGroupX<<header.jpegUpsamplingX[c] != groupX {
+				continue
+			}
+			blockY := (groupLocation.Y<<5)>>header.jpegUpsamplingY[c] + sGroupY
+			blockX := (groupLocation.X<<5)>>header.jpegUpsamplingX[c] + sGroupX
+			if blockY >= jc.HeightInBlocks[c] || blockX >= jc.WidthInBlocks[c] {
+				continue
+			}
+			out := jc.Coeffs[c][(blockY*jc.WidthInBlocks[c]+blockX)<<6:][:64]
+			coeffs := hf.quantizedCoeffs[c]
+			lumaCoeffs := hf.quantizedCoeffs[1]
+			pixelGroupY := sGroupY << 3
+			pixelGroupX := sGroupX << 3
+			const round = 1 << (cflFixedPointPrecision - 1)
+			for y := int32(0); y < 8; y++ {
+				for x := int32(0); x < 8; x++ {
+					v := coeffs[pixelGroupY+y][pixelGroupX+x]
+					if cflScale[c] != 0 {
+						coeffScale := (jc.scaledQuant[c][y*8+x]*cflScale[c] + round) >> cflFixedPointPrecision
+						v += (lumaCoeffs[pixelGroupY+y][pixelGroupX+x]*coeffScale + round) >> cflFixedPointPrecision
+					}
+					out[y*8+x] = int16(v)
+				}
+			}
+
+			dc := lfQuant[cMap[c]][posInLfg.Y>>header.jpegUpsamplingY[c]][posInLfg.X>>header.jpegUpsamplingX[c]]
+			out[0] = int16(util.Clamp3(dc-dcOffset[c], -2047, 2047))
+		}
+	}
+	return nil
+}
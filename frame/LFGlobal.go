@@ -12,8 +12,8 @@ import (
 type LFGlobal struct {
 	frame           Framer
 	Patches         []Patch
-	splines         []SplinesBundle
-	noiseParameters []NoiseParameters
+	splines         *SplinesBundle
+	noiseParameters *NoiseParameters
 	lfDequant       []float32
 	hfBlockCtx      *HFBlockContext
 	lfChanCorr      *LFChannelCorrelation
@@ -46,39 +46,48 @@ func NewLFGlobalWithReader(reader jxlio.BitReader, parent Framer, hfBlockContext
 	lf.frame = parent
 	extra := len(lf.frame.getGlobalMetadata().ExtraChannelInfo)
 
-	// nolint
 	if lf.frame.getFrameHeader().Flags&PATCHES != 0 {
-
-		return nil, errors.New("Patches not implemented yet")
-		/*
-			stream, err := entropy.NewEntropyStreamWithReaderAndNumDists(reader, 10, entropy.ReadClusterMap)
-			if err != nil {
-				return nil, err
-			}
-			numPatches, err := stream.ReadSymbol(reader, 0)
+		stream, err := entropy.NewEntropyStreamWithReaderAndNumDists(reader, 10, entropy.ReadClusterMap)
+		if err != nil {
+			return nil, err
+		}
+		numPatches, err := stream.ReadSymbol(reader, patchNumRefContext)
+		if err != nil {
+			return nil, err
+		}
+		if numPatches < 0 || numPatches > 1<<24 {
+			return nil, errors.New("too many patches")
+		}
+		lf.Patches = make([]Patch, numPatches)
+		for i := 0; i < int(numPatches); i++ {
+			lf.Patches[i], err = NewPatchWithStreamAndReader(stream, reader, extra)
 			if err != nil {
 				return nil, err
 			}
-			lf.Patches = make([]Patch, numPatches)
-			for i := 0; i < int(numPatches); i++ {
-				lf.Patches[i], err = NewPatchWithStreamAndReader(stream, reader, len(parent.getGlobalMetadata().ExtraChannelInfo), len(parent.getGlobalMetadata().AlphaIndices))
-				if err != nil {
-					return nil, err
-				}
-			}
-		*/
+		}
+		if !stream.ValidateFinalState() {
+			return nil, errors.New("invalid final ANS state decoding patches")
+		}
 	} else {
 		lf.Patches = []Patch{}
 	}
 
 	if lf.frame.getFrameHeader().Flags&SPLINES != 0 {
-		return nil, errors.New("Splines not implemented yet")
+		splines, err := NewSplinesBundleWithReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		lf.splines = splines
 	} else {
 		lf.splines = nil
 	}
 
 	if lf.frame.getFrameHeader().Flags&NOISE != 0 {
-		return nil, errors.New("Noise not implemented yet")
+		noiseParameters, err := NewNoiseParametersWithReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		lf.noiseParameters = noiseParameters
 	} else {
 		lf.noiseParameters = nil
 	}
@@ -160,6 +160,7 @@ type ModularStreamer interface {
 	getDecodedBuffer() [][][]int32
 	applyTransforms() error
 	getChannels() []*ModularChannel
+	hasNonLocalTransforms() bool
 }
 
 type ModularStream struct {
@@ -221,12 +222,14 @@ func NewModularStreamWithChannels(reader jxlio.BitReader, frame Framer, streamIn
 
 	if len(channelArray) == 0 {
 		for i := 0; i < channelCount; i++ {
-			size := frame.getFrameHeader().Bounds.Size
+			header := frame.getFrameHeader()
+			size := header.Bounds.Size
 			var dimShift int32
 			if i < ecStart {
 				dimShift = 0
 			} else {
-				dimShift = frame.getGlobalMetadata().ExtraChannelInfo[i-ecStart].DimShift
+				// extra channels are stored at 1/EcUpsampling of the upsampled frame size.
+				dimShift = int32(util.CeilLog2(int64(header.EcUpsampling[i-ecStart])) - util.CeilLog2(int64(header.Upsampling)))
 			}
 			ms.channels = append(ms.channels, NewModularChannelWithAllParams(int32(size.Height), int32(size.Width), dimShift, dimShift, false))
 		}
@@ -700,6 +703,19 @@ func tendancy(a int32, b int32, c int32) int32 {
 	return 0
 }
 
+// hasNonLocalTransforms returns true if inverting the transforms makes samples depend on samples
+// in other groups. The inverse squeeze and delta palette entries (which any negative palette index
+// is) predict each sample from the previously inverted ones, so a single group can't be inverted
+// without the groups to its left and above.
+func (ms *ModularStream) hasNonLocalTransforms() bool {
+	for _, transform := range ms.transforms {
+		if transform.tr == SQUEEZE || transform.tr == PALETTE {
+			return true
+		}
+	}
+	return false
+}
+
 func (ms *ModularStream) getDecodedBuffer() [][][]int32 {
 	bands := make([][][]int32, len(ms.channels))
 	for i := 0; i < len(bands); i++ {
@@ -14,13 +14,18 @@ func NewNoiseGroupWithHeader(header *FrameHeader, seed0 int64, noiseBuffer [][][
 	ng := &NoiseGroup{}
 
 	seed1 := (int64(x0) << 32) | int64(y0)
-	xSize := util.Min(header.groupDim, header.Width-uint32(x0))
-	ySize := util.Min(header.groupDim, header.Height-uint32(y0))
 	ng.rng = NewXorShiroWith2Seeds(seed0, seed1)
+	size := header.Bounds.Size
+	if uint32(x0) >= size.Width || uint32(y0) >= size.Height {
+		return ng
+	}
+	xSize := util.Min(header.groupDim, size.Width-uint32(x0))
+	ySize := util.Min(header.groupDim, size.Height-uint32(y0))
 	bits := make([]int64, 16)
 	for c := 0; c < 3; c++ {
 		for y := 0; y < int(ySize); y++ {
-			for x := 0; x < int(xSize); x++ {
+			// each fill generates 16 samples, any left over at the end of the row are discarded.
+			for x := 0; x < int(xSize); x += 16 {
 				ng.rng.fill(bits)
 				for i := 0; i < 16 && x+i < int(xSize); i++ {
 					f := (uint32(bits[i]) >> 9) | 0x3f_80_00_00
@@ -3,6 +3,8 @@ package frame
 import (
 	"testing"
 
+	"github.com/kpfaulkner/jxl-go/util"
+
 	"github.com/stretchr/testify/assert"
 )
 
@@ -10,8 +12,9 @@ func TestNewNoiseGroupWithHeader(t *testing.T) {
 	// Setup FrameHeader
 	header := &FrameHeader{
 		groupDim: 128,
-		Width:    256,
-		Height:   256,
+		Bounds: &util.Rectangle{
+			Size: util.Dimension{Width: 256, Height: 256},
+		},
 	}
 
 	// Setup noiseBuffer
@@ -1,4 +1,42 @@
 package frame
 
+import (
+	"math"
+
+	"github.com/kpfaulkner/jxl-go/jxlio"
+)
+
+const numNoisePoints = 8
+
+// NoiseParameters holds the noise strength LUT. The LUT is indexed by intensity
+// (scaled to 0..numNoisePoints-1) and linearly interpolated.
 type NoiseParameters struct {
+	Lut [numNoisePoints]float32
+}
+
+func NewNoiseParametersWithReader(reader jxlio.BitReader) (*NoiseParameters, error) {
+	np := &NoiseParameters{}
+	for i := 0; i < numNoisePoints; i++ {
+		bits, err := reader.ReadBits(10)
+		if err != nil {
+			return nil, err
+		}
+		np.Lut[i] = float32(bits) / 1024.0
+	}
+	return np, nil
+}
+
+// strength returns the interpolated noise strength for the given intensity.
+func (np *NoiseParameters) strength(intensity float32) float32 {
+	scaled := max(0, intensity*(numNoisePoints-2))
+	floor := float32(math.Floor(float64(scaled)))
+	frac := scaled - floor
+	index := int(floor)
+	if scaled >= numNoisePoints-1 {
+		index = numNoisePoints - 2
+		frac = 1
+	}
+	low := np.Lut[index]
+	high := np.Lut[index+1]
+	return (high-low)*frac + low
 }
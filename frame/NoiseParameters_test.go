@@ -0,0 +1,67 @@
+package frame
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kpfaulkner/jxl-go/testcommon"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewNoiseParametersWithReader(t *testing.T) {
+
+	for _, tc := range []struct {
+		name           string
+		bitsData       []uint64
+		expectedResult NoiseParameters
+		expectErr      bool
+	}{
+		{
+			name:      "no data",
+			expectErr: true,
+		},
+		{
+			name:      "missing lut entry",
+			bitsData:  []uint64{1, 2, 3, 4, 5, 6, 7},
+			expectErr: true,
+		},
+		{
+			name:     "success",
+			bitsData: []uint64{0, 128, 256, 512, 1023, 0, 0, 1},
+			expectedResult: NoiseParameters{
+				Lut: [8]float32{0, 0.125, 0.25, 0.5, 1023.0 / 1024.0, 0, 0, 1.0 / 1024.0},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			bitReader := &testcommon.FakeBitReader{
+				ReadBitsData: tc.bitsData,
+			}
+			np, err := NewNoiseParametersWithReader(bitReader)
+			if err != nil && !tc.expectErr {
+				t.Errorf("got error when none was expected : %v", err)
+			}
+			if err == nil && tc.expectErr {
+				t.Errorf("expected error but got none")
+			}
+			if err != nil && tc.expectErr {
+				return
+			}
+
+			if !reflect.DeepEqual(*np, tc.expectedResult) {
+				t.Errorf("expected NoiseParameters %+v, got %+v", tc.expectedResult, *np)
+			}
+		})
+	}
+}
+
+func TestNoiseParametersStrength(t *testing.T) {
+	np := &NoiseParameters{Lut: [8]float32{0, 0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7}}
+
+	assert.InDelta(t, 0, np.strength(-1), 1e-6)
+	assert.InDelta(t, 0.1, np.strength(1.0/6.0), 1e-6)
+	// halfway between the 3rd and 4th entries
+	assert.InDelta(t, 0.25, np.strength(2.5/6.0), 1e-6)
+	// beyond the end of the LUT clamps to the last entry
+	assert.InDelta(t, 0.7, np.strength(2), 1e-6)
+}
@@ -2,7 +2,6 @@ package frame
 
 import (
 	"errors"
-	"fmt"
 
 	log "github.com/sirupsen/logrus"
 
@@ -108,8 +107,8 @@ func NewPassGroupWithReader(reader jxlio.BitReader, frame *Frame, pass uint32, g
 func (g *PassGroup) invertVarDCT(frameBuffer [][][]float32, prev *PassGroup) error {
 
 	header := g.frame.Header
-	if prev != nil {
-		panic("not implemented")
+	if err := g.accumulateHFCoefficients(prev); err != nil {
+		return err
 	}
 
 	// differs from jxlatte
@@ -125,9 +124,6 @@ func (g *PassGroup) invertVarDCT(frameBuffer [][][]float32, prev *PassGroup) err
 	scratchBlock := util.MakeMatrix3DPooled[float32](5, 256, 256)
 	defer util.ReturnMatrix3DToPool(scratchBlock)
 
-	// Pre-allocate lfs outside the loop to avoid repeated allocations
-	lfs := make([]float32, 2)
-
 	for i := 0; i < len(g.hfCoefficients.blocks); i++ {
 		posInLFG := g.hfCoefficients.blocks[i]
 		if posInLFG == nil {
@@ -152,123 +148,157 @@ func (g *PassGroup) invertVarDCT(frameBuffer [][][]float32, prev *PassGroup) err
 				Y: ppg.Y + (groupLocation.Y >> header.jpegUpsamplingY[c]),
 			}
 
-			var coeff0 float32
-			var coeff1 float32
-			switch tt.transformMethod {
-			case METHOD_DCT:
-				if err := util.InverseDCT2D(coeffs[c], frameBuffer[c], ppg, ppf, tt.getPixelSize(), scratchBlock[0], scratchBlock[1], false); err != nil {
-					return err
+			if err := g.invertBlock(tt, coeffs[c], frameBuffer[c], ppg, ppf, scratchBlock); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// invertBlock performs the inverse transform of a single varblock of one channel, reading the
+// dequantized coefficients at ppg (position in group) and writing the samples into buffer at
+// ppf (position in frame).
+func (g *PassGroup) invertBlock(tt *TransformType, coeffs [][]float32, buffer [][]float32, ppg util.Point, ppf util.Point, scratchBlock [][][]float32) error {
+
+	var lfs [2]float32
+	var coeff0 float32
+	var coeff1 float32
+	switch tt.transformMethod {
+	case METHOD_DCT:
+		if err := util.InverseDCT2D(coeffs, buffer, ppg, ppf, tt.getPixelSize(), scratchBlock[0], scratchBlock[1], false); err != nil {
+			return err
+		}
+	case METHOD_DCT8_4:
+		coeff0 = coeffs[ppg.Y][ppg.X]
+		coeff1 = coeffs[ppg.Y+1][ppg.X]
+		lfs[0] = coeff0 + coeff1
+		lfs[1] = coeff0 - coeff1
+		for x := int32(0); x < 2; x++ {
+			scratchBlock[0][0][0] = lfs[x]
+			for iy := int32(0); iy < 4; iy++ {
+				startX := int32(0)
+				if iy == 0 {
+					startX = 1
 				}
-			case METHOD_DCT8_4:
-				coeff0 = coeffs[c][ppg.Y][ppg.X]
-				coeff1 = coeffs[c][ppg.Y+1][ppg.X]
-				lfs[0] = coeff0 + coeff1
-				lfs[1] = coeff0 - coeff1
-				for x := int32(0); x < 2; x++ {
-					scratchBlock[0][0][0] = lfs[x]
-					for iy := int32(0); iy < 4; iy++ {
-						startX := int32(0)
-						if iy == 0 {
-							startX = 1
-						}
-						for ix := startX; ix < 8; ix++ {
-							scratchBlock[0][iy][ix] = coeffs[c][ppg.Y+x+iy*2][ppg.X+ix]
-						}
-					}
-					ppf2 := util.Point{
-						X: ppf.X,
-						Y: ppf.Y,
+				for ix := startX; ix < 8; ix++ {
+					scratchBlock[0][iy][ix] = coeffs[ppg.Y+x+iy*2][ppg.X+ix]
+				}
+			}
+			ppf2 := util.Point{
+				X: ppf.X,
+				Y: ppf.Y,
+			}
+			ppf2.X += x << 2
+			if err := util.InverseDCT2D(scratchBlock[0], buffer, util.ZERO, ppf2,
+				util.Dimension{Height: 4, Width: 8}, scratchBlock[1], scratchBlock[2], true); err != nil {
+				return err
+			}
+		}
+
+	case METHOD_DCT4_8:
+		coeff0 = coeffs[ppg.Y][ppg.X]
+		coeff1 = coeffs[ppg.Y+1][ppg.X]
+		lfs[0] = coeff0 + coeff1
+		lfs[1] = coeff0 - coeff1
+		for y := int32(0); y < 2; y++ {
+			scratchBlock[0][0][0] = lfs[y]
+			for iy := int32(0); iy < 4; iy++ {
+				startX := int32(0)
+				if iy == 0 {
+					startX = 1
+				}
+				for ix := startX; ix < 8; ix++ {
+					scratchBlock[0][iy][ix] = coeffs[ppg.Y+y+iy*2][ppg.X+ix]
+				}
+			}
+			ppf2 := util.Point{
+				X: ppf.X,
+				Y: ppf.Y,
+			}
+			ppf2.Y += y << 2
+			if err := util.InverseDCT2D(scratchBlock[0], buffer, util.ZERO, ppf2,
+				util.Dimension{Height: 4, Width: 8}, scratchBlock[1], scratchBlock[2], false); err != nil {
+				return err
+			}
+		}
+
+	case METHOD_AFV:
+		if log.GetLevel() >= log.DebugLevel {
+			displayBuffer("before", buffer)
+		}
+		if err := g.invertAFV(coeffs, buffer, tt, ppg, ppf, scratchBlock); err != nil {
+			return err
+		}
+		if log.GetLevel() >= log.DebugLevel {
+			displayBuffer("after", buffer)
+		}
+	case METHOD_DCT2:
+		if log.GetLevel() >= log.DebugLevel {
+			displayBuffer("DCT2-before", buffer)
+		}
+		g.auxDCT2(coeffs, scratchBlock[0], ppg, util.ZERO, 2)
+		g.auxDCT2(scratchBlock[0], scratchBlock[1], util.ZERO, util.ZERO, 4)
+		g.auxDCT2(scratchBlock[1], buffer, util.ZERO, ppf, 8)
+		if log.GetLevel() >= log.DebugLevel {
+			displayBuffer("DCT2-after", buffer)
+		}
+	case METHOD_HORNUSS:
+		g.auxDCT2(coeffs, scratchBlock[1], ppg, util.ZERO, 2)
+		for y := int32(0); y < 2; y++ {
+			for x := int32(0); x < 2; x++ {
+				blockLF := scratchBlock[1][y][x]
+				residual := float32(0.0)
+				for iy := int32(0); iy < 4; iy++ {
+					ixTemp := int32(0)
+					if iy == 0 {
+						ixTemp = 1
 					}
-					ppf2.X += x << 2
-					if err := util.InverseDCT2D(scratchBlock[0], frameBuffer[c], util.ZERO, ppf2,
-						util.Dimension{Height: 4, Width: 8}, scratchBlock[1], scratchBlock[2], true); err != nil {
-						return err
+					for ix := ixTemp; ix < 4; ix++ {
+						residual += coeffs[ppg.Y+y+iy*2][ppg.X+x+ix*2]
 					}
 				}
-
-			case METHOD_DCT4_8:
-				coeff0 = coeffs[c][ppg.Y][ppg.X]
-				coeff1 = coeffs[c][ppg.Y+1][ppg.X]
-				lfs[0] = coeff0 + coeff1
-				lfs[1] = coeff0 - coeff1
-				for y := int32(0); y < 2; y++ {
-					scratchBlock[0][0][0] = lfs[y]
-					for iy := int32(0); iy < 4; iy++ {
-						startX := int32(0)
-						if iy == 0 {
-							startX = 1
-						}
-						for ix := startX; ix < 8; ix++ {
-							scratchBlock[0][iy][ix] = coeffs[c][ppg.Y+y+iy*2][ppg.X+ix]
+				scratchBlock[0][4*y+1][4*x+1] = blockLF - residual*0.0625
+				for iy := int32(0); iy < 4; iy++ {
+					for ix := int32(0); ix < 4; ix++ {
+						if ix == 1 && iy == 1 {
+							continue
 						}
+						scratchBlock[0][4*y+iy][x*4+ix] = coeffs[ppg.Y+y+iy*2][ppg.X+x+ix*2] + scratchBlock[0][4*y+1][4*x+1]
 					}
-					ppf2 := util.Point{
-						X: ppf.X,
-						Y: ppf.Y,
+				}
+				scratchBlock[0][4*y][4*x] = coeffs[ppg.Y+y+2][ppg.X+x+2] + scratchBlock[0][4*y+1][4*x+1]
+			}
+		}
+		layBlock(scratchBlock[0], buffer, util.ZERO, ppf, tt.getPixelSize())
+	case METHOD_DCT4:
+		g.auxDCT2(coeffs, scratchBlock[0], ppg, util.ZERO, 2)
+		for y := int32(0); y < 2; y++ {
+			for x := int32(0); x < 2; x++ {
+				scratchBlock[1][0][0] = scratchBlock[0][y][x]
+				for iy := int32(0); iy < 4; iy++ {
+					startX := int32(0)
+					if iy == 0 {
+						startX = 1
 					}
-					ppf2.Y += y << 2
-					if err := util.InverseDCT2D(scratchBlock[0], frameBuffer[c], util.ZERO, ppf2,
-						util.Dimension{Height: 4, Width: 8}, scratchBlock[1], scratchBlock[2], false); err != nil {
-						return err
+					for ix := startX; ix < 4; ix++ {
+						scratchBlock[1][iy][ix] = coeffs[ppg.Y+y+iy*2][ppg.X+x+ix*2]
 					}
 				}
-
-			case METHOD_AFV:
-				if log.GetLevel() >= log.DebugLevel {
-					displayBuffer("before", frameBuffer[c])
+				ppf2 := util.Point{
+					X: ppf.X + x<<2,
+					Y: ppf.Y + y<<2,
 				}
-				// FIXME(kpfaulkner) there is some bug in here compared to JXLatte, but
-				// have yet to figure it out.
-				if err := g.invertAFV(coeffs[c], frameBuffer[c], tt, ppg, ppf, scratchBlock); err != nil {
+				// like the other 4 sample wide blocks the coefficients aren't flipped, so the
+				// output is transposed.
+				if err := util.InverseDCT2D(scratchBlock[1], buffer, util.ZERO, ppf2,
+					util.Dimension{Height: 4, Width: 4}, scratchBlock[2], scratchBlock[3], true); err != nil {
 					return err
 				}
-				if log.GetLevel() >= log.DebugLevel {
-					displayBuffer("after", frameBuffer[c])
-				}
-			case METHOD_DCT2:
-				if log.GetLevel() >= log.DebugLevel {
-					displayBuffer("DCT2-before", frameBuffer[c])
-				}
-				g.auxDCT2(coeffs[c], scratchBlock[0], ppg, util.ZERO, 2)
-				g.auxDCT2(scratchBlock[0], scratchBlock[1], util.ZERO, util.ZERO, 4)
-				g.auxDCT2(scratchBlock[1], frameBuffer[c], util.ZERO, ppf, 8)
-				if log.GetLevel() >= log.DebugLevel {
-					displayBuffer("DCT2-after", frameBuffer[c])
-				}
-			case METHOD_HORNUSS:
-				g.auxDCT2(coeffs[c], scratchBlock[1], ppg, util.ZERO, 2)
-				for y := int32(0); y < 2; y++ {
-					for x := int32(0); x < 2; x++ {
-						blockLF := scratchBlock[1][y][x]
-						residual := float32(0.0)
-						for iy := int32(0); iy < 4; iy++ {
-							ixTemp := int32(0)
-							if iy == 0 {
-								ixTemp = 1
-							}
-							for ix := ixTemp; ix < 4; ix++ {
-								residual += coeffs[c][ppg.Y+y+iy*2][ppg.X+x+ix*2]
-							}
-						}
-						scratchBlock[0][4*y+1][4*x+1] = blockLF - residual*0.0625
-						for iy := int32(0); iy < 4; iy++ {
-							for ix := int32(0); ix < 4; ix++ {
-								if ix == 1 && iy == 1 {
-									continue
-								}
-								scratchBlock[0][4*y+iy][x*4+ix] = coeffs[c][ppg.Y+y+iy*2][ppg.X+x+ix*2] + scratchBlock[0][4*y+1][4*x+1]
-							}
-						}
-						scratchBlock[0][4*y][4*x] = coeffs[c][ppg.Y+y+2][ppg.X+x+2] + scratchBlock[0][4*y+1][4*x+1]
-					}
-				}
-				layBlock(scratchBlock[0], frameBuffer[c], util.ZERO, ppf, tt.getPixelSize())
-			case METHOD_DCT4:
-				panic("not implemented")
-			default:
-				return errors.New("transform not implemented")
 			}
 		}
+	default:
+		return errors.New("transform not implemented")
 	}
 	return nil
 }
@@ -284,27 +314,6 @@ func layBlock(block [][]float32, buffer [][]float32, inPos util.Point, outPos ut
 
 func (g *PassGroup) invertAFV(coeffs [][]float32, buffer [][]float32, tt *TransformType, ppg util.Point, ppf util.Point, scratchBlock [][][]float32) error {
 
-	// some debugging logic here... there's a bug in here somewhere :/
-	if false {
-		fmt.Printf("invertAFV coeffs:\n")
-		for y := 0; y < len(coeffs); y++ {
-			total := float32(0.0)
-			for x := 0; x < len(coeffs[y]); x++ {
-				//fmt.Printf("%0.10f ", coeffs[i][j])
-				total += coeffs[y][x]
-			}
-			if total != 0.0 {
-				// super inefficient... but dont care.
-				fmt.Printf("coord y=%d non zero %0.10f\n", y, total)
-				for x := 0; x < len(coeffs[y]); x++ {
-					//fmt.Printf("%0.10f ", coeffs[y][x])
-				}
-				//fmt.Printf("\n")
-			}
-		}
-		fmt.Printf("==========\n")
-	}
-
 	scratchBlock[0][0][0] = (coeffs[ppg.Y][ppg.X] + coeffs[ppg.Y+1][ppg.X] + coeffs[ppg.Y][ppg.X+1]) * 4.0
 	for iy := int32(0); iy < 4; iy++ {
 		startX := int32(0)
@@ -318,10 +327,11 @@ func (g *PassGroup) invertAFV(coeffs [][]float32, buffer [][]float32, tt *Transf
 
 	var flipX int32
 	var flipY int32
-	if tt == AFV2 || tt == AFV3 {
+	// the transform types in the HF metadata are copies, so compare the type rather than the pointer.
+	if tt.ttType == AFV2.ttType || tt.ttType == AFV3.ttType {
 		flipY = 1
 	}
-	if tt == AFV1 || tt == AFV3 {
+	if tt.ttType == AFV1.ttType || tt.ttType == AFV3.ttType {
 		flipX = 1
 	}
 	totalSample := float32(0)
@@ -435,6 +445,18 @@ func (g *PassGroup) layBlock(block [][]float32, buffer [][]float32, inPos util.P
 	}
 }
 
+// accumulateHFCoefficients adds the HF coefficients of the previous pass (which in turn already
+// contains all the passes before it) to this pass group.
+func (g *PassGroup) accumulateHFCoefficients(prev *PassGroup) error {
+	if prev == nil || prev.hfCoefficients == nil {
+		return nil
+	}
+	if g.hfCoefficients == nil {
+		return errors.New("pass group has no HF coefficients")
+	}
+	return g.hfCoefficients.accumulate(prev.hfCoefficients)
+}
+
 // Release returns pooled buffers to the pool
 func (g *PassGroup) Release() {
 	if g.hfCoefficients != nil {
@@ -1,11 +1,39 @@
 package frame
 
 import (
+	"errors"
+
 	"github.com/kpfaulkner/jxl-go/entropy"
 	"github.com/kpfaulkner/jxl-go/jxlio"
 	"github.com/kpfaulkner/jxl-go/util"
 )
 
+// Patch blend modes. These are NOT the same values as the frame BLEND_* modes.
+const (
+	PATCH_BLEND_NONE                 = 0
+	PATCH_BLEND_REPLACE              = 1
+	PATCH_BLEND_ADD                  = 2
+	PATCH_BLEND_MULT                 = 3
+	PATCH_BLEND_BLEND_ABOVE          = 4
+	PATCH_BLEND_BLEND_BELOW          = 5
+	PATCH_BLEND_ALPHA_WEIGHTED_ABOVE = 6
+	PATCH_BLEND_ALPHA_WEIGHTED_BELOW = 7
+
+	// contexts used in the patch dictionary entropy stream
+	patchNumRefContext       = 0
+	patchRefFrameContext     = 1
+	patchSizeContext         = 2
+	patchRefPositionContext  = 3
+	patchPositionContext     = 4
+	patchBlendModeContext    = 5
+	patchOffsetContext       = 6
+	patchCountContext        = 7
+	patchAlphaChannelContext = 8
+	patchClampContext        = 9
+)
+
+// Patch is a rectangle copied out of a reference frame (Bounds) and blended
+// onto the current frame at each of the Positions.
 type Patch struct {
 	Width         int32
 	Height        int32
@@ -16,7 +44,122 @@ type Patch struct {
 	BlendingInfos [][]BlendingInfo
 }
 
-func NewPatchWithStreamAndReader(stream entropy.EntropyStreamer, reader jxlio.BitReader, extraChannelCount int, alphaChannelCount int) (Patch, error) {
+// NewPatchWithStreamAndReader reads a single patch dictionary entry.
+func NewPatchWithStreamAndReader(stream entropy.EntropyStreamer, reader jxlio.BitReader, extraChannelCount int) (Patch, error) {
+
+	patch := Patch{}
+	readSymbol := func(ctx int) (int32, error) {
+		return stream.ReadSymbol(reader, ctx)
+	}
+
+	ref, err := readSymbol(patchRefFrameContext)
+	if err != nil {
+		return Patch{}, err
+	}
+	if ref > 3 {
+		return Patch{}, errors.New("patch reference frame out of range")
+	}
+	patch.Ref = ref
+
+	x0, err := readSymbol(patchRefPositionContext)
+	if err != nil {
+		return Patch{}, err
+	}
+	y0, err := readSymbol(patchRefPositionContext)
+	if err != nil {
+		return Patch{}, err
+	}
+	width, err := readSymbol(patchSizeContext)
+	if err != nil {
+		return Patch{}, err
+	}
+	height, err := readSymbol(patchSizeContext)
+	if err != nil {
+		return Patch{}, err
+	}
+	patch.Width = width + 1
+	patch.Height = height + 1
+	patch.Origin = util.Point{X: x0, Y: y0}
+	patch.Bounds = util.Rectangle{
+		Origin: patch.Origin,
+		Size:   util.Dimension{Width: uint32(patch.Width), Height: uint32(patch.Height)},
+	}
+
+	count, err := readSymbol(patchCountContext)
+	if err != nil {
+		return Patch{}, err
+	}
+	count++
+	if count <= 0 || count > (1<<24) {
+		return Patch{}, errors.New("too many patch positions")
+	}
+
+	patch.Positions = make([]util.Point, count)
+	patch.BlendingInfos = make([][]BlendingInfo, count)
+	for j := 0; j < int(count); j++ {
+		if j == 0 {
+			x, err := readSymbol(patchPositionContext)
+			if err != nil {
+				return Patch{}, err
+			}
+			y, err := readSymbol(patchPositionContext)
+			if err != nil {
+				return Patch{}, err
+			}
+			patch.Positions[j] = util.Point{X: x, Y: y}
+		} else {
+			dx, err := readSymbol(patchOffsetContext)
+			if err != nil {
+				return Patch{}, err
+			}
+			dy, err := readSymbol(patchOffsetContext)
+			if err != nil {
+				return Patch{}, err
+			}
+			patch.Positions[j] = util.Point{
+				X: patch.Positions[j-1].X + jxlio.UnpackSigned(uint32(dx)),
+				Y: patch.Positions[j-1].Y + jxlio.UnpackSigned(uint32(dy)),
+			}
+		}
+		if patch.Positions[j].X < 0 || patch.Positions[j].Y < 0 {
+			return Patch{}, errors.New("patch position out of bounds")
+		}
+
+		patch.BlendingInfos[j] = make([]BlendingInfo, extraChannelCount+1)
+		for k := 0; k < extraChannelCount+1; k++ {
+			mode, err := readSymbol(patchBlendModeContext)
+			if err != nil {
+				return Patch{}, err
+			}
+			if mode < 0 || mode > PATCH_BLEND_ALPHA_WEIGHTED_BELOW {
+				return Patch{}, errors.New("invalid patch blend mode")
+			}
+			info := BlendingInfo{Mode: uint32(mode)}
+			if patchUsesAlpha(info.Mode) && extraChannelCount > 1 {
+				alpha, err := readSymbol(patchAlphaChannelContext)
+				if err != nil {
+					return Patch{}, err
+				}
+				if alpha < 0 || int(alpha) >= extraChannelCount {
+					return Patch{}, errors.New("patch alpha channel out of range")
+				}
+				info.AlphaChannel = uint32(alpha)
+			}
+			if patchUsesAlpha(info.Mode) || info.Mode == PATCH_BLEND_MULT {
+				clamp, err := readSymbol(patchClampContext)
+				if err != nil {
+					return Patch{}, err
+				}
+				info.Clamp = clamp != 0
+			}
+			patch.BlendingInfos[j][k] = info
+		}
+	}
+
+	return patch, nil
+}
 
-	return Patch{}, nil
+func patchUsesAlpha(mode uint32) bool {
+	return mode == PATCH_BLEND_BLEND_ABOVE || mode == PATCH_BLEND_BLEND_BELOW ||
+		mode == PATCH_BLEND_ALPHA_WEIGHTED_ABOVE || mode == PATCH_BLEND_ALPHA_WEIGHTED_BELOW
 }
@@ -0,0 +1,113 @@
+package frame
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kpfaulkner/jxl-go/util"
+)
+
+func TestNewPatchWithStreamAndReader(t *testing.T) {
+
+	for _, tc := range []struct {
+		name              string
+		symbols           []int32
+		extraChannelCount int
+		expectedPatch     Patch
+		expectErr         bool
+	}{
+		{
+			name:      "no data",
+			expectErr: true,
+		},
+		{
+			name: "two positions, no extra channels",
+			symbols: []int32{
+				2,    // ref
+				4, 5, // x0, y0
+				7, 3, // width-1, height-1
+				1,      // count-1
+				10, 20, // first position
+				PATCH_BLEND_REPLACE, // mode
+				3, 4,                // packed signed offset for 2nd position (-2, +2)
+				PATCH_BLEND_MULT, // mode
+				1,                // clamp
+			},
+			expectedPatch: Patch{
+				Width:  8,
+				Height: 4,
+				Bounds: util.Rectangle{Origin: util.Point{X: 4, Y: 5}, Size: util.Dimension{Width: 8, Height: 4}},
+				Ref:    2,
+				Origin: util.Point{X: 4, Y: 5},
+				Positions: []util.Point{
+					{X: 10, Y: 20},
+					{X: 8, Y: 22},
+				},
+				BlendingInfos: [][]BlendingInfo{
+					{{Mode: PATCH_BLEND_REPLACE}},
+					{{Mode: PATCH_BLEND_MULT, Clamp: true}},
+				},
+			},
+		},
+		{
+			name: "blend above with multiple extra channels reads alpha channel",
+			symbols: []int32{
+				0,
+				0, 0,
+				0, 0,
+				0,
+				1, 1,
+				PATCH_BLEND_BLEND_ABOVE, 1, 0, // colour: mode, alpha channel, clamp
+				PATCH_BLEND_NONE, // ec 0
+				PATCH_BLEND_ADD,  // ec 1
+			},
+			extraChannelCount: 2,
+			expectedPatch: Patch{
+				Width:         1,
+				Height:        1,
+				Bounds:        util.Rectangle{Size: util.Dimension{Width: 1, Height: 1}},
+				Positions:     []util.Point{{X: 1, Y: 1}},
+				BlendingInfos: [][]BlendingInfo{{{Mode: PATCH_BLEND_BLEND_ABOVE, AlphaChannel: 1}, {Mode: PATCH_BLEND_NONE}, {Mode: PATCH_BLEND_ADD}}},
+			},
+		},
+		{
+			name:      "invalid reference",
+			symbols:   []int32{4},
+			expectErr: true,
+		},
+		{
+			name:      "invalid blend mode",
+			symbols:   []int32{0, 0, 0, 0, 0, 0, 0, 0, 8},
+			expectErr: true,
+		},
+		{
+			name:              "alpha channel out of range",
+			symbols:           []int32{0, 0, 0, 0, 0, 0, 0, 0, PATCH_BLEND_BLEND_ABOVE, 2},
+			extraChannelCount: 2,
+			expectErr:         true,
+		},
+		{
+			name:      "negative position",
+			symbols:   []int32{0, 0, 0, 0, 0, 1, 0, 0, PATCH_BLEND_REPLACE, 1, 0},
+			expectErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			stream := &FakeEntropyStreamer{FakeSymbols: tc.symbols}
+			patch, err := NewPatchWithStreamAndReader(stream, nil, tc.extraChannelCount)
+			if err != nil && !tc.expectErr {
+				t.Errorf("got error when none was expected : %v", err)
+			}
+			if err == nil && tc.expectErr {
+				t.Errorf("expected error but got none")
+			}
+			if err != nil && tc.expectErr {
+				return
+			}
+
+			if !reflect.DeepEqual(patch, tc.expectedPatch) {
+				t.Errorf("expected patch %+v, got %+v", tc.expectedPatch, patch)
+			}
+		})
+	}
+}
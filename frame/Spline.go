@@ -0,0 +1,184 @@
+package frame
+
+import (
+	"math"
+)
+
+var (
+	splineChannelWeight = []float32{0.0042, 0.075, 0.07, 0.3333}
+)
+
+const (
+	// distance between each point that gets rendered along a spline.
+	splineRenderingDistance = 1.0
+
+	// ln(0.1) * 3, used to determine how far from the centre a segment needs to be drawn.
+	splineDistanceFactor = -6.907755278982137
+)
+
+type SplinePoint struct {
+	X float32
+	Y float32
+}
+
+// Spline is a dequantised spline. Control points are absolute positions in the frame
+// and the coefficients are DCT32 coefficients (varying along the length of the spline).
+type Spline struct {
+	ControlPoints []SplinePoint
+	CoeffX        [32]float32
+	CoeffY        [32]float32
+	CoeffB        [32]float32
+	CoeffSigma    [32]float32
+}
+
+type splineSegment struct {
+	point      SplinePoint
+	multiplier float32
+}
+
+func (p SplinePoint) distance(o SplinePoint) float32 {
+	dx := p.X - o.X
+	dy := p.Y - o.Y
+	return float32(math.Sqrt(float64(dx*dx + dy*dy)))
+}
+
+// upsampleControlPoints generates 16 points per control point segment using centripetal Catmull-Rom.
+func (s *Spline) upsampleControlPoints() []SplinePoint {
+	points := s.ControlPoints
+	if len(points) == 1 {
+		return []SplinePoint{points[0]}
+	}
+
+	extended := make([]SplinePoint, 0, len(points)+2)
+	first := points[0]
+	second := points[1]
+	extended = append(extended, SplinePoint{X: 2*first.X - second.X, Y: 2*first.Y - second.Y})
+	extended = append(extended, points...)
+	last := points[len(points)-1]
+	penultimate := points[len(points)-2]
+	extended = append(extended, SplinePoint{X: 2*last.X - penultimate.X, Y: 2*last.Y - penultimate.Y})
+
+	upsampled := make([]SplinePoint, 0, 16*(len(extended)-3)+1)
+	var t [4]float32
+	var a [3]SplinePoint
+	var b [2]SplinePoint
+	for start := 0; start+3 < len(extended); start++ {
+		control := extended[start : start+4]
+		upsampled = append(upsampled, control[1])
+		t[0] = 0
+		for k := 1; k < 4; k++ {
+			t[k] = t[k-1] + float32(math.Sqrt(float64(control[k].distance(control[k-1]))))
+		}
+		for step := 1; step < 16; step++ {
+			knot := t[1] + float32(step)/16.0*(t[2]-t[1])
+			for k := 0; k < 3; k++ {
+				f := (knot - t[k]) / (t[k+1] - t[k])
+				a[k] = SplinePoint{X: control[k].X + f*(control[k+1].X-control[k].X), Y: control[k].Y + f*(control[k+1].Y-control[k].Y)}
+			}
+			for k := 0; k < 2; k++ {
+				f := (knot - t[k]) / (t[k+2] - t[k])
+				b[k] = SplinePoint{X: a[k].X + f*(a[k+1].X-a[k].X), Y: a[k].Y + f*(a[k+1].Y-a[k].Y)}
+			}
+			f := (knot - t[1]) / (t[2] - t[1])
+			upsampled = append(upsampled, SplinePoint{X: b[0].X + f*(b[1].X-b[0].X), Y: b[0].Y + f*(b[1].Y-b[0].Y)})
+		}
+	}
+	upsampled = append(upsampled, last)
+	return upsampled
+}
+
+// equallySpacedPoints walks along the upsampled points generating a point every splineRenderingDistance.
+// Each returned segment has the arc length it represents as the multiplier.
+func equallySpacedPoints(points []SplinePoint) []splineSegment {
+	segments := []splineSegment{{point: points[0], multiplier: splineRenderingDistance}}
+	current := points[0]
+	next := 0
+	for next < len(points) {
+		previous := current
+		arcLengthFromPrevious := float32(0)
+		for {
+			if next == len(points) {
+				segments = append(segments, splineSegment{point: previous, multiplier: arcLengthFromPrevious})
+				return segments
+			}
+			arcLengthToNext := points[next].distance(previous)
+			if arcLengthFromPrevious+arcLengthToNext >= splineRenderingDistance {
+				f := (splineRenderingDistance - arcLengthFromPrevious) / arcLengthToNext
+				current = SplinePoint{X: previous.X + f*(points[next].X-previous.X), Y: previous.Y + f*(points[next].Y-previous.Y)}
+				segments = append(segments, splineSegment{point: current, multiplier: splineRenderingDistance})
+				break
+			}
+			arcLengthFromPrevious += arcLengthToNext
+			previous = points[next]
+			next++
+		}
+	}
+	return segments
+}
+
+// continuousIDCT evaluates the DCT32 at position t (0 <= t <= 31)
+func continuousIDCT(coeffs *[32]float32, t float32) float32 {
+	result := float64(coeffs[0])
+	for i := 1; i < 32; i++ {
+		result += math.Sqrt2 * float64(coeffs[i]) * math.Cos(float64(i)*math.Pi/32.0*(float64(t)+0.5))
+	}
+	return float32(result)
+}
+
+// render adds the spline onto the XYB buffers.
+func (s *Spline) render(buffers [][][]float32, width int32, height int32) {
+
+	segments := equallySpacedPoints(s.upsampleControlPoints())
+	arcLength := float32(len(segments)-2)*splineRenderingDistance + segments[len(segments)-1].multiplier
+	coeffs := []*[32]float32{&s.CoeffX, &s.CoeffY, &s.CoeffB}
+	var colour [3]float32
+	for i, segment := range segments {
+		progress := float32(1)
+		if arcLength > 0 {
+			progress = min(1, float32(i)*splineRenderingDistance/arcLength)
+		}
+		t := 31 * progress
+		for c := 0; c < 3; c++ {
+			colour[c] = continuousIDCT(coeffs[c], t)
+		}
+		sigma := continuousIDCT(&s.CoeffSigma, t)
+		drawSplineSegment(buffers, width, height, segment, colour, sigma)
+	}
+}
+
+func drawSplineSegment(buffers [][][]float32, width int32, height int32, segment splineSegment, colour [3]float32, sigma float32) {
+
+	invSigma := 1 / sigma
+	if sigma == 0 || math.IsNaN(float64(sigma)) || math.IsInf(float64(sigma), 0) || math.IsInf(float64(invSigma), 0) {
+		return
+	}
+
+	maxColour := float32(0.01)
+	for c := 0; c < 3; c++ {
+		maxColour = max(maxColour, float32(math.Abs(float64(colour[c]*segment.multiplier))))
+	}
+	maxDistance := math.Sqrt(-2 * float64(sigma) * float64(sigma) * (splineDistanceFactor - math.Log(float64(maxColour))))
+	if math.IsNaN(maxDistance) || math.IsInf(maxDistance, 0) {
+		return
+	}
+
+	centre := segment.point
+	y0 := max(0, int32(float64(centre.Y)-maxDistance+0.5))
+	y1 := min(height, int32(float64(centre.Y)+maxDistance+1.5))
+	x0 := max(0, int32(float64(centre.X)-maxDistance+0.5))
+	x1 := min(width, int32(float64(centre.X)+maxDistance+1.5))
+	sigmaOver4TimesIntensity := 0.25 * float64(sigma) * float64(segment.multiplier)
+	for y := y0; y < y1; y++ {
+		dy := float64(float32(y) - centre.Y)
+		for x := x0; x < x1; x++ {
+			dx := float64(float32(x) - centre.X)
+			distance := math.Sqrt(dx*dx + dy*dy)
+			factor := math.Erf((0.5*distance+math.Sqrt(0.125))*float64(invSigma)) -
+				math.Erf((0.5*distance-math.Sqrt(0.125))*float64(invSigma))
+			localIntensity := float32(sigmaOver4TimesIntensity * factor * factor)
+			for c := 0; c < 3; c++ {
+				buffers[c][y][x] += colour[c] * localIntensity
+			}
+		}
+	}
+}
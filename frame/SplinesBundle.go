@@ -1,4 +1,172 @@
 package frame
 
+import (
+	"errors"
+
+	"github.com/kpfaulkner/jxl-go/entropy"
+	"github.com/kpfaulkner/jxl-go/jxlio"
+	"github.com/kpfaulkner/jxl-go/util"
+)
+
+const (
+	splineQuantAdjustContext  = 0
+	splineStartPosContext     = 1
+	splineNumSplinesContext   = 2
+	splineNumControlContext   = 3
+	splineControlPointContext = 4
+	splineDCTContext          = 5
+	splineNumContexts         = 6
+
+	maxNumSplines       = 1 << 24
+	maxNumControlPoints = 1 << 20
+)
+
+// SplinesBundle holds the quantised splines as read from the LFGlobal section.
+// They are dequantised (see Dequantize) just before rendering since that requires the
+// LF channel correlation factors.
 type SplinesBundle struct {
+	NumSplines    int32
+	QuantAdjust   int32
+	StartPoints   []util.Point
+	ControlCounts []int32
+	ControlPoints [][]util.Point // delta encoded, NOT absolute positions.
+	CoeffX        [][32]int32
+	CoeffY        [][32]int32
+	CoeffB        [][32]int32
+	CoeffSigma    [][32]int32
+}
+
+func NewSplinesBundleWithReader(reader jxlio.BitReader) (*SplinesBundle, error) {
+
+	stream, err := entropy.NewEntropyStreamWithReaderAndNumDists(reader, splineNumContexts, entropy.ReadClusterMap)
+	if err != nil {
+		return nil, err
+	}
+
+	sb := &SplinesBundle{}
+	numSplines, err := stream.ReadSymbol(reader, splineNumSplinesContext)
+	if err != nil {
+		return nil, err
+	}
+	numSplines++
+	if numSplines <= 0 || numSplines > maxNumSplines {
+		return nil, errors.New("too many splines")
+	}
+	sb.NumSplines = numSplines
+
+	sb.StartPoints = make([]util.Point, numSplines)
+	lastX := int32(0)
+	lastY := int32(0)
+	for i := 0; i < int(numSplines); i++ {
+		x, err := stream.ReadSymbol(reader, splineStartPosContext)
+		if err != nil {
+			return nil, err
+		}
+		y, err := stream.ReadSymbol(reader, splineStartPosContext)
+		if err != nil {
+			return nil, err
+		}
+		if i != 0 {
+			x = jxlio.UnpackSigned(uint32(x)) + lastX
+			y = jxlio.UnpackSigned(uint32(y)) + lastY
+		}
+		sb.StartPoints[i] = util.Point{X: x, Y: y}
+		lastX = x
+		lastY = y
+	}
+
+	quantAdjust, err := stream.ReadSymbol(reader, splineQuantAdjustContext)
+	if err != nil {
+		return nil, err
+	}
+	sb.QuantAdjust = jxlio.UnpackSigned(uint32(quantAdjust))
+
+	sb.ControlCounts = make([]int32, numSplines)
+	sb.ControlPoints = make([][]util.Point, numSplines)
+	sb.CoeffX = make([][32]int32, numSplines)
+	sb.CoeffY = make([][32]int32, numSplines)
+	sb.CoeffB = make([][32]int32, numSplines)
+	sb.CoeffSigma = make([][32]int32, numSplines)
+	totalControlPoints := int64(0)
+	for i := 0; i < int(numSplines); i++ {
+		count, err := stream.ReadSymbol(reader, splineNumControlContext)
+		if err != nil {
+			return nil, err
+		}
+		totalControlPoints += int64(count)
+		if count < 0 || totalControlPoints > maxNumControlPoints {
+			return nil, errors.New("too many spline control points")
+		}
+		sb.ControlCounts[i] = count
+		sb.ControlPoints[i] = make([]util.Point, count)
+		for j := 0; j < int(count); j++ {
+			x, err := stream.ReadSymbol(reader, splineControlPointContext)
+			if err != nil {
+				return nil, err
+			}
+			y, err := stream.ReadSymbol(reader, splineControlPointContext)
+			if err != nil {
+				return nil, err
+			}
+			sb.ControlPoints[i][j] = util.Point{X: jxlio.UnpackSigned(uint32(x)), Y: jxlio.UnpackSigned(uint32(y))}
+		}
+
+		for _, coeffs := range []*[32]int32{&sb.CoeffX[i], &sb.CoeffY[i], &sb.CoeffB[i], &sb.CoeffSigma[i]} {
+			for j := 0; j < 32; j++ {
+				v, err := stream.ReadSymbol(reader, splineDCTContext)
+				if err != nil {
+					return nil, err
+				}
+				coeffs[j] = jxlio.UnpackSigned(uint32(v))
+			}
+		}
+	}
+
+	if !stream.ValidateFinalState() {
+		return nil, errors.New("invalid final ANS state decoding splines")
+	}
+	return sb, nil
+}
+
+// Dequantize converts the quantised splines into absolute control points and
+// floating point DCT32 coefficients.
+func (sb *SplinesBundle) Dequantize(baseCorrelationX float32, baseCorrelationB float32) []Spline {
+
+	var invQuant float32
+	if sb.QuantAdjust >= 0 {
+		invQuant = 1.0 / (1.0 + 0.125*float32(sb.QuantAdjust))
+	} else {
+		invQuant = 1.0 - 0.125*float32(sb.QuantAdjust)
+	}
+
+	splines := make([]Spline, sb.NumSplines)
+	for i := 0; i < int(sb.NumSplines); i++ {
+		s := &splines[i]
+		s.ControlPoints = make([]SplinePoint, 0, len(sb.ControlPoints[i])+1)
+		current := SplinePoint{X: float32(sb.StartPoints[i].X), Y: float32(sb.StartPoints[i].Y)}
+		s.ControlPoints = append(s.ControlPoints, current)
+
+		// control points are delta-of-delta encoded.
+		deltaX := int64(0)
+		deltaY := int64(0)
+		curX := int64(sb.StartPoints[i].X)
+		curY := int64(sb.StartPoints[i].Y)
+		for _, p := range sb.ControlPoints[i] {
+			deltaX += int64(p.X)
+			deltaY += int64(p.Y)
+			curX += deltaX
+			curY += deltaY
+			s.ControlPoints = append(s.ControlPoints, SplinePoint{X: float32(curX), Y: float32(curY)})
+		}
+
+		for j := 0; j < 32; j++ {
+			s.CoeffY[j] = float32(sb.CoeffY[i][j]) * splineChannelWeight[1] * invQuant
+		}
+		for j := 0; j < 32; j++ {
+			s.CoeffX[j] = float32(sb.CoeffX[i][j])*splineChannelWeight[0]*invQuant + baseCorrelationX*s.CoeffY[j]
+			s.CoeffB[j] = float32(sb.CoeffB[i][j])*splineChannelWeight[2]*invQuant + baseCorrelationB*s.CoeffY[j]
+			s.CoeffSigma[j] = float32(sb.CoeffSigma[i][j]) * splineChannelWeight[3] * invQuant
+		}
+	}
+	return splines
 }
@@ -0,0 +1,79 @@
+package frame
+
+import (
+	"testing"
+
+	"github.com/kpfaulkner/jxl-go/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplinesBundleDequantize(t *testing.T) {
+
+	for _, tc := range []struct {
+		name                  string
+		bundle                SplinesBundle
+		expectedControlPoints []SplinePoint
+		expectedY0            float32
+		expectedX0            float32
+	}{
+		{
+			name: "delta of delta control points",
+			bundle: SplinesBundle{
+				NumSplines:    1,
+				StartPoints:   []util.Point{{X: 1, Y: 2}},
+				ControlPoints: [][]util.Point{{{X: 1, Y: 0}, {X: 1, Y: 1}, {X: -2, Y: 0}}},
+				CoeffX:        [][32]int32{{10}},
+				CoeffY:        [][32]int32{{10}},
+				CoeffB:        [][32]int32{{}},
+				CoeffSigma:    [][32]int32{{}},
+			},
+			expectedControlPoints: []SplinePoint{{X: 1, Y: 2}, {X: 2, Y: 2}, {X: 4, Y: 3}, {X: 4, Y: 4}},
+			expectedY0:            10 * 0.075,
+			expectedX0:            10*0.0042 + 0.5*10*0.075,
+		},
+		{
+			name: "positive quant adjust",
+			bundle: SplinesBundle{
+				NumSplines:    1,
+				QuantAdjust:   8,
+				StartPoints:   []util.Point{{X: 0, Y: 0}},
+				ControlPoints: [][]util.Point{{}},
+				CoeffX:        [][32]int32{{}},
+				CoeffY:        [][32]int32{{20}},
+				CoeffB:        [][32]int32{{}},
+				CoeffSigma:    [][32]int32{{}},
+			},
+			expectedControlPoints: []SplinePoint{{X: 0, Y: 0}},
+			expectedY0:            20 * 0.075 / 2,
+			expectedX0:            0.5 * 20 * 0.075 / 2,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			splines := tc.bundle.Dequantize(0.5, 1.0)
+			assert.Len(t, splines, 1)
+			assert.Equal(t, tc.expectedControlPoints, splines[0].ControlPoints)
+			assert.InDelta(t, tc.expectedY0, splines[0].CoeffY[0], 1e-6)
+			assert.InDelta(t, tc.expectedX0, splines[0].CoeffX[0], 1e-6)
+			assert.InDelta(t, splines[0].CoeffY[0], splines[0].CoeffB[0], 1e-6)
+		})
+	}
+}
+
+func TestEquallySpacedPoints(t *testing.T) {
+	spline := Spline{ControlPoints: []SplinePoint{{X: 0, Y: 0}, {X: 3.5, Y: 0}}}
+	segments := equallySpacedPoints(spline.upsampleControlPoints())
+
+	assert.Len(t, segments, 5)
+	for i, s := range segments[:4] {
+		assert.InDelta(t, float32(i), s.point.X, 1e-4)
+		assert.InDelta(t, 0, s.point.Y, 1e-4)
+		assert.Equal(t, float32(1), s.multiplier)
+	}
+	assert.InDelta(t, 0.5, segments[4].multiplier, 1e-4)
+}
+
+func TestContinuousIDCT(t *testing.T) {
+	coeffs := [32]float32{2}
+	assert.InDelta(t, 2, continuousIDCT(&coeffs, 0), 1e-6)
+	assert.InDelta(t, 2, continuousIDCT(&coeffs, 31), 1e-6)
+}
@@ -28,3 +28,14 @@ func TestXorShiroFill(t *testing.T) {
 		assert.NotEqual(t, int64(0), b)
 	}
 }
+
+func TestSplitMix64(t *testing.T) {
+	// the first outputs of the reference SplitMix64 generator seeded with 0, which
+	// steps its state by the golden ratio before mixing.
+	expected := []uint64{0xe220a8397b1dcdaf, 0x6e789e6aa1b965f4, 0x06c45d188009454f}
+	state := uint64(0)
+	for _, e := range expected {
+		state += 0x9e3779b97f4a7c15
+		assert.Equal(t, e, splitMix64(state))
+	}
+}
@@ -93,9 +93,11 @@ func NewFakeFramerWithFlags(encoding uint32, flags uint64) Framer {
 				Origin: util.Point{},
 				Size:   util.Dimension{Width: 5, Height: 5},
 			},
-			passes:   NewPassesInfo(),
-			Encoding: encoding,
-			Flags:    flags,
+			passes:       NewPassesInfo(),
+			Encoding:     encoding,
+			Flags:        flags,
+			Upsampling:   1,
+			EcUpsampling: []uint32{1},
 		},
 		lfGlobal: NewLFGlobal(),
 		hfGlobal: &HFGlobal{
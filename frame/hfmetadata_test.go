@@ -463,6 +463,10 @@ func (f *fakeModularStreamHFMeta) applyTransforms() error {
 	return nil
 }
 
+func (f *fakeModularStreamHFMeta) hasNonLocalTransforms() bool {
+	return false
+}
+
 func (f *fakeModularStreamHFMeta) getChannels() []*ModularChannel {
 	return nil
 }
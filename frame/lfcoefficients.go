@@ -16,6 +16,9 @@ type LFCoefficients struct {
 	dequantLFCoeff [][][]float32
 	lfIndex        [][]int32
 	frame          Framer
+
+	// quantised LF coefficients in modular channel order, kept for JPEG reconstruction.
+	lfQuant [][][]int32
 }
 
 func NewLFCoefficientsWithReader(reader jxlio.BitReader, parent *LFGroup, frame Framer, lfBuffer []image.ImageBuffer, modularStreamFunc NewModularStreamFunc) (*LFCoefficients, error) {
@@ -73,6 +76,7 @@ func NewLFCoefficientsWithReader(reader jxlio.BitReader, parent *LFGroup, frame
 		return nil, err
 	}
 	lfQuant := lfQuantStream.getDecodedBuffer()
+	lf.lfQuant = lfQuant
 	scaledDequant := frame.getLFGlobal().scaledDequant
 	for i := 0; i < 3; i++ {
 		c := cMap[i]
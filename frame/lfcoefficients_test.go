@@ -32,6 +32,10 @@ func (f *FakeModularStreamer) applyTransforms() error {
 	return nil
 }
 
+func (f *FakeModularStreamer) hasNonLocalTransforms() bool {
+	return false
+}
+
 func (f *FakeModularStreamer) getChannels() []*ModularChannel {
 	return nil
 }
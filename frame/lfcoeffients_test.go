@@ -87,6 +87,10 @@ func (fms *fakeModularStream) applyTransforms() error {
 	return nil
 }
 
+func (fms *fakeModularStream) hasNonLocalTransforms() bool {
+	return false
+}
+
 func (fms *fakeModularStream) getChannels() []*ModularChannel {
 	//TODO implement me
 	panic("implement me")
@@ -2,6 +2,7 @@ package image
 
 import (
 	"errors"
+	"math"
 
 	"github.com/kpfaulkner/jxl-go/util"
 )
@@ -229,6 +230,105 @@ func (ib *ImageBuffer) castToIntBuffer(maxValue int32) error {
 	return nil
 }
 
+// Downsample returns the buffer scaled down by factor, with each sample being the average of a
+// factor x factor block (clipped at the right and bottom edges).
+func (ib *ImageBuffer) Downsample(factor int32) (*ImageBuffer, error) {
+
+	height := (ib.Height + factor - 1) / factor
+	width := (ib.Width + factor - 1) / factor
+	out, err := NewImageBuffer(ib.BufferType, height, width)
+	if err != nil {
+		return nil, err
+	}
+	for y := int32(0); y < height; y++ {
+		y1 := min((y+1)*factor, ib.Height)
+		for x := int32(0); x < width; x++ {
+			x1 := min((x+1)*factor, ib.Width)
+			count := (y1 - y*factor) * (x1 - x*factor)
+			if ib.IsInt() {
+				sum := int64(0)
+				for yy := y * factor; yy < y1; yy++ {
+					for xx := x * factor; xx < x1; xx++ {
+						sum += int64(ib.IntBuffer[yy][xx])
+					}
+				}
+				out.IntBuffer[y][x] = int32((sum + int64(count)/2) / int64(count))
+			} else {
+				sum := float32(0)
+				for yy := y * factor; yy < y1; yy++ {
+					for xx := x * factor; xx < x1; xx++ {
+						sum += ib.FloatBuffer[yy][xx]
+					}
+				}
+				out.FloatBuffer[y][x] = sum / float32(count)
+			}
+		}
+	}
+	return out, nil
+}
+
+// DownsampleTo returns the buffer scaled down by factor to height x width, with each sample being
+// the average of the factor x factor area that it covers (clipped at the right and bottom edges).
+// Samples of the buffer that are only partly covered are weighted by how much is covered, so
+// factor doesn't need to be a whole number. Each sample must cover some of the buffer.
+func (ib *ImageBuffer) DownsampleTo(height int32, width int32, factor float64) (*ImageBuffer, error) {
+
+	if height <= 0 || width <= 0 || factor < 1 ||
+		float64(height-1)*factor >= float64(ib.Height) || float64(width-1)*factor >= float64(ib.Width) {
+		return nil, errors.New("invalid downsampled size")
+	}
+	out, err := NewImageBuffer(ib.BufferType, height, width)
+	if err != nil {
+		return nil, err
+	}
+	rows := areaWeights(ib.Height, height, factor)
+	cols := areaWeights(ib.Width, width, factor)
+	for y := int32(0); y < height; y++ {
+		for x := int32(0); x < width; x++ {
+			sum := float64(0)
+			area := float64(0)
+			for _, wy := range rows[y] {
+				for _, wx := range cols[x] {
+					weight := wy.weight * wx.weight
+					area += weight
+					if ib.IsInt() {
+						sum += weight * float64(ib.IntBuffer[wy.index][wx.index])
+					} else {
+						sum += weight * float64(ib.FloatBuffer[wy.index][wx.index])
+					}
+				}
+			}
+			if ib.IsInt() {
+				out.IntBuffer[y][x] = int32(math.Round(sum / area))
+			} else {
+				out.FloatBuffer[y][x] = float32(sum / area)
+			}
+		}
+	}
+	return out, nil
+}
+
+type areaWeight struct {
+	index  int32
+	weight float64
+}
+
+// areaWeights returns, for each of the to samples that size samples are scaled down to by
+// factor, the samples that it covers and how much of each is covered.
+func areaWeights(size int32, to int32, factor float64) [][]areaWeight {
+
+	weights := make([][]areaWeight, to)
+	for i := int32(0); i < to; i++ {
+		start := float64(i) * factor
+		end := float64(i+1) * factor
+		for j := int32(start); j < size && float64(j) < end; j++ {
+			covered := min(end, float64(j+1)) - max(start, float64(j))
+			weights[i] = append(weights[i], areaWeight{index: j, weight: covered})
+		}
+	}
+	return weights
+}
+
 func (ib *ImageBuffer) Clamp(maxValue int32) error {
 	if ib.IsFloat() {
 		return errors.New("Clamp only supported for int buffers")
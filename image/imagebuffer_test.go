@@ -91,6 +91,50 @@ func TestCastToFloatIfInt(t *testing.T) {
 	assert.Nil(t, err)
 }
 
+func TestDownsample(t *testing.T) {
+	buf := NewImageBufferFromInts([][]int32{{1, 2, 3}, {4, 5, 6}, {7, 8, 9}})
+	down, err := buf.Downsample(2)
+	assert.Nil(t, err)
+	// the right and bottom blocks are clipped to the buffer.
+	assert.Equal(t, [][]int32{{3, 5}, {8, 9}}, down.IntBuffer)
+
+	buf = NewImageBufferFromFloats([][]float32{{1, 2, 3}, {4, 5, 6}})
+	down, err = buf.Downsample(2)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]float32{{3, 4.5}}, down.FloatBuffer)
+	assert.Equal(t, int32(2), down.Width)
+	assert.Equal(t, int32(1), down.Height)
+}
+
+func TestDownsampleTo(t *testing.T) {
+	// scaling by 1.5, the middle row and column are split between the output samples.
+	buf := NewImageBufferFromInts([][]int32{{1, 2, 3}, {4, 5, 6}, {7, 8, 9}})
+	down, err := buf.DownsampleTo(2, 2, 1.5)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]int32{{2, 4}, {6, 8}}, down.IntBuffer)
+
+	// whole factors are the same as Downsample.
+	down, err = buf.DownsampleTo(2, 2, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]int32{{3, 5}, {8, 9}}, down.IntBuffer)
+
+	// the right column is clipped to the buffer.
+	buf = NewImageBufferFromFloats([][]float32{{1, 2, 3}, {4, 5, 6}})
+	down, err = buf.DownsampleTo(1, 2, 2.5)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(2), down.Width)
+	assert.Equal(t, int32(1), down.Height)
+	assert.InDelta(t, 3.3, down.FloatBuffer[0][0], 0.0001)
+	assert.InDelta(t, 4.5, down.FloatBuffer[0][1], 0.0001)
+
+	_, err = buf.DownsampleTo(2, 2, 2)
+	assert.NotNil(t, err)
+	_, err = buf.DownsampleTo(0, 1, 2)
+	assert.NotNil(t, err)
+	_, err = buf.DownsampleTo(1, 1, 0.5)
+	assert.NotNil(t, err)
+}
+
 func TestClamp(t *testing.T) {
 	origBuf := [][]int32{{1, 2, 3}, {4, 5, 6}, {7, 8, 9}}
 	buf := NewImageBufferFromInts(origBuf)
@@ -0,0 +1,641 @@
+// Package jpeg parses the JPEG bitstream reconstruction data (jbrd box) of a JPEG XL file and
+// uses it, along with the DCT coefficients of the VarDCT frame, to rebuild the original JPEG.
+package jpeg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/kpfaulkner/jxl-go/brotli"
+	"github.com/kpfaulkner/jxl-go/jxlio"
+)
+
+const (
+	APP_MARKER_UNKNOWN = 0
+	APP_MARKER_ICC     = 1
+	APP_MARKER_EXIF    = 2
+	APP_MARKER_XMP     = 3
+
+	COMPONENT_GRAY   = 0
+	COMPONENT_YCBCR  = 1
+	COMPONENT_RGB    = 2
+	COMPONENT_CUSTOM = 3
+
+	maxMarkers          = 16384
+	huffmanAlphabetSize = 256
+)
+
+var (
+	iccProfileTag = []byte("ICC_PROFILE\x00")
+	exifTag       = []byte("Exif\x00\x00")
+	xmpTag        = []byte("http://ns.adobe.com/xap/1.0/\x00")
+)
+
+// QuantTable is a JPEG quantisation table. Values are in row major (not zigzag) order.
+type QuantTable struct {
+	Values    [64]int32
+	Precision uint32
+	Index     uint32
+	IsLast    bool
+}
+
+// Component is a JPEG colour component. The sampling factors, size and coefficients aren't
+// stored in the jbrd box, they come from the JXL frame.
+type Component struct {
+	ID             uint32
+	QuantIndex     uint32
+	HSampFactor    int32
+	VSampFactor    int32
+	WidthInBlocks  int32
+	HeightInBlocks int32
+
+	// Coeffs holds 64 coefficients per block, blocks in raster order and each block row major.
+	Coeffs []int16
+}
+
+// HuffmanCode is a DC or AC Huffman table as stored in a DHT marker, with an extra sentinel
+// symbol (256) as the last value.
+type HuffmanCode struct {
+	SlotID uint32
+	IsLast bool
+	Counts [17]uint32
+	Values []uint32
+}
+
+type ScanComponent struct {
+	ComponentIndex uint32
+	ACTableIndex   uint32
+	DCTableIndex   uint32
+}
+
+// ExtraZeroRun records blocks that were encoded with redundant ZRL (16 zero) symbols.
+type ExtraZeroRun struct {
+	BlockIndex uint32
+	NumRuns    uint32
+}
+
+type ScanInfo struct {
+	Components []ScanComponent
+	Ss, Se     uint32
+	Al, Ah     uint32
+
+	// ResetPoints are block indices where the encoder flushed an end of band run early.
+	ResetPoints   []uint32
+	ExtraZeroRuns []ExtraZeroRun
+}
+
+// JPEGData is everything needed, other than the DCT coefficients, to rebuild a JPEG byte for
+// byte. The marker payloads that are stored elsewhere in the JXL file (ICC profile, Exif and
+// XMP) need to be filled in with SetICC, SetExif and SetXMP.
+type JPEGData struct {
+	Width  uint32
+	Height uint32
+
+	MarkerOrder     []byte
+	AppData         [][]byte
+	AppMarkerTypes  []uint32
+	ComData         [][]byte
+	Quant           []QuantTable
+	ComponentType   uint32
+	Components      []Component
+	HuffmanCodes    []HuffmanCode
+	Scans           []ScanInfo
+	RestartInterval uint32
+	InterMarkerData [][]byte
+	TailData        []byte
+
+	HasZeroPaddingBit bool
+	PaddingBits       []byte
+}
+
+// ReadJPEGData parses the contents of a jbrd box.
+func ReadJPEGData(data []byte) (*JPEGData, error) {
+
+	reader := jxlio.NewBitStreamReader(bytes.NewReader(data))
+	jd := &JPEGData{}
+	interMarkerSizes, err := jd.readHeader(reader)
+	if err != nil {
+		return nil, err
+	}
+	if err := reader.ZeroPadToByte(); err != nil {
+		return nil, err
+	}
+
+	// all the marker payloads that aren't reconstructed from elsewhere are in a single
+	// brotli stream at the end of the box.
+	consumed := reader.BitsRead() >> 3
+	if consumed > uint64(len(data)) {
+		return nil, errors.New("truncated JPEG reconstruction data")
+	}
+	payload, err := brotli.Decompress(data[consumed:], jd.payloadSize(interMarkerSizes))
+	if err != nil {
+		return nil, err
+	}
+	if err := jd.readPayload(payload, interMarkerSizes); err != nil {
+		return nil, err
+	}
+	return jd, nil
+}
+
+// readHeader reads the bit packed part of the jbrd box and returns the sizes of the
+// inter marker data.
+func (jd *JPEGData) readHeader(reader jxlio.BitReader) ([]uint32, error) {
+
+	// is_gray, which is redundant as the component type is stored later on.
+	if _, err := reader.ReadBool(); err != nil {
+		return nil, err
+	}
+
+	var err error
+	numApp, numCom, numScans, numInterMarker := 0, 0, 0, 0
+	hasDRI := false
+	for {
+		m, err := reader.ReadBits(6)
+		if err != nil {
+			return nil, err
+		}
+		marker := byte(m + 0xC0)
+		jd.MarkerOrder = append(jd.MarkerOrder, marker)
+		if marker == 0xD9 {
+			break
+		}
+		switch {
+		case marker >= 0xE0 && marker <= 0xEF:
+			numApp++
+		case marker == 0xFE:
+			numCom++
+		case marker == 0xDA:
+			numScans++
+		case marker == 0xFF:
+			numInterMarker++
+		case marker == 0xDD:
+			hasDRI = true
+		}
+		if len(jd.MarkerOrder) > maxMarkers {
+			return nil, errors.New("too many JPEG markers")
+		}
+	}
+
+	jd.AppData = make([][]byte, numApp)
+	jd.AppMarkerTypes = make([]uint32, numApp)
+	for i := range jd.AppData {
+		if jd.AppMarkerTypes[i], err = reader.ReadU32(0, 0, 1, 0, 2, 1, 4, 2); err != nil {
+			return nil, err
+		}
+		if jd.AppMarkerTypes[i] > APP_MARKER_XMP {
+			return nil, fmt.Errorf("unknown APP marker type %d", jd.AppMarkerTypes[i])
+		}
+		l, err := reader.ReadBits(16)
+		if err != nil {
+			return nil, err
+		}
+		if l < 2 {
+			return nil, errors.New("invalid APP marker size")
+		}
+		jd.AppData[i] = make([]byte, l+1)
+	}
+
+	jd.ComData = make([][]byte, numCom)
+	for i := range jd.ComData {
+		l, err := reader.ReadBits(16)
+		if err != nil {
+			return nil, err
+		}
+		if l < 2 {
+			return nil, errors.New("invalid COM marker size")
+		}
+		jd.ComData[i] = make([]byte, l+1)
+	}
+
+	numQuant, err := reader.ReadU32(1, 0, 2, 0, 3, 0, 4, 0)
+	if err != nil {
+		return nil, err
+	}
+	if numQuant == 4 {
+		return nil, errors.New("invalid number of quantisation tables")
+	}
+	jd.Quant = make([]QuantTable, numQuant)
+	for i := range jd.Quant {
+		q := &jd.Quant[i]
+		if q.Precision, err = readBits(reader, 1); err != nil {
+			return nil, err
+		}
+		if q.Index, err = readBits(reader, 2); err != nil {
+			return nil, err
+		}
+		if q.IsLast, err = reader.ReadBool(); err != nil {
+			return nil, err
+		}
+	}
+
+	if jd.ComponentType, err = readBits(reader, 2); err != nil {
+		return nil, err
+	}
+	switch jd.ComponentType {
+	case COMPONENT_GRAY:
+		jd.Components = make([]Component, 1)
+		jd.Components[0].ID = 1
+	case COMPONENT_YCBCR:
+		jd.Components = make([]Component, 3)
+		for i := range jd.Components {
+			jd.Components[i].ID = uint32(i + 1)
+		}
+	case COMPONENT_RGB:
+		jd.Components = make([]Component, 3)
+		for i, id := range []byte{'R', 'G', 'B'} {
+			jd.Components[i].ID = uint32(id)
+		}
+	default:
+		numComponents, err := reader.ReadU32(1, 0, 2, 0, 3, 0, 4, 0)
+		if err != nil {
+			return nil, err
+		}
+		jd.Components = make([]Component, numComponents)
+		for i := range jd.Components {
+			if jd.Components[i].ID, err = readBits(reader, 8); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	usedTables := make([]bool, len(jd.Quant))
+	for i := range jd.Components {
+		if jd.Components[i].QuantIndex, err = readBits(reader, 2); err != nil {
+			return nil, err
+		}
+		if jd.Components[i].QuantIndex >= uint32(len(jd.Quant)) {
+			return nil, errors.New("invalid quantisation table index")
+		}
+		usedTables[jd.Components[i].QuantIndex] = true
+	}
+	for _, used := range usedTables {
+		if !used {
+			return nil, errors.New("unused quantisation table")
+		}
+	}
+
+	numHuffman, err := reader.ReadU32(4, 0, 2, 3, 10, 4, 26, 6)
+	if err != nil {
+		return nil, err
+	}
+	jd.HuffmanCodes = make([]HuffmanCode, numHuffman)
+	for i := range jd.HuffmanCodes {
+		if err := jd.HuffmanCodes[i].read(reader); err != nil {
+			return nil, err
+		}
+	}
+
+	jd.Scans = make([]ScanInfo, numScans)
+	for i := range jd.Scans {
+		scan := &jd.Scans[i]
+		numComponents, err := reader.ReadU32(1, 0, 2, 0, 3, 0, 4, 0)
+		if err != nil {
+			return nil, err
+		}
+		if numComponents >= 4 {
+			return nil, errors.New("invalid number of components in scan")
+		}
+		for _, v := range []*uint32{&scan.Ss, &scan.Se} {
+			if *v, err = readBits(reader, 6); err != nil {
+				return nil, err
+			}
+		}
+		for _, v := range []*uint32{&scan.Al, &scan.Ah} {
+			if *v, err = readBits(reader, 4); err != nil {
+				return nil, err
+			}
+		}
+		scan.Components = make([]ScanComponent, numComponents)
+		for j := range scan.Components {
+			sc := &scan.Components[j]
+			if sc.ComponentIndex, err = readBits(reader, 2); err != nil {
+				return nil, err
+			}
+			if sc.ComponentIndex >= uint32(len(jd.Components)) {
+				return nil, errors.New("invalid scan component index")
+			}
+			if sc.ACTableIndex, err = readBits(reader, 2); err != nil {
+				return nil, err
+			}
+			if sc.DCTableIndex, err = readBits(reader, 2); err != nil {
+				return nil, err
+			}
+		}
+
+		// last needed pass is only used for progressive decoding, so can be ignored.
+		if _, err := reader.ReadU32(0, 0, 1, 0, 2, 0, 3, 3); err != nil {
+			return nil, err
+		}
+	}
+
+	if hasDRI {
+		if jd.RestartInterval, err = readBits(reader, 16); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range jd.Scans {
+		scan := &jd.Scans[i]
+		numResetPoints, err := reader.ReadU32(0, 0, 1, 2, 4, 4, 20, 16)
+		if err != nil {
+			return nil, err
+		}
+		scan.ResetPoints = make([]uint32, numResetPoints)
+		last := int64(-1)
+		for j := range scan.ResetPoints {
+			delta, err := reader.ReadU32(0, 0, 1, 3, 9, 5, 41, 28)
+			if err != nil {
+				return nil, err
+			}
+			blockIndex := int64(delta) + last + 1
+			if blockIndex >= 3<<26 {
+				return nil, errors.New("invalid reset point")
+			}
+			scan.ResetPoints[j] = uint32(blockIndex)
+			last = blockIndex
+		}
+
+		numExtraZeroRuns, err := reader.ReadU32(0, 0, 1, 2, 4, 4, 20, 16)
+		if err != nil {
+			return nil, err
+		}
+		scan.ExtraZeroRuns = make([]ExtraZeroRun, numExtraZeroRuns)
+		last = -1
+		for j := range scan.ExtraZeroRuns {
+			run := &scan.ExtraZeroRuns[j]
+			if run.NumRuns, err = reader.ReadU32(1, 0, 2, 2, 5, 4, 20, 8); err != nil {
+				return nil, err
+			}
+			delta, err := reader.ReadU32(0, 0, 1, 3, 9, 5, 41, 28)
+			if err != nil {
+				return nil, err
+			}
+			blockIndex := int64(delta) + last + 1
+			if blockIndex > 3<<26 {
+				return nil, errors.New("invalid extra zero run")
+			}
+			run.BlockIndex = uint32(blockIndex)
+			last = blockIndex
+		}
+	}
+
+	interMarkerSizes := make([]uint32, numInterMarker)
+	for i := range interMarkerSizes {
+		if interMarkerSizes[i], err = readBits(reader, 16); err != nil {
+			return nil, err
+		}
+	}
+
+	tailLength, err := reader.ReadU32(0, 0, 1, 8, 257, 16, 65793, 22)
+	if err != nil {
+		return nil, err
+	}
+	jd.TailData = make([]byte, tailLength)
+
+	if jd.HasZeroPaddingBit, err = reader.ReadBool(); err != nil {
+		return nil, err
+	}
+	if jd.HasZeroPaddingBit {
+		numBits, err := readBits(reader, 24)
+		if err != nil {
+			return nil, err
+		}
+		jd.PaddingBits = make([]byte, 0, min(numBits, 1<<16))
+		for i := uint32(0); i < numBits; i++ {
+			bit, err := reader.ReadBits(1)
+			if err != nil {
+				return nil, err
+			}
+			jd.PaddingBits = append(jd.PaddingBits, byte(bit))
+		}
+	}
+
+	return interMarkerSizes, nil
+}
+
+func (hc *HuffmanCode) read(reader jxlio.BitReader) error {
+
+	isAC, err := reader.ReadBool()
+	if err != nil {
+		return err
+	}
+	id, err := readBits(reader, 2)
+	if err != nil {
+		return err
+	}
+	hc.SlotID = id
+	if isAC {
+		hc.SlotID |= 0x10
+	}
+	if hc.IsLast, err = reader.ReadBool(); err != nil {
+		return err
+	}
+
+	numSymbols := uint32(0)
+	for i := range hc.Counts {
+		if hc.Counts[i], err = reader.ReadU32(0, 0, 1, 0, 2, 3, 0, 8); err != nil {
+			return err
+		}
+		numSymbols += hc.Counts[i]
+	}
+	if numSymbols == 0 {
+		return errors.New("empty Huffman table")
+	}
+	if numSymbols > huffmanAlphabetSize+1 {
+		return errors.New("Huffman table too large")
+	}
+
+	// the last symbol must be the sentinel and no symbol can appear twice.
+	var seen [huffmanAlphabetSize + 1]bool
+	hc.Values = make([]uint32, numSymbols)
+	for i := range hc.Values {
+		if hc.Values[i], err = reader.ReadU32(0, 2, 4, 2, 8, 4, 1, 8); err != nil {
+			return err
+		}
+		if seen[hc.Values[i]] {
+			return errors.New("duplicate Huffman symbol")
+		}
+		seen[hc.Values[i]] = true
+	}
+	if hc.Values[numSymbols-1] != huffmanAlphabetSize {
+		return errors.New("missing Huffman sentinel symbol")
+	}
+	// matches libjxl, which only checks the first 64 symbols.
+	if !isAC {
+		for _, v := range hc.Values[:numSymbols-1] {
+			if v >= 12 && v < 64 {
+				return errors.New("invalid DC Huffman symbol")
+			}
+		}
+	}
+	return nil
+}
+
+// readPayload fills in the marker data, inter marker data and tail data from the decompressed
+// brotli stream.
+// payloadSize returns the size of the brotli compressed data, which is known from the header.
+func (jd *JPEGData) payloadSize(interMarkerSizes []uint32) int {
+	size := len(jd.TailData)
+	for i, m := range jd.AppData {
+		if jd.AppMarkerTypes[i] == APP_MARKER_UNKNOWN {
+			size += len(m)
+		}
+	}
+	for _, m := range jd.ComData {
+		size += len(m)
+	}
+	for _, s := range interMarkerSizes {
+		size += int(s)
+	}
+	return size
+}
+
+func (jd *JPEGData) readPayload(payload []byte, interMarkerSizes []uint32) error {
+
+	pos := 0
+	next := func(dst []byte) error {
+		if pos+len(dst) > len(payload) {
+			return errors.New("not enough JPEG reconstruction data")
+		}
+		copy(dst, payload[pos:])
+		pos += len(dst)
+		return nil
+	}
+	checkMarkerSize := func(m []byte) error {
+		if int(m[1])<<8+int(m[2])+1 != len(m) {
+			return errors.New("incorrect JPEG marker size")
+		}
+		return nil
+	}
+
+	numICC := byte(0)
+	for i, m := range jd.AppData {
+		if jd.AppMarkerTypes[i] == APP_MARKER_UNKNOWN {
+			if err := next(m); err != nil {
+				return err
+			}
+			if err := checkMarkerSize(m); err != nil {
+				return err
+			}
+			continue
+		}
+
+		m[1] = byte((len(m) - 1) >> 8)
+		m[2] = byte(len(m) - 1)
+		var tag []byte
+		switch jd.AppMarkerTypes[i] {
+		case APP_MARKER_ICC:
+			m[0] = 0xE2
+			tag = iccProfileTag
+		case APP_MARKER_EXIF:
+			m[0] = 0xE1
+			tag = exifTag
+		case APP_MARKER_XMP:
+			m[0] = 0xE1
+			tag = xmpTag
+		}
+		headerSize := 3 + len(tag)
+		if jd.AppMarkerTypes[i] == APP_MARKER_ICC {
+			headerSize += 2
+		}
+		if len(m) < headerSize {
+			return errors.New("APP marker too small")
+		}
+		copy(m[3:], tag)
+		if jd.AppMarkerTypes[i] == APP_MARKER_ICC {
+			numICC++
+			m[15] = numICC
+		}
+	}
+	for i, m := range jd.AppData {
+		if jd.AppMarkerTypes[i] == APP_MARKER_ICC {
+			m[16] = numICC
+		}
+	}
+
+	for _, m := range jd.ComData {
+		if err := next(m); err != nil {
+			return err
+		}
+		if err := checkMarkerSize(m); err != nil {
+			return err
+		}
+	}
+
+	jd.InterMarkerData = make([][]byte, len(interMarkerSizes))
+	for i, size := range interMarkerSizes {
+		jd.InterMarkerData[i] = make([]byte, size)
+		if err := next(jd.InterMarkerData[i]); err != nil {
+			return err
+		}
+	}
+
+	if err := next(jd.TailData); err != nil {
+		return err
+	}
+	if pos != len(payload) {
+		return errors.New("unexpected extra JPEG reconstruction data")
+	}
+	return nil
+}
+
+// SetICC copies the ICC profile into the ICC APP2 markers.
+func (jd *JPEGData) SetICC(icc []byte) error {
+	pos := 0
+	for i, m := range jd.AppData {
+		if jd.AppMarkerTypes[i] != APP_MARKER_ICC {
+			continue
+		}
+		l := len(m) - 17
+		if pos+l > len(icc) {
+			return errors.New("ICC profile too small for JPEG markers")
+		}
+		copy(m[17:], icc[pos:pos+l])
+		pos += l
+	}
+	if pos != 0 && pos != len(icc) {
+		return errors.New("ICC profile size does not match JPEG markers")
+	}
+	return nil
+}
+
+// SetExif copies the Exif data (without the 4 byte TIFF header offset that starts an Exif box)
+// into the Exif APP1 marker.
+func (jd *JPEGData) SetExif(exif []byte) error {
+	return jd.setAppData(APP_MARKER_EXIF, exifTag, exif)
+}
+
+// SetXMP copies the XMP data into the XMP APP1 marker.
+func (jd *JPEGData) SetXMP(xmp []byte) error {
+	return jd.setAppData(APP_MARKER_XMP, xmpTag, xmp)
+}
+
+func (jd *JPEGData) setAppData(markerType uint32, tag []byte, data []byte) error {
+	for i, m := range jd.AppData {
+		if jd.AppMarkerTypes[i] != markerType {
+			continue
+		}
+		if len(m)-3-len(tag) != len(data) {
+			return errors.New("metadata size does not match JPEG marker")
+		}
+		copy(m[3+len(tag):], data)
+	}
+	return nil
+}
+
+// HasAppMarker returns true if the JPEG has an APP marker of the given type, whose data has
+// to be set before the JPEG can be written.
+func (jd *JPEGData) HasAppMarker(markerType uint32) bool {
+	for _, t := range jd.AppMarkerTypes {
+		if t == markerType {
+			return true
+		}
+	}
+	return false
+}
+
+func readBits(reader jxlio.BitReader, bits uint32) (uint32, error) {
+	v, err := reader.ReadBits(bits)
+	return uint32(v), err
+}
@@ -0,0 +1,101 @@
+package jpeg
+
+import (
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readBox returns the payload of the first box of the given type in a JXL container file.
+func readBox(t *testing.T, filename string, boxType string) []byte {
+	data, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	for pos := 0; pos+8 <= len(data); {
+		size := int(binary.BigEndian.Uint32(data[pos:]))
+		require.GreaterOrEqual(t, size, 8)
+		if string(data[pos+4:pos+8]) == boxType {
+			return data[pos+8 : pos+size]
+		}
+		pos += size
+	}
+	t.Fatalf("no %s box in %s", boxType, filename)
+	return nil
+}
+
+func TestReadJPEGData(t *testing.T) {
+
+	jd, err := ReadJPEGData(readBox(t, "../testdata/cafe.jxl", "jbrd"))
+	require.NoError(t, err)
+
+	assert.Equal(t, []byte{0xE0, 0xE2, 0xDB, 0xDB, 0xC0, 0xC4, 0xC4, 0xC4, 0xC4, 0xDA, 0xD9}, jd.MarkerOrder)
+	assert.Equal(t, []uint32{APP_MARKER_UNKNOWN, APP_MARKER_ICC}, jd.AppMarkerTypes)
+	assert.True(t, jd.HasAppMarker(APP_MARKER_ICC))
+	assert.False(t, jd.HasAppMarker(APP_MARKER_EXIF))
+	require.Len(t, jd.AppData, 2)
+	assert.Equal(t, byte(0xE0), jd.AppData[0][0])
+	assert.Equal(t, "JFIF\x00", string(jd.AppData[0][3:8]))
+	assert.Equal(t, iccProfileTag, jd.AppData[1][3:15])
+
+	assert.Equal(t, uint32(COMPONENT_YCBCR), jd.ComponentType)
+	require.Len(t, jd.Components, 3)
+	for i, c := range jd.Components {
+		assert.Equal(t, uint32(i+1), c.ID)
+	}
+	assert.Equal(t, uint32(0), jd.Components[0].QuantIndex)
+	assert.Equal(t, uint32(1), jd.Components[1].QuantIndex)
+	assert.Len(t, jd.Quant, 2)
+	assert.Len(t, jd.HuffmanCodes, 4)
+	for _, hc := range jd.HuffmanCodes {
+		assert.Equal(t, uint32(huffmanAlphabetSize), hc.Values[len(hc.Values)-1])
+	}
+	require.Len(t, jd.Scans, 1)
+	assert.Len(t, jd.Scans[0].Components, 3)
+	assert.Equal(t, uint32(63), jd.Scans[0].Se)
+	assert.Empty(t, jd.TailData)
+}
+
+func TestReadJPEGDataErrors(t *testing.T) {
+
+	valid := readBox(t, "../testdata/cafe.jxl", "jbrd")
+	for _, tc := range []struct {
+		name string
+		data []byte
+	}{
+		{
+			name: "no data",
+			data: []byte{},
+		},
+		{
+			name: "truncated",
+			data: valid[:len(valid)/2],
+		},
+		{
+			name: "trailing data",
+			data: append(append([]byte{}, valid...), 0),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ReadJPEGData(tc.data)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestSetAppData(t *testing.T) {
+
+	jd := &JPEGData{
+		AppData: [][]byte{
+			append([]byte{0xE1, 0x00, 0x0C}, append(append([]byte{}, exifTag...), 0, 0, 0, 0)...),
+		},
+		AppMarkerTypes: []uint32{APP_MARKER_EXIF},
+	}
+	require.NoError(t, jd.SetExif([]byte{1, 2, 3, 4}))
+	assert.Equal(t, []byte{1, 2, 3, 4}, jd.AppData[0][9:])
+	assert.Error(t, jd.SetExif([]byte{1, 2, 3}))
+
+	// no XMP marker, so there is nothing to set.
+	assert.NoError(t, jd.SetXMP([]byte{1}))
+}
@@ -0,0 +1,655 @@
+package jpeg
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+const maxRefinementBits = 1<<16 - 64 + 1
+
+var (
+	// zigzag position to row major position
+	naturalOrder = [64]int{
+		0, 1, 8, 16, 9, 2, 3, 10,
+		17, 24, 32, 25, 18, 11, 4, 5,
+		12, 19, 26, 33, 40, 48, 41, 34,
+		27, 20, 13, 6, 7, 14, 21, 28,
+		35, 42, 49, 56, 57, 50, 43, 36,
+		29, 22, 15, 23, 30, 37, 44, 51,
+		58, 59, 52, 45, 38, 31, 39, 46,
+		53, 60, 61, 54, 47, 55, 62, 63,
+	}
+
+	errHuffmanSymbol = errors.New("symbol missing from JPEG Huffman table")
+)
+
+// huffmanTable maps symbols to their code and code length, built from a HuffmanCode.
+type huffmanTable struct {
+	depth [huffmanAlphabetSize]uint8
+	code  [huffmanAlphabetSize]uint16
+}
+
+func newHuffmanTable(hc *HuffmanCode) (*huffmanTable, error) {
+	table := &huffmanTable{}
+	code := uint32(0)
+	p := 0
+	for length := 1; length <= 16; length++ {
+		for i := uint32(0); i < hc.Counts[length]; i++ {
+			if p >= len(hc.Values) {
+				return nil, errors.New("invalid JPEG Huffman table")
+			}
+			// the last value is the sentinel, which doesn't get a code.
+			if p < len(hc.Values)-1 {
+				table.depth[hc.Values[p]] = uint8(length)
+				table.code[hc.Values[p]] = uint16(code)
+			}
+			code++
+			p++
+		}
+		code <<= 1
+	}
+	return table, nil
+}
+
+// bitWriter writes the entropy coded segment of a scan, stuffing a 0 after every 0xFF byte.
+type bitWriter struct {
+	out   []byte
+	acc   uint64
+	nbits uint32
+}
+
+func (bw *bitWriter) writeBits(n uint32, v uint32) {
+	if n == 0 {
+		return
+	}
+	bw.acc = bw.acc<<n | uint64(v&(1<<n-1))
+	bw.nbits += n
+	for bw.nbits >= 8 {
+		bw.nbits -= 8
+		b := byte(bw.acc >> bw.nbits)
+		bw.out = append(bw.out, b)
+		if b == 0xFF {
+			bw.out = append(bw.out, 0)
+		}
+	}
+}
+
+func (bw *bitWriter) writeSymbol(symbol int, table *huffmanTable) error {
+	if symbol < 0 || symbol >= huffmanAlphabetSize || table.depth[symbol] == 0 {
+		return errHuffmanSymbol
+	}
+	bw.writeBits(uint32(table.depth[symbol]), uint32(table.code[symbol]))
+	return nil
+}
+
+// dctCodingState buffers the end of band run and refinement bits of progressive scans.
+type dctCodingState struct {
+	eobRun         int
+	acTable        *huffmanTable
+	refinementBits []int
+}
+
+func (s *dctCodingState) flush(bw *bitWriter) error {
+	if s.eobRun > 0 {
+		nbits := uint32(bits.Len(uint(s.eobRun)) - 1)
+		if err := bw.writeSymbol(int(nbits<<4), s.acTable); err != nil {
+			return err
+		}
+		bw.writeBits(nbits, uint32(s.eobRun))
+		s.eobRun = 0
+	}
+	for _, bit := range s.refinementBits {
+		bw.writeBits(1, uint32(bit))
+	}
+	s.refinementBits = s.refinementBits[:0]
+	return nil
+}
+
+func (s *dctCodingState) bufferEndOfBand(table *huffmanTable, newBits []int, bw *bitWriter) error {
+	if s.eobRun == 0 {
+		s.acTable = table
+	}
+	s.eobRun++
+	s.refinementBits = append(s.refinementBits, newBits...)
+	if s.eobRun == 0x7FFF || len(s.refinementBits) > maxRefinementBits {
+		return s.flush(bw)
+	}
+	return nil
+}
+
+// writer holds the state needed while serialising the markers in order.
+type writer struct {
+	jd            *JPEGData
+	out           []byte
+	dcTables      [4]*huffmanTable
+	acTables      [4]*huffmanTable
+	isProgressive bool
+	seenDRI       bool
+	paddingBits   []byte
+
+	appIndex, comIndex, quantIndex, huffmanIndex, scanIndex, interMarkerIndex int
+}
+
+// Write serialises the JPEG. All the component coefficients, quantisation table values and
+// metadata markers must have been filled in first.
+func (jd *JPEGData) Write(w io.Writer) error {
+
+	wr := &writer{jd: jd}
+	if jd.HasZeroPaddingBit {
+		wr.paddingBits = jd.PaddingBits
+	}
+
+	wr.out = append(wr.out, 0xFF, 0xD8)
+	for _, marker := range jd.MarkerOrder {
+		var err error
+		switch {
+		case marker >= 0xC0 && marker <= 0xC2:
+			err = wr.writeSOF(marker)
+		case marker == 0xC4:
+			err = wr.writeDHT()
+		case marker == 0xD9:
+			wr.out = append(wr.out, 0xFF, 0xD9)
+			wr.out = append(wr.out, jd.TailData...)
+		case marker == 0xDA:
+			err = wr.writeScan()
+		case marker == 0xDB:
+			err = wr.writeDQT()
+		case marker == 0xDD:
+			wr.out = append(wr.out, 0xFF, 0xDD, 0, 4, byte(jd.RestartInterval>>8), byte(jd.RestartInterval))
+			wr.seenDRI = true
+		case marker >= 0xE0 && marker <= 0xEF:
+			if wr.appIndex >= len(jd.AppData) {
+				return errors.New("missing APP marker data")
+			}
+			wr.out = append(wr.out, 0xFF)
+			wr.out = append(wr.out, jd.AppData[wr.appIndex]...)
+			wr.appIndex++
+		case marker == 0xFE:
+			if wr.comIndex >= len(jd.ComData) {
+				return errors.New("missing COM marker data")
+			}
+			wr.out = append(wr.out, 0xFF)
+			wr.out = append(wr.out, jd.ComData[wr.comIndex]...)
+			wr.comIndex++
+		case marker == 0xFF:
+			if wr.interMarkerIndex >= len(jd.InterMarkerData) {
+				return errors.New("missing inter marker data")
+			}
+			wr.out = append(wr.out, jd.InterMarkerData[wr.interMarkerIndex]...)
+			wr.interMarkerIndex++
+		default:
+			err = fmt.Errorf("unsupported JPEG marker 0x%02X", marker)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(wr.paddingBits) != 0 {
+		return errors.New("unused JPEG padding bits")
+	}
+	_, err := w.Write(wr.out)
+	return err
+}
+
+func (wr *writer) writeSOF(marker byte) error {
+	jd := wr.jd
+	if jd.Width == 0 || jd.Height == 0 || jd.Width > 0xFFFF || jd.Height > 0xFFFF {
+		return errors.New("invalid JPEG dimensions")
+	}
+	wr.isProgressive = marker == 0xC2
+	length := 8 + 3*len(jd.Components)
+	wr.out = append(wr.out, 0xFF, marker, byte(length>>8), byte(length), 8,
+		byte(jd.Height>>8), byte(jd.Height), byte(jd.Width>>8), byte(jd.Width), byte(len(jd.Components)))
+	for _, c := range jd.Components {
+		wr.out = append(wr.out, byte(c.ID), byte(c.HSampFactor<<4|c.VSampFactor), byte(jd.Quant[c.QuantIndex].Index))
+	}
+	return nil
+}
+
+func (wr *writer) writeDQT() error {
+	jd := wr.jd
+	length := 2
+	for i := wr.quantIndex; i < len(jd.Quant); i++ {
+		length += 1 + 64*int(jd.Quant[i].Precision+1)
+		if jd.Quant[i].IsLast {
+			break
+		}
+	}
+	wr.out = append(wr.out, 0xFF, 0xDB, byte(length>>8), byte(length))
+	for {
+		if wr.quantIndex >= len(jd.Quant) {
+			return errors.New("missing JPEG quantisation table")
+		}
+		q := &jd.Quant[wr.quantIndex]
+		wr.quantIndex++
+		wr.out = append(wr.out, byte(q.Precision<<4|q.Index))
+		for _, pos := range naturalOrder {
+			v := q.Values[pos]
+			if q.Precision != 0 {
+				wr.out = append(wr.out, byte(v>>8))
+			}
+			wr.out = append(wr.out, byte(v))
+		}
+		if q.IsLast {
+			return nil
+		}
+	}
+}
+
+func (wr *writer) writeDHT() error {
+	jd := wr.jd
+	length := 2
+	for i := wr.huffmanIndex; i < len(jd.HuffmanCodes); i++ {
+		length += 16 + len(jd.HuffmanCodes[i].Values)
+		if jd.HuffmanCodes[i].IsLast {
+			break
+		}
+	}
+	wr.out = append(wr.out, 0xFF, 0xC4, byte(length>>8), byte(length))
+	for {
+		if wr.huffmanIndex >= len(jd.HuffmanCodes) {
+			return errors.New("missing JPEG Huffman table")
+		}
+		hc := &jd.HuffmanCodes[wr.huffmanIndex]
+		wr.huffmanIndex++
+		table, err := newHuffmanTable(hc)
+		if err != nil {
+			return err
+		}
+		if hc.SlotID&0x10 != 0 {
+			wr.acTables[hc.SlotID&3] = table
+		} else {
+			wr.dcTables[hc.SlotID&3] = table
+		}
+
+		// the sentinel symbol is counted in the longest code length, so is removed there.
+		maxLength := 0
+		for i, count := range hc.Counts {
+			if count != 0 {
+				maxLength = i
+			}
+		}
+		wr.out = append(wr.out, byte(hc.SlotID))
+		for i := 1; i <= 16; i++ {
+			count := hc.Counts[i]
+			if i == maxLength {
+				count--
+			}
+			wr.out = append(wr.out, byte(count))
+		}
+		for _, v := range hc.Values[:len(hc.Values)-1] {
+			wr.out = append(wr.out, byte(v))
+		}
+		if hc.IsLast {
+			return nil
+		}
+	}
+}
+
+// jumpToByteBoundary pads the entropy coded data to a whole byte, either with 1 bits or with the
+// padding bits the original encoder used.
+func (wr *writer) jumpToByteBoundary(bw *bitWriter) error {
+	n := (8 - bw.nbits) & 7
+	if n == 0 {
+		return nil
+	}
+	pattern := uint32(1)<<n - 1
+	if wr.jd.HasZeroPaddingBit {
+		if len(wr.paddingBits) < int(n) {
+			return errors.New("not enough JPEG padding bits")
+		}
+		pattern = 0
+		for _, bit := range wr.paddingBits[:n] {
+			pattern = pattern<<1 | uint32(bit&1)
+		}
+		wr.paddingBits = wr.paddingBits[n:]
+	}
+	bw.writeBits(n, pattern)
+	return nil
+}
+
+// mcuSize returns the number of MCUs in each row and the number of MCU rows of a scan.
+func (jd *JPEGData) mcuSize(scan *ScanInfo) (int, int) {
+	hGroup, vGroup := int32(1), int32(1)
+	if len(scan.Components) == 1 {
+		c := &jd.Components[scan.Components[0].ComponentIndex]
+		hGroup, vGroup = c.HSampFactor, c.VSampFactor
+	}
+	maxH, maxV := int32(1), int32(1)
+	for _, c := range jd.Components {
+		maxH = max(maxH, c.HSampFactor)
+		maxV = max(maxV, c.VSampFactor)
+	}
+	mcusPerRow := (int(jd.Width)*int(hGroup) + 8*int(maxH) - 1) / (8 * int(maxH))
+	mcuRows := (int(jd.Height)*int(vGroup) + 8*int(maxV) - 1) / (8 * int(maxV))
+	return mcusPerRow, mcuRows
+}
+
+func (wr *writer) writeScan() error {
+	jd := wr.jd
+	if wr.scanIndex >= len(jd.Scans) {
+		return errors.New("missing JPEG scan info")
+	}
+	scan := &jd.Scans[wr.scanIndex]
+	wr.scanIndex++
+
+	length := 6 + 2*len(scan.Components)
+	wr.out = append(wr.out, 0xFF, 0xDA, byte(length>>8), byte(length), byte(len(scan.Components)))
+	for _, sc := range scan.Components {
+		wr.out = append(wr.out, byte(jd.Components[sc.ComponentIndex].ID), byte(sc.DCTableIndex<<4|sc.ACTableIndex))
+	}
+	wr.out = append(wr.out, byte(scan.Ss), byte(scan.Se), byte(scan.Ah<<4|scan.Al))
+
+	ss, se, al, ah := 0, 63, 0, 0
+	if wr.isProgressive {
+		ss, se, al, ah = int(scan.Ss), int(scan.Se), int(scan.Al), int(scan.Ah)
+	}
+	sequential := ss == 0 && se == 63 && al == 0 && ah == 0
+	restartInterval := 0
+	if wr.seenDRI {
+		restartInterval = int(jd.RestartInterval)
+	}
+
+	for _, sc := range scan.Components {
+		c := &jd.Components[sc.ComponentIndex]
+		if len(c.Coeffs) < int(c.WidthInBlocks*c.HeightInBlocks)<<6 {
+			return errors.New("missing JPEG coefficients")
+		}
+		if (ss == 0 && !(ah != 0 && !sequential) && wr.dcTables[sc.DCTableIndex] == nil) ||
+			(se > 0 && wr.acTables[sc.ACTableIndex] == nil) {
+			return errors.New("scan uses undefined JPEG Huffman table")
+		}
+	}
+
+	bw := &bitWriter{out: wr.out}
+	state := &dctCodingState{}
+	var lastDC [4]int
+	restartsToGo := restartInterval
+	nextRestartMarker := 0
+	blockScanIndex := uint32(0)
+	resetPoints := scan.ResetPoints
+	extraZeroRuns := scan.ExtraZeroRuns
+	interleaved := len(scan.Components) > 1
+	mcusPerRow, mcuRows := jd.mcuSize(scan)
+
+	for mcuY := 0; mcuY < mcuRows; mcuY++ {
+		for mcuX := 0; mcuX < mcusPerRow; mcuX++ {
+
+			if restartInterval > 0 && restartsToGo == 0 {
+				if err := state.flush(bw); err != nil {
+					return err
+				}
+				if err := wr.jumpToByteBoundary(bw); err != nil {
+					return err
+				}
+				bw.out = append(bw.out, 0xFF, byte(0xD0+nextRestartMarker))
+				nextRestartMarker = (nextRestartMarker + 1) & 7
+				restartsToGo = restartInterval
+				lastDC = [4]int{}
+			}
+
+			for _, sc := range scan.Components {
+				c := &jd.Components[sc.ComponentIndex]
+				blocksY, blocksX := 1, 1
+				if interleaved {
+					blocksY, blocksX = int(c.VSampFactor), int(c.HSampFactor)
+				}
+				for iy := 0; iy < blocksY; iy++ {
+					for ix := 0; ix < blocksX; ix++ {
+						blockY := mcuY*blocksY + iy
+						blockX := mcuX*blocksX + ix
+						if blockY >= int(c.HeightInBlocks) || blockX >= int(c.WidthInBlocks) {
+							return errors.New("JPEG scan is larger than the image")
+						}
+
+						if len(resetPoints) > 0 && resetPoints[0] == blockScanIndex {
+							if err := state.flush(bw); err != nil {
+								return err
+							}
+							resetPoints = resetPoints[1:]
+						}
+						numZeroRuns := 0
+						if len(extraZeroRuns) > 0 && extraZeroRuns[0].BlockIndex == blockScanIndex {
+							numZeroRuns = int(extraZeroRuns[0].NumRuns)
+							extraZeroRuns = extraZeroRuns[1:]
+						}
+
+						blockIndex := blockY*int(c.WidthInBlocks) + blockX
+						coeffs := c.Coeffs[blockIndex<<6 : (blockIndex+1)<<6]
+						dcTable := wr.dcTables[sc.DCTableIndex]
+						acTable := wr.acTables[sc.ACTableIndex]
+						var err error
+						if sequential {
+							err = encodeBlockSequential(coeffs, dcTable, acTable, numZeroRuns, &lastDC[sc.ComponentIndex], bw)
+						} else if ah == 0 {
+							err = encodeBlockProgressive(coeffs, dcTable, acTable, ss, se, al, numZeroRuns, state, &lastDC[sc.ComponentIndex], bw)
+						} else {
+							err = encodeRefinementBits(coeffs, acTable, ss, se, al, state, bw)
+						}
+						if err != nil {
+							return err
+						}
+						blockScanIndex++
+					}
+				}
+			}
+			restartsToGo--
+		}
+	}
+
+	if err := state.flush(bw); err != nil {
+		return err
+	}
+	if err := wr.jumpToByteBoundary(bw); err != nil {
+		return err
+	}
+	wr.out = bw.out
+	return nil
+}
+
+// magnitude returns the JPEG size category of v, along with the bits used to encode it.
+func magnitude(v int) (uint32, uint32) {
+	if v < 0 {
+		n := uint32(bits.Len(uint(-v)))
+		return n, uint32(v - 1)
+	}
+	return uint32(bits.Len(uint(v))), uint32(v)
+}
+
+func encodeBlockSequential(coeffs []int16, dcTable *huffmanTable, acTable *huffmanTable, numZeroRuns int, lastDC *int, bw *bitWriter) error {
+
+	dc := int(coeffs[0])
+	nbits, v := magnitude(dc - *lastDC)
+	*lastDC = dc
+	if nbits >= 12 {
+		return errors.New("JPEG DC coefficient out of range")
+	}
+	if err := bw.writeSymbol(int(nbits), dcTable); err != nil {
+		return err
+	}
+	bw.writeBits(nbits, v)
+
+	r := 0
+	for k := 1; k < 64; k++ {
+		coeff := int(coeffs[naturalOrder[k]])
+		if coeff == 0 {
+			r++
+			continue
+		}
+		for ; r > 15; r -= 16 {
+			if err := bw.writeSymbol(0xF0, acTable); err != nil {
+				return err
+			}
+		}
+		nbits, v := magnitude(coeff)
+		if nbits >= 16 {
+			return errors.New("JPEG AC coefficient out of range")
+		}
+		if err := bw.writeSymbol(r<<4|int(nbits), acTable); err != nil {
+			return err
+		}
+		bw.writeBits(nbits, v)
+		r = 0
+	}
+	for i := 0; i < numZeroRuns; i++ {
+		if err := bw.writeSymbol(0xF0, acTable); err != nil {
+			return err
+		}
+		r -= 16
+	}
+	if r > 0 {
+		return bw.writeSymbol(0, acTable)
+	}
+	return nil
+}
+
+func encodeBlockProgressive(coeffs []int16, dcTable *huffmanTable, acTable *huffmanTable, ss int, se int, al int,
+	numZeroRuns int, state *dctCodingState, lastDC *int, bw *bitWriter) error {
+
+	eobRunAllowed := ss > 0
+	if ss == 0 {
+		dc := int(coeffs[0]) >> al
+		nbits, v := magnitude(dc - *lastDC)
+		*lastDC = dc
+		if err := bw.writeSymbol(int(nbits), dcTable); err != nil {
+			return err
+		}
+		bw.writeBits(nbits, v)
+		ss++
+	}
+	if ss > se {
+		return nil
+	}
+
+	r := 0
+	for k := ss; k <= se; k++ {
+		coeff := int(coeffs[naturalOrder[k]])
+		if coeff == 0 {
+			r++
+			continue
+		}
+		var v int
+		if coeff < 0 {
+			v = -((-coeff) >> al)
+		} else {
+			v = coeff >> al
+		}
+		if v == 0 {
+			r++
+			continue
+		}
+		if err := state.flush(bw); err != nil {
+			return err
+		}
+		for ; r > 15; r -= 16 {
+			if err := bw.writeSymbol(0xF0, acTable); err != nil {
+				return err
+			}
+		}
+		nbits, bitsValue := magnitude(v)
+		if err := bw.writeSymbol(r<<4|int(nbits), acTable); err != nil {
+			return err
+		}
+		bw.writeBits(nbits, bitsValue)
+		r = 0
+	}
+	if numZeroRuns > 0 {
+		if err := state.flush(bw); err != nil {
+			return err
+		}
+		for i := 0; i < numZeroRuns; i++ {
+			if err := bw.writeSymbol(0xF0, acTable); err != nil {
+				return err
+			}
+			r -= 16
+		}
+	}
+	if r > 0 {
+		if err := state.bufferEndOfBand(acTable, nil, bw); err != nil {
+			return err
+		}
+		if !eobRunAllowed {
+			return state.flush(bw)
+		}
+	}
+	return nil
+}
+
+func encodeRefinementBits(coeffs []int16, acTable *huffmanTable, ss int, se int, al int, state *dctCodingState, bw *bitWriter) error {
+
+	eobRunAllowed := ss > 0
+	if ss == 0 {
+		bw.writeBits(1, uint32(int(coeffs[0])>>al)&1)
+		ss++
+	}
+	if ss > se {
+		return nil
+	}
+
+	var absValues [64]int
+	eob := 0
+	for k := ss; k <= se; k++ {
+		v := int(coeffs[naturalOrder[k]])
+		if v < 0 {
+			v = -v
+		}
+		absValues[k] = v >> al
+		if absValues[k] == 1 {
+			eob = k
+		}
+	}
+
+	r := 0
+	var refinementBits []int
+	for k := ss; k <= se; k++ {
+		if absValues[k] == 0 {
+			r++
+			continue
+		}
+		for r > 15 && k <= eob {
+			if err := state.flush(bw); err != nil {
+				return err
+			}
+			if err := bw.writeSymbol(0xF0, acTable); err != nil {
+				return err
+			}
+			r -= 16
+			for _, bit := range refinementBits {
+				bw.writeBits(1, uint32(bit))
+			}
+			refinementBits = refinementBits[:0]
+		}
+		if absValues[k] > 1 {
+			refinementBits = append(refinementBits, absValues[k]&1)
+			continue
+		}
+		if err := state.flush(bw); err != nil {
+			return err
+		}
+		newNonZeroBit := uint32(1)
+		if coeffs[naturalOrder[k]] < 0 {
+			newNonZeroBit = 0
+		}
+		if err := bw.writeSymbol(r<<4|1, acTable); err != nil {
+			return err
+		}
+		bw.writeBits(1, newNonZeroBit)
+		for _, bit := range refinementBits {
+			bw.writeBits(1, uint32(bit))
+		}
+		refinementBits = refinementBits[:0]
+		r = 0
+	}
+	if r > 0 || len(refinementBits) > 0 {
+		if err := state.bufferEndOfBand(acTable, refinementBits, bw); err != nil {
+			return err
+		}
+		if !eobRunAllowed {
+			return state.flush(bw)
+		}
+	}
+	return nil
+}
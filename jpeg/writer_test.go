@@ -0,0 +1,148 @@
+package jpeg
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestJPEGData returns a 16x16 grayscale baseline JPEG of 4 flat blocks, with a restart
+// marker after every block.
+func newTestJPEGData(dc int16) *JPEGData {
+	coeffs := make([]int16, 4*64)
+	for i := 0; i < 4; i++ {
+		coeffs[i*64] = dc
+	}
+	var quant [64]int32
+	for i := range quant {
+		quant[i] = 8
+	}
+	return &JPEGData{
+		Width:       16,
+		Height:      16,
+		MarkerOrder: []byte{0xFE, 0xDB, 0xC4, 0xDD, 0xC0, 0xDA, 0xD9},
+		ComData:     [][]byte{{0xFE, 0x00, 0x06, 't', 'e', 's', 't'}},
+		Quant:       []QuantTable{{Values: quant, IsLast: true}},
+		Components: []Component{
+			{ID: 1, HSampFactor: 1, VSampFactor: 1, WidthInBlocks: 2, HeightInBlocks: 2, Coeffs: coeffs},
+		},
+		HuffmanCodes: []HuffmanCode{
+			{SlotID: 0x00, Counts: [17]uint32{2: 3}, Values: []uint32{0, 4, huffmanAlphabetSize}},
+			{SlotID: 0x10, Counts: [17]uint32{1: 2}, Values: []uint32{0, huffmanAlphabetSize}, IsLast: true},
+		},
+		Scans: []ScanInfo{
+			{Components: []ScanComponent{{}}, Se: 63},
+		},
+		RestartInterval: 1,
+		TailData:        []byte{0x12, 0x34},
+	}
+}
+
+func TestWrite(t *testing.T) {
+
+	var buf bytes.Buffer
+	require.NoError(t, newTestJPEGData(8).Write(&buf))
+	out := buf.Bytes()
+
+	assert.Equal(t, []byte{0xFF, 0xD8, 0xFF, 0xFE, 0x00, 0x06, 't', 'e', 's', 't'}, out[:10])
+	assert.Equal(t, []byte{0xFF, 0xD9, 0x12, 0x34}, out[len(out)-4:])
+	assert.True(t, bytes.Contains(out, []byte{0xFF, 0xDD, 0x00, 0x04, 0x00, 0x01}))
+	for _, rst := range []byte{0xD0, 0xD1, 0xD2} {
+		assert.True(t, bytes.Contains(out, []byte{0xFF, rst}))
+	}
+	assert.False(t, bytes.Contains(out, []byte{0xFF, 0xD3}))
+
+	img, err := jpeg.Decode(bytes.NewReader(out))
+	require.NoError(t, err)
+	gray, ok := img.(*image.Gray)
+	require.True(t, ok)
+	assert.Equal(t, image.Rect(0, 0, 16, 16), gray.Bounds())
+	for _, v := range gray.Pix {
+		// DC of 8 * quant of 8, scaled by 1/8 and level shifted.
+		require.Equal(t, uint8(136), v)
+	}
+}
+
+func TestWriteErrors(t *testing.T) {
+
+	for _, tc := range []struct {
+		name   string
+		modify func(jd *JPEGData)
+	}{
+		{
+			name: "symbol missing from Huffman table",
+			modify: func(jd *JPEGData) {
+				jd.Components[0].Coeffs[0] = 100
+			},
+		},
+		{
+			name: "missing padding bits",
+			modify: func(jd *JPEGData) {
+				jd.HasZeroPaddingBit = true
+			},
+		},
+		{
+			name: "unused padding bits",
+			modify: func(jd *JPEGData) {
+				jd.HasZeroPaddingBit = true
+				jd.PaddingBits = make([]byte, 5)
+			},
+		},
+		{
+			name: "missing coefficients",
+			modify: func(jd *JPEGData) {
+				jd.Components[0].Coeffs = nil
+			},
+		},
+		{
+			name: "missing scan",
+			modify: func(jd *JPEGData) {
+				jd.Scans = nil
+			},
+		},
+		{
+			name: "unknown marker",
+			modify: func(jd *JPEGData) {
+				jd.MarkerOrder = append([]byte{0xC3}, jd.MarkerOrder...)
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			jd := newTestJPEGData(8)
+			tc.modify(jd)
+			var buf bytes.Buffer
+			assert.Error(t, jd.Write(&buf))
+			assert.Zero(t, buf.Len())
+		})
+	}
+}
+
+func TestWritePaddingBits(t *testing.T) {
+
+	// each block is 7 bits, so each of the 4 entropy coded segments needs 1 padding bit.
+	jd := newTestJPEGData(8)
+	jd.HasZeroPaddingBit = true
+	jd.PaddingBits = []byte{0, 1, 0, 0}
+
+	var ones bytes.Buffer
+	require.NoError(t, newTestJPEGData(8).Write(&ones))
+	var buf bytes.Buffer
+	require.NoError(t, jd.Write(&buf))
+	require.Equal(t, ones.Len(), buf.Len())
+
+	diff := 0
+	for i := range buf.Bytes() {
+		if buf.Bytes()[i] != ones.Bytes()[i] {
+			assert.Equal(t, byte(1), ones.Bytes()[i]^buf.Bytes()[i])
+			diff++
+		}
+	}
+	assert.Equal(t, 3, diff)
+
+	_, err := jpeg.Decode(bytes.NewReader(buf.Bytes()))
+	assert.NoError(t, err)
+}
@@ -108,6 +108,21 @@ func (br *BitStreamReader) ReadBytesToBuffer(buffer []uint8, numBytes uint32) er
 	return nil
 }
 
+// Read lets a byte aligned BitStreamReader be used as an io.Reader. Unlike ReadBytesToBuffer
+// a short read at the end of the stream isn't an error.
+func (br *BitStreamReader) Read(p []byte) (int, error) {
+
+	if br.index != 0 {
+		return 0, errors.New("BitStreamReader cache not aligned")
+	}
+
+	n, err := io.ReadFull(br.stream, p)
+	if err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return n, err
+}
+
 // read single bit and will cache the current byte we're working on.
 func (br *BitStreamReader) readBit() (uint8, error) {
 	if br.index == 0 {
@@ -388,9 +403,27 @@ func (br *BitStreamReader) ShowBits(bits int) (uint64, error) {
 	oldIndex := br.index
 	oldBitsRead := br.bitsRead
 
-	b, err := br.ReadBits(uint32(bits))
-	if err != nil {
-		return 0, err
+	b, readErr := br.ReadBits(uint32(bits))
+	if errors.Is(readErr, io.EOF) {
+		// prefix codes are looked up with the longest code length, which can go past the end
+		// of the stream for the last symbols. The missing bits are zero, as long as there is
+		// at least one bit left.
+		if _, err = br.Seek(curPos, io.SeekStart); err != nil {
+			return 0, err
+		}
+		br.currentByte = oldCur
+		br.index = oldIndex
+		b = 0
+		for n := 0; n < bits; n++ {
+			bit, err := br.readBit()
+			if err != nil {
+				if n > 0 {
+					readErr = nil
+				}
+				break
+			}
+			b |= uint64(bit) << n
+		}
 	}
 
 	_, err = br.Seek(curPos, io.SeekStart)
@@ -401,6 +434,9 @@ func (br *BitStreamReader) ShowBits(bits int) (uint64, error) {
 	br.index = oldIndex
 	br.bitsRead = oldBitsRead
 
+	if readErr != nil {
+		return 0, readErr
+	}
 	return b, nil
 }
 
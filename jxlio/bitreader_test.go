@@ -661,6 +661,13 @@ func TestShowBits(t *testing.T) {
 			expectedResponse: 0x3FFFF,
 			expectErr:        false,
 		},
+		{
+			name:             "Show 15 bits with 8 left, missing bits are zero",
+			data:             []uint8{0xFF},
+			numBitsToShow:    15,
+			expectedResponse: 0xFF,
+			expectErr:        false,
+		},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
 
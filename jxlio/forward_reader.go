@@ -0,0 +1,119 @@
+package jxlio
+
+import (
+	"errors"
+	"io"
+)
+
+const (
+	// FORWARD_READER_LOOKBACK is how far back a ForwardReader can seek. This covers peeking at
+	// bits and seeking back to the start of the image header after reading it.
+	FORWARD_READER_LOOKBACK = 64 * 1024
+
+	forwardReaderChunk = 32 * 1024
+
+	// maxConsecutiveEmptyReads is how many times the input can return no data and no error before
+	// Read gives up with io.ErrNoProgress, as bufio.Reader does.
+	maxConsecutiveEmptyReads = 100
+)
+
+var ErrSeekTooFarBack = errors.New("can not seek that far back in non-seekable input")
+
+// ForwardReader lets a non-seekable io.Reader (eg a HTTP body or pipe) be used as an io.ReadSeeker.
+// Seeking forwards discards input, and seeking backwards is only possible within the last
+// FORWARD_READER_LOOKBACK bytes. Unlike most readers Read only returns less than asked for at the
+// end of the input, as BitStreamReader expects.
+type ForwardReader struct {
+	in io.Reader
+
+	// buffer holds the input from bufferStart onwards, both already read (for seeking back) and
+	// read ahead.
+	buffer      []byte
+	bufferStart int64
+	pos         int64
+	err         error
+}
+
+func NewForwardReader(in io.Reader) *ForwardReader {
+	return &ForwardReader{in: in}
+}
+
+func (fr *ForwardReader) Read(p []byte) (int, error) {
+
+	n := 0
+	for n < len(p) {
+		offset := fr.pos - fr.bufferStart
+		if offset >= int64(len(fr.buffer)) {
+			if err := fr.fill(); err != nil {
+				if n > 0 && err == io.EOF {
+					return n, nil
+				}
+				return n, err
+			}
+			continue
+		}
+		copied := copy(p[n:], fr.buffer[offset:])
+		n += copied
+		fr.pos += int64(copied)
+	}
+	return n, nil
+}
+
+// fill reads more of the input, dropping whatever is no longer needed for seeking back. This is
+// only called once everything in the buffer has been read.
+func (fr *ForwardReader) fill() error {
+	if fr.err != nil {
+		return fr.err
+	}
+
+	if fr.buffer == nil {
+		fr.buffer = make([]byte, 0, FORWARD_READER_LOOKBACK+2*forwardReaderChunk)
+	}
+	if drop := fr.pos - fr.bufferStart - FORWARD_READER_LOOKBACK; drop >= forwardReaderChunk {
+		drop = min(drop, int64(len(fr.buffer)))
+		fr.buffer = fr.buffer[:copy(fr.buffer, fr.buffer[drop:])]
+		fr.bufferStart += drop
+	}
+
+	size := len(fr.buffer)
+	for i := 0; i < maxConsecutiveEmptyReads; i++ {
+		n, err := fr.in.Read(fr.buffer[size:cap(fr.buffer)])
+		fr.buffer = fr.buffer[:size+n]
+		if err != nil {
+			fr.err = err
+			if n > 0 {
+				return nil
+			}
+			return err
+		}
+		if n > 0 {
+			return nil
+		}
+	}
+	return io.ErrNoProgress
+}
+
+// Seek moves to a new position. Seeking relative to the end isn't possible as the length of the
+// input isn't known.
+func (fr *ForwardReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += fr.pos
+	default:
+		return 0, errors.New("ForwardReader can only seek from the start or current position")
+	}
+	if offset < fr.bufferStart {
+		return 0, ErrSeekTooFarBack
+	}
+
+	// skip forwards by reading.
+	for offset > fr.bufferStart+int64(len(fr.buffer)) {
+		fr.pos = fr.bufferStart + int64(len(fr.buffer))
+		if err := fr.fill(); err != nil {
+			return fr.pos, err
+		}
+	}
+	fr.pos = offset
+	return offset, nil
+}
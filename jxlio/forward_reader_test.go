@@ -0,0 +1,104 @@
+package jxlio
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForwardReader(t *testing.T) {
+
+	data := make([]byte, 3*FORWARD_READER_LOOKBACK)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	fr := NewForwardReader(iotest.OneByteReader(bytes.NewReader(data)))
+
+	// reads are filled, even though the input only returns a byte at a time.
+	buffer := make([]byte, 100)
+	n, err := fr.Read(buffer)
+	require.NoError(t, err)
+	assert.Equal(t, 100, n)
+	assert.Equal(t, data[:100], buffer)
+
+	// seek back to the start and forwards past the lookback.
+	pos, err := fr.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), pos)
+	pos, err = fr.Seek(2*FORWARD_READER_LOOKBACK, io.SeekCurrent)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2*FORWARD_READER_LOOKBACK), pos)
+	_, err = fr.Read(buffer)
+	require.NoError(t, err)
+	assert.Equal(t, data[pos:pos+100], buffer)
+
+	// only the last FORWARD_READER_LOOKBACK bytes can be seeked back to.
+	_, err = fr.Seek(-FORWARD_READER_LOOKBACK, io.SeekCurrent)
+	require.NoError(t, err)
+	_, err = fr.Seek(0, io.SeekStart)
+	assert.Equal(t, ErrSeekTooFarBack, err)
+	_, err = fr.Seek(0, io.SeekEnd)
+	assert.Error(t, err)
+
+	// the end of the input is a short read, then EOF.
+	_, err = fr.Seek(int64(len(data)-10), io.SeekStart)
+	require.NoError(t, err)
+	n, err = fr.Read(buffer)
+	require.NoError(t, err)
+	assert.Equal(t, 10, n)
+	assert.Equal(t, data[len(data)-10:], buffer[:n])
+	_, err = fr.Read(buffer)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestForwardReaderBits(t *testing.T) {
+
+	br := NewBitStreamReader(NewForwardReader(iotest.OneByteReader(bytes.NewReader([]byte{0x12, 0x34}))))
+	v, err := br.ShowBits(12)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0x412), v)
+	v, err = br.ReadBits(16)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0x3412), v)
+	assert.True(t, br.AtEnd())
+}
+
+// emptyReader returns no data and no error for the first empty reads, then reads from in.
+type emptyReader struct {
+	in    io.Reader
+	empty int
+	reads int
+}
+
+func (r *emptyReader) Read(p []byte) (int, error) {
+	r.reads++
+	if r.reads <= r.empty {
+		return 0, nil
+	}
+	return r.in.Read(p)
+}
+
+func TestForwardReaderEmptyReads(t *testing.T) {
+
+	// a few empty reads are retried.
+	in := &emptyReader{in: bytes.NewReader([]byte{1, 2, 3}), empty: 10}
+	buffer := make([]byte, 3)
+	n, err := NewForwardReader(in).Read(buffer)
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, []byte{1, 2, 3}, buffer)
+
+	// input that never returns anything fails rather than looping forever.
+	in = &emptyReader{empty: math.MaxInt}
+	fr := NewForwardReader(in)
+	_, err = fr.Read(buffer)
+	assert.Equal(t, io.ErrNoProgress, err)
+	assert.Equal(t, maxConsecutiveEmptyReads, in.reads)
+	_, err = fr.Seek(10, io.SeekStart)
+	assert.Equal(t, io.ErrNoProgress, err)
+}
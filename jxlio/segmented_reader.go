@@ -0,0 +1,118 @@
+package jxlio
+
+import (
+	"errors"
+	"io"
+)
+
+// Segment is a section of a stream, such as the payload of a jxlp box.
+type Segment struct {
+	Offset int64
+	Length int64
+}
+
+// SegmentedReader reads a number of segments of a stream as if they were one contiguous stream.
+// This is used for codestreams split over multiple jxlp boxes, which may be split anywhere
+// (even part way through a frame).
+type SegmentedReader struct {
+	in       io.ReadSeeker
+	segments []Segment
+
+	// starts holds the logical offset of the start of each segment.
+	starts []int64
+	length int64
+
+	// pos is the logical position, inPos the position of the underlying stream (or -1 if it
+	// needs seeking before the next read).
+	pos   int64
+	inPos int64
+}
+
+func NewSegmentedReader(in io.ReadSeeker, segments []Segment) *SegmentedReader {
+	sr := &SegmentedReader{
+		in:       in,
+		segments: segments,
+		starts:   make([]int64, len(segments)),
+		inPos:    -1,
+	}
+	for i, s := range segments {
+		sr.starts[i] = sr.length
+		sr.length += s.Length
+	}
+	return sr
+}
+
+// Read reads from as many segments as needed to fill p, only returning less than len(p) at the end
+// of the last segment.
+func (sr *SegmentedReader) Read(p []byte) (int, error) {
+
+	n := 0
+	for n < len(p) {
+		if sr.pos >= sr.length {
+			if n == 0 {
+				return 0, io.EOF
+			}
+			break
+		}
+
+		i := sr.segmentIndex(sr.pos)
+		segPos := sr.pos - sr.starts[i]
+		inPos := sr.segments[i].Offset + segPos
+		if inPos != sr.inPos {
+			if _, err := sr.in.Seek(inPos, io.SeekStart); err != nil {
+				sr.inPos = -1
+				return n, err
+			}
+			sr.inPos = inPos
+		}
+
+		toRead := min(int64(len(p)-n), sr.segments[i].Length-segPos)
+		read, err := io.ReadFull(sr.in, p[n:n+int(toRead)])
+		n += read
+		sr.pos += int64(read)
+		sr.inPos += int64(read)
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return n, errors.New("segment extends past end of stream")
+			}
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Seek sets the logical position within the segments.
+func (sr *SegmentedReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += sr.pos
+	case io.SeekEnd:
+		offset += sr.length
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	sr.pos = offset
+	return offset, nil
+}
+
+// segmentIndex returns the index of the segment containing the logical position pos.
+func (sr *SegmentedReader) segmentIndex(pos int64) int {
+	lo, hi := 0, len(sr.segments)-1
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if sr.starts[mid] <= pos {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	// skip over empty segments.
+	for lo < len(sr.segments)-1 && pos-sr.starts[lo] >= sr.segments[lo].Length {
+		lo++
+	}
+	return lo
+}
@@ -0,0 +1,93 @@
+package jxlio
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSegmentedReader(t *testing.T) {
+
+	data := []byte("..abc...de....fgh.")
+	segments := []Segment{{Offset: 2, Length: 3}, {Offset: 7, Length: 0}, {Offset: 8, Length: 2}, {Offset: 14, Length: 3}}
+
+	for _, tc := range []struct {
+		name     string
+		seek     int64
+		whence   int
+		readSize int
+		expected string
+	}{
+		{
+			name:     "read everything",
+			readSize: 20,
+			expected: "abcdefgh",
+		},
+		{
+			name:     "read across segments",
+			seek:     1,
+			readSize: 5,
+			expected: "bcdef",
+		},
+		{
+			name:     "seek current",
+			seek:     3,
+			whence:   io.SeekCurrent,
+			readSize: 3,
+			expected: "def",
+		},
+		{
+			name:     "seek end",
+			seek:     -2,
+			whence:   io.SeekEnd,
+			readSize: 3,
+			expected: "gh",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			sr := NewSegmentedReader(bytes.NewReader(data), segments)
+			_, err := sr.Seek(tc.seek, tc.whence)
+			require.NoError(t, err)
+			buffer := make([]byte, tc.readSize)
+			n, err := sr.Read(buffer)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, string(buffer[:n]))
+
+			_, err = sr.Seek(0, io.SeekEnd)
+			require.NoError(t, err)
+			_, err = sr.Read(buffer)
+			assert.Equal(t, io.EOF, err)
+		})
+	}
+}
+
+func TestSegmentedReaderBits(t *testing.T) {
+
+	// bits are read across segment boundaries in the same way as a single stream.
+	data := []byte{0xAA, 0x12, 0xBB, 0x34, 0x56}
+	br := NewBitStreamReader(NewSegmentedReader(bytes.NewReader(data), []Segment{{Offset: 1, Length: 1}, {Offset: 3, Length: 2}}))
+	v, err := br.ReadBits(12)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0x412), v)
+
+	v, err = br.ShowBits(4)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0x3), v)
+	v, err = br.ReadBits(12)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0x563), v)
+	assert.True(t, br.AtEnd())
+}
+
+func TestSegmentedReaderErrors(t *testing.T) {
+
+	sr := NewSegmentedReader(bytes.NewReader([]byte{1, 2, 3}), []Segment{{Offset: 1, Length: 5}})
+	_, err := sr.Read(make([]byte, 5))
+	assert.Error(t, err)
+
+	_, err = sr.Seek(-1, io.SeekStart)
+	assert.Error(t, err)
+}
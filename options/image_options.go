@@ -0,0 +1,41 @@
+package options
+
+const (
+
+	// BitDepth values for ImageOptions.
+	BIT_DEPTH_AUTO = 0
+	BIT_DEPTH_8    = 8
+	BIT_DEPTH_16   = 16
+)
+
+// ImageOptions controls how a decoded image is converted into a Go image.Image.
+type ImageOptions struct {
+
+	// AssociatedAlpha returns images with alpha as premultiplied image.RGBA/image.RGBA64
+	// instead of image.NRGBA. Useful when compositing, as it avoids un-premultiplying and
+	// then premultiplying again at 8/16 bit precision.
+	AssociatedAlpha bool
+
+	// BitDepth is the precision of the returned image. BIT_DEPTH_AUTO picks 16 bit
+	// (image.NRGBA64, image.Gray16 etc) for sources over 8 bits, otherwise 8 bit.
+	BitDepth int
+
+	// RenderSpotColours blends any spot colour extra channels onto the colour channels.
+	RenderSpotColours bool
+
+	// SDR converts HDR images (PQ, HLG, linear or wide gamut) to sRGB for a standard dynamic
+	// range display, instead of to PQ with BT.2100 primaries.
+	SDR bool
+}
+
+func NewImageOptions(options *ImageOptions) *ImageOptions {
+	opt := &ImageOptions{}
+
+	if options != nil {
+		opt.AssociatedAlpha = options.AssociatedAlpha
+		opt.BitDepth = options.BitDepth
+		opt.RenderSpotColours = options.RenderSpotColours
+		opt.SDR = options.SDR
+	}
+	return opt
+}
@@ -2,11 +2,19 @@ package options
 
 import "runtime"
 
+// DEFAULT_MAX_BOX_SIZE is the default limit on the size of container metadata boxes.
+const DEFAULT_MAX_BOX_SIZE = 64 << 20
+
 type JXLOptions struct {
 	debug           bool
 	ParseOnly       bool
 	RenderVarblocks bool
 	MaxGoroutines   int
+
+	// MaxBoxSize limits the size of the metadata (and JPEG reconstruction) boxes read from the
+	// container, after any Brotli decompression, to protect against decompression bombs.
+	// 0 uses DEFAULT_MAX_BOX_SIZE.
+	MaxBoxSize int
 }
 
 func NewJXLOptions(options *JXLOptions) *JXLOptions {
@@ -14,6 +22,7 @@ func NewJXLOptions(options *JXLOptions) *JXLOptions {
 	// default goroutines to max.
 	opt := &JXLOptions{
 		MaxGoroutines: runtime.GOMAXPROCS(-1),
+		MaxBoxSize:    DEFAULT_MAX_BOX_SIZE,
 	}
 
 	if options != nil {
@@ -23,6 +32,9 @@ func NewJXLOptions(options *JXLOptions) *JXLOptions {
 		if options.MaxGoroutines > 1 {
 			opt.MaxGoroutines = options.MaxGoroutines
 		}
+		if options.MaxBoxSize > 0 {
+			opt.MaxBoxSize = options.MaxBoxSize
+		}
 	}
 	return opt
 }
@@ -1,7 +1,6 @@
 package jxl_go
 
 import (
-	"errors"
 	"image"
 	color2 "image/color"
 	"io"
@@ -10,22 +9,21 @@ import (
 	"github.com/kpfaulkner/jxl-go/core"
 )
 
-const jxlHeader = "\x00\x00\x00\x0C\x4A\x58\x4C\x20\x0D\x0A\x87\x0A"
+const (
+	jxlHeader           = "\x00\x00\x00\x0C\x4A\x58\x4C\x20\x0D\x0A\x87\x0A"
+	jxlCodestreamHeader = "\xFF\x0A"
+)
 
 func init() {
 	image.RegisterFormat("jxl", jxlHeader, Decode, DecodeConfig)
+	image.RegisterFormat("jxl", jxlCodestreamHeader, Decode, DecodeConfig)
 }
 
+// Decode decodes a JXL image. r doesn't need to be seekable, so HTTP bodies, pipes etc can be
+// decoded directly.
 func Decode(r io.Reader) (image.Image, error) {
 
-	var rs io.ReadSeeker
-	var ok bool
-
-	if rs, ok = r.(io.ReadSeeker); !ok {
-		return nil, errors.New("required ReadSeeker")
-	}
-
-	jxl := core.NewJXLDecoder(rs, nil)
+	jxl := core.NewJXLDecoder(r, nil)
 
 	var img image.Image
 	var jxlImg *core.JXLImage
@@ -41,30 +39,30 @@ func Decode(r io.Reader) (image.Image, error) {
 }
 
 func DecodeConfig(r io.Reader) (image.Config, error) {
-	var rs io.ReadSeeker
-	var ok bool
-
-	if rs, ok = r.(io.ReadSeeker); !ok {
-		return image.Config{}, errors.New("required ReadSeeker")
-	}
 
-	jxl := core.NewJXLDecoder(rs, nil)
+	jxl := core.NewJXLDecoder(r, nil)
 	header, err := jxl.GetImageHeader()
 	if err != nil {
 		return image.Config{}, err
 	}
 
+	// matches the image types ToImage returns.
+	highBitDepth := header.BitDepth.BitsPerSample > 8
 	var colourModel color2.Model
 
-	switch header.GetColourModel() {
-	case colour.CE_RGB:
-		colourModel = color2.RGBAModel
-	case colour.CE_XYB:
-		colourModel = color2.GrayModel // unsure how to deal with XYB
-	case colour.CE_GRAY:
+	switch {
+	case header.IsCMYK():
+		colourModel = color2.CMYKModel
+	case header.GetColourModel() == colour.CE_GRAY && !header.HasAlpha():
 		colourModel = color2.GrayModel
+		if highBitDepth {
+			colourModel = color2.Gray16Model
+		}
 	default:
-		colourModel = color2.RGBAModel
+		colourModel = color2.NRGBAModel
+		if highBitDepth {
+			colourModel = color2.NRGBA64Model
+		}
 	}
 
 	dim := header.GetSize()
@@ -0,0 +1,50 @@
+package jxl_go
+
+import (
+	"bytes"
+	"image"
+	"os"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImageDecode(t *testing.T) {
+
+	for _, tc := range []struct {
+		filename string
+		width    int
+		height   int
+	}{
+		{
+			filename: "testdata/tiny2.jxl",
+			width:    16,
+			height:   16,
+		},
+		{
+			// bare codestream
+			filename: "testdata/grayscale.jxl",
+			width:    200,
+			height:   200,
+		},
+	} {
+		t.Run(tc.filename, func(t *testing.T) {
+			data, err := os.ReadFile(tc.filename)
+			require.NoError(t, err)
+
+			// image.Decode wraps readers that can't peek, so it never sees a ReadSeeker.
+			config, format, err := image.DecodeConfig(iotest.HalfReader(bytes.NewReader(data)))
+			require.NoError(t, err)
+			assert.Equal(t, "jxl", format)
+
+			img, format, err := image.Decode(iotest.HalfReader(bytes.NewReader(data)))
+			require.NoError(t, err)
+			assert.Equal(t, "jxl", format)
+			assert.Equal(t, tc.width, config.Width)
+			assert.Equal(t, tc.height, config.Height)
+			assert.Equal(t, image.Rect(0, 0, tc.width, tc.height), img.Bounds())
+		})
+	}
+}
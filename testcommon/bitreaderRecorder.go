@@ -1,7 +1,9 @@
 package testcommon
 
 import (
+	"errors"
 	"fmt"
+	"io"
 
 	"github.com/kpfaulkner/jxl-go/jxlio"
 )
@@ -49,6 +51,15 @@ func (fbr *BitReaderRecorder) ReadBytesToBuffer(buffer []uint8, numBytes uint32)
 	return nil
 }
 
+// Read passes through to the real BitReader, if it can be used as an io.Reader.
+func (fbr *BitReaderRecorder) Read(p []byte) (int, error) {
+	r, ok := fbr.realBitReader.(io.Reader)
+	if !ok {
+		return 0, errors.New("BitReader does not support Read")
+	}
+	return r.Read(p)
+}
+
 func (fbr *BitReaderRecorder) ReadBits(bits uint32) (uint64, error) {
 
 	res, err := fbr.realBitReader.ReadBits(bits)